@@ -0,0 +1,104 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"legacymigration/pkg/fakeapi"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	stateFlag = "state"
+)
+
+type fakeAPIOptions struct {
+	address   string
+	statePath string
+}
+
+func newFakeAPICmd() *cobra.Command {
+	o := fakeAPIOptions{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cmd := &cobra.Command{
+		Use:    "fake-api",
+		Short:  "Serve a stateful fake of the Compute and GKE APIs (for testing).",
+		Hidden: true,
+		Long: `Serve a stateful fake of the Compute and GKE APIs (for testing).
+
+The initial state is read from a JSON file of networks, instance group managers, instance templates,
+clusters, a server config and a script controlling how operations progress. Run the conversion against
+the fake with --compute-base-url and --container-base-url set to the URL it is served on.
+The current state is served at /fakeapi/state.`,
+
+		PreRun: func(cmd *cobra.Command, args []string) {
+			setupCloseHandler(cancel, nil)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cobra.CheckErr(o.Run(ctx))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.address, addressFlag, "localhost:8081", "Address to listen on.")
+	flags.StringVar(&o.statePath, stateFlag, o.statePath, "Path to the JSON file of the initial state.")
+
+	cmd.MarkFlagRequired(stateFlag)
+
+	return cmd
+}
+
+// Run serves the fake APIs until ctx is closed.
+func (o *fakeAPIOptions) Run(ctx context.Context) error {
+	state, err := fakeapi.LoadState(o.statePath)
+	if err != nil {
+		return err
+	}
+	srv, err := fakeapi.New(state)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", o.address)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{Handler: srv}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Error shutting down server: %v", err)
+		}
+	}()
+
+	url := fmt.Sprintf("http://%s/", l.Addr())
+	log.Infof("Serving fake APIs for project %s on %s; use --%s=%s --%s=%s --%s=%s", state.ProjectID, url,
+		computeBasePathFlag, url, containerBasePathFlag, url, resourceManagerBasePathFlag, url)
+	if err := httpServer.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,466 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const fakeState = `{
+  "projectId": "test-project",
+  "networks": [{"name": "legacy-network", "IPv4Range": "10.0.0.0/8"}],
+  "instanceGroupManagers": [{
+    "name": "gke-pool-grp",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-central1-a",
+    "instanceTemplate": "https://www.googleapis.com/compute/v1/projects/test-project/global/instanceTemplates/gke-pool-tmpl"
+  }],
+  "instanceTemplates": [{
+    "name": "gke-pool-tmpl",
+    "properties": {"networkInterfaces": [{"network": "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/legacy-network"}]}
+  }],
+  "clusters": [{
+    "name": "cluster-a",
+    "location": "us-central1-a",
+    "network": "legacy-network",
+    "status": "RUNNING",
+    "currentMasterVersion": "1.19.10-gke.1700",
+    "nodePools": [{
+      "name": "default-pool",
+      "version": "1.19.10-gke.1700",
+      "status": "RUNNING",
+      "instanceGroupUrls": ["https://www.googleapis.com/compute/v1/projects/test-project/zones/us-central1-a/instanceGroupManagers/gke-pool-grp"]
+    }]
+  }],
+  "serverConfig": {
+    "defaultClusterVersion": "1.19.10-gke.1700",
+    "validMasterVersions": ["1.20.7-gke.1800", "1.19.11-gke.1700", "1.19.10-gke.1700"],
+    "validNodeVersions": ["1.20.7-gke.1800", "1.19.11-gke.1700", "1.19.10-gke.1700"]
+  },
+  "script": {"polls": 2}
+}`
+
+// TestFakeAPI_Conversion runs a conversion end-to-end against the fake APIs.
+func TestFakeAPI_Conversion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakeapi")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(path, []byte(fakeState), 0644); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+
+	state, err := fakeapi.LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState unexpected error: %v", err)
+	}
+	srv, err := fakeapi.New(state)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	o := &migrateOptions{
+		projectID:                  "test-project",
+		endpoints:                  convert.Endpoints{Compute: hs.URL, Container: hs.URL + "/", ResourceManager: hs.URL + "/"},
+		selectedNetwork:            "legacy-network",
+		desiredControlPlaneVersion: "1.20.7-gke.1800",
+	}
+	o.setDefaults()
+	o.pollingInterval = time.Millisecond
+
+	ctx := context.Background()
+	if err := o.Complete(ctx); err != nil {
+		t.Fatalf("migrateOptions.Complete unexpected error: %v", err)
+	}
+	if err := o.Run(ctx); err != nil {
+		t.Fatalf("migrateOptions.Run unexpected error: %v", err)
+	}
+
+	got, err := srv.State()
+	if err != nil {
+		t.Fatalf("State unexpected error: %v", err)
+	}
+	if got.Networks[0].IPv4Range != "" {
+		t.Errorf("Network was not converted: %+v", got.Networks[0])
+	}
+	c := got.Clusters[0]
+	if diff := cmp.Diff([]string{"1.20.7-gke.1800", "legacy-network", "1.20.7-gke.1800"},
+		[]string{c.CurrentMasterVersion, c.Subnetwork, c.NodePools[0].Version}); diff != "" {
+		t.Errorf("Cluster (version, subnetwork, node pool version) diff (-want +got):\n%s", diff)
+	}
+	if ni := got.InstanceTemplates[0].Properties.NetworkInterfaces[0]; ni.Subnetwork == "" {
+		t.Errorf("Instance template was not given a subnetwork")
+	}
+}
+
+func TestFakeAPIOptions_RunMissingState(t *testing.T) {
+	o := &fakeAPIOptions{address: "localhost:0", statePath: "missing.json"}
+	err := o.Run(context.Background())
+	if diff := test.ErrorDiff("no such file", err); diff != "" {
+		t.Errorf("fakeAPIOptions.Run diff (-want +got):\n%s", diff)
+	}
+}
+
+// TestCassette_RecordReplay records a validate-only run against the fake APIs and replays it
+// with the fake stopped.
+func TestCassette_RecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+	statePath := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(statePath, []byte(fakeState), 0644); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+
+	state, err := fakeapi.LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState unexpected error: %v", err)
+	}
+	srv, err := fakeapi.New(state)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	hs := httptest.NewServer(srv)
+
+	newOptions := func() *migrateOptions {
+		o := &migrateOptions{
+			projectID:                  "test-project",
+			endpoints:                  convert.Endpoints{Compute: hs.URL, Container: hs.URL + "/", ResourceManager: hs.URL + "/"},
+			selectedNetwork:            "legacy-network",
+			desiredControlPlaneVersion: "1.20.7-gke.1800",
+			validateOnly:               true,
+		}
+		o.setDefaults()
+		return o
+	}
+	ctx := context.Background()
+
+	o := newOptions()
+	o.recordCassette = path
+	if err := o.Complete(ctx); err != nil {
+		t.Fatalf("migrateOptions.Complete unexpected error: %v", err)
+	}
+	if err := o.Run(ctx); err != nil {
+		t.Fatalf("migrateOptions.Run unexpected error: %v", err)
+	}
+	hs.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read cassette: %v", err)
+	}
+	if strings.Contains(string(b), "test-project") {
+		t.Errorf("Cassette contains the project ID:\n%s", b)
+	}
+
+	o = newOptions()
+	o.replayCassette = path
+	if err := o.Complete(ctx); err != nil {
+		t.Fatalf("migrateOptions.Complete replay unexpected error: %v", err)
+	}
+	if err := o.Run(ctx); err != nil {
+		t.Errorf("migrateOptions.Run replay unexpected error: %v", err)
+	}
+}
+
+// TestFaultConfig runs a conversion against the fake APIs with a node pool upgrade failing.
+func TestFaultConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faults")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(statePath, []byte(fakeState), 0644); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+	faultPath := filepath.Join(dir, "faults.json")
+	faultConfig := `{"faults": [{"kind": "operationError", "method": "UpdateNodePool", "message": "injected upgrade failure"}]}`
+	if err := ioutil.WriteFile(faultPath, []byte(faultConfig), 0644); err != nil {
+		t.Fatalf("Unable to write fault config: %v", err)
+	}
+	transportFaultPath := filepath.Join(dir, "transport-faults.json")
+	transportFaultConfig := `{"faults": [{"kind": "error", "transport": true, "target": "networks/legacy-network/switchToCustomMode", "code": 403, "reason": "forbidden", "message": "injected permission denied"}]}`
+	if err := ioutil.WriteFile(transportFaultPath, []byte(transportFaultConfig), 0644); err != nil {
+		t.Fatalf("Unable to write fault config: %v", err)
+	}
+
+	state, err := fakeapi.LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState unexpected error: %v", err)
+	}
+	srv, err := fakeapi.New(state)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	cases := []struct {
+		desc        string
+		faultConfig string
+		wantErr     string
+	}{
+		{
+			desc:        "Missing config",
+			faultConfig: filepath.Join(dir, "missing.json"),
+			wantErr:     "no such file",
+		},
+		{
+			// A 403 without a rate limit reason is not retried.
+			desc:        "Permission denied in the transport",
+			faultConfig: transportFaultPath,
+			wantErr:     "Error 403: injected permission denied, forbidden",
+		},
+		{
+			desc:        "Failed node pool upgrade",
+			faultConfig: faultPath,
+			wantErr:     "injected upgrade failure",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			o := &migrateOptions{
+				projectID:                  "test-project",
+				endpoints:                  convert.Endpoints{Compute: hs.URL, Container: hs.URL + "/", ResourceManager: hs.URL + "/"},
+				selectedNetwork:            "legacy-network",
+				desiredControlPlaneVersion: "1.20.7-gke.1800",
+				faultConfig:                tc.faultConfig,
+			}
+			o.setDefaults()
+			o.pollingInterval = time.Millisecond
+
+			ctx := context.Background()
+			err := o.Complete(ctx)
+			if err == nil {
+				err = o.Run(ctx)
+			}
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("migrateOptions diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFakeAPI_MissingPermissions fails a conversion, or a validate-only run, before any resource is
+// modified if a permission required by the plan is missing.
+func TestFakeAPI_MissingPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakeapi")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(path, []byte(fakeState), 0644); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+
+	cases := []struct {
+		desc            string
+		denied          []string
+		validateOnly    bool
+		wantCompleteErr string
+		wantErr         string
+	}{
+		{
+			desc:    "Conversion",
+			denied:  []string{"compute.networks.switchToCustomMode"},
+			wantErr: "validation error: missing permissions on project test-project: compute.networks.switchToCustomMode",
+		},
+		{
+			desc:         "Validate only",
+			denied:       []string{"compute.networks.switchToCustomMode", "container.clusters.update"},
+			validateOnly: true,
+			wantErr:      "missing permissions on project test-project: compute.networks.switchToCustomMode, container.clusters.update",
+		},
+		{
+			desc:            "Read permission",
+			denied:          []string{"container.clusters.list"},
+			wantCompleteErr: "missing permissions on project test-project: container.clusters.list",
+		},
+		{
+			desc:   "Permission not required by the plan",
+			denied: []string{"compute.subnetworks.update"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			state, err := fakeapi.LoadState(path)
+			if err != nil {
+				t.Fatalf("LoadState unexpected error: %v", err)
+			}
+			state.DeniedPermissions = tc.denied
+			srv, err := fakeapi.New(state)
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			hs := httptest.NewServer(srv)
+			defer hs.Close()
+
+			o := &migrateOptions{
+				projectID:                  "test-project",
+				endpoints:                  convert.Endpoints{Compute: hs.URL, Container: hs.URL + "/", ResourceManager: hs.URL + "/"},
+				selectedNetwork:            "legacy-network",
+				desiredControlPlaneVersion: "1.20.7-gke.1800",
+				validateOnly:               tc.validateOnly,
+			}
+			o.setDefaults()
+			o.pollingInterval = time.Millisecond
+			err = o.Complete(context.Background())
+			if diff := test.ErrorDiff(tc.wantCompleteErr, err); diff != "" {
+				t.Fatalf("migrateOptions.Complete diff (-want +got):\n%s", diff)
+			}
+			if err != nil {
+				return
+			}
+			err = o.Run(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("migrateOptions.Run diff (-want +got):\n%s", diff)
+			}
+			if tc.wantErr == "" {
+				return
+			}
+			got, err := srv.State()
+			if err != nil {
+				t.Fatalf("State unexpected error: %v", err)
+			}
+			if got.Networks[0].IPv4Range == "" {
+				t.Errorf("Network was converted despite missing permissions")
+			}
+		})
+	}
+}
+
+func TestFakeAPI_MissingZones(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakeapi")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(path, []byte(fakeState), 0644); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+
+	cases := []struct {
+		desc              string
+		script            fakeapi.Script
+		locations         []string
+		allowMissingZones bool
+		wantErr           string
+		wantConverted     bool
+		wantUpgraded      bool
+	}{
+		{
+			desc:          "Missing zone listed individually",
+			script:        fakeapi.Script{MissingZones: []string{"us-central1-a"}},
+			wantConverted: true,
+			wantUpgraded:  true,
+		},
+		{
+			desc:    "Unavailable zone",
+			script:  fakeapi.Script{UnavailableZones: []string{"us-central1-a"}},
+			wantErr: "validation error for network projects/test-project/global/networks/legacy-network: unable to list Clusters in zones us-central1-a",
+		},
+		{
+			desc:              "Unavailable zone allowed",
+			script:            fakeapi.Script{UnavailableZones: []string{"us-central1-a"}},
+			allowMissingZones: true,
+			wantConverted:     true,
+		},
+		{
+			desc:          "Locations",
+			locations:     []string{"us-central1-a"},
+			wantConverted: true,
+			wantUpgraded:  true,
+		},
+		{
+			desc:      "Cluster in other location",
+			locations: []string{"us-east1"},
+			wantErr:   "validation error for network projects/test-project/global/networks/legacy-network: Clusters projects/test-project/locations/us-central1-a/clusters/cluster-a are on the network but not in locations us-east1",
+		},
+		{
+			desc:              "Cluster in other location allowed",
+			locations:         []string{"us-east1"},
+			allowMissingZones: true,
+			wantConverted:     true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			state, err := fakeapi.LoadState(path)
+			if err != nil {
+				t.Fatalf("LoadState unexpected error: %v", err)
+			}
+			state.Script.MissingZones = tc.script.MissingZones
+			state.Script.UnavailableZones = tc.script.UnavailableZones
+			srv, err := fakeapi.New(state)
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			hs := httptest.NewServer(srv)
+			defer hs.Close()
+
+			o := &migrateOptions{
+				projectID:                  "test-project",
+				endpoints:                  convert.Endpoints{Compute: hs.URL, Container: hs.URL + "/", ResourceManager: hs.URL + "/"},
+				selectedNetwork:            "legacy-network",
+				locations:                  tc.locations,
+				allowMissingZones:          tc.allowMissingZones,
+				desiredControlPlaneVersion: "1.20.7-gke.1800",
+			}
+			o.setDefaults()
+			o.pollingInterval = time.Millisecond
+			if err := o.Complete(context.Background()); err != nil {
+				t.Fatalf("migrateOptions.Complete unexpected error: %v", err)
+			}
+			err = o.Run(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("migrateOptions.Run diff (-want +got):\n%s", diff)
+			}
+
+			got, err := srv.State()
+			if err != nil {
+				t.Fatalf("State unexpected error: %v", err)
+			}
+			if converted := got.Networks[0].IPv4Range == ""; converted != tc.wantConverted {
+				t.Errorf("Network converted: %t, want: %t", converted, tc.wantConverted)
+			}
+			if upgraded := got.Clusters[0].Subnetwork != ""; upgraded != tc.wantUpgraded {
+				t.Errorf("Cluster upgraded: %t, want: %t", upgraded, tc.wantUpgraded)
+			}
+		})
+	}
+}
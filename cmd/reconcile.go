@@ -0,0 +1,191 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/controller"
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/operations"
+	"legacymigration/pkg/ratelimit"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	specFlag              = "spec"
+	reconcileIntervalFlag = "reconcile-interval"
+	onceFlag              = "once"
+)
+
+type reconcileOptions struct {
+	specPath    string
+	interval    time.Duration
+	once        bool
+	endpoints   convert.Endpoints
+	credentials convert.Credentials
+	rateLimits  ratelimit.Limits
+
+	// clients are initialized once and reused by every conversion and observation.
+	clients *pkg.Clients
+
+	// Fields used for faking clients during tests.
+	fetchClientFunc fetchClientFunc
+	authClientFunc  authClientFunc
+}
+
+func newReconcileCmd() *cobra.Command {
+	o := reconcileOptions{
+		fetchClientFunc: convert.NewClients,
+		authClientFunc:  convert.DefaultClient,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Reconcile a declarative NetworkConversion spec until the network and its clusters are converted.",
+		Long: `Reconcile a declarative NetworkConversion spec until the network and its clusters are converted.
+
+The spec file is YAML (or JSON), e.g.:
+
+  apiVersion: gkeconvert/v1alpha1
+  kind: NetworkConversion
+  metadata:
+    name: my-network
+  spec:
+    projectId: my-project
+    network: my-network
+    controlPlaneVersion: "1.20"
+    nodeVersion: "-"
+
+The conversion is run until the network is a VPC network, all clusters have a subnetwork
+and no node pools require an upgrade. Status conditions are written to the spec file.`,
+
+		PreRun: func(cmd *cobra.Command, args []string) {
+			cobra.CheckErr(o.ValidateFlags())
+			setupCloseHandler(cancel, nil)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cobra.CheckErr(o.Run(ctx))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.specPath, specFlag, o.specPath, "Path to the NetworkConversion spec file.")
+	flags.DurationVar(&o.interval, reconcileIntervalFlag, 5*time.Minute, "Period between reconcile attempts.")
+	flags.BoolVar(&o.once, onceFlag, false, "Reconcile once rather than until the conversion is complete.")
+	addCredentialsFlags(flags, &o.credentials)
+	addRateLimitFlags(flags, &o.rateLimits)
+	flags.StringVar(&o.endpoints.Container, containerBasePathFlag, o.endpoints.Container, "Custom URL for the container API endpoint (for testing).")
+	flags.StringVar(&o.endpoints.Compute, computeBasePathFlag, o.endpoints.Compute, "Custom URL for the compute API endpoint (for testing).")
+	flags.StringVar(&o.endpoints.ResourceManager, resourceManagerBasePathFlag, o.endpoints.ResourceManager, "Custom URL for the Resource Manager API endpoint (for testing).")
+
+	cmd.MarkFlagRequired(specFlag)
+	flags.MarkHidden(containerBasePathFlag)
+	flags.MarkHidden(computeBasePathFlag)
+	flags.MarkHidden(resourceManagerBasePathFlag)
+
+	return cmd
+}
+
+// ValidateFlags ensures flags values are valid for execution.
+func (o *reconcileOptions) ValidateFlags() error {
+	if o.specPath == "" {
+		return fmt.Errorf("--%s not provided or empty", specFlag)
+	}
+	if o.interval < time.Minute {
+		return fmt.Errorf("--%s must greater than or equal to 1 minute", reconcileIntervalFlag)
+	}
+	return nil
+}
+
+// Run reconciles the spec.
+func (o *reconcileOptions) Run(ctx context.Context) error {
+	if err := o.initClients(ctx); err != nil {
+		return err
+	}
+	c := controller.New(&controller.FileStore{Path: o.specPath}, o.convert, o.observe, o.interval)
+	if !o.once {
+		return c.Run(ctx)
+	}
+	_, err := c.Reconcile(ctx)
+	return err
+}
+
+// convert implements controller.Runner.
+func (o *reconcileOptions) convert(ctx context.Context, spec controller.Spec) error {
+	opts := o.migrateOptions(spec)
+	if err := opts.ValidateFlags(); err != nil {
+		return err
+	}
+	if err := opts.Complete(ctx); err != nil {
+		return err
+	}
+	return opts.Run(ctx)
+}
+
+// observe implements controller.Observer.
+func (o *reconcileOptions) observe(ctx context.Context, spec controller.Spec) (*controller.Observation, error) {
+	return controller.Observe(ctx, o.clients, spec)
+}
+
+// initClients initializes the API clients shared by all reconcile attempts.
+func (o *reconcileOptions) initClients(ctx context.Context) error {
+	opts := &migrateOptions{
+		endpoints:       o.endpoints,
+		credentials:     o.credentials,
+		rateLimits:      o.rateLimits,
+		fetchClientFunc: o.fetchClientFunc,
+		authClientFunc:  o.authClientFunc,
+	}
+	if err := opts.initClients(ctx); err != nil {
+		return err
+	}
+	o.clients = opts.clients
+	return nil
+}
+
+// migrateOptions converts a Spec to migrateOptions, applying the flag defaults.
+func (o *reconcileOptions) migrateOptions(spec controller.Spec) *migrateOptions {
+	opts := &migrateOptions{
+		projectID:                  spec.ProjectID,
+		endpoints:                  o.endpoints,
+		credentials:                o.credentials,
+		rateLimits:                 o.rateLimits,
+		selectedNetwork:            spec.Network,
+		selectedClusters:           spec.Clusters,
+		locations:                  spec.Locations,
+		allowMissingZones:          spec.AllowMissingZones,
+		concurrentClusters:         spec.ConcurrentClusters,
+		desiredControlPlaneVersion: spec.ControlPlaneVersion,
+		desiredNodeVersion:         spec.NodeVersion,
+		inPlaceControlPlaneUpgrade: spec.InPlaceControlPlaneUpgrade,
+		autoVersion:                spec.AutoVersion,
+		validateOnly:               false,
+		pollingInterval:            time.Duration(spec.PollingInterval),
+		pollingDeadline:            time.Duration(spec.PollingDeadline),
+		conflictRetries:            operations.DefaultConflictRetries,
+		clients:                    o.clients,
+		fetchClientFunc:            o.fetchClientFunc,
+		authClientFunc:             o.authClientFunc,
+	}
+	opts.setDefaults()
+	return opts
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/clusters"
+	"legacymigration/pkg/controller"
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/ratelimit"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReconcileOptions_ValidateFlags(t *testing.T) {
+	cases := []struct {
+		desc string
+		opts reconcileOptions
+		want string
+	}{
+		{
+			desc: "Valid",
+			opts: reconcileOptions{specPath: "spec.yaml", interval: time.Minute},
+		},
+		{
+			desc: "Missing spec",
+			opts: reconcileOptions{interval: time.Minute},
+			want: "--spec not provided or empty",
+		},
+		{
+			desc: "Interval too low",
+			opts: reconcileOptions{specPath: "spec.yaml", interval: time.Second},
+			want: "--reconcile-interval must greater than or equal to 1 minute",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := tc.opts.ValidateFlags()
+			if diff := test.ErrorDiff(tc.want, got); diff != "" {
+				t.Errorf("reconcileOptions.ValidateFlags diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReconcileOptions_MigrateOptions(t *testing.T) {
+	o := &reconcileOptions{fetchClientFunc: testClientFunc, authClientFunc: testAuthClientFunc}
+
+	got := o.migrateOptions(controller.Spec{
+		ProjectID:           test.ProjectName,
+		Network:             test.SelectedNetwork,
+		Clusters:            []string{test.ClusterName},
+		ControlPlaneVersion: clusters.DefaultVersion,
+	})
+	if got.validateOnly {
+		t.Errorf("reconcileOptions.migrateOptions must not be validate-only")
+	}
+	if diff := cmp.Diff([]string{test.ClusterName}, got.selectedClusters); diff != "" {
+		t.Errorf("selectedClusters diff (-want +got):\n%s", diff)
+	}
+	if err := got.ValidateFlags(); err != nil {
+		t.Errorf("reconcileOptions.migrateOptions produced invalid options: %v", err)
+	}
+}
+
+func TestReconcileOptions_Observe(t *testing.T) {
+	var built int
+	o := &reconcileOptions{
+		fetchClientFunc: func(ctx context.Context, endpoints convert.Endpoints, authedClient *http.Client, limits ratelimit.Limits) (*pkg.Clients, error) {
+			built++
+			return testClientFunc(ctx, endpoints, authedClient, limits)
+		},
+		authClientFunc: testAuthClientFunc,
+	}
+	if err := o.initClients(context.Background()); err != nil {
+		t.Fatalf("reconcileOptions.initClients unexpected error: %v", err)
+	}
+
+	want := &controller.Observation{
+		NetworkConverted: true,
+		PendingClusters:  []string{"projects/test-project/locations/region-a/clusters/cluster-c"},
+		PendingNodePools: []string{"projects/test-project/locations/region-a/clusters/cluster-c/nodePools/default-pool"},
+	}
+	// The clients are reused by each observation.
+	for i := 0; i < 2; i++ {
+		got, err := o.observe(context.Background(), controller.Spec{
+			ProjectID: test.ProjectName,
+			Network:   test.SelectedNetwork,
+		})
+		if err != nil {
+			t.Fatalf("reconcileOptions.observe unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("reconcileOptions.observe diff (-want +got):\n%s", diff)
+		}
+	}
+	if built != 1 {
+		t.Errorf("reconcileOptions clients built %d times; wanted: 1", built)
+	}
+	if got := o.migrateOptions(controller.Spec{}).clients; got != o.clients {
+		t.Errorf("reconcileOptions.migrateOptions must reuse the reconciler's clients")
+	}
+}
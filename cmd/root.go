@@ -17,67 +17,138 @@ package cmd
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/cassette"
 	"legacymigration/pkg/clusters"
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/faults"
 	"legacymigration/pkg/migrate"
-	"legacymigration/pkg/networks"
 	"legacymigration/pkg/operations"
+	"legacymigration/pkg/ratelimit"
 
-	"github.com/hashicorp/go-retryablehttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"golang.org/x/oauth2/google"
-	computebeta "google.golang.org/api/compute/v0.beta"
-	"google.golang.org/api/compute/v1"
-	"google.golang.org/api/container/v1"
-	"google.golang.org/api/option"
+	"github.com/spf13/pflag"
+	"go.uber.org/multierr"
 )
 
 const (
 	// Flag name constants.
 	projectFlag                    = "project"
 	containerBasePathFlag          = "container-base-url"
+	computeBasePathFlag            = "compute-base-url"
+	resourceManagerBasePathFlag    = "resource-manager-base-url"
 	networkFlag                    = "network"
 	concurrentClustersFlag         = "concurrent-clusters"
 	desiredControlPlaneVersionFlag = "control-plane-version"
 	desiredNodeVersionFlag         = "node-version"
 	pollingIntervalFlag            = "polling-interval"
 	pollingDeadlineFlag            = "polling-deadline"
+	pollingStrategyFlag            = "polling-strategy"
+	networkDeadlineFlag            = "network-polling-deadline"
+	controlPlaneDeadlineFlag       = "control-plane-polling-deadline"
+	nodePoolDeadlineFlag           = "node-pool-polling-deadline"
+	perNodeDeadlineFlag            = "per-node-polling-deadline"
+	stallPeriodFlag                = "stall-period"
+	failOnStallFlag                = "fail-on-stall"
+	maxPollingIntervalFlag         = "max-polling-interval"
 	inPlaceControlPlaneUpgradeFlag = "in-place-control-plane"
+	autoVersionFlag                = "auto-version"
 	validateOnlyFlag               = "validate-only"
-
-	ConcurrentNetworks  = 1
-	ConcurrentNodePools = 1
+	interactiveFlag                = "interactive"
+	clustersFlag                   = "clusters"
+	locationsFlag                  = "locations"
+	allowMissingZonesFlag          = "allow-missing-zones"
+	execHookFlag                   = "exec-hook"
+	conflictRetriesFlag            = "conflict-retries"
+	conflictBackoffFlag            = "conflict-backoff"
+	maintenanceExclusionFlag       = "maintenance-exclusion"
+	cancelOnAbortFlag              = "cancel-on-abort"
+	stateFileFlag                  = "state-file"
+	recordCassetteFlag             = "record-cassette"
+	replayCassetteFlag             = "replay-cassette"
+	faultConfigFlag                = "fault-config"
+	credentialsFileFlag            = "credentials-file"
+	accessTokenEnvFlag             = "access-token-env"
+	impersonateFlag                = "impersonate-service-account"
+	skipPermissionCheckFlag        = "skip-permission-check"
+	computeReadRateFlag            = "compute-read-rate"
+	computeWriteRateFlag           = "compute-write-rate"
+	containerReadRateFlag          = "container-read-rate"
+	containerWriteRateFlag         = "container-write-rate"
+
+	// cancelTimeout is the time allowed to cancel operations after an abort.
+	cancelTimeout = time.Minute
 )
 
+type fetchClientFunc func(ctx context.Context, endpoints convert.Endpoints, authedClient *http.Client, limits ratelimit.Limits) (*pkg.Clients, error)
+
+type authClientFunc func(ctx context.Context, endpoints convert.Endpoints, creds convert.Credentials) (*http.Client, error)
+
 type migrateOptions struct {
 	// Options set by flags.
 	projectID                  string
-	containerBasePath          string
+	endpoints                  convert.Endpoints
+	credentials                convert.Credentials
+	skipPermissionCheck        bool
+	rateLimits                 ratelimit.Limits
 	selectedNetwork            string
+	selectedClusters           []string
+	locations                  []string
+	allowMissingZones          bool
 	concurrentClusters         uint16
 	desiredControlPlaneVersion string
 	desiredNodeVersion         string
 	inPlaceControlPlaneUpgrade bool
+	autoVersion                bool
 	validateOnly               bool
+	interactive                bool
+	execHooks                  []string
 	pollingInterval            time.Duration
 	pollingDeadline            time.Duration
-
-	// Field used for faking clients during tests.
-	fetchClientFunc func(ctx context.Context, basePath string, authedClient *http.Client) (*pkg.Clients, error)
+	pollingStrategies          []string
+	networkDeadline            time.Duration
+	controlPlaneDeadline       time.Duration
+	nodePoolDeadline           time.Duration
+	perNodeDeadline            time.Duration
+	stallPeriod                time.Duration
+	failOnStall                bool
+	maxPollingInterval         time.Duration
+	conflictRetries            int
+	conflictBackoff            time.Duration
+	maintenanceExclusion       time.Duration
+	cancelOnAbort              bool
+	stateFile                  string
+	recordCassette             string
+	replayCassette             string
+	faultConfig                string
+
+	// stop is closed to stop launching new operations; nil if unused.
+	stop chan struct{}
+
+	// Fields used for faking clients during tests.
+	fetchClientFunc fetchClientFunc
+	authClientFunc  authClientFunc
+
+	// Streams used for interactive approval prompts.
+	in  io.Reader
+	out io.Writer
 
 	// Options set during Complete
 	clients   *pkg.Clients
-	migrators []migrate.Migrator
+	recorder  *cassette.Recorder
+	injector  *faults.Injector
+	converter *convert.Converter
 }
 
 var (
@@ -87,7 +158,9 @@ var (
 // rootCmd represents the base command when called without any subcommands
 func newRootCmd() *cobra.Command {
 	o := migrateOptions{
-		fetchClientFunc: fetchClients,
+		fetchClientFunc: convert.NewClients,
+		authClientFunc:  convert.DefaultClient,
+		stop:            make(chan struct{}),
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -99,8 +172,10 @@ the network to custom subnet mode). It then performs GKE cluster upgrades to ens
 the clusters are compatible with a VPC network.`,
 
 		PreRun: func(cmd *cobra.Command, args []string) {
+			o.in = cmd.InOrStdin()
+			o.out = cmd.OutOrStdout()
 			cobra.CheckErr(o.ValidateFlags())
-			setupCloseHandler(cancel)
+			setupCloseHandler(cancel, func() { close(o.stop) })
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cobra.CheckErr(o.Complete(ctx))
@@ -114,13 +189,43 @@ the clusters are compatible with a VPC network.`,
 
 	// Target network options.
 	flags.StringVarP(&o.selectedNetwork, networkFlag, "n", o.selectedNetwork, "GCE network to process.")
+	flags.StringSliceVar(&o.selectedClusters, clustersFlag, o.selectedClusters, "Names of the clusters on the network to upgrade. All clusters are upgraded if not provided.")
+	flags.StringSliceVar(&o.locations, locationsFlag, o.locations,
+		"Zones and regions in which to list clusters. Clusters are listed in all locations if not provided. A legacy network with clusters in other locations is not converted, unless --allow-missing-zones is set.")
+	flags.BoolVar(&o.allowMissingZones, allowMissingZonesFlag, false,
+		"Convert the network even if clusters could not be listed in some zones, or are in locations other than --locations. Those clusters are not upgraded.")
 
 	// Concurrency options.
-	flags.Uint16VarP(&o.concurrentClusters, concurrentClustersFlag, "C", 1, "Number of clusters per network to upgrade concurrently.")
+	flags.Uint16VarP(&o.concurrentClusters, concurrentClustersFlag, "C", convert.DefaultConcurrentClusters, "Number of clusters per network to upgrade concurrently.")
 
 	// Polling options.
-	flags.DurationVar(&o.pollingInterval, pollingIntervalFlag, 15*time.Second, "Period between polling attempts.")
-	flags.DurationVar(&o.pollingDeadline, pollingDeadlineFlag, 24*time.Hour, "Deadline for a long running operation to complete (e.g. to upgrade a cluster node pool).")
+	flags.DurationVar(&o.pollingInterval, pollingIntervalFlag, convert.DefaultPollingInterval, "Period between polling attempts.")
+	flags.DurationVar(&o.pollingDeadline, pollingDeadlineFlag, convert.DefaultPollingDeadline, "Deadline for a long running operation to complete (e.g. to upgrade a cluster node pool).")
+	flags.DurationVar(&o.networkDeadline, networkDeadlineFlag, 0, "Deadline for network operations to complete. Defaults to --polling-deadline.")
+	flags.DurationVar(&o.controlPlaneDeadline, controlPlaneDeadlineFlag, 0, "Deadline for control plane upgrades to complete. Defaults to --polling-deadline.")
+	flags.DurationVar(&o.nodePoolDeadline, nodePoolDeadlineFlag, 0, "Deadline for node pool upgrades to complete. Defaults to --polling-deadline.")
+	flags.DurationVar(&o.perNodeDeadline, perNodeDeadlineFlag, 0,
+		`Additional deadline per node for node pool upgrades. The node count is the larger of the node pool's
+initial node count and its autoscaling maximum, multiplied by its number of zones.`)
+	flags.DurationVar(&o.stallPeriod, stallPeriodFlag, 0,
+		`Period without progress after which an operation is reported as stalled (e.g. a node pool upgrade blocked
+by a PodDisruptionBudget). Disabled if zero.`)
+	flags.BoolVar(&o.failOnStall, failOnStallFlag, false, fmt.Sprintf("Fail the upgrade of a resource when its operation stalls. Requires --%s.", stallPeriodFlag))
+	flags.StringSliceVar(&o.pollingStrategies, pollingStrategyFlag, o.pollingStrategies,
+		`Polling strategy for long running operations: fixed, exponential or eta.
+Use <TYPE>=<STRATEGY> to select the strategy for an operation type (network, control-plane or node-pool),
+e.g. --polling-strategy=exponential,node-pool=eta. Defaults to fixed.`)
+	flags.DurationVar(&o.maxPollingInterval, maxPollingIntervalFlag, convert.DefaultMaxPollingInterval, "Maximum period between polling attempts for the exponential and eta polling strategies.")
+
+	// Conflict options.
+	flags.IntVar(&o.conflictRetries, conflictRetriesFlag, operations.DefaultConflictRetries,
+		`Number of times to retry an upgrade which conflicts with another operation in progress (e.g. an auto-upgrade).
+The conflicting operation is waited on before retrying, if reported.`)
+	flags.DurationVar(&o.conflictBackoff, conflictBackoffFlag, operations.DefaultConflictBackoff,
+		fmt.Sprintf("Initial delay before retrying an upgrade which conflicts with an unreported operation. Doubles after each retry, up to --%s.", maxPollingIntervalFlag))
+	flags.DurationVar(&o.maintenanceExclusion, maintenanceExclusionFlag, 0,
+		fmt.Sprintf(`Duration of a maintenance exclusion added to each cluster while it is upgraded, preventing auto-upgrades
+from starting. Removed once the cluster is converted. Disabled if 0; must not exceed %v.`, clusters.MaxMaintenanceExclusion))
 
 	// Cluster upgrade options.
 	flags.StringVar(&o.desiredControlPlaneVersion, desiredControlPlaneVersionFlag, o.desiredControlPlaneVersion,
@@ -136,25 +241,140 @@ Note:
 	flags.BoolVar(&o.inPlaceControlPlaneUpgrade, inPlaceControlPlaneUpgradeFlag, false,
 		`Perform in-place control plane upgrade for all clusters.`)
 
+	flags.BoolVar(&o.autoVersion, autoVersionFlag, false,
+		fmt.Sprintf(`Select, per cluster, the lowest version in its release channel which is a valid upgrade for its control plane
+and node pools. The choice is explained in the logs. Cannot be combined with --%s, --%s or --%s.`,
+			desiredControlPlaneVersionFlag, desiredNodeVersionFlag, inPlaceControlPlaneUpgradeFlag))
+
 	flags.BoolVar(&o.validateOnly, validateOnlyFlag, true,
 		`Only run validation on the network and cluster resources; do not perform conversion`)
 
+	flags.BoolVar(&o.interactive, interactiveFlag, false,
+		`Prompt to approve, skip, or abort before converting the network and before upgrading each control plane and node pool.`)
+
+	flags.StringArrayVar(&o.execHooks, execHookFlag, o.execHooks,
+		`Command to run with sh -c before and after each phase of every network, cluster and node pool, with the event as JSON on stdin.
+A non-zero exit status before a phase aborts that phase. May be repeated.`)
+
+	// Abort options.
+	flags.BoolVar(&o.cancelOnAbort, cancelOnAbortFlag, false,
+		`Cancel running control plane and node pool upgrades if the conversion is aborted.
+The first interrupt stops new operations from starting; a second interrupt stops waiting on running operations.`)
+	flags.StringVar(&o.stateFile, stateFileFlag, o.stateFile, "Write the final state of the conversion, including any operations left running, to this file as JSON.")
+
+	// Credential options.
+	addCredentialsFlags(flags, &o.credentials)
+	flags.BoolVar(&o.skipPermissionCheck, skipPermissionCheckFlag, false,
+		"Skip testing that the caller has every permission required by the run before it starts.")
+
+	// Rate limit options.
+	addRateLimitFlags(flags, &o.rateLimits)
+
 	// Test options.
-	flags.StringVar(&o.containerBasePath, containerBasePathFlag, o.containerBasePath, "Custom URL for the container API endpoint (for testing).")
+	flags.StringVar(&o.endpoints.Container, containerBasePathFlag, o.endpoints.Container, "Custom URL for the container API endpoint (for testing).")
+	flags.StringVar(&o.endpoints.Compute, computeBasePathFlag, o.endpoints.Compute, "Custom URL for the compute API endpoint (for testing).")
+	flags.StringVar(&o.endpoints.ResourceManager, resourceManagerBasePathFlag, o.endpoints.ResourceManager, "Custom URL for the Resource Manager API endpoint (for testing).")
+	flags.StringVar(&o.recordCassette, recordCassetteFlag, o.recordCassette,
+		`Record API requests and responses to this cassette file, with the project ID redacted (e.g. during a validate-only run).`)
+	flags.StringVar(&o.replayCassette, replayCassetteFlag, o.replayCassette,
+		`Replay API responses from this cassette file instead of calling the APIs (for testing).`)
+	flags.StringVar(&o.faultConfig, faultConfigFlag, o.faultConfig, "Inject the faults configured in this JSON file into API calls (for testing).")
+
+	cmd.AddCommand(newServeCmd())
+	cmd.AddCommand(newReconcileCmd())
+	cmd.AddCommand(newFakeAPICmd())
 
 	cmd.MarkFlagRequired(projectFlag)
 	cmd.MarkFlagRequired(networkFlag)
 	flags.MarkHidden(containerBasePathFlag)
+	flags.MarkHidden(computeBasePathFlag)
+	flags.MarkHidden(resourceManagerBasePathFlag)
+	flags.MarkHidden(faultConfigFlag)
 
 	return cmd
 }
 
+// addCredentialsFlags adds the flags selecting the credentials used to call the APIs.
+func addCredentialsFlags(flags *pflag.FlagSet, creds *convert.Credentials) {
+	flags.StringVar(&creds.File, credentialsFileFlag, creds.File,
+		"Service account key file to use instead of Application Default Credentials.")
+	flags.StringVar(&creds.AccessTokenEnv, accessTokenEnvFlag, creds.AccessTokenEnv,
+		"Name of an environment variable holding an OAuth2 access token to use instead of Application Default Credentials.")
+	flags.StringVar(&creds.ImpersonateServiceAccount, impersonateFlag, creds.ImpersonateServiceAccount,
+		"Email of a service account to impersonate. The credentials must be granted roles/iam.serviceAccountTokenCreator on it.")
+}
+
+// addRateLimitFlags adds the flags limiting the rate of requests per API.
+func addRateLimitFlags(flags *pflag.FlagSet, limits *ratelimit.Limits) {
+	flags.Float64Var(&limits.ComputeRead, computeReadRateFlag, ratelimit.Default.ComputeRead,
+		"Maximum rate of compute API reads, in requests per second, shared by all clusters and node pools. 0 is unlimited.")
+	flags.Float64Var(&limits.ComputeWrite, computeWriteRateFlag, ratelimit.Default.ComputeWrite,
+		"Maximum rate of compute API writes, in requests per second. 0 is unlimited.")
+	flags.Float64Var(&limits.ContainerRead, containerReadRateFlag, ratelimit.Default.ContainerRead,
+		"Maximum rate of container API reads, in requests per second, shared by all clusters and node pools. 0 is unlimited.")
+	flags.Float64Var(&limits.ContainerWrite, containerWriteRateFlag, ratelimit.Default.ContainerWrite,
+		"Maximum rate of container API writes, in requests per second. 0 is unlimited.")
+}
+
 // Execute runs the root command.
 func Execute() {
 	cobra.CheckErr(rootCmd.Execute())
 }
 
+// setDefaults applies the flag defaults to unset options.
+// Used when options are not populated from flags (e.g. for server jobs).
+func (o *migrateOptions) setDefaults() {
+	if o.concurrentClusters == 0 {
+		o.concurrentClusters = convert.DefaultConcurrentClusters
+	}
+	if o.desiredNodeVersion == "" {
+		o.desiredNodeVersion = clusters.DefaultVersion
+	}
+	if o.pollingInterval == 0 {
+		o.pollingInterval = convert.DefaultPollingInterval
+	}
+	if o.pollingDeadline == 0 {
+		o.pollingDeadline = convert.DefaultPollingDeadline
+	}
+	if o.maxPollingInterval == 0 {
+		o.maxPollingInterval = convert.DefaultMaxPollingInterval
+	}
+	if o.fetchClientFunc == nil {
+		o.fetchClientFunc = convert.NewClients
+	}
+	if o.authClientFunc == nil {
+		o.authClientFunc = convert.DefaultClient
+	}
+}
+
+// flagNames names the convert.Options fields set by flags after those flags in validation errors.
+var flagNames = convert.FieldNames{
+	"ProjectID":                       "--" + projectFlag,
+	"Network":                         "--" + networkFlag,
+	"ConcurrentClusters":              "--" + concurrentClustersFlag,
+	"Locations":                       "--" + locationsFlag,
+	"PollingInterval":                 "--" + pollingIntervalFlag,
+	"PollingDeadline":                 "--" + pollingDeadlineFlag,
+	"PollingDeadlines.Default":        "--" + pollingDeadlineFlag,
+	"PollingDeadlines.PerNode":        "--" + perNodeDeadlineFlag,
+	"PollingDeadlines[network]":       "--" + networkDeadlineFlag,
+	"PollingDeadlines[control-plane]": "--" + controlPlaneDeadlineFlag,
+	"PollingDeadlines[node-pool]":     "--" + nodePoolDeadlineFlag,
+	"StallPeriod":                     "--" + stallPeriodFlag,
+	"FailOnStall":                     "--" + failOnStallFlag,
+	"ConflictRetry.Retries":           "--" + conflictRetriesFlag,
+	"MaintenanceExclusion":            "--" + maintenanceExclusionFlag,
+	"Credentials":                     fmt.Sprintf("--%s, --%s and --%s", credentialsFileFlag, accessTokenEnvFlag, impersonateFlag),
+	"RateLimits":                      "rate limit flags",
+	"AutoVersion":                     "--" + autoVersionFlag,
+	"ControlPlaneVersion":             "--" + desiredControlPlaneVersionFlag,
+	"NodeVersion":                     "--" + desiredNodeVersionFlag,
+	"InPlaceControlPlaneUpgrade":      "--" + inPlaceControlPlaneUpgradeFlag,
+}
+
 // ValidateFlags ensures flags values are valid for execution.
+// Checks specific to flags are made here; the resulting convert.Options are then validated by
+// convert.Options.ValidateNames, naming the flags which set them.
 func (o *migrateOptions) ValidateFlags() error {
 	if o.projectID == "" {
 		return fmt.Errorf("--%s not provided or empty", projectFlag)
@@ -163,11 +383,6 @@ func (o *migrateOptions) ValidateFlags() error {
 		return fmt.Errorf("--%s not provided or empty", networkFlag)
 	}
 
-	// Concurrency validation.
-	if o.concurrentClusters < 1 {
-		return fmt.Errorf("--%s must be an integer greater than 0", concurrentClustersFlag)
-	}
-
 	// Polling validation.
 	if o.pollingInterval < 10*time.Second {
 		return fmt.Errorf("--%s must greater than or equal to 10 seconds. Note: Upgrade operations times are O(minutes)", pollingIntervalFlag)
@@ -175,173 +390,259 @@ func (o *migrateOptions) ValidateFlags() error {
 	if o.pollingDeadline < 5*time.Minute {
 		return fmt.Errorf("--%s must greater than or equal to 5 minutes. Note: Upgrade operations times are O(minutes)", pollingDeadlineFlag)
 	}
-	if o.pollingInterval > o.pollingDeadline {
-		return fmt.Errorf("--%s=%v must be greater than --%s=%v", pollingDeadlineFlag, o.pollingDeadline, pollingIntervalFlag, o.pollingInterval)
+	for flag, d := range map[string]time.Duration{
+		networkDeadlineFlag:      o.networkDeadline,
+		controlPlaneDeadlineFlag: o.controlPlaneDeadline,
+		nodePoolDeadlineFlag:     o.nodePoolDeadline,
+	} {
+		if d != 0 && d < o.pollingInterval {
+			return fmt.Errorf("--%s=%v must be greater than --%s=%v", flag, d, pollingIntervalFlag, o.pollingInterval)
+		}
 	}
-
-	// Version validation.
-	if (o.desiredControlPlaneVersion == "" && !o.inPlaceControlPlaneUpgrade) ||
-		(o.desiredControlPlaneVersion != "" && o.inPlaceControlPlaneUpgrade) {
-		return fmt.Errorf("specify --%s or provide a version for --%s, but not both", inPlaceControlPlaneUpgradeFlag, desiredControlPlaneVersionFlag)
+	if len(o.pollingStrategies) > 0 && o.maxPollingInterval < o.pollingInterval {
+		return fmt.Errorf("--%s=%v must be greater than or equal to --%s=%v", maxPollingIntervalFlag, o.maxPollingInterval, pollingIntervalFlag, o.pollingInterval)
 	}
-	if o.desiredControlPlaneVersion != "" {
-		if err := clusters.IsFormatValid(o.desiredControlPlaneVersion); err != nil {
-			return fmt.Errorf("--%s=%q is not valid: %v", desiredControlPlaneVersionFlag, o.desiredControlPlaneVersion, err)
-		}
+	if o.conflictBackoff < 0 {
+		return fmt.Errorf("--%s must not be negative", conflictBackoffFlag)
 	}
-	if err := clusters.IsFormatValid(o.desiredNodeVersion); err != nil {
-		return fmt.Errorf("--%s=%q is not valid: %v", desiredNodeVersionFlag, o.desiredNodeVersion, err)
+	if o.recordCassette != "" && o.replayCassette != "" {
+		return fmt.Errorf("--%s cannot be combined with --%s", recordCassetteFlag, replayCassetteFlag)
 	}
-	// Use of `-` or `latest` aliases are validated later at the control plane and node pool level.
-	if !o.inPlaceControlPlaneUpgrade &&
-		o.desiredControlPlaneVersion != clusters.DefaultVersion &&
-		o.desiredControlPlaneVersion != clusters.LatestVersion &&
-		o.desiredNodeVersion != clusters.DefaultVersion &&
-		o.desiredNodeVersion != clusters.LatestVersion {
-		if err := clusters.IsWithinVersionSkew(o.desiredNodeVersion, o.desiredControlPlaneVersion, clusters.MaxVersionSkew); err != nil {
-			return err
+	for _, h := range o.execHooks {
+		if strings.TrimSpace(h) == "" {
+			return fmt.Errorf("--%s must not be empty", execHookFlag)
 		}
 	}
 
-	return nil
-}
-
-// Complete cascades down the resource hierarchy, ensuring that all descendent migrators are initialized.
-func (o *migrateOptions) Complete(ctx context.Context) error {
-	authedClient, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
+	opts, err := o.convertOptions()
 	if err != nil {
 		return err
 	}
+	return opts.ValidateNames(flagNames)
+}
 
-	o.clients, err = o.fetchClientFunc(ctx, o.containerBasePath, authedClient)
+// convertOptions returns the convert.Options for the flags, without the Approver and Clients.
+func (o *migrateOptions) convertOptions() (convert.Options, error) {
+	strategies, err := o.strategies()
 	if err != nil {
-		return err
+		return convert.Options{}, fmt.Errorf("--%s is not valid: %w", pollingStrategyFlag, err)
 	}
-
-	handler := operations.NewHandler(o.pollingInterval, o.pollingDeadline)
-	options := &clusters.Options{
-		ConcurrentNodePools:        ConcurrentNodePools,
-		DesiredControlPlaneVersion: o.desiredControlPlaneVersion,
-		DesiredNodeVersion:         o.desiredNodeVersion,
+	return convert.Options{
+		ProjectID:                  o.projectID,
+		Network:                    o.selectedNetwork,
+		Clusters:                   o.selectedClusters,
+		Locations:                  o.locations,
+		AllowMissingZones:          o.allowMissingZones,
+		ConcurrentClusters:         o.concurrentClusters,
+		ControlPlaneVersion:        o.desiredControlPlaneVersion,
+		NodeVersion:                o.desiredNodeVersion,
 		InPlaceControlPlaneUpgrade: o.inPlaceControlPlaneUpgrade,
+		AutoVersion:                o.autoVersion,
+		ValidateOnly:               o.validateOnly,
+		PollingInterval:            o.pollingInterval,
+		PollingDeadline:            o.pollingDeadline,
+		PollingDeadlines:           o.deadlines(),
+		PollingStrategies:          strategies,
+		StallPeriod:                o.stallPeriod,
+		FailOnStall:                o.failOnStall,
+		ConflictRetry:              o.conflictRetry(),
+		MaintenanceExclusion:       o.maintenanceExclusion,
+		Credentials:                o.credentials,
+		RateLimits:                 o.rateLimits,
+		Hooks:                      o.hooks(),
+		Stop:                       o.stop,
+		SkipPermissionCheck:        o.skipPermissionCheck,
+	}, nil
+}
+
+// Complete initializes the API clients, unless already set (e.g. by the reconciler), and the Converter for the flags.
+func (o *migrateOptions) Complete(ctx context.Context) error {
+	if o.clients == nil {
+		if err := o.initClients(ctx); err != nil {
+			return err
+		}
 	}
 
-	factory := func(n *compute.Network) migrate.Migrator {
-		return networks.New(o.projectID, n, handler, o.clients, o.concurrentClusters, options)
+	var approver approval.Approver = approval.AutoApprover{}
+	if o.interactive {
+		approver = approval.NewPrompter(o.in, o.out)
 	}
 
-	log.Infof("Fetching network %s for project %q", o.selectedNetwork, o.projectID)
+	opts, err := o.convertOptions()
+	if err != nil {
+		return err
+	}
+	opts.Approver = approver
+	opts.Clients = o.clients
 
-	ns, err := o.clients.Compute.ListNetworks(ctx, o.projectID)
+	c, err := convert.New(opts)
 	if err != nil {
-		return fmt.Errorf("error listing networks: %w", err)
+		return err
 	}
+	o.converter = c
+	return o.converter.Complete(ctx)
+}
 
-	o.migrators = make([]migrate.Migrator, 0)
-	for _, n := range ns {
-		if n.Name == o.selectedNetwork {
-			o.migrators = append(o.migrators, factory(n))
+// deadlines returns the polling deadlines for each operation type.
+func (o *migrateOptions) deadlines() operations.Deadlines {
+	d := operations.Deadlines{
+		Default: o.pollingDeadline,
+		ByType:  make(map[operations.OperationType]time.Duration),
+		PerNode: o.perNodeDeadline,
+	}
+	for t, deadline := range map[operations.OperationType]time.Duration{
+		operations.TypeNetwork:      o.networkDeadline,
+		operations.TypeControlPlane: o.controlPlaneDeadline,
+		operations.TypeNodePool:     o.nodePoolDeadline,
+	} {
+		if deadline > 0 {
+			d.ByType[t] = deadline
 		}
 	}
+	return d
+}
 
-	if len(o.migrators) == 0 {
-		return fmt.Errorf("unable to find network %s", o.selectedNetwork)
+// strategies returns the polling strategies selected by --polling-strategy.
+func (o *migrateOptions) strategies() (operations.Strategies, error) {
+	return operations.ParseStrategies(o.pollingStrategies, o.pollingInterval, o.maxPollingInterval)
+}
+
+// conflictRetry returns the retries for upgrades which conflict with another operation.
+func (o *migrateOptions) conflictRetry() operations.ConflictRetry {
+	backoff, max := o.conflictBackoff, o.maxPollingInterval
+	if backoff == 0 {
+		backoff = operations.DefaultConflictBackoff
+	}
+	if max == 0 {
+		max = convert.DefaultMaxPollingInterval
 	}
+	return operations.ConflictRetry{
+		Retries: o.conflictRetries,
+		Backoff: operations.NewExponential(backoff, max),
+	}
+}
 
-	return nil
+// hooks returns an ExecHook for each --exec-hook command, run by the shell
+// so that quoting, pipes and redirections behave as on the command line.
+func (o *migrateOptions) hooks() []migrate.Hook {
+	var hooks []migrate.Hook
+	for _, h := range o.execHooks {
+		hooks = append(hooks, migrate.NewExecHook("sh", "-c", h))
+	}
+	return hooks
 }
 
-// Run cascades down the resource hierarchy, initializing, validating, and converting all descendent migrators.
-func (o *migrateOptions) Run(ctx context.Context) error {
-	sem := make(chan struct{}, ConcurrentNetworks)
+// initClients initializes the API clients.
+// Requests are recorded with --record-cassette, and responses replayed without credentials with --replay-cassette.
+// Faults are injected into the calls of the clients, or into their HTTP requests, with --fault-config.
+func (o *migrateOptions) initClients(ctx context.Context) error {
+	var authedClient *http.Client
+	if o.replayCassette != "" {
+		c, err := cassette.Load(o.replayCassette)
+		if err != nil {
+			return err
+		}
+		authedClient = &http.Client{Transport: cassette.NewReplayer(c, o.redactor())}
+	} else {
+		var err error
+		authedClient, err = o.authClientFunc(ctx, o.endpoints, o.credentials)
+		if err != nil {
+			return err
+		}
+	}
+	if o.recordCassette != "" {
+		o.recorder = &cassette.Recorder{Transport: authedClient.Transport, Redactor: o.redactor()}
+		authedClient = &http.Client{Transport: o.recorder}
+	}
 
-	log.Info("Initialize objects for conversion.")
-	if err := migrate.Complete(ctx, sem, o.migrators...); err != nil {
-		return err
+	if o.faultConfig != "" {
+		config, err := faults.LoadConfig(o.faultConfig)
+		if err != nil {
+			return err
+		}
+		if o.injector, err = faults.New(config); err != nil {
+			return err
+		}
+		log.Warnf("Injecting faults from %s into API calls", o.faultConfig)
+		// Transport faults are injected below the retries of the clients, and are not recorded.
+		authedClient = &http.Client{Transport: o.injector.Transport(authedClient.Transport)}
 	}
 
-	log.Info("Validate resources for conversion.")
-	if err := migrate.Validate(ctx, sem, o.migrators...); err != nil {
+	var err error
+	o.clients, err = o.fetchClientFunc(ctx, o.endpoints, authedClient, o.rateLimits)
+	if err != nil {
 		return err
 	}
-
-	if o.validateOnly {
-		log.Infof("--%s==true; skipping conversion.", validateOnlyFlag)
-		return nil
+	if o.injector != nil {
+		o.clients = o.injector.Wrap(o.clients)
 	}
-
-	log.Info("Initiate resource conversion.")
-	return migrate.Migrate(ctx, sem, o.migrators...)
-}
-
-// setupCloseHandler cancels the shared context when the user hits ctrl-c.
-func setupCloseHandler(cancel context.CancelFunc) {
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		cancel()
-	}()
+	return nil
 }
 
-func getRetryableClientOption(retry int, waitMin, waitMax time.Duration, authedClient *http.Client) option.ClientOption {
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = retry
-	retryClient.RetryWaitMin = waitMin
-	retryClient.RetryWaitMax = waitMax
-	retryClient.Logger = nil
-	retryClient.CheckRetry = retryPolicy()
-
-	c := retryClient.StandardClient()
-	c.Transport.(*retryablehttp.RoundTripper).Client.HTTPClient = authedClient
-	return option.WithHTTPClient(c)
+// redactor returns the Redactor for recorded and replayed cassettes.
+func (o *migrateOptions) redactor() *cassette.Redactor {
+	if o.projectID == "" {
+		return nil
+	}
+	return cassette.NewRedactor(o.projectID, cassette.RedactedProject)
 }
 
-func fetchClients(ctx context.Context, basePath string, authedClient *http.Client) (*pkg.Clients, error) {
-	opt := getRetryableClientOption(3, 5*time.Second, 30*time.Second, authedClient)
-	computeService, err := compute.NewService(ctx, opt)
-	if err != nil {
-		return nil, err
-	}
-	containerService, err := container.NewService(ctx, opt)
-	if err != nil {
-		return nil, err
+// Run runs the Converter and reports any stalled operations.
+// If the conversion is aborted, running operations are cancelled when --cancel-on-abort is set.
+func (o *migrateOptions) Run(ctx context.Context) error {
+	if o.injector != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		o.injector.SetCancel(cancel)
+		defer func() { log.Infof("Injected %d faults", len(o.injector.Injected())) }()
 	}
 
-	// Retry for up-to 5 minutes for Compute Beta API calls.
-	betaOpt := getRetryableClientOption(5, 5*time.Second, 160*time.Second, authedClient)
-	computeServiceBeta, err := computebeta.NewService(ctx, betaOpt)
-	if err != nil {
-		return nil, err
+	result, err := o.converter.Run(ctx)
+	for _, s := range result.Stalls {
+		log.Warnf("Stalled during conversion: %s", s)
 	}
 
-	if basePath != "" {
-		containerService.BasePath = basePath
+	aborted := ctx.Err() != nil
+	if aborted && o.cancelOnAbort {
+		// The context is closed, so allow the cancellation requests time of their own.
+		cctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+		if _, cerr := o.converter.CancelOperations(cctx); cerr != nil {
+			err = multierr.Append(err, cerr)
+		}
+		result.Abandoned = o.converter.Abandoned()
 	}
-	return &pkg.Clients{
-		Compute: &pkg.Compute{
-			V1:    computeService,
-			Beta: computeServiceBeta,
-		},
-		Container: &pkg.Container{V1: containerService},
-	}, nil
-}
 
-func retryPolicy() func(ctx context.Context, resp *http.Response, err error) (bool, error) {
-	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
-		shouldRetry, newErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
-		if newErr != nil || shouldRetry {
-			return shouldRetry, newErr
+	state := newConversionState(result, aborted, err)
+	state.logSummary()
+	if o.stateFile != "" {
+		err = multierr.Append(err, state.write(o.stateFile))
+	}
+	if o.recorder != nil {
+		if serr := o.recorder.Cassette().Save(o.recordCassette); serr != nil {
+			err = multierr.Append(err, fmt.Errorf("error saving cassette: %w", serr))
+		} else {
+			log.Infof("Recorded API interactions to %s", o.recordCassette)
 		}
+	}
+	return err
+}
 
-		// GCE returns 403 as a RateLimiting response code.
-		if resp.StatusCode == 403 {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return true, err
-			}
-			return true, errors.New(string(body))
+// setupCloseHandler handles interrupts. The first interrupt calls stop, if set, to stop launching
+// new operations while running operations are waited on. Otherwise, or on a second interrupt,
+// the shared context is cancelled.
+func setupCloseHandler(cancel context.CancelFunc, stop func()) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		if stop != nil {
+			log.Info("Interrupted; no new operations will be started. Interrupt again to stop waiting on running operations.")
+			stop()
+			<-c
 		}
-		return false, nil
-	}
+		log.Info("Interrupted; stopping.")
+		cancel()
+	}()
 }
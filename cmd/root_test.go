@@ -18,18 +18,32 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"legacymigration/pkg"
 	"legacymigration/pkg/clusters"
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/faults"
 	"legacymigration/pkg/migrate"
+	"legacymigration/pkg/operations"
+	"legacymigration/pkg/ratelimit"
 	"legacymigration/test"
 
+	"github.com/google/go-cmp/cmp"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
 )
 
 func TestMigrateOptions_ValidateFlagsNoProject(t *testing.T) {
@@ -167,6 +181,141 @@ func TestMigrateOptions_ValidateFlags(t *testing.T) {
 			}(defaultOptions()),
 			want: `--node-version="x.y" is not valid`,
 		},
+		{
+			desc: "Operation type deadlines",
+			opts: func(o migrateOptions) migrateOptions {
+				o.networkDeadline = 15 * time.Minute
+				o.nodePoolDeadline = 6 * time.Hour
+				o.perNodeDeadline = 5 * time.Minute
+				return o
+			}(defaultOptions()),
+		},
+		{
+			desc: "Network deadline less than interval",
+			opts: func(o migrateOptions) migrateOptions {
+				o.networkDeadline = time.Minute
+				return o
+			}(defaultOptions()),
+			want: "--network-polling-deadline=1m0s must be greater than --polling-interval=10m0s",
+		},
+		{
+			desc: "Negative per node deadline",
+			opts: func(o migrateOptions) migrateOptions {
+				o.perNodeDeadline = -time.Minute
+				return o
+			}(defaultOptions()),
+			want: "--per-node-polling-deadline must not be negative",
+		},
+		{
+			desc: "Fail on stall without period",
+			opts: func(o migrateOptions) migrateOptions {
+				o.failOnStall = true
+				return o
+			}(defaultOptions()),
+			want: "--fail-on-stall requires a --stall-period",
+		},
+		{
+			desc: "Polling strategies",
+			opts: func(o migrateOptions) migrateOptions {
+				o.pollingStrategies = []string{"exponential", "node-pool=eta"}
+				o.maxPollingInterval = 15 * time.Minute
+				return o
+			}(defaultOptions()),
+		},
+		{
+			desc: "Unknown polling strategy",
+			opts: func(o migrateOptions) migrateOptions {
+				o.pollingStrategies = []string{"node-pool=linear"}
+				o.maxPollingInterval = 15 * time.Minute
+				return o
+			}(defaultOptions()),
+			want: `--polling-strategy is not valid: unknown polling strategy "linear"`,
+		},
+		{
+			desc: "Max polling interval too low",
+			opts: func(o migrateOptions) migrateOptions {
+				o.pollingStrategies = []string{"exponential"}
+				o.maxPollingInterval = time.Minute
+				return o
+			}(defaultOptions()),
+			want: "--max-polling-interval=1m0s must be greater than or equal to --polling-interval=10m0s",
+		},
+		{
+			desc: "Empty exec hook",
+			opts: func(o migrateOptions) migrateOptions {
+				o.execHooks = []string{"notify.sh", " "}
+				return o
+			}(defaultOptions()),
+			want: "--exec-hook must not be empty",
+		},
+		{
+			desc: "Negative conflict retries",
+			opts: func(o migrateOptions) migrateOptions {
+				o.conflictRetries = -1
+				return o
+			}(defaultOptions()),
+			want: "--conflict-retries must not be negative",
+		},
+		{
+			desc: "Auto version",
+			opts: func(o migrateOptions) migrateOptions {
+				o.desiredControlPlaneVersion = ""
+				o.autoVersion = true
+				return o
+			}(defaultOptions()),
+		},
+		{
+			desc: "Auto version with node version",
+			opts: func(o migrateOptions) migrateOptions {
+				o.desiredControlPlaneVersion = ""
+				o.desiredNodeVersion = "1.20"
+				o.autoVersion = true
+				return o
+			}(defaultOptions()),
+			want: "--auto-version cannot be combined with --control-plane-version, --node-version or --in-place-control-plane",
+		},
+		{
+			desc: "Maintenance exclusion too long",
+			opts: func(o migrateOptions) migrateOptions {
+				o.maintenanceExclusion = 31 * 24 * time.Hour
+				return o
+			}(defaultOptions()),
+			want: "--maintenance-exclusion must be between 0 and 720h0m0s",
+		},
+		{
+			desc: "All locations",
+			opts: func(o migrateOptions) migrateOptions {
+				o.locations = []string{"us-central1", "-"}
+				return o
+			}(defaultOptions()),
+			want: `--locations must be zones or regions; got: "-"`,
+		},
+		{
+			desc: "Negative rate limit",
+			opts: func(o migrateOptions) migrateOptions {
+				o.rateLimits.ComputeWrite = -1
+				return o
+			}(defaultOptions()),
+			want: "rate limit flags are not valid: compute write rate limit must be a non-negative number",
+		},
+		{
+			desc: "Record and replay cassettes",
+			opts: func(o migrateOptions) migrateOptions {
+				o.recordCassette = "out.json"
+				o.replayCassette = "in.json"
+				return o
+			}(defaultOptions()),
+			want: "--record-cassette cannot be combined with --replay-cassette",
+		},
+		{
+			desc: "Credentials file and access token",
+			opts: func(o migrateOptions) migrateOptions {
+				o.credentials.File = "key.json"
+				o.credentials.AccessTokenEnv = "TOKEN"
+				return o
+			}(defaultOptions()),
+			want: "--credentials-file, --access-token-env and --impersonate-service-account are not valid: a credentials file and an access token cannot both be used",
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -201,14 +350,22 @@ func TestMigrateOptions_Complete(t *testing.T) {
 		{
 			desc: "ListNetworks error",
 			opts: func(o migrateOptions) migrateOptions {
-				o.fetchClientFunc = func(ctx context.Context, basePath string, authedClient *http.Client) (*pkg.Clients, error) {
-					clients := test.DefaultClients()
-					clients.Compute.(*test.FakeCompute).ListNetworksErr = errors.New("ListNetworks error")
-					return clients, nil
+				o.fetchClientFunc = func(ctx context.Context, endpoints convert.Endpoints, authedClient *http.Client, limits ratelimit.Limits) (*pkg.Clients, error) {
+					clients, err := testClientFunc(ctx, endpoints, authedClient, limits)
+					if err != nil {
+						return nil, err
+					}
+					injector, err := faults.New(&faults.Config{Faults: []faults.Fault{
+						{Kind: faults.Error, Method: "ListNetworks", Message: "ListNetworks error"},
+					}})
+					if err != nil {
+						return nil, err
+					}
+					return injector.Wrap(clients), nil
 				}
 				return o
 			}(defaultOptions()),
-			wantErr: "error listing networks: ListNetworks error",
+			wantErr: "error listing networks: googleapi: Error 503: ListNetworks error",
 		},
 	}
 	for _, tc := range cases {
@@ -227,46 +384,48 @@ func TestMigrateOptions_Complete(t *testing.T) {
 			if tc.opts.clients.Container == nil {
 				t.Errorf("Container client is nil")
 			}
+			if tc.wantErr == "" && tc.opts.converter == nil {
+				t.Errorf("opts.converter is nil")
+			}
 		})
 	}
 }
 
+// failingUpdateMaster fails control plane upgrades.
+type failingUpdateMaster struct {
+	pkg.ContainerService
+}
+
+func (failingUpdateMaster) UpdateMaster(_ context.Context, _ *container.UpdateMasterRequest, _ ...googleapi.CallOption) (*container.Operation, error) {
+	return nil, errors.New("migrate error")
+}
+
 func TestMigrateOptions_Run(t *testing.T) {
 	cases := []struct {
 		desc    string
 		opts    migrateOptions
+		wrap    func(*pkg.Clients)
 		wantErr string
 		wantLog string
 	}{
 		{
-			desc:    "Empty migrator list",
-			opts:    migrateOptions{},
-			wantLog: "Initiate resource conversion",
+			desc:    "Single conversion",
+			opts:    defaultOptions(),
+			wantLog: "Initiate resource conversion.",
 		},
 		{
 			desc: "Skip conversions",
-			opts: migrateOptions{
-				validateOnly: true,
-			},
+			opts: func(o migrateOptions) migrateOptions {
+				o.validateOnly = true
+				return o
+			}(defaultOptions()),
 			wantLog: "skipping conversion",
 		},
-		{
-			desc: "Single conversion",
-			opts: migrateOptions{
-				migrators: []migrate.Migrator{
-					&migrate.FakeMigrator{},
-				},
-			},
-			wantLog: "Initiate resource conversion.",
-		},
 		{
 			desc: "Conversion error",
-			opts: migrateOptions{
-				migrators: []migrate.Migrator{
-					&migrate.FakeMigrator{
-						MigrateError: errors.New("migrate error"),
-					},
-				},
+			opts: defaultOptions(),
+			wrap: func(c *pkg.Clients) {
+				c.Container = failingUpdateMaster{c.Container}
 			},
 			wantLog: "Initiate resource conversion",
 			wantErr: "migrate error",
@@ -274,9 +433,33 @@ func TestMigrateOptions_Run(t *testing.T) {
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
+			var state fakeapi.State
+			if err := json.Unmarshal([]byte(fakeState), &state); err != nil {
+				t.Fatalf("Unable to decode state: %v", err)
+			}
+			srv, err := fakeapi.New(&state)
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			tc.opts.projectID = "test-project"
+			tc.opts.selectedNetwork = "legacy-network"
+			tc.opts.desiredControlPlaneVersion = "1.20.7-gke.1800"
+			tc.opts.pollingInterval = time.Millisecond
+			tc.opts.fetchClientFunc = func(_ context.Context, _ convert.Endpoints, _ *http.Client, _ ratelimit.Limits) (*pkg.Clients, error) {
+				clients := srv.Clients()
+				if tc.wrap != nil {
+					tc.wrap(clients)
+				}
+				return clients, nil
+			}
+
 			buf := &bytes.Buffer{}
 			log.StandardLogger().SetOutput(buf)
+			defer log.StandardLogger().SetOutput(os.Stderr)
 
+			if err := tc.opts.Complete(context.Background()); err != nil {
+				t.Fatalf("migrateOptions.Complete unexpected error: %v", err)
+			}
 			got := tc.opts.Run(context.Background())
 			if diff := test.ErrorDiff(tc.wantErr, got); diff != "" {
 				t.Errorf("migrateOptions.Run diff (-want +got):\n%s", diff)
@@ -289,8 +472,173 @@ func TestMigrateOptions_Run(t *testing.T) {
 	}
 }
 
-func testClientFunc(_ context.Context, _ string, _ *http.Client) (*pkg.Clients, error) {
-	return test.DefaultClients(), nil
+func TestMigrateOptions_Hooks(t *testing.T) {
+	o := migrateOptions{execHooks: []string{"notify.sh", `/bin/update-ticket --id 42 --comment "conversion event" | tee -a hooks.log`}}
+
+	got := o.hooks()
+
+	want := []migrate.Hook{
+		&migrate.ExecHook{Command: "sh", Args: []string{"-c", "notify.sh"}},
+		&migrate.ExecHook{Command: "sh", Args: []string{"-c", `/bin/update-ticket --id 42 --comment "conversion event" | tee -a hooks.log`}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("migrateOptions.hooks diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestMigrateOptions_HooksShell(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "event type.txt")
+	o := migrateOptions{execHooks: []string{fmt.Sprintf(`grep -o '"type":"[A-Za-z]*"' > %q`, out)}}
+
+	for _, h := range o.hooks() {
+		if err := h.Handle(context.Background(), migrate.Event{Type: migrate.BeforeMigrate, ResourcePath: "cluster-a"}); err != nil {
+			t.Fatalf("Handle unexpected error: %v", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("ReadFile unexpected error: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(b)), `"type":"BeforeMigrate"`; got != want {
+		t.Errorf("Hook output; wanted: %s, got: %s", want, got)
+	}
+}
+
+func TestMigrateOptions_Deadlines(t *testing.T) {
+	o := defaultOptions()
+	o.controlPlaneDeadline = time.Hour
+	o.perNodeDeadline = time.Minute
+
+	got := o.deadlines()
+
+	want := operations.Deadlines{
+		Default: o.pollingDeadline,
+		ByType:  map[operations.OperationType]time.Duration{operations.TypeControlPlane: time.Hour},
+		PerNode: time.Minute,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("migrateOptions.deadlines diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestMigrateOptions_RunAborted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := defaultOptions()
+	o.validateOnly = true
+	o.cancelOnAbort = true
+	o.stateFile = filepath.Join(dir, "state.json")
+	if err := o.Complete(context.Background()); err != nil {
+		t.Fatalf("migrateOptions.Complete unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := o.Run(ctx); err == nil {
+		t.Errorf("migrateOptions.Run; wanted an error, got: nil")
+	}
+
+	b, err := ioutil.ReadFile(o.stateFile)
+	if err != nil {
+		t.Fatalf("Unable to read state: %v", err)
+	}
+	var got conversionState
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unable to decode state: %v", err)
+	}
+	if !got.Aborted || got.Error == "" {
+		t.Errorf("State not aborted with an error: %+v", got)
+	}
+}
+
+func TestSetupCloseHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan struct{})
+	setupCloseHandler(cancel, func() { close(stop) })
+
+	interrupt := func() {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+			t.Fatalf("Unable to send interrupt: %v", err)
+		}
+	}
+
+	interrupt()
+	select {
+	case <-stop:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("First interrupt did not stop")
+	}
+	if ctx.Err() != nil {
+		t.Errorf("First interrupt cancelled the context")
+	}
+
+	interrupt()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Second interrupt did not cancel the context")
+	}
+}
+
+func TestMigrateOptions_ConflictRetry(t *testing.T) {
+	o := defaultOptions()
+	o.conflictRetries = 5
+	o.conflictBackoff = time.Minute
+	o.maxPollingInterval = 10 * time.Minute
+
+	got := o.conflictRetry()
+
+	want := operations.ConflictRetry{Retries: 5, Backoff: operations.NewExponential(time.Minute, 10*time.Minute)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("migrateOptions.conflictRetry diff (-want +got):\n%s", diff)
+	}
+
+	// --conflict-retries=0 disables retries, rather than selecting the default.
+	o.conflictRetries = 0
+	o.setDefaults()
+	if got := o.conflictRetry(); got.Retries != 0 {
+		t.Errorf("migrateOptions.conflictRetry with 0 retries; wanted: 0, got: %d", got.Retries)
+	}
+}
+
+// testState returns a regional cluster with a single node pool on the converted network test.SelectedNetwork.
+func testState() *fakeapi.State {
+	sc := &fakeapi.Scenario{
+		ProjectID: test.ProjectName,
+		Network:   test.SelectedNetwork,
+		Clusters: []fakeapi.ScenarioCluster{
+			{
+				Name:      test.ClusterName,
+				Location:  test.RegionA,
+				Version:   test.PrePatchCluster.CurrentMasterVersion,
+				NodePools: []fakeapi.ScenarioNodePool{{Name: test.NodePoolName}},
+			},
+		},
+		ServerConfig: test.ServerConfig(),
+	}
+	s := sc.State()
+	s.Networks[0].IPv4Range, s.Networks[0].GatewayIPv4 = "", ""
+	return s
+}
+
+// testClientFunc returns clients of a fake API serving the testState.
+func testClientFunc(_ context.Context, _ convert.Endpoints, _ *http.Client, _ ratelimit.Limits) (*pkg.Clients, error) {
+	srv, err := fakeapi.New(testState())
+	if err != nil {
+		return nil, err
+	}
+	return srv.Clients(), nil
+}
+
+// testAuthClientFunc returns an unauthenticated client, so that tests do not require Application Default Credentials.
+func testAuthClientFunc(_ context.Context, _ convert.Endpoints, _ convert.Credentials) (*http.Client, error) {
+	return http.DefaultClient, nil
 }
 
 func defaultOptions() migrateOptions {
@@ -303,5 +651,6 @@ func defaultOptions() migrateOptions {
 		pollingInterval:            10 * time.Minute,
 		pollingDeadline:            20 * time.Minute,
 		fetchClientFunc:            testClientFunc,
+		authClientFunc:             testAuthClientFunc,
 	}
 }
@@ -0,0 +1,201 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/operations"
+	"legacymigration/pkg/ratelimit"
+	"legacymigration/pkg/server"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	addressFlag      = "address"
+	queueSizeFlag    = "queue-size"
+	retainedJobsFlag = "retained-jobs"
+
+	shutdownTimeout = 30 * time.Second
+)
+
+type serveOptions struct {
+	address string
+	server  server.Options
+
+	// Options shared by all jobs.
+	runner jobRunner
+}
+
+// jobRunner implements server.Runner by running jobs as migrateOptions.
+type jobRunner struct {
+	endpoints   convert.Endpoints
+	credentials convert.Credentials
+	rateLimits  ratelimit.Limits
+	execHooks   []string
+
+	// Fields used for faking clients during tests.
+	fetchClientFunc fetchClientFunc
+	authClientFunc  authClientFunc
+}
+
+func newServeCmd() *cobra.Command {
+	o := serveOptions{
+		runner: jobRunner{
+			fetchClientFunc: convert.NewClients,
+			authClientFunc:  convert.DefaultClient,
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP/JSON API to submit and track conversion jobs.",
+		Long: `Serve an HTTP/JSON API to submit and track conversion jobs.
+
+Jobs are run one at a time in order of submission; at most --queue-size jobs may be pending. Endpoints:
+  POST /v1/jobs               Submit a job.
+  GET  /v1/jobs               List jobs.
+  GET  /v1/jobs/{id}          Get a job and the progress of its resources.
+  GET  /v1/jobs/{id}/logs     Get job logs; use ?offset=N to skip N entries.
+  POST /v1/jobs/{id}/cancel   Cancel a job.
+
+Jobs and their logs are kept in memory; the oldest finished jobs beyond --retained-jobs are evicted.
+
+WARNING: the server has no authentication or TLS, and jobs run with the operator's credentials.
+Anyone who can reach --address can convert networks and upgrade clusters. Do not bind it
+beyond localhost.`,
+
+		PreRun: func(cmd *cobra.Command, args []string) {
+			setupCloseHandler(cancel, nil)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cobra.CheckErr(o.Run(ctx))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.address, addressFlag, "localhost:8080", "Address to listen on. The API is unauthenticated; do not bind it beyond localhost.")
+	flags.IntVar(&o.server.QueueSize, queueSizeFlag, server.DefaultQueueSize, "Number of jobs which may be pending. Submissions are rejected with 503 Service Unavailable beyond it.")
+	flags.IntVar(&o.server.RetainedJobs, retainedJobsFlag, server.DefaultRetainedJobs, "Number of finished jobs, with their logs, to keep in memory. The oldest are evicted beyond it.")
+	addCredentialsFlags(flags, &o.runner.credentials)
+	addRateLimitFlags(flags, &o.runner.rateLimits)
+	flags.StringArrayVar(&o.runner.execHooks, execHookFlag, o.runner.execHooks,
+		`Command to run with sh -c before and after each phase of every network, cluster and node pool of every job,
+with the event as JSON on stdin. A non-zero exit status before a phase aborts that phase. May be repeated.`)
+	flags.StringVar(&o.runner.endpoints.Container, containerBasePathFlag, o.runner.endpoints.Container, "Custom URL for the container API endpoint (for testing).")
+	flags.StringVar(&o.runner.endpoints.Compute, computeBasePathFlag, o.runner.endpoints.Compute, "Custom URL for the compute API endpoint (for testing).")
+	flags.StringVar(&o.runner.endpoints.ResourceManager, resourceManagerBasePathFlag, o.runner.endpoints.ResourceManager, "Custom URL for the Resource Manager API endpoint (for testing).")
+	flags.MarkHidden(containerBasePathFlag)
+	flags.MarkHidden(computeBasePathFlag)
+	flags.MarkHidden(resourceManagerBasePathFlag)
+
+	return cmd
+}
+
+// Run serves the jobs API until ctx is closed.
+func (o *serveOptions) Run(ctx context.Context) error {
+	srv := server.New(&o.runner, o.server)
+	log.AddHook(srv)
+	go srv.Start(ctx)
+
+	httpServer := &http.Server{
+		Addr:    o.address,
+		Handler: srv,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Error shutting down server: %v", err)
+		}
+	}()
+
+	log.Infof("Serving conversion jobs on %s", o.address)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Validate implements server.Runner.
+func (o *jobRunner) Validate(req server.Request) error {
+	opts := o.migrateOptions(req)
+	return opts.ValidateFlags()
+}
+
+// Run implements server.Runner.
+func (o *jobRunner) Run(ctx context.Context, req server.Request) error {
+	opts := o.migrateOptions(req)
+	if err := opts.ValidateFlags(); err != nil {
+		return err
+	}
+	if err := opts.Complete(ctx); err != nil {
+		return err
+	}
+	return opts.Run(ctx)
+}
+
+// migrateOptions converts a job Request to migrateOptions, applying the flag defaults.
+func (o *jobRunner) migrateOptions(req server.Request) *migrateOptions {
+	opts := &migrateOptions{
+		projectID:                  req.ProjectID,
+		endpoints:                  o.endpoints,
+		credentials:                o.credentials,
+		rateLimits:                 o.rateLimits,
+		selectedNetwork:            req.Network,
+		selectedClusters:           req.Clusters,
+		locations:                  req.Locations,
+		allowMissingZones:          req.AllowMissingZones,
+		concurrentClusters:         req.ConcurrentClusters,
+		desiredControlPlaneVersion: req.ControlPlaneVersion,
+		desiredNodeVersion:         req.NodeVersion,
+		inPlaceControlPlaneUpgrade: req.InPlaceControlPlaneUpgrade,
+		autoVersion:                req.AutoVersion,
+		validateOnly:               true,
+		execHooks:                  o.execHooks,
+		pollingInterval:            time.Duration(req.PollingInterval),
+		pollingDeadline:            time.Duration(req.PollingDeadline),
+		pollingStrategies:          req.PollingStrategies,
+		maxPollingInterval:         time.Duration(req.MaxPollingInterval),
+		networkDeadline:            time.Duration(req.NetworkDeadline),
+		controlPlaneDeadline:       time.Duration(req.ControlPlaneDeadline),
+		nodePoolDeadline:           time.Duration(req.NodePoolDeadline),
+		perNodeDeadline:            time.Duration(req.PerNodeDeadline),
+		stallPeriod:                time.Duration(req.StallPeriod),
+		failOnStall:                req.FailOnStall,
+		conflictRetries:            operations.DefaultConflictRetries,
+		conflictBackoff:            time.Duration(req.ConflictBackoff),
+		maintenanceExclusion:       time.Duration(req.MaintenanceExclusion),
+		fetchClientFunc:            o.fetchClientFunc,
+		authClientFunc:             o.authClientFunc,
+	}
+	if req.ValidateOnly != nil {
+		opts.validateOnly = *req.ValidateOnly
+	}
+	if req.ConflictRetries != nil {
+		opts.conflictRetries = *req.ConflictRetries
+	}
+	opts.setDefaults()
+	return opts
+}
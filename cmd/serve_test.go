@@ -0,0 +1,171 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/clusters"
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/operations"
+	"legacymigration/pkg/server"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJobRunner_Validate(t *testing.T) {
+	cases := []struct {
+		desc    string
+		req     server.Request
+		wantErr string
+	}{
+		{
+			desc: "Defaults",
+			req: server.Request{
+				ProjectID:           test.ProjectName,
+				Network:             test.SelectedNetwork,
+				ControlPlaneVersion: clusters.DefaultVersion,
+			},
+		},
+		{
+			desc: "Missing network",
+			req: server.Request{
+				ProjectID:           test.ProjectName,
+				ControlPlaneVersion: clusters.DefaultVersion,
+			},
+			wantErr: "--network not provided or empty",
+		},
+		{
+			desc: "Polling interval too low",
+			req: server.Request{
+				ProjectID:           test.ProjectName,
+				Network:             test.SelectedNetwork,
+				ControlPlaneVersion: clusters.DefaultVersion,
+				PollingInterval:     pkg.Duration(time.Second),
+			},
+			wantErr: "--polling-interval must greater than or equal to 10 seconds",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := &jobRunner{fetchClientFunc: testClientFunc, authClientFunc: testAuthClientFunc}
+			err := r.Validate(tc.req)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("jobRunner.Validate diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestJobRunner_MigrateOptions(t *testing.T) {
+	r := &jobRunner{fetchClientFunc: testClientFunc, authClientFunc: testAuthClientFunc}
+
+	got := r.migrateOptions(server.Request{ProjectID: test.ProjectName, Network: test.SelectedNetwork})
+	if got.concurrentClusters != 1 ||
+		got.desiredNodeVersion != clusters.DefaultVersion ||
+		!got.validateOnly ||
+		got.pollingInterval != convert.DefaultPollingInterval ||
+		got.pollingDeadline != convert.DefaultPollingDeadline {
+		t.Errorf("jobRunner.migrateOptions did not apply defaults: %+v", got)
+	}
+
+	if got.conflictRetries != operations.DefaultConflictRetries || got.maxPollingInterval != convert.DefaultMaxPollingInterval {
+		t.Errorf("jobRunner.migrateOptions did not apply conflict and polling defaults: %+v", got)
+	}
+
+	validateOnly := false
+	got = r.migrateOptions(server.Request{ValidateOnly: &validateOnly})
+	if got.validateOnly {
+		t.Errorf("jobRunner.migrateOptions did not apply validateOnly=false")
+	}
+}
+
+func TestJobRunner_MigrateOptionsFields(t *testing.T) {
+	r := &jobRunner{execHooks: []string{"notify.sh"}, fetchClientFunc: testClientFunc, authClientFunc: testAuthClientFunc}
+	retries := 0
+	req := server.Request{
+		ProjectID:            test.ProjectName,
+		Network:              test.SelectedNetwork,
+		ControlPlaneVersion:  clusters.DefaultVersion,
+		Clusters:             []string{test.ClusterName},
+		Locations:            []string{test.RegionA},
+		AllowMissingZones:    true,
+		PollingStrategies:    []string{"exponential", "node-pool=eta"},
+		MaxPollingInterval:   pkg.Duration(time.Hour),
+		NetworkDeadline:      pkg.Duration(time.Hour),
+		ControlPlaneDeadline: pkg.Duration(2 * time.Hour),
+		NodePoolDeadline:     pkg.Duration(3 * time.Hour),
+		PerNodeDeadline:      pkg.Duration(time.Minute),
+		StallPeriod:          pkg.Duration(30 * time.Minute),
+		FailOnStall:          true,
+		ConflictRetries:      &retries,
+		ConflictBackoff:      pkg.Duration(2 * time.Minute),
+		MaintenanceExclusion: pkg.Duration(24 * time.Hour),
+	}
+
+	got := r.migrateOptions(req)
+
+	fields := func(o *migrateOptions) []interface{} {
+		return []interface{}{
+			o.selectedClusters, o.locations, o.allowMissingZones, o.execHooks,
+			o.pollingStrategies, o.maxPollingInterval, o.networkDeadline, o.controlPlaneDeadline, o.nodePoolDeadline,
+			o.perNodeDeadline, o.stallPeriod, o.failOnStall, o.conflictRetries, o.conflictBackoff, o.maintenanceExclusion,
+		}
+	}
+	want := &migrateOptions{
+		selectedClusters:     []string{test.ClusterName},
+		locations:            []string{test.RegionA},
+		allowMissingZones:    true,
+		execHooks:            []string{"notify.sh"},
+		pollingStrategies:    []string{"exponential", "node-pool=eta"},
+		maxPollingInterval:   time.Hour,
+		networkDeadline:      time.Hour,
+		controlPlaneDeadline: 2 * time.Hour,
+		nodePoolDeadline:     3 * time.Hour,
+		perNodeDeadline:      time.Minute,
+		stallPeriod:          30 * time.Minute,
+		failOnStall:          true,
+		conflictRetries:      0,
+		conflictBackoff:      2 * time.Minute,
+		maintenanceExclusion: 24 * time.Hour,
+	}
+	if diff := cmp.Diff(fields(want), fields(got)); diff != "" {
+		t.Errorf("jobRunner.migrateOptions diff (-want +got):\n%s", diff)
+	}
+	if err := r.Validate(req); err != nil {
+		t.Errorf("jobRunner.Validate unexpected error: %v", err)
+	}
+}
+
+func TestJobRunner_Run(t *testing.T) {
+	r := &jobRunner{
+		fetchClientFunc: testClientFunc,
+		authClientFunc:  testAuthClientFunc,
+	}
+	err := r.Run(context.Background(), server.Request{
+		ProjectID:           test.ProjectName,
+		Network:             test.SelectedNetwork,
+		ControlPlaneVersion: "1.20.7-gke.1800",
+		NodeVersion:         "1.20.7-gke.1800",
+	})
+	if err != nil {
+		t.Errorf("jobRunner.Run unexpected error: %v", err)
+	}
+}
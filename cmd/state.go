@@ -0,0 +1,127 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/operations"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// conversionState is the final state of a conversion, written to --state-file.
+type conversionState struct {
+	Time      time.Time             `json:"time"`
+	Converted bool                  `json:"converted"`
+	Stopped   bool                  `json:"stopped,omitempty"`
+	Aborted   bool                  `json:"aborted,omitempty"`
+	Error     string                `json:"error,omitempty"`
+	Resources []resourceState       `json:"resources"`
+	Abandoned []operations.InFlight `json:"abandoned,omitempty"`
+	Stalls    []stallState          `json:"stalls,omitempty"`
+	Retries   *convert.RetryStats   `json:"retries,omitempty"`
+}
+
+type resourceState struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind,omitempty"`
+	Phase string `json:"phase"`
+	Error string `json:"error,omitempty"`
+}
+
+type stallState struct {
+	Operation string                   `json:"operation"`
+	Type      operations.OperationType `json:"type,omitempty"`
+	Progress  float64                  `json:"progress"`
+	Duration  string                   `json:"duration"`
+	Reason    string                   `json:"reason,omitempty"`
+}
+
+// newConversionState returns the state for the result of a conversion and the error returned by it.
+func newConversionState(result *convert.Result, aborted bool, err error) *conversionState {
+	s := &conversionState{
+		Time:      time.Now().UTC(),
+		Converted: result.Converted,
+		Stopped:   result.Stopped,
+		Aborted:   aborted,
+		Resources: make([]resourceState, 0, len(result.Resources)),
+		Abandoned: result.Abandoned,
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	for _, r := range result.Resources {
+		rs := resourceState{Path: r.Path, Kind: r.Kind, Phase: r.Phase}
+		if r.Err != nil {
+			rs.Error = r.Err.Error()
+		}
+		s.Resources = append(s.Resources, rs)
+	}
+	for _, st := range result.Stalls {
+		s.Stalls = append(s.Stalls, stallState{
+			Operation: st.Operation,
+			Type:      st.Type,
+			Progress:  st.Progress,
+			Duration:  st.Duration.String(),
+			Reason:    st.Reason,
+		})
+	}
+	if r := result.Retries; r.Total() > 0 || r.Exhausted > 0 {
+		s.Retries = &r
+	}
+	return s
+}
+
+// logSummary logs the resources which did not complete and the operations left running.
+func (s *conversionState) logSummary() {
+	for _, r := range s.Resources {
+		if r.Error != "" {
+			log.Infof("%s %s did not complete %s: %s", r.Kind, r.Path, r.Phase, r.Error)
+		}
+	}
+	for _, f := range s.Abandoned {
+		log.Warnf("Abandoned %s.", f)
+	}
+	if r := s.Retries; r != nil {
+		reasons := make([]string, 0, len(r.Retries))
+		for reason, n := range r.Retries {
+			reasons = append(reasons, fmt.Sprintf("%s: %d", reason, n))
+		}
+		sort.Strings(reasons)
+		log.Infof("Retried %d API requests (%s), %d as requested by Retry-After; %d failed once retries were exhausted.",
+			r.Total(), strings.Join(reasons, ", "), r.RetryAfter, r.Exhausted)
+	}
+}
+
+// write writes the state as JSON to path.
+func (s *conversionState) write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("error writing state to %s: %w", path, err)
+	}
+	log.Infof("Final state written to %s", path)
+	return nil
+}
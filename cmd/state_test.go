@@ -0,0 +1,110 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"legacymigration/pkg/convert"
+	"legacymigration/pkg/operations"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestNewConversionState(t *testing.T) {
+	started := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	result := &convert.Result{
+		Converted: true,
+		Stopped:   true,
+		Resources: []convert.ResourceResult{
+			{Path: "network", Kind: convert.KindNetwork, Phase: "Migrate"},
+			{Path: "node-pool", Kind: convert.KindNodePool, Phase: "Migrate", Err: errors.New("not started")},
+		},
+		Abandoned: []operations.InFlight{{Operation: "op", Type: operations.TypeNodePool, Started: started, Cancelled: true}},
+		Stalls:    []operations.Stall{{Operation: "op", Type: operations.TypeNodePool, Progress: 0.5, Duration: time.Hour, Reason: "drain blocked"}},
+		Retries:   convert.RetryStats{Retries: map[string]int{"rateLimitExceeded": 2}, RetryAfter: 1},
+	}
+
+	got := newConversionState(result, true, errors.New("stopped"))
+
+	want := &conversionState{
+		Converted: true,
+		Stopped:   true,
+		Aborted:   true,
+		Error:     "stopped",
+		Resources: []resourceState{
+			{Path: "network", Kind: convert.KindNetwork, Phase: "Migrate"},
+			{Path: "node-pool", Kind: convert.KindNodePool, Phase: "Migrate", Error: "not started"},
+		},
+		Abandoned: result.Abandoned,
+		Stalls:    []stallState{{Operation: "op", Type: operations.TypeNodePool, Progress: 0.5, Duration: "1h0m0s", Reason: "drain blocked"}},
+		Retries:   &convert.RetryStats{Retries: map[string]int{"rateLimitExceeded": 2}, RetryAfter: 1},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(conversionState{}, "Time"), cmpopts.IgnoreUnexported(operations.InFlight{})); diff != "" {
+		t.Errorf("newConversionState diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConversionState_Write(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s := &conversionState{
+		Resources: []resourceState{{Path: "network", Phase: "Validate"}},
+		Abandoned: []operations.InFlight{{Operation: "op", Type: operations.TypeControlPlane}},
+		Retries:   &convert.RetryStats{Retries: map[string]int{"503": 1}, Exhausted: 1},
+	}
+	if err := s.write(path); err != nil {
+		t.Fatalf("conversionState.write unexpected error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat unexpected error: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("State file mode; wanted: %v, got: %v", os.FileMode(0600), mode)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read state: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unable to decode state: %v", err)
+	}
+	want := map[string]interface{}{
+		"time":      "0001-01-01T00:00:00Z",
+		"converted": false,
+		"resources": []interface{}{map[string]interface{}{"path": "network", "phase": "Validate"}},
+		"abandoned": []interface{}{map[string]interface{}{"operation": "op", "type": "control-plane", "started": "0001-01-01T00:00:00Z"}},
+		"retries":   map[string]interface{}{"retries": map[string]interface{}{"503": float64(1)}, "exhausted": float64(1)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("conversionState.write diff (-want +got):\n%s", diff)
+	}
+}
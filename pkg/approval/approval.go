@@ -0,0 +1,174 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package approval
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+type Decision int
+
+const (
+	Approve Decision = iota
+	Skip
+	Abort
+)
+
+var (
+	// ErrAborted is returned by migrators when a step is aborted by the user.
+	ErrAborted = errors.New("conversion aborted by user")
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Approve:
+		return "Approve"
+	case Skip:
+		return "Skip"
+	case Abort:
+		return "Abort"
+	default:
+		return fmt.Sprintf("%d", int(d))
+	}
+}
+
+// Step describes a mutating step which requires approval.
+type Step struct {
+	// Action is a short description of the mutation (e.g. "Upgrade control plane").
+	Action string
+	// ResourcePath identifies the resource being mutated.
+	ResourcePath string
+	// CurrentVersion and DesiredVersion are the resolved versions, if applicable.
+	CurrentVersion string
+	DesiredVersion string
+	// Path are the intermediate versions upgraded through to reach DesiredVersion, if any.
+	Path []string
+}
+
+func (s Step) String() string {
+	if s.DesiredVersion == "" {
+		return fmt.Sprintf("%s: %s", s.Action, s.ResourcePath)
+	}
+	current := s.CurrentVersion
+	if current == "" {
+		current = "unknown"
+	}
+	versions := append(append([]string{current}, s.Path...), s.DesiredVersion)
+	return fmt.Sprintf("%s: %s (%s)", s.Action, s.ResourcePath, strings.Join(versions, " -> "))
+}
+
+// Approver decides whether a mutating step may proceed.
+type Approver interface {
+	Approve(ctx context.Context, step Step) (Decision, error)
+}
+
+// Confirm requests a decision on the step, returning whether the step should proceed.
+// A nil Approver approves all steps. An aborted step returns an error wrapping ErrAborted.
+func Confirm(ctx context.Context, a Approver, step Step) (bool, error) {
+	if a == nil {
+		return true, nil
+	}
+	d, err := a.Approve(ctx, step)
+	if err != nil {
+		return false, err
+	}
+	switch d {
+	case Approve:
+		return true, nil
+	case Skip:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s: %w", step, ErrAborted)
+	}
+}
+
+// AutoApprover approves every step.
+type AutoApprover struct{}
+
+func (AutoApprover) Approve(_ context.Context, _ Step) (Decision, error) {
+	return Approve, nil
+}
+
+// Prompter is a thread-safe Approver which prompts for a decision on each step.
+// Once a step is aborted, all subsequent steps are aborted without prompting.
+type Prompter struct {
+	mu      sync.Mutex
+	in      *bufio.Reader
+	out     io.Writer
+	aborted bool
+}
+
+// NewPrompter returns a Prompter reading decisions from in and writing prompts to out.
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// Approve prompts for a decision until a valid answer is read.
+// Reaching the end of the input aborts the step.
+func (p *Prompter) Approve(ctx context.Context, step Step) (Decision, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.aborted {
+		return Abort, nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return Abort, fmt.Errorf("context error: %w", err)
+		}
+
+		fmt.Fprintf(p.out, "%s? [y]es/[s]kip/[a]bort: ", step)
+		line, err := p.in.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Abort, fmt.Errorf("error reading decision: %w", err)
+		}
+
+		d, ok := parseDecision(line)
+		if ok {
+			p.aborted = d == Abort
+			return d, nil
+		}
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(p.out)
+			p.aborted = true
+			return Abort, nil
+		}
+		fmt.Fprintf(p.out, "Invalid response %q.\n", strings.TrimSpace(line))
+	}
+}
+
+func parseDecision(s string) (Decision, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "y", "yes", "approve":
+		return Approve, true
+	case "s", "skip":
+		return Skip, true
+	case "a", "abort":
+		return Abort, true
+	default:
+		return Approve, false
+	}
+}
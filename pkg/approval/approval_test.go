@@ -0,0 +1,189 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package approval
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	testStep = Step{
+		Action:         "Upgrade control plane",
+		ResourcePath:   "projects/p/locations/l/clusters/c",
+		CurrentVersion: "1.19.10-gke.1700",
+		DesiredVersion: "1.20.7-gke.1800",
+	}
+)
+
+func TestPrompter_Approve(t *testing.T) {
+	cases := []struct {
+		desc    string
+		input   string
+		want    []Decision
+		wantOut string
+	}{
+		{
+			desc:    "Approve",
+			input:   "y\n",
+			want:    []Decision{Approve},
+			wantOut: "Upgrade control plane: projects/p/locations/l/clusters/c (1.19.10-gke.1700 -> 1.20.7-gke.1800)? [y]es/[s]kip/[a]bort: ",
+		},
+		{
+			desc:  "Skip then approve",
+			input: "skip\nyes\n",
+			want:  []Decision{Skip, Approve},
+		},
+		{
+			desc:    "Invalid response is re-prompted",
+			input:   "maybe\ns\n",
+			want:    []Decision{Skip},
+			wantOut: `Invalid response "maybe".`,
+		},
+		{
+			desc:  "Abort is sticky",
+			input: "a\ny\n",
+			want:  []Decision{Abort, Abort},
+		},
+		{
+			desc:  "End of input aborts",
+			input: "",
+			want:  []Decision{Abort},
+		},
+		{
+			desc:  "Answer without trailing newline",
+			input: "Y",
+			want:  []Decision{Approve},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			p := NewPrompter(strings.NewReader(tc.input), out)
+
+			var got []Decision
+			for range tc.want {
+				d, err := p.Approve(context.Background(), testStep)
+				if err != nil {
+					t.Fatalf("Prompter.Approve unexpected error: %v", err)
+				}
+				got = append(got, d)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Prompter.Approve diff (-want +got):\n%s", diff)
+			}
+			if !strings.Contains(out.String(), tc.wantOut) {
+				t.Errorf("Prompter.Approve missing output:\n\twanted: %s\n\tgot: %s", tc.wantOut, out.String())
+			}
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		desc     string
+		ctx      context.Context
+		approver Approver
+		want     bool
+		wantErr  string
+	}{
+		{
+			desc: "Nil approver",
+			ctx:  context.Background(),
+			want: true,
+		},
+		{
+			desc:     "Auto approver",
+			ctx:      context.Background(),
+			approver: AutoApprover{},
+			want:     true,
+		},
+		{
+			desc:     "Skip",
+			ctx:      context.Background(),
+			approver: NewPrompter(strings.NewReader("s\n"), &bytes.Buffer{}),
+			want:     false,
+		},
+		{
+			desc:     "Abort",
+			ctx:      context.Background(),
+			approver: NewPrompter(strings.NewReader("a\n"), &bytes.Buffer{}),
+			wantErr:  "Upgrade control plane: projects/p/locations/l/clusters/c (1.19.10-gke.1700 -> 1.20.7-gke.1800): conversion aborted by user",
+		},
+		{
+			desc:     "Context cancelled",
+			ctx:      cancelled,
+			approver: NewPrompter(strings.NewReader("y\n"), &bytes.Buffer{}),
+			wantErr:  "context error: context canceled",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := Confirm(tc.ctx, tc.approver, testStep)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Confirm error diff (-want +got):\n%s", diff)
+			}
+			if got != tc.want {
+				t.Errorf("Confirm diff; wanted: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStep_String(t *testing.T) {
+	cases := []struct {
+		desc string
+		step Step
+		want string
+	}{
+		{
+			desc: "Without versions",
+			step: Step{Action: "Switch to custom mode VPC network", ResourcePath: "projects/p/global/networks/n"},
+			want: "Switch to custom mode VPC network: projects/p/global/networks/n",
+		},
+		{
+			desc: "Unknown current version",
+			step: Step{Action: "Upgrade NodePool", ResourcePath: "np", DesiredVersion: "1.20"},
+			want: "Upgrade NodePool: np (unknown -> 1.20)",
+		},
+		{
+			desc: "With versions",
+			step: testStep,
+			want: "Upgrade control plane: projects/p/locations/l/clusters/c (1.19.10-gke.1700 -> 1.20.7-gke.1800)",
+		},
+		{
+			desc: "With path",
+			step: Step{Action: "Upgrade control plane", ResourcePath: "c", CurrentVersion: "1.18.18-gke.1700", DesiredVersion: "1.20.7-gke.1800", Path: []string{"1.19.11-gke.1700"}},
+			want: "Upgrade control plane: c (1.18.18-gke.1700 -> 1.19.11-gke.1700 -> 1.20.7-gke.1800)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, tc.step.String()); diff != "" {
+				t.Errorf("Step.String diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cache caches API responses which are read repeatedly during a conversion, such as the
+// ServerConfig of a location shared by many clusters and the instance templates and instance group
+// managers of node pools, which are read when they are initialized and again once upgraded.
+package cache
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"legacymigration/pkg"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+)
+
+var (
+	// targetRegex matches the location, cluster and optional node pool of a resource path or of
+	// the TargetLink of a GKE Operation, which may use a project number and a zones/ path.
+	targetRegex = regexp.MustCompile(`/(?:zones|locations)/([^/]+)/clusters/([^/]+)(?:/nodePools/([^/]+))?/?$`)
+	// instanceGroupManagerRegex matches the project, location and name of an InstanceGroupManager URL.
+	instanceGroupManagerRegex = regexp.MustCompile(`projects/([^/]+)/(?:zones|regions)/([^/]+)/instanceGroupManagers/([^/]+)$`)
+)
+
+// Key prefixes of cached responses.
+const (
+	serverConfigPrefix         = "serverConfig/"
+	instanceTemplatePrefix     = "instanceTemplate/"
+	instanceGroupManagerPrefix = "instanceGroupManager/"
+)
+
+// Cache caches responses of the clients it wraps. It is meant to be scoped to a single run,
+// as responses are cached until invalidated. ServerConfigs are never invalidated. The instance group
+// managers and instance templates of a node pool are invalidated when it or its cluster is mutated,
+// and once an operation targeting either is done, since a node pool upgrade or an auto-upgrade changes
+// their network interfaces. Those of every node pool are invalidated if the target of a mutation or
+// operation is not a cluster or node pool read through the clients, or when a network is converted.
+// Concurrent reads of an uncached response share a single request. Errors are not cached.
+// Cached responses are shared, so must not be modified by callers.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	// nodePools maps the location/cluster/nodePool of each node pool read to the keys
+	// of its instance group managers.
+	nodePools map[string][]string
+	hits      int
+	misses    int
+}
+
+type entry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Stats are the hits and misses of a Cache.
+type Stats struct {
+	Hits   int
+	Misses int
+}
+
+// New returns an empty Cache.
+func New() *Cache {
+	return &Cache{entries: make(map[string]*entry), nodePools: make(map[string][]string)}
+}
+
+// Wrap returns clients which cache the responses of clients.
+func (c *Cache) Wrap(clients *pkg.Clients) *pkg.Clients {
+	return &pkg.Clients{
+		Compute:         &computeClient{ComputeService: clients.Compute, c: c},
+		Container:       &containerClient{ContainerService: clients.Container, c: c},
+		ResourceManager: clients.ResourceManager,
+	}
+}
+
+// Stats returns the hits and misses so far.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Hits: c.hits, Misses: c.misses}
+}
+
+// Invalidate removes all cached responses.
+func (c *Cache) Invalidate() {
+	c.invalidate("")
+}
+
+// InvalidateInstanceGroups removes the cached instance group managers and instance templates.
+func (c *Cache) InvalidateInstanceGroups() {
+	c.invalidate(instanceGroupManagerPrefix)
+	c.invalidate(instanceTemplatePrefix)
+}
+
+// recordNodePools records the instance group managers of the node pools of a cluster,
+// so that they can be invalidated when the node pool or cluster is targeted.
+func (c *Cache) recordNodePools(location, cluster string, nps []*container.NodePool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, np := range nps {
+		if np == nil {
+			continue
+		}
+		var keys []string
+		for _, url := range np.InstanceGroupUrls {
+			if m := instanceGroupManagerRegex.FindStringSubmatch(url); m != nil {
+				keys = append(keys, instanceGroupManagerPrefix+strings.Join(m[1:], "/"))
+			}
+		}
+		c.nodePools[strings.Join([]string{location, cluster, np.Name}, "/")] = keys
+	}
+}
+
+// invalidateTarget removes the cached instance group managers and instance templates of the node pool,
+// or of every node pool of the cluster, targeted by a mutation or operation, given its resource path
+// or TargetLink. Those of every node pool are removed if the target is unknown.
+func (c *Cache) invalidateTarget(target string) {
+	t := targetRegex.FindStringSubmatch(target)
+	if t == nil || !c.invalidateNodePools(t[1]+"/"+t[2]+"/", t[3]) {
+		c.InvalidateInstanceGroups()
+	}
+}
+
+// invalidateNodePools removes the cached instance group managers and instance templates of the node pool
+// of the cluster (a location/cluster/ prefix), or of all of its node pools if nodePool is empty.
+// It returns false if no such node pool was recorded.
+func (c *Cache) invalidateNodePools(cluster, nodePool string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := false
+	n := 0
+	for np, keys := range c.nodePools {
+		if !strings.HasPrefix(np, cluster) || (nodePool != "" && np != cluster+nodePool) {
+			continue
+		}
+		found = true
+		for _, key := range keys {
+			n += c.removeInstanceGroupManager(key)
+		}
+	}
+	if n > 0 {
+		log.Debugf("Invalidated %d cached responses for node pools of %s", n, strings.TrimSuffix(cluster, "/"))
+	}
+	return found
+}
+
+// removeInstanceGroupManager removes a cached instance group manager and its instance template,
+// returning the number of responses removed. c.mu must be held.
+func (c *Cache) removeInstanceGroupManager(key string) int {
+	e, ok := c.entries[key]
+	if !ok {
+		return 0
+	}
+	delete(c.entries, key)
+	select {
+	case <-e.done:
+	default:
+		// The request is in flight, so its instance template cannot have been read through it yet.
+		return 1
+	}
+	igm, ok := e.value.(*compute.InstanceGroupManager)
+	if !ok || igm.InstanceTemplate == "" {
+		return 1
+	}
+	project := strings.SplitN(strings.TrimPrefix(key, instanceGroupManagerPrefix), "/", 2)[0]
+	templateKey := instanceTemplatePrefix + project + "/" + path.Base(igm.InstanceTemplate)
+	if _, ok := c.entries[templateKey]; !ok {
+		return 1
+	}
+	delete(c.entries, templateKey)
+	return 2
+}
+
+// invalidate removes the cached responses with keys starting with prefix.
+// Requests in flight complete, but their responses are not cached for later reads.
+func (c *Cache) invalidate(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	if n > 0 {
+		log.Debugf("Invalidated %d cached responses with prefix %q", n, prefix)
+	}
+}
+
+type refreshKey struct{}
+
+// WithRefresh returns a copy of ctx whose reads bypass cached responses, e.g. to detect changes
+// before a mutation. The responses read replace those cached.
+func WithRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+func refreshing(ctx context.Context) bool {
+	refresh, _ := ctx.Value(refreshKey{}).(bool)
+	return refresh
+}
+
+// get returns the cached response for key, or fetches it.
+func (c *Cache) get(ctx context.Context, key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && !refreshing(ctx) {
+		c.hits++
+		c.mu.Unlock()
+		<-e.done
+		return e.value, e.err
+	}
+	e := &entry{done: make(chan struct{})}
+	c.entries[key] = e
+	c.misses++
+	c.mu.Unlock()
+
+	e.value, e.err = fetch()
+	if e.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	close(e.done)
+	return e.value, e.err
+}
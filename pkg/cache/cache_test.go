@@ -0,0 +1,169 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCache_Get(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	cases := []struct {
+		desc       string
+		keys       []string
+		errs       []error
+		invalidate string
+		refresh    bool
+		wantCalls  int
+		wantStats  Stats
+		wantErr    string
+	}{
+		{
+			desc:      "Repeated reads are cached",
+			keys:      []string{"a", "a", "a"},
+			wantCalls: 1,
+			wantStats: Stats{Hits: 2, Misses: 1},
+		},
+		{
+			desc:      "Distinct keys are fetched",
+			keys:      []string{"a", "b", "a"},
+			wantCalls: 2,
+			wantStats: Stats{Hits: 1, Misses: 2},
+		},
+		{
+			desc:      "Errors are not cached",
+			keys:      []string{"a", "a"},
+			errs:      []error{errFetch},
+			wantCalls: 2,
+			wantStats: Stats{Misses: 2},
+			wantErr:   "fetch failed",
+		},
+		{
+			desc:       "Invalidated prefix is fetched again",
+			keys:       []string{instanceTemplatePrefix + "a", instanceTemplatePrefix + "a"},
+			invalidate: instanceTemplatePrefix,
+			wantCalls:  2,
+			wantStats:  Stats{Misses: 2},
+		},
+		{
+			desc:       "Other prefixes are not invalidated",
+			keys:       []string{serverConfigPrefix + "a", serverConfigPrefix + "a"},
+			invalidate: instanceTemplatePrefix,
+			wantCalls:  1,
+			wantStats:  Stats{Hits: 1, Misses: 1},
+		},
+		{
+			desc:      "Refresh replaces cached responses",
+			keys:      []string{"a", "a", "a"},
+			refresh:   true,
+			wantCalls: 2,
+			wantStats: Stats{Hits: 1, Misses: 2},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := New()
+			calls := 0
+			var err error
+			for i, key := range tc.keys {
+				ctx := context.Background()
+				if i > 0 && tc.invalidate != "" {
+					c.invalidate(tc.invalidate)
+				}
+				if i == 1 && tc.refresh {
+					ctx = WithRefresh(ctx)
+				}
+				v, gotErr := c.get(ctx, key, func() (interface{}, error) {
+					calls++
+					if calls <= len(tc.errs) && tc.errs[calls-1] != nil {
+						return nil, tc.errs[calls-1]
+					}
+					return key, nil
+				})
+				if gotErr == nil && v != key {
+					t.Errorf("get(%q) = %v, want: %q", key, v, key)
+				}
+				if err == nil {
+					err = gotErr
+				}
+			}
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("get error diff (-want +got):\n%s", diff)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("get fetched %d times, want: %d", calls, tc.wantCalls)
+			}
+			if diff := cmp.Diff(tc.wantStats, c.Stats()); diff != "" {
+				t.Errorf("Stats diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCache_GetConcurrent(t *testing.T) {
+	ctx := context.Background()
+	c := New()
+	var (
+		mu      sync.Mutex
+		calls   int
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	fetch := func() (interface{}, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return "value", nil
+	}
+
+	// The first read is in flight before the others start.
+	started := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.get(ctx, "key", func() (interface{}, error) {
+			close(started)
+			return fetch()
+		})
+	}()
+	<-started
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, _ := c.get(ctx, "key", fetch); v != "value" {
+				t.Errorf("get = %v, want: %q", v, "value")
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("get fetched %d times, want: 1", calls)
+	}
+	if diff := cmp.Diff(Stats{Hits: 4, Misses: 1}, c.Stats()); diff != "" {
+		t.Errorf("Stats diff (-want +got):\n%s", diff)
+	}
+}
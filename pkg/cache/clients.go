@@ -0,0 +1,150 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"legacymigration/pkg"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// operationDone is the status of a done operation.
+const operationDone = "DONE"
+
+// computeClient caches the responses of a ComputeService.
+type computeClient struct {
+	pkg.ComputeService
+	c *Cache
+}
+
+func (c *computeClient) GetInstanceGroupManager(ctx context.Context, project, location, name string, opts ...googleapi.CallOption) (*compute.InstanceGroupManager, error) {
+	if len(opts) > 0 {
+		return c.ComputeService.GetInstanceGroupManager(ctx, project, location, name, opts...)
+	}
+	key := instanceGroupManagerPrefix + strings.Join([]string{project, location, name}, "/")
+	v, err := c.c.get(ctx, key, func() (interface{}, error) {
+		return c.ComputeService.GetInstanceGroupManager(ctx, project, location, name)
+	})
+	igm, _ := v.(*compute.InstanceGroupManager)
+	return igm, err
+}
+
+func (c *computeClient) GetInstanceTemplate(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.InstanceTemplate, error) {
+	if len(opts) > 0 {
+		return c.ComputeService.GetInstanceTemplate(ctx, project, name, opts...)
+	}
+	key := instanceTemplatePrefix + project + "/" + name
+	v, err := c.c.get(ctx, key, func() (interface{}, error) {
+		return c.ComputeService.GetInstanceTemplate(ctx, project, name)
+	})
+	it, _ := v.(*compute.InstanceTemplate)
+	return it, err
+}
+
+func (c *computeClient) GetGlobalOperation(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	op, err := c.ComputeService.GetGlobalOperation(ctx, project, name, opts...)
+	if err == nil && op.Status == operationDone {
+		c.c.InvalidateInstanceGroups()
+	}
+	return op, err
+}
+
+func (c *computeClient) WaitOperation(ctx context.Context, project string, op *compute.Operation, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	op, err := c.ComputeService.WaitOperation(ctx, project, op, opts...)
+	if err == nil && op.Status == operationDone {
+		c.c.InvalidateInstanceGroups()
+	}
+	return op, err
+}
+
+func (c *computeClient) SwitchToCustomMode(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	defer c.c.InvalidateInstanceGroups()
+	return c.ComputeService.SwitchToCustomMode(ctx, project, name, opts...)
+}
+
+// containerClient caches the responses of a ContainerService.
+type containerClient struct {
+	pkg.ContainerService
+	c *Cache
+}
+
+func (c *containerClient) GetServerConfig(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.ServerConfig, error) {
+	if len(opts) > 0 {
+		return c.ContainerService.GetServerConfig(ctx, name, opts...)
+	}
+	v, err := c.c.get(ctx, serverConfigPrefix+name, func() (interface{}, error) {
+		return c.ContainerService.GetServerConfig(ctx, name)
+	})
+	sc, _ := v.(*container.ServerConfig)
+	return sc, err
+}
+
+func (c *containerClient) GetCluster(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.Cluster, error) {
+	cluster, err := c.ContainerService.GetCluster(ctx, name, opts...)
+	if err == nil && cluster != nil {
+		c.c.recordNodePools(cluster.Location, cluster.Name, cluster.NodePools)
+	}
+	return cluster, err
+}
+
+func (c *containerClient) ListClusters(ctx context.Context, parent string, opts ...googleapi.CallOption) (*container.ListClustersResponse, error) {
+	resp, err := c.ContainerService.ListClusters(ctx, parent, opts...)
+	if err == nil && resp != nil {
+		for _, cluster := range resp.Clusters {
+			c.c.recordNodePools(cluster.Location, cluster.Name, cluster.NodePools)
+		}
+	}
+	return resp, err
+}
+
+func (c *containerClient) GetNodePool(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.NodePool, error) {
+	np, err := c.ContainerService.GetNodePool(ctx, name, opts...)
+	if t := targetRegex.FindStringSubmatch(name); err == nil && t != nil {
+		c.c.recordNodePools(t[1], t[2], []*container.NodePool{np})
+	}
+	return np, err
+}
+
+func (c *containerClient) ListNodePools(ctx context.Context, parent string, opts ...googleapi.CallOption) (*container.ListNodePoolsResponse, error) {
+	resp, err := c.ContainerService.ListNodePools(ctx, parent, opts...)
+	if t := targetRegex.FindStringSubmatch(parent); err == nil && resp != nil && t != nil {
+		c.c.recordNodePools(t[1], t[2], resp.NodePools)
+	}
+	return resp, err
+}
+
+func (c *containerClient) GetOperation(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.Operation, error) {
+	op, err := c.ContainerService.GetOperation(ctx, name, opts...)
+	if err == nil && op.Status == operationDone {
+		c.c.invalidateTarget(op.TargetLink)
+	}
+	return op, err
+}
+
+func (c *containerClient) UpdateMaster(ctx context.Context, req *container.UpdateMasterRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	defer c.c.invalidateTarget(req.Name)
+	return c.ContainerService.UpdateMaster(ctx, req, opts...)
+}
+
+func (c *containerClient) UpdateNodePool(ctx context.Context, req *container.UpdateNodePoolRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	defer c.c.invalidateTarget(req.Name)
+	return c.ContainerService.UpdateNodePool(ctx, req, opts...)
+}
@@ -0,0 +1,247 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// countingCompute counts the reads served by the wrapped ComputeService.
+type countingCompute struct {
+	pkg.ComputeService
+	calls map[string]int
+}
+
+func (c *countingCompute) GetInstanceGroupManager(ctx context.Context, project, zone, name string, opts ...googleapi.CallOption) (*compute.InstanceGroupManager, error) {
+	c.calls["GetInstanceGroupManager"]++
+	return c.ComputeService.GetInstanceGroupManager(ctx, project, zone, name, opts...)
+}
+
+func (c *countingCompute) GetInstanceTemplate(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.InstanceTemplate, error) {
+	c.calls["GetInstanceTemplate"]++
+	return c.ComputeService.GetInstanceTemplate(ctx, project, name, opts...)
+}
+
+// countingContainer counts the reads served by the wrapped ContainerService.
+type countingContainer struct {
+	pkg.ContainerService
+	calls map[string]int
+}
+
+func (c *countingContainer) GetServerConfig(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.ServerConfig, error) {
+	c.calls["GetServerConfig"]++
+	return c.ContainerService.GetServerConfig(ctx, name, opts...)
+}
+
+func TestWrap(t *testing.T) {
+	sc := &fakeapi.Scenario{
+		ProjectID: test.ProjectName,
+		Network:   test.SelectedNetwork,
+		Clusters: []fakeapi.ScenarioCluster{
+			{
+				Name:      test.ClusterName,
+				Location:  test.RegionA,
+				Version:   "1.19.10-gke.1700",
+				NodePools: []fakeapi.ScenarioNodePool{{Name: test.NodePoolName}, {Name: "other-pool"}},
+			},
+		},
+		ServerConfig: test.ServerConfig(),
+	}
+	clusterPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName)
+	nodePoolPath := pkg.NodePoolPath(test.ProjectName, test.RegionA, test.ClusterName, test.NodePoolName)
+	nodePoolTarget := "https://container.googleapis.com/v1/projects/123/zones/region-a/clusters/cluster-c/nodePools/default-pool"
+	readNodePool := func(ctx context.Context, clients *pkg.Clients) error {
+		if _, err := clients.Compute.GetInstanceGroupManager(ctx, test.ProjectName, "region-a-a", "gke-cluster-c-default-pool-a-grp"); err != nil {
+			return err
+		}
+		_, err := clients.Compute.GetInstanceTemplate(ctx, test.ProjectName, "gke-cluster-c-default-pool-tmpl")
+		return err
+	}
+	readServerConfig := func(ctx context.Context, clients *pkg.Clients) error {
+		_, err := clients.Container.GetServerConfig(ctx, pkg.LocationPath(test.ProjectName, test.RegionA))
+		return err
+	}
+	getOperation := func(ctx context.Context, clients *pkg.Clients) error {
+		_, err := clients.Container.GetOperation(ctx, pkg.OperationsPath(test.ProjectName, test.RegionA, "operation"))
+		return err
+	}
+	cases := []struct {
+		desc       string
+		setup      func(ctx context.Context, clients *pkg.Clients) error
+		call       func(ctx context.Context, clients *pkg.Clients) error
+		ops        []*container.Operation
+		polls      int
+		invalidate bool
+		wantCalls  map[string]int
+		wantStats  Stats
+	}{
+		{
+			desc:      "Repeated reads",
+			call:      func(ctx context.Context, clients *pkg.Clients) error { return nil },
+			wantCalls: map[string]int{"GetInstanceGroupManager": 1, "GetInstanceTemplate": 1, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 3, Misses: 3},
+		},
+		{
+			desc: "Reads with call options bypass the cache",
+			call: func(ctx context.Context, clients *pkg.Clients) error {
+				_, err := clients.Container.GetServerConfig(ctx, pkg.LocationPath(test.ProjectName, test.RegionA), googleapi.QuotaUser("user"))
+				return err
+			},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 1, "GetInstanceTemplate": 1, "GetServerConfig": 2},
+			wantStats: Stats{Hits: 3, Misses: 3},
+		},
+		{
+			desc: "Node pool upgrade invalidates its instance groups",
+			call: func(ctx context.Context, clients *pkg.Clients) error {
+				_, err := clients.Container.UpdateNodePool(ctx, &container.UpdateNodePoolRequest{Name: nodePoolPath, NodeVersion: "1.19.10-gke.1700"})
+				return err
+			},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 2, "GetInstanceTemplate": 2, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 1, Misses: 5},
+		},
+		{
+			desc: "Upgrade of another node pool keeps instance groups",
+			call: func(ctx context.Context, clients *pkg.Clients) error {
+				_, err := clients.Container.UpdateNodePool(ctx, &container.UpdateNodePoolRequest{Name: clusterPath + "/nodePools/other-pool", NodeVersion: "1.19.10-gke.1700"})
+				return err
+			},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 1, "GetInstanceTemplate": 1, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 3, Misses: 3},
+		},
+		{
+			desc: "Upgrade of an unknown node pool invalidates all instance groups",
+			call: func(ctx context.Context, clients *pkg.Clients) error {
+				// The request is rejected by the API, after the cache is invalidated.
+				clients.Container.UpdateNodePool(ctx, &container.UpdateNodePoolRequest{})
+				return nil
+			},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 2, "GetInstanceTemplate": 2, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 1, Misses: 5},
+		},
+		{
+			desc: "Control plane upgrade invalidates instance groups of the cluster",
+			call: func(ctx context.Context, clients *pkg.Clients) error {
+				_, err := clients.Container.UpdateMaster(ctx, &container.UpdateMasterRequest{Name: clusterPath, MasterVersion: "1.19.11-gke.1700"})
+				return err
+			},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 2, "GetInstanceTemplate": 2, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 1, Misses: 5},
+		},
+		{
+			desc:      "Done operation on the node pool invalidates its instance groups",
+			call:      getOperation,
+			ops:       []*container.Operation{{Name: "operation", Status: "RUNNING", TargetLink: nodePoolTarget}},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 2, "GetInstanceTemplate": 2, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 1, Misses: 5},
+		},
+		{
+			desc:      "Running operation keeps instance groups",
+			call:      getOperation,
+			ops:       []*container.Operation{{Name: "operation", Status: "RUNNING", TargetLink: nodePoolTarget}},
+			polls:     1,
+			wantCalls: map[string]int{"GetInstanceGroupManager": 1, "GetInstanceTemplate": 1, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 3, Misses: 3},
+		},
+		{
+			desc:      "Done operation with an unknown target invalidates all instance groups",
+			call:      getOperation,
+			ops:       []*container.Operation{{Name: "operation", Status: "RUNNING"}},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 2, "GetInstanceTemplate": 2, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 1, Misses: 5},
+		},
+		{
+			desc: "Done compute operation invalidates all instance groups",
+			setup: func(ctx context.Context, clients *pkg.Clients) error {
+				_, err := clients.Compute.SwitchToCustomMode(ctx, test.ProjectName, test.SelectedNetwork)
+				return err
+			},
+			call: func(ctx context.Context, clients *pkg.Clients) error {
+				_, err := clients.Compute.GetGlobalOperation(ctx, test.ProjectName, "operation-1-00000001")
+				return err
+			},
+			wantCalls: map[string]int{"GetInstanceGroupManager": 2, "GetInstanceTemplate": 2, "GetServerConfig": 1},
+			wantStats: Stats{Hits: 1, Misses: 5},
+		},
+		{
+			desc:       "Invalidate",
+			call:       func(ctx context.Context, clients *pkg.Clients) error { return nil },
+			invalidate: true,
+			wantCalls:  map[string]int{"GetInstanceGroupManager": 2, "GetInstanceTemplate": 2, "GetServerConfig": 2},
+			wantStats:  Stats{Misses: 6},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			calls := make(map[string]int)
+			state := sc.State()
+			state.Operations = tc.ops
+			state.Script.Polls = tc.polls
+			srv, err := fakeapi.New(state)
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			fake := srv.Clients()
+			if tc.setup != nil {
+				if err := tc.setup(ctx, fake); err != nil {
+					t.Fatalf("setup unexpected error: %v", err)
+				}
+			}
+			c := New()
+			clients := c.Wrap(&pkg.Clients{
+				Compute:   &countingCompute{ComputeService: fake.Compute, calls: calls},
+				Container: &countingContainer{ContainerService: fake.Container, calls: calls},
+			})
+			if _, err := clients.Container.GetCluster(ctx, clusterPath); err != nil {
+				t.Fatalf("GetCluster unexpected error: %v", err)
+			}
+
+			read := func() {
+				if err := readNodePool(ctx, clients); err != nil {
+					t.Fatalf("read NodePool unexpected error: %v", err)
+				}
+				if err := readServerConfig(ctx, clients); err != nil {
+					t.Fatalf("read ServerConfig unexpected error: %v", err)
+				}
+			}
+			read()
+			if err := tc.call(ctx, clients); err != nil {
+				t.Fatalf("call unexpected error: %v", err)
+			}
+			if tc.invalidate {
+				c.Invalidate()
+			}
+			read()
+
+			if diff := cmp.Diff(tc.wantCalls, calls); diff != "" {
+				t.Errorf("calls diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantStats, c.Stats()); diff != "" {
+				t.Errorf("Stats diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cassette records API requests and responses to cassette files and replays them,
+// so that conversions can be reproduced against recorded responses without network access.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// RedactedProject replaces the project ID in recorded interactions.
+	RedactedProject = "redacted-project"
+	// Redacted replaces credentials in recorded interactions.
+	Redacted = "REDACTED"
+	// RedactedIP replaces IPv4 addresses in recorded interactions, keeping the prefix length of ranges.
+	RedactedIP = "192.0.2.0"
+	// RedactedEmail replaces emails, e.g. of service accounts, in recorded interactions.
+	RedactedEmail = "redacted@example.com"
+)
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded HTTP request. Headers are not recorded, as they may hold credentials.
+type Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Response is a recorded HTTP response.
+type Response struct {
+	StatusCode  int    `json:"statusCode"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// Cassette is a sequence of recorded interactions.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Load reads a Cassette from a JSON file.
+func Load(path string) (*Cassette, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("error parsing cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the Cassette to a JSON file, readable only by its owner.
+func (c *Cassette) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// Redactor replaces sensitive strings, e.g. the project ID, in recorded interactions.
+// It also redacts the sensitivePatterns, such as credentials, IP addresses and ranges,
+// and service account emails.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// sensitivePatterns match sensitive values in recorded interactions, which are replaced by their replacements.
+var sensitivePatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	// Credentials of the cluster, e.g. in masterAuth.
+	{regexp.MustCompile(`("(?:clusterCaCertificate|clientCertificate|clientKey|password)"\s*:\s*)"[^"]*"`), `$1"` + Redacted + `"`},
+	// IPv4 addresses and ranges, e.g. of cluster endpoints and networks.
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), RedactedIP},
+	// Emails, e.g. of service accounts.
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`), RedactedEmail},
+}
+
+// NewRedactor returns a Redactor for pairs of old and new strings, as for strings.NewReplacer.
+func NewRedactor(oldnew ...string) *Redactor {
+	return &Redactor{replacer: strings.NewReplacer(oldnew...)}
+}
+
+// Redact returns s with sensitive strings replaced.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	s = r.replacer.Replace(s)
+	for _, p := range sensitivePatterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// Recorder is an http.RoundTripper which records the requests sent through Transport,
+// with their responses, to a Cassette.
+type Recorder struct {
+	// Transport sends the requests; http.DefaultTransport is used if nil.
+	Transport http.RoundTripper
+	Redactor  *Redactor
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    r.Redactor.Redact(req.URL.String()),
+			Body:   r.Redactor.Redact(reqBody),
+		},
+		Response: Response{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        r.Redactor.Redact(string(respBody)),
+		},
+	})
+	return resp, nil
+}
+
+// Cassette returns the interactions recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: append([]*Interaction(nil), r.cassette.Interactions...)}
+}
+
+// Replayer is an http.RoundTripper which responds to requests from a Cassette without network access.
+// Requests are matched by method, URL and body, after redaction. Interactions with the same request
+// are replayed in the order recorded, and the last is repeated once all have been replayed (e.g. when
+// an operation is polled more often than during recording).
+type Replayer struct {
+	redactor *Redactor
+
+	mu           sync.Mutex
+	interactions map[Request][]*Interaction
+}
+
+// NewReplayer returns a Replayer for the Cassette. The Redactor used to record the Cassette
+// should be provided so that requests match the recorded, redacted, requests.
+func NewReplayer(c *Cassette, redactor *Redactor) *Replayer {
+	r := &Replayer{redactor: redactor, interactions: make(map[Request][]*Interaction)}
+	for _, i := range c.Interactions {
+		r.interactions[i.Request] = append(r.interactions[i.Request], i)
+	}
+	return r
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := Request{
+		Method: req.Method,
+		URL:    r.redactor.Redact(req.URL.String()),
+		Body:   r.redactor.Redact(body),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	is := r.interactions[key]
+	if len(is) == 0 {
+		return nil, fmt.Errorf("no recorded interaction for %s %s", key.Method, key.URL)
+	}
+	i := is[0]
+	if len(is) > 1 {
+		r.interactions[key] = is[1:]
+	}
+	header := make(http.Header)
+	if i.Response.ContentType != "" {
+		header.Set("Content-Type", i.Response.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+		StatusCode:    i.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(i.Response.Body)),
+		ContentLength: int64(len(i.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// readRequestBody reads the body of the request, leaving it readable by the transport.
+func readRequestBody(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return string(b), nil
+}
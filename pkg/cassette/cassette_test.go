@@ -0,0 +1,201 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cassette
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// send sends a request using the client, returning the status code and body of the response.
+func send(t *testing.T, c *http.Client, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.NewRequest unexpected error: %v", err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll unexpected error: %v", err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestRecordReplay(t *testing.T) {
+	polls := 0
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/projects/my-project/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		polls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":%q,"body":%q,"poll":%d}`, r.URL.Path, b, polls)
+	}))
+	redactor := NewRedactor("my-project", RedactedProject)
+
+	rec := &Recorder{Redactor: redactor}
+	c := &http.Client{Transport: rec}
+	var want []string
+	for _, req := range []struct{ method, path, body string }{
+		{"GET", "/projects/my-project/op", ""},
+		{"GET", "/projects/my-project/op", ""},
+		{"POST", "/projects/my-project/op", "my-project"},
+		{"GET", "/projects/my-project/missing", ""},
+	} {
+		code, body := send(t, c, req.method, hs.URL+req.path, req.body)
+		want = append(want, fmt.Sprintf("%d %s", code, strings.Replace(body, "my-project", RedactedProject, -1)))
+	}
+	hs.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := rec.Cassette().Save(path); err != nil {
+		t.Fatalf("Cassette.Save unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile unexpected error: %v", err)
+	}
+	if strings.Contains(string(b), "my-project") {
+		t.Errorf("Cassette was not redacted:\n%s", b)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat unexpected error: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Cassette file mode; wanted: %v, got: %v", os.FileMode(0600), mode)
+	}
+	cassette, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load unexpected error: %v", err)
+	}
+
+	c = &http.Client{Transport: NewReplayer(cassette, redactor)}
+	var got []string
+	for _, req := range []struct{ method, path, body string }{
+		{"GET", "/projects/my-project/op", ""},
+		{"GET", "/projects/my-project/op", ""},
+		{"POST", "/projects/my-project/op", "my-project"},
+		{"GET", "/projects/my-project/missing", ""},
+	} {
+		code, body := send(t, c, req.method, hs.URL+req.path, req.body)
+		got = append(got, fmt.Sprintf("%d %s", code, body))
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Replayed responses diff (-want +got):\n%s", diff)
+	}
+
+	// The last response to a request is repeated.
+	if _, body := send(t, c, "GET", hs.URL+"/projects/my-project/op", ""); body != got[1][len("200 "):] {
+		t.Errorf("Repeated response; wanted: %s, got: %s", got[1], body)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	cases := []struct {
+		desc string
+		in   string
+		want string
+	}{
+		{
+			desc: "Project ID",
+			in:   `{"selfLink":"projects/my-project/zones/us-central1-a/clusters/c"}`,
+			want: `{"selfLink":"projects/redacted-project/zones/us-central1-a/clusters/c"}`,
+		},
+		{
+			desc: "Endpoint",
+			in:   `{"endpoint":"35.184.10.2","privateEndpoint":"10.0.0.2"}`,
+			want: `{"endpoint":"192.0.2.0","privateEndpoint":"192.0.2.0"}`,
+		},
+		{
+			desc: "Network ranges",
+			in:   `{"IPv4Range":"10.128.0.0/9","clusterIpv4Cidr":"10.4.0.0/14"}`,
+			want: `{"IPv4Range":"192.0.2.0/9","clusterIpv4Cidr":"192.0.2.0/14"}`,
+		},
+		{
+			desc: "Master auth",
+			in:   `{"masterAuth":{"clusterCaCertificate": "LS0tLS1CRUdJTg==","clientCertificate":"Y2VydA==","clientKey":"a2V5","password":"secret"}}`,
+			want: `{"masterAuth":{"clusterCaCertificate": "REDACTED","clientCertificate":"REDACTED","clientKey":"REDACTED","password":"REDACTED"}}`,
+		},
+		{
+			desc: "Service account",
+			in:   `{"serviceAccount":"sa-1@my-project.iam.gserviceaccount.com"}`,
+			want: `{"serviceAccount":"redacted@example.com"}`,
+		},
+		{
+			desc: "Versions unchanged",
+			in:   `{"currentMasterVersion":"1.20.7-gke.1800","validMasterVersions":["1.19.10"]}`,
+			want: `{"currentMasterVersion":"1.20.7-gke.1800","validMasterVersions":["1.19.10"]}`,
+		},
+	}
+	r := NewRedactor("my-project", RedactedProject)
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, r.Redact(tc.in)); diff != "" {
+				t.Errorf("Redact diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReplayer_Unrecorded(t *testing.T) {
+	c := &http.Client{Transport: NewReplayer(&Cassette{}, nil)}
+	_, err := c.Get("http://localhost/missing")
+	if diff := test.ErrorDiff("no recorded interaction for GET http://localhost/missing", err); diff != "" {
+		t.Errorf("Replayer.RoundTrip diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte("{"), 0644); err != nil {
+		t.Fatalf("WriteFile unexpected error: %v", err)
+	}
+	cases := []struct {
+		desc    string
+		path    string
+		wantErr string
+	}{
+		{desc: "Missing file", path: filepath.Join(dir, "missing.json"), wantErr: "no such file"},
+		{desc: "Invalid JSON", path: invalid, wantErr: "error parsing cassette"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := Load(tc.path)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Load diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/operations"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/container/v1"
+)
+
+const (
+	// MaintenanceExclusionName is the name of the maintenance exclusion added while a cluster is upgraded.
+	MaintenanceExclusionName = "gkeconvert"
+	// MaxMaintenanceExclusion is the longest maintenance exclusion allowed by GKE.
+	MaxMaintenanceExclusion = 30 * 24 * time.Hour
+
+	// removeExclusionTimeout bounds the removal of the maintenance exclusion, which must
+	// proceed even if the conversion was aborted.
+	removeExclusionTimeout = 5 * time.Minute
+
+	statusPending = "PENDING"
+	statusRunning = "RUNNING"
+)
+
+// targetRegex matches the location and name of the cluster targeted by a GKE Operation.
+// The TargetLink may use a project number and a zones/ or locations/ path.
+var targetRegex = regexp.MustCompile(`/(?:zones|locations)/([^/]+)/clusters/([^/]+)(?:/|$)`)
+
+// upgradeOperations returns the control plane and node upgrades which are pending or running
+// for the cluster or its node pools, e.g. auto-upgrades.
+func (m *clusterMigrator) upgradeOperations(ctx context.Context) ([]*container.Operation, error) {
+	resp, err := m.clients.Container.ListOperations(ctx, pkg.LocationPath(m.projectID, m.cluster.Location))
+	if err != nil {
+		return nil, fmt.Errorf("error listing Operations for Cluster %s: %w", m.ResourcePath(), err)
+	}
+	var ops []*container.Operation
+	for _, op := range resp.Operations {
+		if op.Status != statusPending && op.Status != statusRunning {
+			continue
+		}
+		if containerOperationType(op) == operations.TypeUnknown {
+			continue
+		}
+		t := targetRegex.FindStringSubmatch(op.TargetLink)
+		if t == nil || t[1] != m.cluster.Location || t[2] != m.cluster.Name {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// awaitUpgrades waits for upgrades of the cluster which were not started by this migrator (e.g. auto-upgrades),
+// then refreshes the cluster state and re-resolves the desired versions.
+func (m *clusterMigrator) awaitUpgrades(ctx context.Context) error {
+	ops, err := m.upgradeOperations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	for _, op := range ops {
+		path := pkg.PathRegex.FindString(op.SelfLink)
+		log.Infof("Waiting for %s Operation %s on Cluster %s to complete before upgrading.", op.OperationType, path, m.ResourcePath())
+		w := &ContainerOperation{
+			ProjectID:     m.projectID,
+			Path:          path,
+			OperationType: containerOperationType(op),
+			Client:        m.clients.Container,
+		}
+		if err := m.handler.Wait(ctx, w); err != nil {
+			return fmt.Errorf("error waiting on ongoing operation %s: %w", path, err)
+		}
+	}
+	return m.refresh(ctx)
+}
+
+// addMaintenanceExclusion adds a maintenance exclusion to the cluster, ending after Options.MaintenanceExclusion.
+func (m *clusterMigrator) addMaintenanceExclusion(ctx context.Context) error {
+	now := time.Now().UTC()
+	return m.setMaintenanceExclusion(ctx, &container.TimeWindow{
+		StartTime: now.Format(time.RFC3339),
+		EndTime:   now.Add(m.opts.MaintenanceExclusion).Format(time.RFC3339),
+	})
+}
+
+// removeMaintenanceExclusion removes the maintenance exclusion added by addMaintenanceExclusion.
+// A new context is used so that the exclusion is removed even if the conversion was aborted.
+func (m *clusterMigrator) removeMaintenanceExclusion() error {
+	ctx, cancel := context.WithTimeout(context.Background(), removeExclusionTimeout)
+	defer cancel()
+	return m.setMaintenanceExclusion(ctx, nil)
+}
+
+// setMaintenanceExclusion adds, or removes if w is nil, the MaintenanceExclusionName exclusion
+// while preserving the rest of the cluster's maintenance policy.
+func (m *clusterMigrator) setMaintenanceExclusion(ctx context.Context, w *container.TimeWindow) error {
+	action := "adding"
+	if w == nil {
+		action = "removing"
+	}
+	cluster, err := m.clients.Container.GetCluster(ctx, m.ResourcePath())
+	if err != nil {
+		return fmt.Errorf("error %s maintenance exclusion for Cluster %s: %w", action, m.ResourcePath(), err)
+	}
+
+	policy := &container.MaintenancePolicy{Window: &container.MaintenanceWindow{}}
+	if cluster.MaintenancePolicy != nil {
+		policy.ResourceVersion = cluster.MaintenancePolicy.ResourceVersion
+		if cluster.MaintenancePolicy.Window != nil {
+			window := *cluster.MaintenancePolicy.Window
+			policy.Window = &window
+		}
+	}
+	exclusions := make(map[string]container.TimeWindow)
+	for k, v := range policy.Window.MaintenanceExclusions {
+		exclusions[k] = v
+	}
+	if w == nil {
+		if _, ok := exclusions[MaintenanceExclusionName]; !ok {
+			return nil
+		}
+		delete(exclusions, MaintenanceExclusionName)
+	} else {
+		exclusions[MaintenanceExclusionName] = *w
+	}
+	policy.Window.MaintenanceExclusions = exclusions
+
+	op, err := m.clients.Container.SetMaintenancePolicy(ctx, &container.SetMaintenancePolicyRequest{
+		Name:              m.ResourcePath(),
+		MaintenancePolicy: policy,
+	})
+	if err != nil {
+		return fmt.Errorf("error %s maintenance exclusion for Cluster %s: %w", action, m.ResourcePath(), err)
+	}
+	path := pkg.PathRegex.FindString(op.SelfLink)
+	if err := m.handler.Wait(ctx, &ContainerOperation{ProjectID: m.projectID, Path: path, Client: m.clients.Container}); err != nil {
+		return fmt.Errorf("error waiting on Operation %s: %w", path, err)
+	}
+	if w == nil {
+		log.Infof("Removed maintenance exclusion %q from Cluster %s.", MaintenanceExclusionName, m.ResourcePath())
+	} else {
+		log.Infof("Added maintenance exclusion %q to Cluster %s until %s.", MaintenanceExclusionName, m.ResourcePath(), w.EndTime)
+	}
+	return nil
+}
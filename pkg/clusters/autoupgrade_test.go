@@ -0,0 +1,319 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/migrate"
+	"legacymigration/pkg/operations"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/container/v1"
+)
+
+// targetLink returns the TargetLink of an Operation for a resource in the test project.
+func targetLink(path string) string {
+	return test.SelfLink(test.ContainerAPI, path)
+}
+
+func TestClusterMigrator_UpgradeOperations(t *testing.T) {
+	clusterPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName)
+	ops := []*container.Operation{
+		{Name: "auto-upgrade-master", OperationType: "UPGRADE_MASTER", Status: statusRunning, TargetLink: targetLink(clusterPath)},
+		{Name: "auto-upgrade-nodes", OperationType: "UPGRADE_NODES", Status: statusPending, TargetLink: targetLink(clusterPath + "/nodePools/default-pool")},
+		{Name: "zonal-target", OperationType: "UPGRADE_NODES", Status: statusRunning, TargetLink: "https://container.googleapis.com/v1/projects/123/zones/region-a/clusters/cluster-c/nodePools/p"},
+		{Name: "done", OperationType: "UPGRADE_MASTER", Status: test.OperationDone, TargetLink: targetLink(clusterPath)},
+		{Name: "other-type", OperationType: "SET_LABELS", Status: statusRunning, TargetLink: targetLink(clusterPath)},
+		{Name: "other-cluster", OperationType: "UPGRADE_MASTER", Status: statusRunning, TargetLink: targetLink(clusterPath + "-2")},
+	}
+	for _, op := range ops {
+		op.Location = test.RegionA
+	}
+	s := testState()
+	s.Operations = ops
+	c := test.PrePatchCluster
+	m := testClusterMigrator(&c, testOptions, testClients(t, s))
+
+	got, err := m.upgradeOperations(context.Background())
+	if err != nil {
+		t.Fatalf("clusterMigrator.upgradeOperations unexpected error: %v", err)
+	}
+	var names []string
+	for _, op := range got {
+		names = append(names, op.Name)
+	}
+	if diff := cmp.Diff([]string{"auto-upgrade-master", "auto-upgrade-nodes", "zonal-target"}, names); diff != "" {
+		t.Errorf("clusterMigrator.upgradeOperations diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestClusterMigrator_AwaitUpgrades(t *testing.T) {
+	clusterPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName)
+	running := []*container.Operation{{
+		Name:          "auto-upgrade-master",
+		OperationType: "UPGRADE_MASTER",
+		Status:        statusRunning,
+		Location:      test.RegionA,
+		TargetLink:    targetLink(clusterPath),
+		SelfLink:      targetLink(pkg.OperationsPath(test.ProjectName, test.RegionA, "auto-upgrade-master")),
+	}}
+
+	cases := []struct {
+		desc        string
+		ops         []*container.Operation
+		opts        *Options
+		wantVersion string
+		wantErr     string
+	}{
+		{
+			desc:        "No upgrades",
+			opts:        testOptions,
+			wantVersion: "1.19.10-gke.1600",
+		},
+		{
+			desc:        "Re-resolved after auto-upgrade",
+			ops:         running,
+			opts:        &Options{ConcurrentNodePools: 1, DesiredControlPlaneVersion: LatestVersion},
+			wantVersion: "1.20.7-gke.1800",
+		},
+		{
+			desc:        "Auto-upgrade past desired version",
+			ops:         running,
+			opts:        &Options{ConcurrentNodePools: 1, DesiredControlPlaneVersion: "1.19.10-gke.1700"},
+			wantVersion: "1.19.10-gke.1700",
+			wantErr:     "validation error for Cluster",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := testState()
+			s.Operations = tc.ops
+			s.Clusters[0].CurrentMasterVersion = "1.20.7-gke.1800"
+			c := test.PrePatchCluster
+			m := testClusterMigrator(&c, tc.opts, testClients(t, s))
+			if err := m.Complete(context.Background()); err != nil {
+				t.Fatalf("clusterMigrator.Complete unexpected error: %v", err)
+			}
+
+			err := m.awaitUpgrades(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("clusterMigrator.awaitUpgrades diff (-want +got):\n%s", diff)
+			}
+			if m.resolvedDesiredControlPlaneVersion != tc.wantVersion {
+				t.Errorf("clusterMigrator.resolvedDesiredControlPlaneVersion; wanted: %q, got: %q", tc.wantVersion, m.resolvedDesiredControlPlaneVersion)
+			}
+		})
+	}
+}
+
+func TestClusterMigrator_Migrate_ApprovedAfterUpgrades(t *testing.T) {
+	clusterPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName)
+	s := testState()
+	s.Operations = []*container.Operation{{
+		Name:          "auto-upgrade-master",
+		OperationType: "UPGRADE_MASTER",
+		Status:        statusRunning,
+		Location:      test.RegionA,
+		TargetLink:    targetLink(clusterPath),
+		SelfLink:      targetLink(pkg.OperationsPath(test.ProjectName, test.RegionA, "auto-upgrade-master")),
+	}}
+	s.Clusters[0].CurrentMasterVersion = "1.20.7-gke.1800"
+	srv, err := fakeapi.New(s)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	clients := srv.Clients()
+	rec := record(clients)
+
+	var steps []string
+	opts := &Options{
+		ConcurrentNodePools:        1,
+		DesiredControlPlaneVersion: LatestVersion,
+		Approver: approverFunc(func(ctx context.Context, step approval.Step) (approval.Decision, error) {
+			state, err := srv.State()
+			if err != nil {
+				return approval.Abort, err
+			}
+			if op := state.Operations[0]; op.Status != test.OperationDone {
+				t.Errorf("%s requested while Operation %s is %s", step, op.Name, op.Status)
+			}
+			steps = append(steps, step.String())
+			return approval.Skip, nil
+		}),
+	}
+	c := test.PrePatchCluster
+	m := testClusterMigrator(&c, opts, clients)
+	if err := m.Complete(context.Background()); err != nil {
+		t.Fatalf("clusterMigrator.Complete unexpected error: %v", err)
+	}
+
+	if err := m.Migrate(context.Background()); err != nil {
+		t.Fatalf("clusterMigrator.Migrate unexpected error: %v", err)
+	}
+	// The upgrade approved is the one resolved once the auto-upgrade is complete.
+	want := []string{"Upgrade control plane: " + clusterPath + " (1.20.7-gke.1800 -> 1.20.7-gke.1800)"}
+	if diff := cmp.Diff(want, steps); diff != "" {
+		t.Errorf("Approved steps diff (-want +got):\n%s", diff)
+	}
+	if len(rec.updateMaster) != 0 {
+		t.Errorf("UpdateMaster; wanted no calls, got: %v", rec.updateMaster)
+	}
+}
+
+// approverFunc is an Approver which decides on each step by calling itself.
+type approverFunc func(ctx context.Context, step approval.Step) (approval.Decision, error)
+
+func (f approverFunc) Approve(ctx context.Context, step approval.Step) (approval.Decision, error) {
+	return f(ctx, step)
+}
+
+func TestClusterMigrator_AwaitedOperationsNotCancelled(t *testing.T) {
+	clusterPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName)
+	autoUpgrade := &container.Operation{
+		Name:          "auto-upgrade-nodes",
+		OperationType: "UPGRADE_NODES",
+		Status:        statusRunning,
+		Location:      test.RegionA,
+		TargetLink:    targetLink(clusterPath),
+		SelfLink:      targetLink(pkg.OperationsPath(test.ProjectName, test.RegionA, "auto-upgrade-nodes")),
+	}
+	conflicting := &container.Operation{
+		Name:          "other-client",
+		OperationType: "UPGRADE_MASTER",
+		Status:        statusRunning,
+		Location:      test.RegionA,
+		SelfLink:      targetLink(pkg.OperationsPath(test.ProjectName, test.RegionA, "other-client")),
+	}
+
+	s := testState()
+	s.Operations = []*container.Operation{autoUpgrade, conflicting}
+	// The operations outlast the deadline of the handler.
+	s.Script.Polls = 1000
+	clients := testClients(t, s)
+	rec := record(clients)
+	c := test.PrePatchCluster
+	m := testClusterMigrator(&c, testOptions, clients)
+	h := operations.NewHandler(time.Microsecond, time.Millisecond)
+	m.handler = h
+
+	if err := m.awaitUpgrades(context.Background()); err == nil {
+		t.Fatalf("clusterMigrator.awaitUpgrades; wanted deadline error, got: nil")
+	}
+	if err := m.wait(context.Background(), &operations.Conflict{Operation: "other-client"}); err == nil {
+		t.Fatalf("clusterMigrator.wait; wanted deadline error, got: nil")
+	}
+	if got := h.Abandoned(); len(got) != 2 {
+		t.Fatalf("HandlerImpl.Abandoned; wanted 2 operations, got: %v", got)
+	}
+
+	cancelled, err := h.CancelInFlight(context.Background())
+	if err != nil {
+		t.Errorf("HandlerImpl.CancelInFlight unexpected error: %v", err)
+	}
+	if len(cancelled) != 0 || len(rec.cancelled) != 0 {
+		t.Errorf("HandlerImpl.CancelInFlight; wanted none cancelled, got: %v (CancelOperation calls: %v)", cancelled, rec.cancelled)
+	}
+}
+
+func TestClusterMigrator_MaintenanceExclusion(t *testing.T) {
+	other := container.TimeWindow{StartTime: "2021-12-24T00:00:00Z", EndTime: "2021-12-26T00:00:00Z"}
+	cases := []struct {
+		desc       string
+		exclusions map[string]container.TimeWindow
+		migrateErr error
+		wantReqs   int
+		wantErr    string
+	}{
+		{
+			desc:       "Added and removed",
+			exclusions: map[string]container.TimeWindow{"holidays": other, MaintenanceExclusionName: {}},
+			wantReqs:   2,
+		},
+		{
+			desc:       "Removed after failure",
+			exclusions: map[string]container.TimeWindow{"holidays": other, MaintenanceExclusionName: {}},
+			migrateErr: errors.New("node pool error"),
+			wantReqs:   2,
+			wantErr:    "node pool error",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := testState()
+			s.Clusters[0].Subnetwork = "subnet"
+			s.Clusters[0].MaintenancePolicy = &container.MaintenancePolicy{
+				ResourceVersion: "v1",
+				Window:          &container.MaintenanceWindow{MaintenanceExclusions: tc.exclusions},
+			}
+			clients := testClients(t, s)
+			rec := record(clients)
+			c := *s.Clusters[0]
+			m := testClusterMigrator(&c, &Options{ConcurrentNodePools: 1, MaintenanceExclusion: time.Hour}, clients)
+			m.children = []migrate.Migrator{&migrate.FakeMigrator{MigrateError: tc.migrateErr}}
+
+			err := m.Migrate(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("clusterMigrator.Migrate diff (-want +got):\n%s", diff)
+			}
+			if len(rec.setMaintenancePolicy) != tc.wantReqs {
+				t.Fatalf("SetMaintenancePolicy calls; wanted: %d, got: %d", tc.wantReqs, len(rec.setMaintenancePolicy))
+			}
+
+			added := rec.setMaintenancePolicy[0].MaintenancePolicy
+			if added.ResourceVersion != "v1" {
+				t.Errorf("Added policy ResourceVersion; wanted: v1, got: %q", added.ResourceVersion)
+			}
+			w, ok := added.Window.MaintenanceExclusions[MaintenanceExclusionName]
+			if !ok || w.StartTime == "" || w.EndTime == "" {
+				t.Errorf("Added policy missing exclusion %q: %+v", MaintenanceExclusionName, added.Window.MaintenanceExclusions)
+			}
+			if diff := cmp.Diff(other, added.Window.MaintenanceExclusions["holidays"]); diff != "" {
+				t.Errorf("Added policy did not preserve exclusion (-want +got):\n%s", diff)
+			}
+
+			removed := rec.setMaintenancePolicy[1].MaintenancePolicy
+			want := map[string]container.TimeWindow{"holidays": other}
+			if diff := cmp.Diff(want, removed.Window.MaintenanceExclusions); diff != "" {
+				t.Errorf("Removed policy exclusions diff (-want +got):\n%s", diff)
+			}
+			if _, ok := tc.exclusions[MaintenanceExclusionName]; !ok {
+				t.Errorf("Fake cluster exclusions were modified: %+v", tc.exclusions)
+			}
+		})
+	}
+}
+
+func TestClusterMigrator_RemoveMaintenanceExclusion_NotPresent(t *testing.T) {
+	clients := testClients(t, testState())
+	rec := record(clients)
+	c := test.PrePatchCluster
+	m := testClusterMigrator(&c, testOptions, clients)
+
+	if err := m.removeMaintenanceExclusion(); err != nil {
+		t.Fatalf("clusterMigrator.removeMaintenanceExclusion unexpected error: %v", err)
+	}
+	if got := rec.setMaintenancePolicy; len(got) != 0 {
+		t.Errorf("SetMaintenancePolicy calls; wanted none, got: %+v", got)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// selectVersion selects the desired control plane and node version for Options.AutoVersion.
+//
+// The lowest version in the cluster's release channel is selected which:
+//  - is a valid upgrade (or in-place upgrade) for the control plane,
+//  - is a valid upgrade for each NodePool requiring one, and
+//  - is within version skew of each NodePool which does not require an upgrade.
+// Selecting the lowest such version minimizes the version jump of every resource.
+func (m *clusterMigrator) selectVersion() error {
+	var (
+		current        = m.cluster.CurrentMasterVersion
+		_, cpValid     = getVersions(m.serverConfig, m.releaseChannel, ControlPlane)
+		_, nodeValid   = getVersions(m.serverConfig, m.releaseChannel, Node)
+		upgrade, other = m.nodePoolsByUpgrade()
+	)
+	sorted, err := sortVersions(cpValid)
+	if err != nil {
+		return err
+	}
+	for _, v := range sorted {
+		if err := m.isSelectable(v, cpValid, nodeValid, upgrade, other); err != nil {
+			log.Debugf("Version %s not selected for Cluster %s: %v", v, m.ResourcePath(), err)
+			continue
+		}
+
+		if v != m.resolvedDesiredControlPlaneVersion {
+			log.Infof("Selected version %s for Cluster %s: %s.", v, m.ResourcePath(), explainVersion(v, current, m.releaseChannel, upgrade, other))
+		}
+		m.resolvedDesiredControlPlaneVersion = v
+		return nil
+	}
+	return fmt.Errorf("no version in release channel %s is a valid upgrade for Cluster %s (%s) and its NodePool(s); valid versions: %v",
+		m.releaseChannel, m.ResourcePath(), current, cpValid)
+}
+
+// isSelectable returns an error if the version cannot be selected for the control plane and node pools.
+func (m *clusterMigrator) isSelectable(v string, cpValid, nodeValid []string, upgrade, other []*nodePoolMigrator) error {
+	if err := isUpgrade(v, m.cluster.CurrentMasterVersion, cpValid, true); err != nil {
+		return err
+	}
+	for _, np := range upgrade {
+		if err := isUpgrade(v, np.nodePool.Version, nodeValid, false); err != nil {
+			return fmt.Errorf("NodePool %s: %w", np.nodePool.Name, err)
+		}
+	}
+	for _, np := range other {
+		if err := IsWithinVersionSkew(np.nodePool.Version, v, MaxVersionSkew); err != nil {
+			return fmt.Errorf("NodePool %s: %w", np.nodePool.Name, err)
+		}
+	}
+	return nil
+}
+
+// nodePoolsByUpgrade splits the cluster's NodePools into those which require an upgrade and those which do not.
+func (m *clusterMigrator) nodePoolsByUpgrade() (upgrade, other []*nodePoolMigrator) {
+	for _, c := range m.children {
+		np, ok := c.(*nodePoolMigrator)
+		if !ok {
+			continue
+		}
+		if np.upgradeRequired {
+			upgrade = append(upgrade, np)
+		} else {
+			other = append(other, np)
+		}
+	}
+	return upgrade, other
+}
+
+// explainVersion describes why a version was selected.
+func explainVersion(v, current, channel string, upgrade, other []*nodePoolMigrator) string {
+	s := []string{fmt.Sprintf("lowest version in release channel %s which upgrades the control plane from %s", channel, current)}
+	if v == current {
+		s[0] = fmt.Sprintf("lowest version in release channel %s which upgrades the control plane in place", channel)
+	}
+	if len(upgrade) > 0 {
+		s = append(s, fmt.Sprintf("upgrades NodePool(s) %s", describeNodePools(upgrade)))
+	}
+	if len(other) > 0 {
+		s = append(s, fmt.Sprintf("is within %d minor version(s) of NodePool(s) %s", MaxVersionSkew, describeNodePools(other)))
+	}
+	return strings.Join(s, ", ")
+}
+
+func describeNodePools(nps []*nodePoolMigrator) string {
+	s := make([]string, len(nps))
+	for i, np := range nps {
+		s[i] = fmt.Sprintf("%s (%s)", np.nodePool.Name, np.nodePool.Version)
+	}
+	return strings.Join(s, ", ")
+}
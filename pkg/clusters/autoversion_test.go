@@ -0,0 +1,106 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"legacymigration/test"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/container/v1"
+)
+
+func TestClusterMigrator_SelectVersion(t *testing.T) {
+	type pool struct {
+		version         string
+		upgradeRequired bool
+	}
+	cases := []struct {
+		desc    string
+		current string
+		pools   []pool
+		want    string
+		wantErr string
+		wantLog string
+	}{
+		{
+			desc:    "In-place control plane upgrade",
+			current: "1.19.10-gke.1700",
+			want:    "1.19.10-gke.1700",
+			wantLog: "upgrades the control plane in place",
+		},
+		{
+			desc:    "NodePool at control plane version",
+			current: "1.19.10-gke.1700",
+			pools:   []pool{{"1.19.10-gke.1700", true}},
+			want:    "1.19.11-gke.1700",
+			wantLog: "upgrades NodePool(s) pool-0 (1.19.10-gke.1700)",
+		},
+		{
+			desc:    "NodePools behind control plane",
+			current: "1.19.10-gke.1700",
+			pools:   []pool{{"1.18.18-gke.1700", true}, {"1.18.19-gke.1700", false}},
+			want:    "1.19.10-gke.1700",
+			wantLog: "is within 1 minor version(s) of NodePool(s) pool-1 (1.18.19-gke.1700)",
+		},
+		{
+			desc:    "Current version no longer valid",
+			current: "1.19.9-gke.100",
+			want:    "1.19.10-gke.1700",
+		},
+		{
+			desc:    "NodePool outside of version skew",
+			current: "1.19.10-gke.1700",
+			pools:   []pool{{"1.17.17-gke.8200", false}},
+			wantErr: "no version in release channel UNSPECIFIED is a valid upgrade for Cluster projects/test-project/locations/region-a/clusters/cluster-c (1.19.10-gke.1700)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			log.StandardLogger().SetOutput(buf)
+			c := test.PrePatchCluster
+			c.CurrentMasterVersion = tc.current
+			m := testClusterMigrator(&c, &Options{AutoVersion: true}, nil)
+			m.serverConfig = ServerConfig
+			for i, p := range tc.pools {
+				np := NewNodePool(m, &container.NodePool{Name: fmt.Sprintf("pool-%d", i), Version: p.version})
+				np.upgradeRequired = p.upgradeRequired
+				m.children = append(m.children, np)
+			}
+
+			err := m.resolveVersions()
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("clusterMigrator.resolveVersions diff (-want +got):\n%s", diff)
+			}
+			if m.resolvedDesiredControlPlaneVersion != tc.want {
+				t.Errorf("clusterMigrator.resolvedDesiredControlPlaneVersion; wanted: %q, got: %q", tc.want, m.resolvedDesiredControlPlaneVersion)
+			}
+			for _, child := range m.children {
+				if np := child.(*nodePoolMigrator); tc.wantErr == "" && np.resolvedDesiredNodeVersion != tc.want {
+					t.Errorf("nodePoolMigrator.resolvedDesiredNodeVersion; wanted: %q, got: %q", tc.want, np.resolvedDesiredNodeVersion)
+				}
+			}
+			if !strings.Contains(buf.String(), tc.wantLog) {
+				t.Errorf("clusterMigrator.resolveVersions missing log output:\n\twanted entry: %s\n\tgot entries: %s", tc.wantLog, buf.String())
+			}
+		})
+	}
+}
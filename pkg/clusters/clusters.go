@@ -17,13 +17,18 @@ package clusters
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
 	"legacymigration/pkg/migrate"
 	"legacymigration/pkg/operations"
 
 	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
 	"google.golang.org/api/container/v1"
 )
 
@@ -33,6 +38,20 @@ type Options struct {
 	DesiredControlPlaneVersion string
 	DesiredNodeVersion         string
 	InPlaceControlPlaneUpgrade bool
+	Approver                   approval.Approver
+	// ClusterNames restricts conversion to the named clusters; all clusters on the network are converted if empty.
+	ClusterNames []string
+	// Locations restricts the clusters listed to those in the locations; clusters are listed in all locations if empty.
+	Locations []string
+	// AllowMissingZones allows the conversion of a network even if clusters could not be listed in some zones,
+	// or are on the network outside of the Locations.
+	AllowMissingZones bool
+	// AutoVersion selects the lowest version per cluster which is a valid upgrade for its control plane and
+	// node pools, instead of DesiredControlPlaneVersion and DesiredNodeVersion.
+	AutoVersion bool
+	// MaintenanceExclusion, if set, is the maximum duration of a maintenance exclusion added to each
+	// cluster while it is upgraded, to prevent auto-upgrades from starting. See MaxMaintenanceExclusion.
+	MaintenanceExclusion time.Duration
 }
 
 type clusterMigrator struct {
@@ -81,13 +100,9 @@ func (m *clusterMigrator) Complete(ctx context.Context) error {
 		return fmt.Errorf("error retrieving ServerConfig for Cluster %s: %w", m.ResourcePath(), err)
 	}
 
-	m.releaseChannel = getReleaseChannel(m.cluster.ReleaseChannel)
-	def, valid := getVersions(m.serverConfig, m.releaseChannel, ControlPlane)
-	if m.opts.InPlaceControlPlaneUpgrade {
-		m.resolvedDesiredControlPlaneVersion = m.cluster.CurrentMasterVersion
-	} else {
-		m.resolvedDesiredControlPlaneVersion, err = resolveVersion(m.opts.DesiredControlPlaneVersion, def, valid)
-		if err != nil {
+	// With AutoVersion, versions are resolved once the NodePools are initialized.
+	if !m.opts.AutoVersion {
+		if err := m.resolveVersion(); err != nil {
 			return err
 		}
 	}
@@ -99,41 +114,260 @@ func (m *clusterMigrator) Complete(ctx context.Context) error {
 
 	log.Infof("Initialize NodePool objects for Cluster %s", m.ResourcePath())
 	sem := make(chan struct{}, m.opts.ConcurrentNodePools)
-	return migrate.Complete(ctx, sem, m.children...)
+	if err := migrate.Complete(ctx, sem, m.children...); err != nil {
+		return err
+	}
+	if m.opts.AutoVersion {
+		// The selected version depends on the NodePools' versions.
+		return m.resolveVersions()
+	}
+	return nil
+}
+
+// resolveVersions resolves the desired versions of the control plane and its NodePools.
+func (m *clusterMigrator) resolveVersions() error {
+	if err := m.resolveVersion(); err != nil {
+		return err
+	}
+	for _, c := range m.children {
+		if np, ok := c.(*nodePoolMigrator); ok {
+			if err := np.resolveVersion(); err != nil {
+				return np.wrap(err, "Complete")
+			}
+		}
+	}
+	return nil
+}
+
+// resolveVersion resolves the desired control plane version against the ServerConfig.
+func (m *clusterMigrator) resolveVersion() error {
+	m.releaseChannel = getReleaseChannel(m.cluster.ReleaseChannel)
+	if m.opts.InPlaceControlPlaneUpgrade {
+		m.resolvedDesiredControlPlaneVersion = m.cluster.CurrentMasterVersion
+		return nil
+	}
+	if m.opts.AutoVersion {
+		return m.selectVersion()
+	}
+	def, valid := getVersions(m.serverConfig, m.releaseChannel, ControlPlane)
+	var err error
+	m.resolvedDesiredControlPlaneVersion, err = resolveVersion(m.opts.DesiredControlPlaneVersion, def, valid)
+	return err
+}
+
+// upgradePermissions are required to update a cluster or NodePool and wait on the operation.
+var upgradePermissions = []string{"container.clusters.update", "container.operations.get"}
+
+// Permissions returns the permissions required to upgrade the control plane, if its cluster
+// is on a legacy network, to add a maintenance exclusion, if configured, and to upgrade its NodePools.
+func (m *clusterMigrator) Permissions() []string {
+	var permissions []string
+	if m.cluster.Subnetwork == "" || m.opts.MaintenanceExclusion > 0 {
+		permissions = append(permissions, upgradePermissions...)
+	}
+	if m.cluster.Subnetwork == "" {
+		// The cluster is read once upgraded to confirm its subnetwork.
+		permissions = append(permissions, "container.clusters.get")
+	}
+	if m.upgradesPlanned() {
+		// Upgrades in progress, e.g. auto-upgrades, are listed and waited on before upgrading.
+		permissions = append(permissions, "container.operations.list")
+	}
+	return append(permissions, migrate.Permissions(m.children...)...)
+}
+
+// upgradesPlanned returns whether the control plane or any NodePool of the cluster is to be upgraded.
+// NodePools require permissions only if they are to be upgraded.
+func (m *clusterMigrator) upgradesPlanned() bool {
+	return m.cluster.Subnetwork == "" || len(migrate.Permissions(m.children...)) > 0
 }
 
 // Validate confirms that this an any child migrators are valid.
 func (m *clusterMigrator) Validate(ctx context.Context) error {
-	_, valid := getVersions(m.serverConfig, m.releaseChannel, ControlPlane)
-	if err := isUpgrade(m.resolvedDesiredControlPlaneVersion, m.cluster.CurrentMasterVersion, valid, true); err != nil {
-		return fmt.Errorf("validation error for Cluster %s: %w", m.ResourcePath(), err)
+	if err := m.checkUpgrade(); err != nil {
+		return err
 	}
 
 	log.Infof("Upgrade for Cluster %s is valid; desired: %q (%s), current: %s",
 		m.ResourcePath(), m.opts.DesiredControlPlaneVersion, m.resolvedDesiredControlPlaneVersion, m.cluster.CurrentMasterVersion)
+	if path, err := m.upgradePath(); err == nil && m.cluster.Subnetwork == "" && len(path) > 1 {
+		log.Infof("Control plane of Cluster %s will be upgraded in %d steps: %s", m.ResourcePath(), len(path), strings.Join(path, " -> "))
+	}
 	log.Infof("Validate NodePool upgrade(s) for Cluster %s", m.ResourcePath())
 	sem := make(chan struct{}, m.opts.ConcurrentNodePools)
 	return migrate.Validate(ctx, sem, m.children...)
 }
 
+// checkUpgrade confirms that the desired control plane version is a valid upgrade from the current version.
+func (m *clusterMigrator) checkUpgrade() error {
+	_, valid := getVersions(m.serverConfig, m.releaseChannel, ControlPlane)
+	if err := isUpgrade(m.resolvedDesiredControlPlaneVersion, m.cluster.CurrentMasterVersion, valid, true); err != nil {
+		return fmt.Errorf("validation error for Cluster %s: %w", m.ResourcePath(), err)
+	}
+	if m.cluster.Subnetwork == "" {
+		path, err := m.upgradePath()
+		if err != nil {
+			return fmt.Errorf("validation error for Cluster %s: %w", m.ResourcePath(), err)
+		}
+		if err := m.checkNodePoolSteps(path); err != nil {
+			return fmt.Errorf("validation error for Cluster %s: %w", m.ResourcePath(), err)
+		}
+	}
+	return nil
+}
+
 // Migrate performs upgrade on the Cluster
-func (m *clusterMigrator) Migrate(ctx context.Context) error {
-	if err := operations.WaitForOperationInProgress(ctx, m.upgradeControlPlane, m.wait); err != nil {
+func (m *clusterMigrator) Migrate(ctx context.Context) (err error) {
+	if err := m.refresh(ctx); err != nil {
+		return err
+	}
+
+	// Upgrades in progress may change the versions resolved, so they are waited on before approval.
+	if m.upgradesPlanned() {
+		if err := m.awaitUpgrades(ctx); err != nil {
+			return err
+		}
+	}
+
+	var approved []string
+	if m.cluster.Subnetwork == "" {
+		var ok bool
+		approved, ok, err = m.confirmUpgrade(ctx, "Upgrade control plane", m.cluster.CurrentMasterVersion)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Infof("Skipping upgrades for Cluster %s and its NodePool(s).", m.ResourcePath())
+			return nil
+		}
+	}
+
+	if m.opts.MaintenanceExclusion > 0 {
+		if err := m.addMaintenanceExclusion(ctx); err != nil {
+			return err
+		}
+		defer func() {
+			err = multierr.Append(err, m.removeMaintenanceExclusion())
+		}()
+	}
+
+	ok, err := m.upgradeControlPlane(ctx, approved)
+	if err != nil {
 		return err
 	}
+	if !ok {
+		log.Infof("Skipping remaining upgrades for Cluster %s and its NodePool(s).", m.ResourcePath())
+		return nil
+	}
 
 	return m.upgradeNodePools(ctx)
 }
 
-func (m *clusterMigrator) upgradeControlPlane(ctx context.Context) error {
+// errPathChanged is returned when the control plane upgrade path changes after it was approved.
+var errPathChanged = errors.New("control plane upgrade path changed since approval")
+
+// confirmUpgrade requests approval of the control plane upgrade from current along its whole upgrade path,
+// returning the path approved. Intermediate versions are approved with the path, not individually.
+func (m *clusterMigrator) confirmUpgrade(ctx context.Context, action, current string) ([]string, bool, error) {
+	path, err := m.upgradePathFrom(current)
+	if err != nil {
+		return nil, false, fmt.Errorf("error upgrading control plane for Cluster %s: %w", m.ResourcePath(), err)
+	}
+	ok, err := approval.Confirm(ctx, m.opts.Approver, approval.Step{
+		Action:         action,
+		ResourcePath:   m.ResourcePath(),
+		CurrentVersion: current,
+		DesiredVersion: path[len(path)-1],
+		Path:           path[:len(path)-1],
+	})
+	return path, ok, err
+}
+
+// upgradeControlPlane upgrades the control plane along the approved path, one minor version at a time.
+// Before each step, NodePools which would fall outside of MaxVersionSkew are upgraded.
+// If the path changes since it was approved, e.g. once an operation in progress is waited on and the
+// cluster refreshed, the new path requires approval; false is returned if it, or a NodePool step, is skipped.
+func (m *clusterMigrator) upgradeControlPlane(ctx context.Context, approved []string) (bool, error) {
 	if m.cluster.Subnetwork != "" {
 		log.Infof("Cluster %s does not require control plane upgrade.", m.ResourcePath())
+		return true, nil
+	}
+
+	if len(approved) > 1 {
+		log.Infof("Upgrading control plane for Cluster %s in %d steps: %s", m.ResourcePath(), len(approved), strings.Join(approved, " -> "))
+	}
+
+	// current is the control plane version, updated as each step completes and once the cluster is refreshed.
+	current := m.cluster.CurrentMasterVersion
+	waitAndRefresh := func(ctx context.Context, c *operations.Conflict) error {
+		if err := m.waitAndRefresh(ctx, c); err != nil {
+			return err
+		}
+		current = m.cluster.CurrentMasterVersion
 		return nil
 	}
+	// changed reports whether the remaining upgrade path differs from the one approved.
+	changed := func() (bool, error) {
+		path, err := m.upgradePathFrom(current)
+		if err != nil {
+			return false, fmt.Errorf("error upgrading control plane for Cluster %s: %w", m.ResourcePath(), err)
+		}
+		return !equalVersions(path, approved), nil
+	}
+
+	for len(approved) > 0 {
+		c, err := changed()
+		if err != nil {
+			return false, err
+		}
+		if c {
+			log.Warnf("Control plane upgrade path for Cluster %s changed since it was approved.", m.ResourcePath())
+			var ok bool
+			approved, ok, err = m.confirmUpgrade(ctx, "Upgrade control plane (changed since approval)", current)
+			if err != nil || !ok {
+				return ok, err
+			}
+		}
+
+		v := approved[0]
+		if ok, err := m.stepNodePools(ctx, current, v); err != nil || !ok {
+			return ok, err
+		}
+		upgrade := func(ctx context.Context) error {
+			// The cluster is refreshed once a conflicting operation is waited on.
+			if c, err := changed(); err != nil || c {
+				if err == nil {
+					err = errPathChanged
+				}
+				return err
+			}
+			return m.upgradeMaster(ctx, v)
+		}
+		if err := operations.WaitForOperationInProgress(ctx, upgrade, waitAndRefresh); errors.Is(err, errPathChanged) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		current = v
+		approved = approved[1:]
+	}
 
+	resp, err := m.clients.Container.GetCluster(ctx, m.ResourcePath())
+	if err != nil {
+		return false, fmt.Errorf("unable to confirm subnetwork value for cluster %s: %w", m.ResourcePath(), err)
+	}
+	if resp.Subnetwork == "" {
+		return false, fmt.Errorf("subnetwork field is empty for cluster %s", m.ResourcePath())
+	}
+
+	return true, nil
+}
+
+// upgradeMaster upgrades the control plane to the version and waits for the upgrade to complete.
+func (m *clusterMigrator) upgradeMaster(ctx context.Context, version string) error {
 	req := &container.UpdateMasterRequest{
 		Name:          m.ResourcePath(),
-		MasterVersion: m.resolvedDesiredControlPlaneVersion,
+		MasterVersion: version,
 	}
 
 	log.Infof("Upgrading control plane for Cluster %q to version %q", req.Name, req.MasterVersion)
@@ -145,9 +379,11 @@ func (m *clusterMigrator) upgradeControlPlane(ctx context.Context) error {
 
 	path := pkg.PathRegex.FindString(op.SelfLink)
 	w := &ContainerOperation{
-		ProjectID: m.projectID,
-		Path:      path,
-		Client:    m.clients.Container,
+		ProjectID:     m.projectID,
+		Path:          path,
+		OperationType: operations.TypeControlPlane,
+		Client:        m.clients.Container,
+		Cancelable:    true,
 	}
 	if err := m.handler.Wait(ctx, w); err != nil {
 		return fmt.Errorf("error waiting on Operation %s: %w", path, err)
@@ -155,14 +391,6 @@ func (m *clusterMigrator) upgradeControlPlane(ctx context.Context) error {
 
 	log.Infof("Upgraded control plane for Cluster %q to version %q", req.Name, req.MasterVersion)
 
-	resp, err := m.clients.Container.GetCluster(ctx, m.ResourcePath())
-	if err != nil {
-		return fmt.Errorf("unable to confirm subnetwork value for cluster %s: %w", m.ResourcePath(), err)
-	}
-	if resp.Subnetwork == "" {
-		return fmt.Errorf("subnetwork field is empty for cluster %s", m.ResourcePath())
-	}
-
 	return nil
 }
 
@@ -179,8 +407,18 @@ func (m *clusterMigrator) ResourcePath() string {
 	return pkg.ClusterPath(m.projectID, m.cluster.Location, m.cluster.Name)
 }
 
-func (m *clusterMigrator) wait(ctx context.Context, opID string) error {
-	name := pkg.OperationsPath(m.projectID, m.cluster.Location, opID)
+// Kind returns the kind of resource migrated.
+func (m *clusterMigrator) Kind() string {
+	return migrate.KindCluster
+}
+
+// wait waits on the operation conflicting with an upgrade, in the cluster's location unless reported otherwise.
+func (m *clusterMigrator) wait(ctx context.Context, c *operations.Conflict) error {
+	location := m.cluster.Location
+	if c.Location != "" {
+		location = c.Location
+	}
+	name := pkg.OperationsPath(m.projectID, location, c.Operation)
 	op, err := m.clients.Container.GetOperation(ctx, name)
 	if err != nil {
 		return err
@@ -188,9 +426,10 @@ func (m *clusterMigrator) wait(ctx context.Context, opID string) error {
 	opPath := pkg.PathRegex.FindString(op.SelfLink)
 
 	w := &ContainerOperation{
-		ProjectID: m.projectID,
-		Path:      opPath,
-		Client:    m.clients.Container,
+		ProjectID:     m.projectID,
+		Path:          opPath,
+		OperationType: containerOperationType(op),
+		Client:        m.clients.Container,
 	}
 	if err := m.handler.Wait(ctx, w); err != nil {
 		return fmt.Errorf("error waiting on ongoing operation %s: %w", name, err)
@@ -199,15 +438,67 @@ func (m *clusterMigrator) wait(ctx context.Context, opID string) error {
 }
 
 type ContainerOperation struct {
-	ProjectID string
-	Path      string
-	Client    pkg.ContainerService
+	ProjectID     string
+	Path          string
+	OperationType operations.OperationType
+	// Nodes is the number of nodes affected by the operation, if known.
+	Nodes  int64
+	Client pkg.ContainerService
+	// Cancelable is set for operations started by this tool (i.e. upgrades), which may be
+	// cancelled on abort. Operations which are only waited on, e.g. auto-upgrades or
+	// operations started by other clients, are never cancelled.
+	Cancelable bool
+
+	// last is the Operation returned by the most recent poll.
+	last *container.Operation
 }
 
 func (o *ContainerOperation) String() string {
 	return o.Path
 }
 
+// Type returns the type of the operation, used to select a polling strategy.
+func (o *ContainerOperation) Type() operations.OperationType {
+	return o.OperationType
+}
+
+// NodeCount returns the number of nodes affected by the operation, used to scale its deadline.
+func (o *ContainerOperation) NodeCount() int64 {
+	return o.Nodes
+}
+
+// Progress returns the fraction of work complete reported by the most recent poll.
+func (o *ContainerOperation) Progress() (float64, bool) {
+	if o.last == nil {
+		return 0, false
+	}
+	return operationProgress(o.last.Progress)
+}
+
+// StatusMessage returns the status reported by the most recent poll.
+func (o *ContainerOperation) StatusMessage() string {
+	if o.last == nil {
+		return ""
+	}
+	if o.last.StatusMessage != "" {
+		return o.last.StatusMessage
+	}
+	return o.last.Detail
+}
+
+// CanCancel reports whether the operation may be cancelled.
+func (o *ContainerOperation) CanCancel() bool {
+	return o.Cancelable
+}
+
+// Cancel cancels the operation server-side.
+func (o *ContainerOperation) Cancel(ctx context.Context) error {
+	if !o.Cancelable {
+		return fmt.Errorf("operation %s was not started by this tool and may not be cancelled", o.Path)
+	}
+	return o.Client.CancelOperation(ctx, o.Path)
+}
+
 func (o *ContainerOperation) poll(ctx context.Context) (operations.OperationStatus, error) {
 	log.Debugf("Polling for %s", o.String())
 
@@ -217,6 +508,7 @@ func (o *ContainerOperation) poll(ctx context.Context) (operations.OperationStat
 	if err != nil {
 		return status, fmt.Errorf("error retrieving Operation %s: %w", o.Path, err)
 	}
+	o.last = resp
 
 	status = operationStatus(resp)
 
@@ -228,6 +520,49 @@ func (o *ContainerOperation) IsFinished(ctx context.Context) (bool, error) {
 	return operations.IsFinished(ctx, o.poll)
 }
 
+// containerOperationType returns the OperationType for a GKE Operation.
+func containerOperationType(op *container.Operation) operations.OperationType {
+	switch op.OperationType {
+	case "UPGRADE_MASTER":
+		return operations.TypeControlPlane
+	case "UPGRADE_NODES":
+		return operations.TypeNodePool
+	default:
+		return operations.TypeUnknown
+	}
+}
+
+// operationProgress derives the fraction of work complete from pairs of <NAME>_TOTAL
+// and <NAME>_DONE (or <NAME>_COMPLETE) metrics, e.g. NODES_TOTAL and NODES_DONE.
+func operationProgress(p *container.OperationProgress) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	values := make(map[string]int64)
+	for _, m := range p.Metrics {
+		values[m.Name] = m.IntValue
+	}
+	var done, total int64
+	for name, v := range values {
+		if !strings.HasSuffix(name, "_TOTAL") || v <= 0 {
+			continue
+		}
+		prefix := strings.TrimSuffix(name, "_TOTAL")
+		d, ok := values[prefix+"_DONE"]
+		if !ok {
+			d, ok = values[prefix+"_COMPLETE"]
+		}
+		if ok {
+			done += d
+			total += v
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(done) / float64(total), true
+}
+
 func operationStatus(op *container.Operation) operations.OperationStatus {
 	var msg string
 	if op.Error != nil {
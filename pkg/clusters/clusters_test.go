@@ -1,7 +1,7 @@
 /*
 Copyright © 2021 Google
 
-Licensed under the Apache License, version 2.0 (the "License");
+Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
 You may obtain a copy of the License at
 
@@ -16,18 +16,25 @@ limitations under the License.
 package clusters
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/faults"
 	"legacymigration/pkg/migrate"
 	"legacymigration/pkg/operations"
 	"legacymigration/test"
 
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
 )
 
 var (
@@ -43,7 +50,7 @@ func TestClusterMigrator_Complete(t *testing.T) {
 	t.Parallel()
 	cases := []struct {
 		desc         string
-		clients      *pkg.Clients
+		faults       []faults.Fault
 		opts         *Options
 		want         string
 		wantChildren int
@@ -51,43 +58,35 @@ func TestClusterMigrator_Complete(t *testing.T) {
 	}{
 		{
 			desc:         "Success",
-			clients:      test.DefaultClients(),
 			opts:         testOptions,
 			want:         "1.19.10-gke.1600",
-			wantChildren: len(test.DefaultFakeContainer().ListNodePoolsResp.NodePools),
+			wantChildren: 1,
 		},
 		{
-			desc: "ListNodePools error",
-			clients: func(clients *pkg.Clients) *pkg.Clients {
-				clients.Container.(*test.FakeContainer).ListNodePoolsErr = errors.New("an error")
-				return clients
-			}(test.DefaultClients()),
+			desc:    "ListNodePools error",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "ListNodePools"}},
 			opts:    testOptions,
 			wantErr: "error retrieving NodePools for Cluster",
 		},
 		{
-			desc: "GetServerConfig error",
-			clients: func(clients *pkg.Clients) *pkg.Clients {
-				clients.Container.(*test.FakeContainer).GetServerConfigErr = errors.New("an error")
-				return clients
-			}(test.DefaultClients()),
+			desc:    "GetServerConfig error",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "GetServerConfig"}},
 			opts:    testOptions,
 			wantErr: "error retrieving ServerConfig for Cluster",
 		},
 		{
-			desc:    "Success - in-place upgrade",
-			clients: test.DefaultClients(),
+			desc: "Success - in-place upgrade",
 			opts: &Options{
 				ConcurrentNodePools:        1,
 				InPlaceControlPlaneUpgrade: true,
 			},
-			wantChildren: len(test.DefaultFakeContainer().ListNodePoolsResp.NodePools),
+			wantChildren: 1,
 			want:         "1.19.10-gke.1600",
 		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			m := testClusterMigrator(&test.PrePatchCluster, testOptions, tc.clients)
+			m := testClusterMigrator(&test.PrePatchCluster, testOptions, testClients(t, testState(), tc.faults...))
 
 			err := m.Complete(context.Background())
 			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
@@ -109,7 +108,7 @@ func TestClusterMigrator_Complete(t *testing.T) {
 
 func TestClusterMigrator_Complete_Error(t *testing.T) {
 	want := "child error"
-	m := testClusterMigrator(&test.PrePatchCluster, testOptions, test.DefaultClients())
+	m := testClusterMigrator(&test.PrePatchCluster, testOptions, testClients(t, testState()))
 	m.factory = func(_ *container.NodePool) migrate.Migrator {
 		return &migrate.FakeMigrator{CompleteError: errors.New(want)}
 	}
@@ -170,7 +169,7 @@ func TestClusterMigrator_Validate(t *testing.T) {
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			m := testClusterMigrator(&test.PrePatchCluster, testOptions, test.DefaultClients())
+			m := testClusterMigrator(&test.PrePatchCluster, testOptions, nil)
 			m.resolvedDesiredControlPlaneVersion = tc.resolved
 			m.serverConfig = tc.config
 			m.children = tc.children
@@ -183,125 +182,164 @@ func TestClusterMigrator_Validate(t *testing.T) {
 	}
 }
 
+func TestClusterMigrator_Permissions(t *testing.T) {
+	converted := test.PrePatchCluster
+	converted.Subnetwork = "subnetwork"
+	child := &migrate.FakeMigrator{RequiredPermissions: []string{"compute.instanceTemplates.get"}}
+	cases := []struct {
+		desc     string
+		cluster  *container.Cluster
+		opts     *Options
+		children []migrate.Migrator
+		want     []string
+	}{
+		{
+			desc:    "Control plane upgrade",
+			cluster: &test.PrePatchCluster,
+			opts:    testOptions,
+			want:    []string{"container.clusters.get", "container.clusters.update", "container.operations.get", "container.operations.list"},
+		},
+		{
+			desc:     "Converted cluster",
+			cluster:  &converted,
+			opts:     testOptions,
+			children: []migrate.Migrator{child},
+			want:     []string{"compute.instanceTemplates.get", "container.operations.list"},
+		},
+		{
+			desc:    "Converted cluster with maintenance exclusion",
+			cluster: &converted,
+			opts:    &Options{MaintenanceExclusion: time.Hour},
+			want:    []string{"container.clusters.update", "container.operations.get"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := testClusterMigrator(tc.cluster, tc.opts, nil)
+			m.children = tc.children
+
+			if diff := cmp.Diff(tc.want, migrate.Permissions(m)); diff != "" {
+				t.Errorf("clusterMigrator.Permissions diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestClusterMigrator_Migrate(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
-	clients := test.DefaultClients()
 	cancelled, cancel := context.WithCancel(context.Background())
 	cancel()
-	c := test.PrePatchCluster
+	clusterPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName)
+	// The default version of the test ServerConfig is not a valid control plane version.
+	opts := &Options{ConcurrentNodePools: 1, DesiredControlPlaneVersion: LatestVersion}
 
 	cases := []struct {
-		desc    string
-		ctx     context.Context
-		m       *clusterMigrator
-		wantErr string
+		desc     string
+		ctx      context.Context
+		state    *fakeapi.State
+		faults   []faults.Fault
+		opts     *Options
+		children []migrate.Migrator
+		wantErr  string
 	}{
 		{
 			desc: "Success",
 			ctx:  ctx,
-			m:    testClusterMigrator(&c, testOptions, clients),
 		},
 		{
 			desc: "Subnet field already present",
 			ctx:  ctx,
-			m:    testClusterMigrator(&container.Cluster{Subnetwork: "subnet"}, testOptions, clients),
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Clusters[0].Subnetwork = "subnet"
+				return s
+			}(testState()),
+			faults: []faults.Fault{{Kind: faults.Error, Method: "UpdateMaster", Message: "should not upgrade"}},
 		},
 		{
 			desc: "UpdateMaster in progress",
 			ctx:  ctx,
-			m: testClusterMigrator(
-				&c,
-				testOptions,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).UpdateMasterResps = []*container.Operation{
-						nil,
-						{Status: test.OperationDone},
-					}
-					clients.Container.(*test.FakeContainer).UpdateMasterErrs = []error{
-						test.ErrorInProgress,
-						nil,
-					}
-					return clients
-				}(test.DefaultClients())),
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Operations = []*container.Operation{{
+					Name:          "operation-set-labels",
+					OperationType: "SET_LABELS",
+					Status:        statusRunning,
+					Location:      test.RegionA,
+					TargetLink:    targetLink(clusterPath),
+					SelfLink:      targetLink(pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-set-labels")),
+				}}
+				return s
+			}(testState()),
 		},
 		{
-			desc: "UpdateMaster error",
-			ctx:  ctx,
-			m: testClusterMigrator(
-				&c,
-				testOptions,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).UpdateMasterErrs = []error{errors.New("unrecoverable error")}
-					clients.Container.(*test.FakeContainer).GetOperationErrs = []error{errors.New("not found")}
-					return clients
-				}(test.DefaultClients())),
+			desc:    "UpdateMaster error",
+			ctx:     ctx,
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "UpdateMaster", Message: "unrecoverable error"}},
 			wantErr: "error upgrading control plane for Cluster",
 		},
 		{
-			desc: "GetCluster error",
-			ctx:  ctx,
-			m: testClusterMigrator(
-				&c,
-				testOptions,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).GetClusterErr = errors.New("cannot get cluster")
-					return clients
-				}(test.DefaultClients())),
+			desc:    "GetCluster error",
+			ctx:     ctx,
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "GetCluster", After: 1, Message: "cannot get cluster"}},
 			wantErr: "unable to confirm subnetwork value for cluster",
 		},
 		{
-			desc: "Patch not performed",
-			ctx:  ctx,
-			m: testClusterMigrator(
-				&c,
-				testOptions,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).GetClusterResp = &test.PrePatchCluster
-					return clients
-				}(test.DefaultClients())),
+			// Control plane upgrades only add a subnetwork once the network is converted.
+			desc:    "Patch not performed",
+			ctx:     ctx,
+			state:   testScenario().State(),
 			wantErr: "subnetwork field is empty for cluster",
 		},
 		{
-			desc: "Polling failure",
-			ctx:  ctx,
-			m: testClusterMigrator(
-				&c,
-				testOptions,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).GetOperationErrs = []error{errors.New("operation get failed")}
-					return clients
-				}(test.DefaultClients())),
-			wantErr: "error retrieving Operation projects/test-project/locations/region-a/operations/operation-update-master: operation get failed",
+			desc:    "Polling failure",
+			ctx:     ctx,
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "GetOperation", Message: "operation get failed"}},
+			wantErr: "error retrieving Operation projects/test-project/locations/region-a/operations/operation-1-00000001: googleapi: Error 503: operation get failed",
 		},
 		{
 			desc: "Operation failure",
 			ctx:  ctx,
-			m: testClusterMigrator(
-				&c,
-				testOptions,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).GetOperationResps = []*container.Operation{
-						{
-							Name:   "op",
-							Status: test.OperationDone,
-							Error:  &container.Status{Message: "operation failed"},
-						},
-					}
-					return clients
-				}(test.DefaultClients())),
-			wantErr: "error waiting on Operation projects/test-project/locations/region-a/operations/operation-update-master: operation failed",
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Script.Failures = []fakeapi.Failure{{OperationType: "UPGRADE_MASTER", Message: "operation failed"}}
+				return s
+			}(testState()),
+			wantErr: "error waiting on Operation projects/test-project/locations/region-a/operations/operation-1-00000001: operation failed",
 		},
 		{
 			desc:    "Context cancelled",
 			ctx:     cancelled,
-			m:       testClusterMigrator(&c, testOptions, clients),
-			wantErr: "context error: context canceled",
+			wantErr: "error retrieving Cluster projects/test-project/locations/region-a/clusters/cluster-c: context canceled",
+		},
+		{
+			desc:     "Upgrade skipped",
+			ctx:      ctx,
+			faults:   []faults.Fault{{Kind: faults.Error, Method: "UpdateMaster", Message: "should not upgrade"}},
+			opts:     &Options{Approver: approval.NewPrompter(strings.NewReader("s\n"), &bytes.Buffer{})},
+			children: []migrate.Migrator{&migrate.FakeMigrator{MigrateError: errors.New("should not migrate")}},
+		},
+		{
+			desc:    "Upgrade aborted",
+			ctx:     ctx,
+			opts:    &Options{Approver: approval.NewPrompter(strings.NewReader("a\n"), &bytes.Buffer{})},
+			wantErr: "conversion aborted by user",
 		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			err := tc.m.Migrate(tc.ctx)
+			state, o := tc.state, tc.opts
+			if state == nil {
+				state = testState()
+			}
+			if o == nil {
+				o = opts
+			}
+			c := test.PrePatchCluster
+			m := testClusterMigrator(&c, o, testClients(t, state, tc.faults...))
+			if tc.children != nil {
+				m.children = tc.children
+			}
+
+			err := m.Migrate(tc.ctx)
 			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
 				t.Errorf("clusterMigrator.Migrate diff (-want +got):\n%s", diff)
 			}
@@ -321,3 +359,196 @@ func testClusterMigrator(c *container.Cluster, opts *Options, clients *pkg.Clien
 		},
 	}
 }
+
+// testScenario returns test.PrePatchCluster, with a single node pool, on a legacy network.
+func testScenario() *fakeapi.Scenario {
+	return &fakeapi.Scenario{
+		ProjectID: test.ProjectName,
+		Network:   test.SelectedNetwork,
+		Clusters: []fakeapi.ScenarioCluster{
+			{
+				Name:      test.ClusterName,
+				Location:  test.RegionA,
+				Version:   test.PrePatchCluster.CurrentMasterVersion,
+				NodePools: []fakeapi.ScenarioNodePool{{Name: test.NodePoolName}},
+			},
+		},
+		ServerConfig: test.ServerConfig(),
+	}
+}
+
+// testState returns the State of the testScenario once its network is converted, as when its clusters are upgraded.
+// The cluster is test.PrePatchCluster, so that it has not drifted from the cluster read by a migrator.
+func testState() *fakeapi.State {
+	s := testScenario().State()
+	s.Networks[0].IPv4Range, s.Networks[0].GatewayIPv4 = "", ""
+	c := test.PrePatchCluster
+	c.NodePools = s.Clusters[0].NodePools
+	s.Clusters[0] = &c
+	return s
+}
+
+// testClients returns clients of a fake API serving the State, into which the faults are injected.
+func testClients(t *testing.T, s *fakeapi.State, fs ...faults.Fault) *pkg.Clients {
+	t.Helper()
+	srv, err := fakeapi.New(s)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	injector, err := faults.New(&faults.Config{Faults: fs})
+	if err != nil {
+		t.Fatalf("faults.New unexpected error: %v", err)
+	}
+	return injector.Wrap(srv.Clients())
+}
+
+// recorder records the mutations requested of a ContainerService.
+type recorder struct {
+	pkg.ContainerService
+
+	mu                   sync.Mutex
+	updateMaster         []*container.UpdateMasterRequest
+	updateNodePool       []*container.UpdateNodePoolRequest
+	setMaintenancePolicy []*container.SetMaintenancePolicyRequest
+	cancelled            []string
+}
+
+// record replaces the clients' ContainerService with a recorder.
+func record(clients *pkg.Clients) *recorder {
+	r := &recorder{ContainerService: clients.Container}
+	clients.Container = r
+	return r
+}
+
+func (r *recorder) UpdateMaster(ctx context.Context, req *container.UpdateMasterRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	r.mu.Lock()
+	r.updateMaster = append(r.updateMaster, req)
+	r.mu.Unlock()
+	return r.ContainerService.UpdateMaster(ctx, req, opts...)
+}
+
+func (r *recorder) UpdateNodePool(ctx context.Context, req *container.UpdateNodePoolRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	r.mu.Lock()
+	r.updateNodePool = append(r.updateNodePool, req)
+	r.mu.Unlock()
+	return r.ContainerService.UpdateNodePool(ctx, req, opts...)
+}
+
+func (r *recorder) SetMaintenancePolicy(ctx context.Context, req *container.SetMaintenancePolicyRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	r.mu.Lock()
+	r.setMaintenancePolicy = append(r.setMaintenancePolicy, req)
+	r.mu.Unlock()
+	return r.ContainerService.SetMaintenancePolicy(ctx, req, opts...)
+}
+
+func (r *recorder) CancelOperation(ctx context.Context, name string, opts ...googleapi.CallOption) error {
+	r.mu.Lock()
+	r.cancelled = append(r.cancelled, name)
+	r.mu.Unlock()
+	return r.ContainerService.CancelOperation(ctx, name, opts...)
+}
+
+func TestContainerOperation_Progress(t *testing.T) {
+	cases := []struct {
+		desc      string
+		progress  *container.OperationProgress
+		want      float64
+		wantKnown bool
+	}{
+		{
+			desc: "No progress",
+		},
+		{
+			desc: "Nodes done",
+			progress: &container.OperationProgress{
+				Metrics: []*container.Metric{
+					{Name: "NODES_TOTAL", IntValue: 8},
+					{Name: "NODES_DONE", IntValue: 2},
+					{Name: "NODES_FAILED", IntValue: 0},
+				},
+			},
+			want:      0.25,
+			wantKnown: true,
+		},
+		{
+			desc: "Nodes complete",
+			progress: &container.OperationProgress{
+				Metrics: []*container.Metric{
+					{Name: "NODES_COMPLETE", IntValue: 3},
+					{Name: "NODES_TOTAL", IntValue: 4},
+				},
+			},
+			want:      0.75,
+			wantKnown: true,
+		},
+		{
+			desc: "Total without done",
+			progress: &container.OperationProgress{
+				Metrics: []*container.Metric{
+					{Name: "NODES_TOTAL", IntValue: 4},
+				},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := testState()
+			s.Operations = []*container.Operation{{Name: "operation-progress", Status: statusRunning, Location: test.RegionA, Progress: tc.progress}}
+			clients := testClients(t, s)
+			o := &ContainerOperation{Path: pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-progress"), Client: clients.Container}
+			if _, known := o.Progress(); known {
+				t.Errorf("ContainerOperation.Progress known before first poll")
+			}
+
+			if _, err := o.IsFinished(context.Background()); err != nil {
+				t.Fatalf("ContainerOperation.IsFinished unexpected error: %v", err)
+			}
+			got, known := o.Progress()
+			if got != tc.want || known != tc.wantKnown {
+				t.Errorf("ContainerOperation.Progress; wanted: %v, %v, got: %v, %v", tc.want, tc.wantKnown, got, known)
+			}
+		})
+	}
+}
+
+func TestContainerOperationType(t *testing.T) {
+	cases := map[string]operations.OperationType{
+		"UPGRADE_MASTER": operations.TypeControlPlane,
+		"UPGRADE_NODES":  operations.TypeNodePool,
+		"UPDATE_CLUSTER": operations.TypeUnknown,
+	}
+	for opType, want := range cases {
+		if got := containerOperationType(&container.Operation{OperationType: opType}); got != want {
+			t.Errorf("containerOperationType(%q); wanted: %q, got: %q", opType, want, got)
+		}
+	}
+}
+
+func TestContainerOperation_Cancel(t *testing.T) {
+	s := testState()
+	s.Operations = []*container.Operation{
+		{Name: "operation-cancel", Status: statusRunning, Location: test.RegionA},
+		{Name: "operation-other", Status: statusRunning, Location: test.RegionA},
+	}
+	clients := testClients(t, s)
+	rec := record(clients)
+	o := &ContainerOperation{Path: pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-cancel"), Client: clients.Container, Cancelable: true}
+
+	if err := o.Cancel(context.Background()); err != nil {
+		t.Fatalf("ContainerOperation.Cancel unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{o.Path}, rec.cancelled); diff != "" {
+		t.Errorf("CancelOperation calls diff (-want +got):\n%s", diff)
+	}
+
+	other := &ContainerOperation{Path: pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-other"), Client: clients.Container}
+	if other.CanCancel() {
+		t.Errorf("ContainerOperation.CanCancel; wanted: false, got: true")
+	}
+	if diff := test.ErrorDiff("may not be cancelled", other.Cancel(context.Background())); diff != "" {
+		t.Errorf("ContainerOperation.Cancel diff (-want +got):\n%s", diff)
+	}
+	if len(rec.cancelled) != 1 {
+		t.Errorf("CancelOperation calls; wanted: 1, got: %v", rec.cancelled)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"legacymigration/pkg"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/container/v1"
+)
+
+// List returns the clusters in the locations, or in all locations if none are given, and the sorted
+// zones which could not be listed. Clusters in such zones are omitted, so zones missing from a response
+// are listed again individually before being reported.
+func List(ctx context.Context, client pkg.ContainerService, projectID string, locations []string) ([]*container.Cluster, []string, error) {
+	if len(locations) == 0 {
+		locations = []string{pkg.AnyLocation}
+	}
+	var (
+		clusters []*container.Cluster
+		seen     = make(map[string]bool)
+		missing  = make(map[string]bool)
+	)
+	add := func(cs []*container.Cluster) {
+		for _, c := range cs {
+			path := pkg.ClusterPath(projectID, c.Location, c.Name)
+			if !seen[path] {
+				seen[path] = true
+				clusters = append(clusters, c)
+			}
+		}
+	}
+	for _, location := range locations {
+		resp, err := client.ListClusters(ctx, pkg.LocationPath(projectID, location))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing Clusters in location %s: %w", location, err)
+		}
+		add(resp.Clusters)
+		for _, zone := range resp.MissingZones {
+			if zone == location || missing[zone] {
+				missing[zone] = true
+				continue
+			}
+			log.Infof("Clusters.List response for location %s is missing zone %s; listing it again.", location, zone)
+			retry, err := client.ListClusters(ctx, pkg.LocationPath(projectID, zone))
+			switch {
+			case err != nil:
+				log.Warnf("Unable to list Clusters in zone %s: %v", zone, err)
+			case contains(retry.MissingZones, zone):
+				log.Warnf("Unable to list Clusters in zone %s: Clusters.List response is missing it.", zone)
+			default:
+				add(retry.Clusters)
+				continue
+			}
+			missing[zone] = true
+		}
+	}
+
+	var zones []string
+	for zone := range missing {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return clusters, zones, nil
+}
+
+// Excluded lists the clusters in all locations and returns the sorted paths of those for which selected
+// reports true but which are not among listed, e.g. because listed holds only the clusters of some locations.
+// Clusters in zones which could not be listed in all locations are not reported.
+func Excluded(ctx context.Context, client pkg.ContainerService, projectID string, listed []*container.Cluster, selected func(c *container.Cluster) bool) ([]string, error) {
+	all, _, err := List(ctx, client, projectID, nil)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, c := range listed {
+		seen[pkg.ClusterPath(projectID, c.Location, c.Name)] = true
+	}
+	var excluded []string
+	for _, c := range all {
+		path := pkg.ClusterPath(projectID, c.Location, c.Name)
+		if !seen[path] && selected(c) {
+			excluded = append(excluded, path)
+		}
+	}
+	sort.Strings(excluded)
+	return excluded, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"context"
+	"testing"
+
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/faults"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/container/v1"
+)
+
+func TestList(t *testing.T) {
+	regional := &container.Cluster{Name: "regional", Location: test.RegionA}
+	zonal := &container.Cluster{Name: "zonal", Location: test.ZoneA0}
+
+	cases := []struct {
+		desc        string
+		locations   []string
+		script      fakeapi.Script
+		faults      []faults.Fault
+		want        []string
+		wantMissing []string
+		wantErr     string
+	}{
+		{
+			desc: "All locations",
+			want: []string{"region-a/regional", "region-a-0/zonal"},
+		},
+		{
+			desc:   "Missing zone listed again",
+			script: fakeapi.Script{MissingZones: []string{test.ZoneA0}},
+			want:   []string{"region-a/regional", "region-a-0/zonal"},
+		},
+		{
+			desc:        "Missing zone still missing",
+			script:      fakeapi.Script{UnavailableZones: []string{test.ZoneA0}},
+			want:        []string{"region-a/regional"},
+			wantMissing: []string{test.ZoneA0},
+		},
+		{
+			desc:      "Locations",
+			locations: []string{test.RegionA, test.ZoneA0},
+			want:      []string{"region-a/regional", "region-a-0/zonal"},
+		},
+		{
+			desc:        "Missing location",
+			locations:   []string{test.RegionA, test.ZoneA0},
+			script:      fakeapi.Script{UnavailableZones: []string{test.ZoneA0}},
+			want:        []string{"region-a/regional"},
+			wantMissing: []string{test.ZoneA0},
+		},
+		{
+			desc:    "List error",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "ListClusters", Message: "list error"}},
+			wantErr: "error listing Clusters in location -: googleapi: Error 503: list error",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &fakeapi.State{
+				ProjectID: test.ProjectName,
+				Clusters:  []*container.Cluster{regional, zonal},
+				Script:    tc.script,
+			}
+			client := testClients(t, s, tc.faults...).Container
+			clusters, missing, err := List(context.Background(), client, test.ProjectName, tc.locations)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("List error diff (-want +got):\n%s", diff)
+			}
+			var got []string
+			for _, c := range clusters {
+				got = append(got, c.Location+"/"+c.Name)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("List clusters diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantMissing, missing); diff != "" {
+				t.Errorf("List missing zones diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	regional := &container.Cluster{Name: "regional", Location: test.RegionA, Network: test.SelectedNetwork}
+	zonal := &container.Cluster{Name: "zonal", Location: test.ZoneA0, Network: test.SelectedNetwork}
+	other := &container.Cluster{Name: "other", Location: test.ZoneA1, Network: "other-network"}
+	onNetwork := func(c *container.Cluster) bool { return c.Network == test.SelectedNetwork }
+
+	cases := []struct {
+		desc    string
+		listed  []*container.Cluster
+		faults  []faults.Fault
+		want    []string
+		wantErr string
+	}{
+		{
+			desc:   "None excluded",
+			listed: []*container.Cluster{regional, zonal},
+		},
+		{
+			desc:   "Excluded by location",
+			listed: []*container.Cluster{regional},
+			want:   []string{"projects/test-project/locations/region-a-0/clusters/zonal"},
+		},
+		{
+			desc:    "List error",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "ListClusters", Message: "list error"}},
+			wantErr: "error listing Clusters in location -: googleapi: Error 503: list error",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &fakeapi.State{
+				ProjectID: test.ProjectName,
+				Clusters:  []*container.Cluster{regional, zonal, other},
+			}
+			client := testClients(t, s, tc.faults...).Container
+			got, err := Excluded(context.Background(), client, test.ProjectName, tc.listed, onNetwork)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Excluded error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Excluded diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"strings"
 
 	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/migrate"
 	"legacymigration/pkg/operations"
 
 	log "github.com/sirupsen/logrus"
@@ -60,6 +62,20 @@ func (m *nodePoolMigrator) Complete(ctx context.Context) error {
 		return fmt.Errorf("unable to verify state for NodePool %s: %w", m.ResourcePath(), err)
 	}
 
+	if err := m.resolveVersion(); err != nil {
+		return m.wrap(err, "Complete")
+	}
+
+	return nil
+}
+
+// resolveVersion resolves the desired node version against the cluster's ServerConfig.
+func (m *nodePoolMigrator) resolveVersion() error {
+	if m.opts.AutoVersion {
+		// The control plane and nodes are upgraded to the selected version.
+		m.resolvedDesiredNodeVersion = m.resolvedDesiredControlPlaneVersion
+		return nil
+	}
 	def, valid := getVersions(m.serverConfig, m.releaseChannel, Node)
 	if m.opts.DesiredNodeVersion == DefaultVersion {
 		// Node pool upgrade using default alias selects the control plane version.
@@ -67,12 +83,18 @@ func (m *nodePoolMigrator) Complete(ctx context.Context) error {
 		def = m.resolvedDesiredControlPlaneVersion
 	}
 
+	var err error
 	m.resolvedDesiredNodeVersion, err = resolveVersion(m.opts.DesiredNodeVersion, def, valid)
-	if err != nil {
-		return m.wrap(err, "Complete")
-	}
+	return err
+}
 
-	return nil
+// Permissions returns the permissions required to upgrade the NodePool, if required,
+// and to confirm that its InstanceTemplates were patched.
+func (m *nodePoolMigrator) Permissions() []string {
+	if !m.upgradeRequired {
+		return nil
+	}
+	return append([]string{"compute.instanceGroupManagers.get", "compute.instanceTemplates.get"}, upgradePermissions...)
 }
 
 // Validate ensures a NodePool upgrade is an allowed upgrade path.
@@ -81,34 +103,49 @@ func (m *nodePoolMigrator) Validate(_ context.Context) error {
 		log.Infof("State of NodePool %s is valid; does not require an upgrade.", m.ResourcePath())
 		return nil
 	}
-	var (
-		desired  = m.opts.DesiredNodeVersion
-		resolved = m.resolvedDesiredNodeVersion
-		current  = m.nodePool.Version
-
-		// Wrap errors with node pool and method context.
-		wrap = func(err error) error {
-			return m.wrap(err, "Validation")
-		}
-	)
-	_, valid := getVersions(m.serverConfig, m.releaseChannel, Node)
-	if err := isUpgrade(resolved, current, valid, false); err != nil {
-		return wrap(err)
-	}
-
-	if err := IsWithinVersionSkew(resolved, m.resolvedDesiredControlPlaneVersion, MaxVersionSkew); err != nil {
-		return wrap(err)
+	if err := m.checkUpgrade(); err != nil {
+		return m.wrap(err, "Validation")
 	}
 
 	log.Infof("Upgrade for NodePool %s is valid; desired: %q (%s), current: %s",
-		m.ResourcePath(), desired, resolved, current)
+		m.ResourcePath(), m.opts.DesiredNodeVersion, m.resolvedDesiredNodeVersion, m.nodePool.Version)
 
 	return nil
 }
 
+// checkUpgrade confirms that the desired version is a valid upgrade for the NodePool
+// and within the allowed version skew of the desired control plane version.
+func (m *nodePoolMigrator) checkUpgrade() error {
+	_, valid := getVersions(m.serverConfig, m.releaseChannel, Node)
+	if err := isUpgrade(m.resolvedDesiredNodeVersion, m.nodePool.Version, valid, false); err != nil {
+		return err
+	}
+	return IsWithinVersionSkew(m.resolvedDesiredNodeVersion, m.resolvedDesiredControlPlaneVersion, MaxVersionSkew)
+}
+
 // Migrate performs a NodePool upgrade is deemed necessary.
 func (m *nodePoolMigrator) Migrate(ctx context.Context) error {
-	return operations.WaitForOperationInProgress(ctx, m.migrate, m.wait)
+	if err := m.refresh(ctx); err != nil {
+		return err
+	}
+
+	if m.upgradeRequired {
+		ok, err := approval.Confirm(ctx, m.opts.Approver, approval.Step{
+			Action:         "Upgrade NodePool",
+			ResourcePath:   m.ResourcePath(),
+			CurrentVersion: m.nodePool.Version,
+			DesiredVersion: m.resolvedDesiredNodeVersion,
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Infof("Skipping upgrade for NodePool %s.", m.ResourcePath())
+			return nil
+		}
+	}
+
+	return operations.WaitForOperationInProgress(ctx, m.migrate, m.waitAndRefresh)
 }
 
 func (m *nodePoolMigrator) migrate(ctx context.Context) error {
@@ -123,10 +160,28 @@ func (m *nodePoolMigrator) migrate(ctx context.Context) error {
 }
 
 func (m *nodePoolMigrator) upgrade(ctx context.Context) error {
+	if err := m.upgradeTo(ctx, m.resolvedDesiredNodeVersion); err != nil {
+		return err
+	}
+
+	required, err := m.isUpgradeRequired(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to verify post-upgrade state for NodePool %s: %w", m.ResourcePath(), err)
+	}
+	if required {
+		// This should not happen, as the cluster must first be successfully migrated.
+		return fmt.Errorf("state was not patched for NodePool %s", m.ResourcePath())
+	}
+
+	return nil
+}
+
+// upgradeTo upgrades the NodePool to the version and waits for the upgrade to complete.
+func (m *nodePoolMigrator) upgradeTo(ctx context.Context, version string) error {
 	npp := m.ResourcePath()
 	req := &container.UpdateNodePoolRequest{
 		Name:        npp,
-		NodeVersion: m.resolvedDesiredNodeVersion,
+		NodeVersion: version,
 	}
 
 	op, err := m.clients.Container.UpdateNodePool(ctx, req)
@@ -138,9 +193,12 @@ func (m *nodePoolMigrator) upgrade(ctx context.Context) error {
 	log.Infof("Upgrade in progress for NodePool %s; operation: %s", npp, opPath)
 
 	w := &ContainerOperation{
-		ProjectID: m.projectID,
-		Path:      opPath,
-		Client:    m.clients.Container,
+		ProjectID:     m.projectID,
+		Path:          opPath,
+		OperationType: operations.TypeNodePool,
+		Nodes:         nodeCount(m.nodePool),
+		Client:        m.clients.Container,
+		Cancelable:    true,
 	}
 	if err := m.handler.Wait(ctx, w); err != nil {
 		return fmt.Errorf("error waiting on Operation %s: %w", opPath, err)
@@ -148,15 +206,6 @@ func (m *nodePoolMigrator) upgrade(ctx context.Context) error {
 
 	log.Infof("NodePool %s upgraded. ", npp)
 
-	required, err := m.isUpgradeRequired(ctx)
-	if err != nil {
-		return fmt.Errorf("unable to verify post-upgrade state for NodePool %s: %w", m.ResourcePath(), err)
-	}
-	if required {
-		// This should not happen, as the cluster must first be successfully migrated.
-		return fmt.Errorf("state was not patched for NodePool %s", m.ResourcePath())
-	}
-
 	return nil
 }
 
@@ -165,28 +214,39 @@ func (m *nodePoolMigrator) ResourcePath() string {
 	return pkg.NodePoolPath(m.projectID, m.cluster.Location, m.cluster.Name, m.nodePool.Name)
 }
 
+// Kind returns the kind of resource migrated.
+func (m *nodePoolMigrator) Kind() string {
+	return migrate.KindNodePool
+}
+
 // isUpgradeRequired returns whether a the NodePool's state requires an upgrade.
 func (m *nodePoolMigrator) isUpgradeRequired(ctx context.Context) (bool, error) {
+	return IsUpgradeRequired(ctx, m.clients.Compute, m.projectID, m.ResourcePath(), m.nodePool)
+}
+
+// IsUpgradeRequired returns whether the NodePool requires an upgrade, i.e. whether any of
+// its InstanceTemplates are missing a subnetwork. The path is used to contextualize errors.
+func IsUpgradeRequired(ctx context.Context, client pkg.ComputeService, projectID, path string, np *container.NodePool) (bool, error) {
 	var (
 		errors   error
 		required bool
 	)
-	for _, url := range m.nodePool.InstanceGroupUrls {
+	for _, url := range np.InstanceGroupUrls {
 		res := instanceGroupManagerRegex.FindStringSubmatch(url)
 		if res == nil {
-			errors = multierr.Append(errors, fmt.Errorf("unable to parse location and name information from InstanceGroup URL (%s) for NodePool %s", url, m.ResourcePath()))
+			errors = multierr.Append(errors, fmt.Errorf("unable to parse location and name information from InstanceGroup URL (%s) for NodePool %s", url, path))
 			continue
 		}
 
-		igm, err := m.clients.Compute.GetInstanceGroupManager(ctx, m.projectID, res[1], res[2])
+		igm, err := client.GetInstanceGroupManager(ctx, projectID, res[1], res[2])
 		if err != nil {
-			errors = multierr.Append(errors, fmt.Errorf("error retrieving InstanceGroupManagers (%s) for NodePool %s: %w", url, m.ResourcePath(), err))
+			errors = multierr.Append(errors, fmt.Errorf("error retrieving InstanceGroupManagers (%s) for NodePool %s: %w", url, path, err))
 			continue
 		}
 
-		it, err := m.clients.Compute.GetInstanceTemplate(ctx, m.projectID, getName(igm.InstanceTemplate))
+		it, err := client.GetInstanceTemplate(ctx, projectID, getName(igm.InstanceTemplate))
 		if err != nil {
-			errors = multierr.Append(errors, fmt.Errorf("error retrieving GetInstanceTemplateResp %s for NodePool %s: %w", igm.InstanceTemplate, m.ResourcePath(), err))
+			errors = multierr.Append(errors, fmt.Errorf("error retrieving GetInstanceTemplateResp %s for NodePool %s: %w", igm.InstanceTemplate, path, err))
 			continue
 		}
 		missing := true
@@ -203,10 +263,10 @@ func (m *nodePoolMigrator) isUpgradeRequired(ctx context.Context) (bool, error)
 	}
 
 	if errors != nil && !required {
-		return required, fmt.Errorf("error(s) encountered obtaining an InstanceTemplate for NodePool %s: %w", m.ResourcePath(), errors)
+		return required, fmt.Errorf("error(s) encountered obtaining an InstanceTemplate for NodePool %s: %w", path, errors)
 	}
 	if errors != nil {
-		log.Infof("Error(s) retrieving InstanceTemplate(s) for NodePool %s: %v", m.ResourcePath(), errors)
+		log.Infof("Error(s) retrieving InstanceTemplate(s) for NodePool %s: %v", path, errors)
 	}
 
 	return required, nil
@@ -224,3 +284,17 @@ func (m *nodePoolMigrator) wrap(err error, stage string) error {
 	//goland:noinspection ALL
 	return fmt.Errorf("NodePool %s error during %s: %w", m.ResourcePath(), stage, err)
 }
+
+// nodeCount estimates the number of nodes in the node pool from its initial size or,
+// if larger, its autoscaling limit, across all of its zones.
+func nodeCount(np *container.NodePool) int64 {
+	perZone := np.InitialNodeCount
+	if a := np.Autoscaling; a != nil && a.Enabled && a.MaxNodeCount > perZone {
+		perZone = a.MaxNodeCount
+	}
+	zones := int64(len(np.InstanceGroupUrls))
+	if zones == 0 {
+		zones = 1
+	}
+	return perZone * zones
+}
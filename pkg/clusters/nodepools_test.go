@@ -18,16 +18,20 @@ package clusters
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"strings"
 	"testing"
 
 	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/faults"
+	"legacymigration/pkg/migrate"
 	"legacymigration/test"
 
 	"github.com/google/go-cmp/cmp"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/container/v1"
 )
 
@@ -68,7 +72,7 @@ func TestNodePoolMigrator_Complete(t *testing.T) {
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			m := testNodePoolMigrator()
+			m := testNodePoolMigrator(testClients(t, testState()))
 			m.opts.DesiredNodeVersion = tc.npDesired
 			m.resolvedDesiredControlPlaneVersion = tc.cpVersion
 
@@ -117,13 +121,13 @@ func TestNodePoolMigrator_Validate(t *testing.T) {
 			ctx:        context.Background(),
 			npDesired:  "1.17.17-gke.8200",
 			npResolved: "1.17.17-gke.8200",
-			npCurrent:  "1.19.17-gke.9100",
+			npCurrent:  "1.17.17-gke.8000",
 			cpVersion:  "1.19.17-gke.9100",
 			wantErr:    "desired node version 1.17.17-gke.8200 must be no less than 1 minor versions from the desired control plane version 1.19.17-gke.9100",
 		},
 	}
 	for _, tc := range cases {
-		m := testNodePoolMigrator()
+		m := testNodePoolMigrator(nil)
 		m.opts.DesiredNodeVersion = tc.npDesired
 		m.nodePool.Version = tc.npCurrent
 		m.resolvedDesiredControlPlaneVersion = tc.cpVersion
@@ -139,6 +143,18 @@ func TestNodePoolMigrator_Validate(t *testing.T) {
 }
 
 func TestNodePoolMigrator_isUpgradeRequired(t *testing.T) {
+	s := testState()
+	// The URLs of the node pool's InstanceGroupManagers in zones region-a-a, -b and -c.
+	urls := s.Clusters[0].NodePools[0].InstanceGroupUrls
+	regional := test.InstanceGroupManagerRegionA
+	s.InstanceGroupManagers = append(s.InstanceGroupManagers, &compute.InstanceGroupManager{
+		Name:             test.InstanceGroupManagerName,
+		Region:           test.SelfLink(test.ComputeAPI, fmt.Sprintf("projects/%s/regions/%s", test.ProjectName, test.RegionA)),
+		InstanceTemplate: s.InstanceGroupManagers[0].InstanceTemplate,
+	})
+	instanceGroup := fmt.Sprintf("%s/projects/%s/zones/%s/instanceGroups/%s", test.ComputeAPI, test.ProjectName, test.ZoneA0, "instanceGroup0")
+	clients := testClients(t, s)
+
 	cases := []struct {
 		desc    string
 		URLs    []string
@@ -147,24 +163,17 @@ func TestNodePoolMigrator_isUpgradeRequired(t *testing.T) {
 	}{
 		{
 			desc: "Single InstanceGroupManager",
-			URLs: []string{
-				test.InstanceGroupManagerZoneA0,
-			},
+			URLs: urls[:1],
 			want: true,
 		},
 		{
 			desc: "Multiple InstanceGroupManagers",
-			URLs: []string{
-				test.InstanceGroupManagerZoneA0,
-				test.InstanceGroupManagerZoneA1,
-			},
+			URLs: urls,
 			want: true,
 		},
 		{
 			desc: "Regional InstanceGroupManager",
-			URLs: []string{
-				test.InstanceGroupManagerRegionA,
-			},
+			URLs: []string{regional},
 			want: true,
 		},
 		{
@@ -173,31 +182,29 @@ func TestNodePoolMigrator_isUpgradeRequired(t *testing.T) {
 		},
 		{
 			// InstanceGroups do not have a NodeTemplate and should not appear in a InstanceGroup NodePool's list of URLs.
-			desc: "Unhandled InstanceGroups",
-			URLs: []string{
-				fmt.Sprintf("%s/projects/%s/zones/%s/instanceGroups/%s", test.ComputeAPI, test.ProjectName, test.ZoneA0, "instanceGroup0"),
-			},
+			desc:    "Unhandled InstanceGroups",
+			URLs:    []string{instanceGroup},
 			wantErr: "error(s) encountered obtaining an InstanceTemplate for NodePool",
 		},
 		{
 			desc: "Matching InstanceGroup",
-			URLs: []string{
-				test.InstanceGroupManagerZoneA1,
-			},
+			URLs: urls[1:2],
 			want: true,
 		},
 		{
 			desc: "One matching InstanceGroup",
-			URLs: []string{
-				fmt.Sprintf("%s/projects/%s/zones/%s/instanceGroups/%s", test.ComputeAPI, test.ProjectName, test.ZoneA0, "instanceGroup0"),
-				test.InstanceGroupManagerZoneA1,
-			},
+			URLs: []string{instanceGroup, urls[1]},
 			want: true,
 		},
+		{
+			desc:    "Missing InstanceGroupManager",
+			URLs:    []string{test.InstanceGroupManagerZoneA0},
+			wantErr: "error retrieving InstanceGroupManagers",
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			m := testNodePoolMigrator()
+			m := testNodePoolMigrator(clients)
 			m.nodePool.InstanceGroupUrls = tc.URLs
 
 			got, err := m.isUpgradeRequired(context.Background())
@@ -213,68 +220,101 @@ func TestNodePoolMigrator_isUpgradeRequired(t *testing.T) {
 }
 
 func TestNodePoolMigrator_Migrate(t *testing.T) {
+	nodePoolPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName) + "/nodePools/" + test.NodePoolName
 	cases := []struct {
-		desc    string
-		clients *pkg.Clients
-		wantErr string
-		wantLog string
+		desc     string
+		state    *fakeapi.State
+		faults   []faults.Fault
+		approver approval.Approver
+		wantErr  string
+		wantLog  string
 	}{
 		{
 			desc:    "Migrate node pool",
-			clients: test.DefaultClients(),
-			wantLog: "NodePool projects/test-project/locations/region-a/clusters/cluster-c/nodePools/pool upgraded",
+			wantLog: "NodePool projects/test-project/locations/region-a/clusters/cluster-c/nodePools/default-pool upgraded",
+		},
+		{
+			desc:     "Upgrade approved",
+			approver: approval.NewPrompter(strings.NewReader("y\n"), &bytes.Buffer{}),
+			wantLog:  "upgraded",
 		},
 		{
-			desc: "UpdateNodePool error",
-			clients: func(clients *pkg.Clients) *pkg.Clients {
-				clients.Container.(*test.FakeContainer).UpdateNodePoolErrs = []error{errors.New("unrecoverable error")}
-				clients.Container.(*test.FakeContainer).GetOperationErrs = []error{errors.New("not found")}
-				return clients
-			}(test.DefaultClients()),
-			wantErr: "error upgrading NodePool projects/test-project/locations/region-a/clusters/cluster-c/nodePools/pool: unrecoverable error",
+			desc:     "Upgrade skipped",
+			faults:   []faults.Fault{{Kind: faults.Error, Method: "UpdateNodePool", Message: "should not upgrade"}},
+			approver: approval.NewPrompter(strings.NewReader("s\n"), &bytes.Buffer{}),
+			wantLog:  "Skipping upgrade for NodePool",
+		},
+		{
+			desc:     "Upgrade aborted",
+			approver: approval.NewPrompter(strings.NewReader("a\n"), &bytes.Buffer{}),
+			wantErr:  "conversion aborted by user",
+		},
+		{
+			desc:    "UpdateNodePool error",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "UpdateNodePool", Message: "unrecoverable error"}},
+			wantErr: "error upgrading NodePool projects/test-project/locations/region-a/clusters/cluster-c/nodePools/default-pool: googleapi: Error 503: unrecoverable error",
 		},
 		{
 			desc: "NodePool upgrade in progress",
-			clients: func(clients *pkg.Clients) *pkg.Clients {
-				clients.Container.(*test.FakeContainer).UpdateNodePoolResps = []*container.Operation{
-					nil,
-					{Status: test.OperationDone},
-				}
-				clients.Container.(*test.FakeContainer).UpdateNodePoolErrs = []error{
-					errors.New("Operation operation-abc-123 is currently upgrading node pool"),
-					nil,
-				}
-				return clients
-			}(test.DefaultClients()),
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Operations = []*container.Operation{{
+					Name:          "operation-set-size",
+					OperationType: "SET_NODE_POOL_SIZE",
+					Status:        statusRunning,
+					Location:      test.RegionA,
+					TargetLink:    targetLink(nodePoolPath),
+					SelfLink:      targetLink(pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-set-size")),
+				}}
+				return s
+			}(testNodePoolState()),
 			wantLog: "upgraded",
 		},
 		{
-			desc: "Polling failure during UpdateNodePool operation",
-			clients: func(clients *pkg.Clients) *pkg.Clients {
-				clients.Container.(*test.FakeContainer).GetOperationErrs = []error{errors.New("operation get failed")}
-				return clients
-			}(test.DefaultClients()),
-			wantErr: "error retrieving Operation projects/test-project/locations/region-a/operations/operation-update-nodepool: operation get failed",
+			desc:    "Polling failure during UpdateNodePool operation",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "GetOperation", Message: "operation get failed"}},
+			wantErr: "error retrieving Operation projects/test-project/locations/region-a/operations/operation-1-00000001: googleapi: Error 503: operation get failed",
 		},
 		{
 			desc: "UpdateNodePool operation failure",
-			clients: func(clients *pkg.Clients) *pkg.Clients {
-				clients.Container.(*test.FakeContainer).GetOperationResps = []*container.Operation{
-					{
-						Name:   "op",
-						Status: test.OperationDone,
-						Error:  &container.Status{Message: "operation failed"},
-					},
-				}
-				return clients
-			}(test.DefaultClients()),
-			wantErr: "error waiting on Operation projects/test-project/locations/region-a/operations/operation-update-nodepool: operation failed",
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Script.Failures = []fakeapi.Failure{{OperationType: "UPGRADE_NODES", Message: "operation failed"}}
+				return s
+			}(testNodePoolState()),
+			wantErr: "error waiting on Operation projects/test-project/locations/region-a/operations/operation-1-00000001: operation failed",
+		},
+		{
+			// The node pool has no InstanceGroupManagers, so no longer requires an upgrade once re-evaluated.
+			desc: "Upgrade not required after drift",
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Clusters[0].NodePools[0].Version = "1.20.6-gke.1000"
+				s.Clusters[0].NodePools[0].InstanceGroupUrls = nil
+				return s
+			}(testNodePoolState()),
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "UpdateNodePool", Message: "should not upgrade"}},
+			wantLog: "Upgrade not required for NodePool",
+		},
+		{
+			desc: "Drift fails validation",
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Clusters[0].NodePools[0].Version = "1.20.7-gke.1800"
+				return s
+			}(testNodePoolState()),
+			wantErr: "error during Refresh: desired version 1.20.7-gke.1800 must be newer than current version 1.20.7-gke.1800",
+		},
+		{
+			desc:    "GetNodePool error",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "GetNodePool", Code: 404, Message: "not found"}},
+			wantErr: "error retrieving NodePool projects/test-project/locations/region-a/clusters/cluster-c/nodePools/default-pool: googleapi: Error 404: not found",
 		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			m := testNodePoolMigrator()
-			m.clients = tc.clients
+			state := tc.state
+			if state == nil {
+				state = testNodePoolState()
+			}
+			m := testNodePoolMigrator(testClients(t, state, tc.faults...))
+			m.opts.Approver = tc.approver
 			buf := &bytes.Buffer{}
 			log.StandardLogger().SetOutput(buf)
 
@@ -289,6 +329,14 @@ func TestNodePoolMigrator_Migrate(t *testing.T) {
 	}
 }
 
+// testNodePoolState returns the testState once the control plane of its cluster is upgraded.
+func testNodePoolState() *fakeapi.State {
+	s := testState()
+	s.Clusters[0].CurrentMasterVersion = "1.20.7-gke.1800"
+	s.Clusters[0].Subnetwork = test.SelectedNetwork
+	return s
+}
+
 func TestGetName(t *testing.T) {
 	cases := []struct {
 		desc string
@@ -326,7 +374,7 @@ func TestGetName(t *testing.T) {
 	}
 }
 
-func testNodePoolMigrator() *nodePoolMigrator {
+func testNodePoolMigrator(clients *pkg.Clients) *nodePoolMigrator {
 	return &nodePoolMigrator{
 		clusterMigrator: &clusterMigrator{
 			projectID: test.ProjectName,
@@ -340,13 +388,72 @@ func testNodePoolMigrator() *nodePoolMigrator {
 			},
 			opts:                               &Options{},
 			handler:                            testHandler,
-			clients:                            test.DefaultClients(),
+			clients:                            clients,
 			serverConfig:                       ServerConfig,
-			resolvedDesiredControlPlaneVersion: "",
+			resolvedDesiredControlPlaneVersion: "1.20.7-gke.1800",
 		},
 		nodePool: &container.NodePool{
-			Name: "pool",
+			Name:    test.NodePoolName,
+			Version: "1.19.10-gke.1700",
 		},
 		upgradeRequired: true,
 	}
 }
+
+func TestNodePoolMigrator_Permissions(t *testing.T) {
+	m := testNodePoolMigrator(nil)
+	want := []string{"compute.instanceGroupManagers.get", "compute.instanceTemplates.get", "container.clusters.update", "container.operations.get"}
+	if diff := cmp.Diff(want, migrate.Permissions(m)); diff != "" {
+		t.Errorf("nodePoolMigrator.Permissions diff (-want +got):\n%s", diff)
+	}
+	m.upgradeRequired = false
+	if got := m.Permissions(); got != nil {
+		t.Errorf("nodePoolMigrator.Permissions without upgrade; wanted none, got: %v", got)
+	}
+}
+
+func TestNodeCount(t *testing.T) {
+	cases := []struct {
+		desc string
+		np   *container.NodePool
+		want int64
+	}{
+		{
+			desc: "Single zone",
+			np:   &container.NodePool{InitialNodeCount: 3},
+			want: 3,
+		},
+		{
+			desc: "Multiple zones",
+			np: &container.NodePool{
+				InitialNodeCount:  3,
+				InstanceGroupUrls: []string{test.InstanceGroupManagerZoneA0, test.InstanceGroupManagerZoneA0},
+			},
+			want: 6,
+		},
+		{
+			desc: "Autoscaling",
+			np: &container.NodePool{
+				InitialNodeCount:  1,
+				Autoscaling:       &container.NodePoolAutoscaling{Enabled: true, MaxNodeCount: 10},
+				InstanceGroupUrls: []string{test.InstanceGroupManagerZoneA0},
+			},
+			want: 10,
+		},
+		{
+			desc: "Autoscaling disabled",
+			np: &container.NodePool{
+				InitialNodeCount: 2,
+				Autoscaling:      &container.NodePoolAutoscaling{MaxNodeCount: 10},
+			},
+			want: 2,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := nodeCount(tc.np); got != tc.want {
+				t.Errorf("nodeCount; wanted: %d, got: %d", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/operations"
+
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+)
+
+// upgradePath returns the versions to upgrade the control plane through to reach the desired version,
+// as GKE does not upgrade a control plane by more than one minor version at a time.
+func (m *clusterMigrator) upgradePath() ([]string, error) {
+	return m.upgradePathFrom(m.cluster.CurrentMasterVersion)
+}
+
+// upgradePathFrom returns the versions to upgrade the control plane through from the version current.
+func (m *clusterMigrator) upgradePathFrom(current string) ([]string, error) {
+	_, valid := getVersions(m.serverConfig, m.releaseChannel, ControlPlane)
+	return upgradePath(current, m.resolvedDesiredControlPlaneVersion, valid)
+}
+
+// upgradePath returns the latest valid version of each minor version between current and desired, followed by desired.
+func upgradePath(current, desired string, valid []string) ([]string, error) {
+	from, err := GetMinorVersion(current)
+	if err != nil {
+		return nil, err
+	}
+	to, err := GetMinorVersion(desired)
+	if err != nil {
+		return nil, err
+	}
+	var path []string
+	for minor := from + 1; minor < to; minor++ {
+		v := latestVersion(valid, minor, "")
+		if v == "" {
+			return nil, fmt.Errorf("no valid version for intermediate minor version 1.%d between %s and %s; valid versions: %v", minor, current, desired, valid)
+		}
+		path = append(path, v)
+	}
+	return append(path, desired), nil
+}
+
+// equalVersions returns whether two upgrade paths are the same.
+func equalVersions(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// latestVersion returns the latest valid version of the minor version, no newer than limit if set.
+func latestVersion(valid []string, minor int, limit string) string {
+	var l Version
+	if limit != "" {
+		var err error
+		if l, err = ParseVersion(limit); err != nil {
+			return ""
+		}
+	}
+	v, err := newestVersion(valid, func(v Version) bool {
+		return v.Minor == minor && (limit == "" || v.Compare(l) <= 0)
+	})
+	if err != nil {
+		return ""
+	}
+	return v
+}
+
+// laggingNodePools returns the NodePools, given their versions, which would fall outside of MaxVersionSkew
+// once the control plane is upgraded to next.
+func (m *clusterMigrator) laggingNodePools(versions map[*nodePoolMigrator]string, next string) ([]*nodePoolMigrator, error) {
+	to, err := GetMinorVersion(next)
+	if err != nil {
+		return nil, err
+	}
+	var lagging []*nodePoolMigrator
+	for _, c := range m.children {
+		np, ok := c.(*nodePoolMigrator)
+		if !ok {
+			continue
+		}
+		if minor, err := GetMinorVersion(versions[np]); err == nil && minor < to-MaxVersionSkew {
+			lagging = append(lagging, np)
+		}
+	}
+	return lagging, nil
+}
+
+// nodePoolVersions returns the current version of each NodePool.
+func (m *clusterMigrator) nodePoolVersions() map[*nodePoolMigrator]string {
+	versions := make(map[*nodePoolMigrator]string)
+	for _, c := range m.children {
+		if np, ok := c.(*nodePoolMigrator); ok {
+			versions[np] = np.nodePool.Version
+		}
+	}
+	return versions
+}
+
+// intermediateNodeVersion returns the latest valid node version no newer than the control plane version current.
+func (m *clusterMigrator) intermediateNodeVersion(current string) (string, error) {
+	minor, err := GetMinorVersion(current)
+	if err != nil {
+		return "", err
+	}
+	_, valid := getVersions(m.serverConfig, m.releaseChannel, Node)
+	v := latestVersion(valid, minor, current)
+	if v == "" {
+		return "", fmt.Errorf("no valid node version for minor version 1.%d no newer than control plane version %s of Cluster %s; valid versions: %v",
+			minor, current, m.ResourcePath(), valid)
+	}
+	return v, nil
+}
+
+// checkNodePoolSteps confirms that the NodePools which would fall outside of MaxVersionSkew along the
+// control plane upgrade path can be upgraded to an intermediate version, simulating stepNodePools.
+func (m *clusterMigrator) checkNodePoolSteps(path []string) error {
+	versions := m.nodePoolVersions()
+	current := m.cluster.CurrentMasterVersion
+	for _, next := range path {
+		lagging, err := m.laggingNodePools(versions, next)
+		if err != nil {
+			return err
+		}
+		if len(lagging) > 0 {
+			v, err := m.intermediateNodeVersion(current)
+			if err != nil {
+				return err
+			}
+			for _, np := range lagging {
+				versions[np] = v
+			}
+		}
+		current = next
+	}
+	return nil
+}
+
+// stepNodePools upgrades the NodePools which would fall outside of MaxVersionSkew once the control plane
+// is upgraded from current to next. They are upgraded to the latest version no newer than current,
+// up to ConcurrentNodePools at a time, once each upgrade is approved. False is returned if one is skipped.
+func (m *clusterMigrator) stepNodePools(ctx context.Context, current, next string) (bool, error) {
+	lagging, err := m.laggingNodePools(m.nodePoolVersions(), next)
+	if err != nil || len(lagging) == 0 {
+		return err == nil, err
+	}
+	v, err := m.intermediateNodeVersion(current)
+	if err != nil {
+		return false, err
+	}
+
+	for _, np := range lagging {
+		ok, err := approval.Confirm(ctx, m.opts.Approver, approval.Step{
+			Action:         "Upgrade NodePool to intermediate version",
+			ResourcePath:   np.ResourcePath(),
+			CurrentVersion: np.nodePool.Version,
+			DesiredVersion: v,
+		})
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		errors error
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, m.opts.ConcurrentNodePools)
+	)
+	for _, np := range lagging {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(np *nodePoolMigrator) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			if err := np.stepTo(ctx, v, next); err != nil {
+				mu.Lock()
+				errors = multierr.Append(errors, err)
+				mu.Unlock()
+			}
+		}(np)
+	}
+	wg.Wait()
+	return errors == nil, errors
+}
+
+// stepTo upgrades the NodePool to the intermediate version v ahead of a control plane upgrade to next,
+// unless it has reached v since it was last read, e.g. through an operation it waited on.
+func (m *nodePoolMigrator) stepTo(ctx context.Context, v, next string) error {
+	upgrade := func(ctx context.Context) error {
+		if current, err := ParseVersion(m.nodePool.Version); err == nil {
+			if target, err := ParseVersion(v); err == nil && current.Compare(target) >= 0 {
+				log.Infof("NodePool %s is already at version %s; skipping intermediate upgrade to %s.", m.ResourcePath(), m.nodePool.Version, v)
+				return nil
+			}
+		}
+		log.Infof("Upgrading NodePool %s from %s to intermediate version %s to stay within version skew of control plane version %s.",
+			m.ResourcePath(), m.nodePool.Version, v, next)
+		if err := m.upgradeTo(ctx, v); err != nil {
+			return err
+		}
+		// Record the intermediate version; the NodePool is read again before its final upgrade.
+		m.nodePool.Version = v
+		return nil
+	}
+	return operations.WaitForOperationInProgress(ctx, upgrade, m.waitAndRefresh)
+}
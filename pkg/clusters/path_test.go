@@ -0,0 +1,289 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/container/v1"
+)
+
+func TestUpgradePath(t *testing.T) {
+	cases := []struct {
+		desc    string
+		current string
+		desired string
+		want    []string
+		wantErr string
+	}{
+		{
+			desc:    "In-place",
+			current: "1.19.10-gke.1700",
+			desired: "1.19.10-gke.1700",
+			want:    []string{"1.19.10-gke.1700"},
+		},
+		{
+			desc:    "Single minor version",
+			current: "1.19.10-gke.1700",
+			desired: "1.20.7-gke.1800",
+			want:    []string{"1.20.7-gke.1800"},
+		},
+		{
+			desc:    "Multiple minor versions",
+			current: "1.17.17-gke.8200",
+			desired: "1.20.6-gke.1000",
+			want:    []string{"1.18.19-gke.1700", "1.19.11-gke.1700", "1.20.6-gke.1000"},
+		},
+		{
+			desc:    "Missing intermediate minor version",
+			current: "1.16.15-gke.100",
+			desired: "1.18.19-gke.1700",
+			want:    []string{"1.17.17-gke.9100", "1.18.19-gke.1700"},
+		},
+		{
+			desc:    "No valid intermediate version",
+			current: "1.14.10-gke.100",
+			desired: "1.17.17-gke.9100",
+			wantErr: "no valid version for intermediate minor version 1.15 between 1.14.10-gke.100 and 1.17.17-gke.9100",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := upgradePath(tc.current, tc.desired, ServerConfig.ValidMasterVersions)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("upgradePath error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("upgradePath diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	cases := []struct {
+		desc  string
+		minor int
+		limit string
+		want  string
+	}{
+		{
+			desc:  "Latest of minor version",
+			minor: 18,
+			want:  "1.18.19-gke.1700",
+		},
+		{
+			desc:  "Limited",
+			minor: 18,
+			limit: "1.18.18-gke.1700",
+			want:  "1.18.18-gke.1700",
+		},
+		{
+			desc:  "Limited below all versions",
+			minor: 18,
+			limit: "1.18.17-gke.100",
+		},
+		{
+			desc:  "No versions of minor version",
+			minor: 21,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := latestVersion(ServerConfig.ValidNodeVersions, tc.minor, tc.limit); got != tc.want {
+				t.Errorf("latestVersion; wanted: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClusterMigrator_UpgradeControlPlane_Steps(t *testing.T) {
+	clusterPath := pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName)
+	cases := []struct {
+		desc          string
+		input         string
+		approved      []string
+		wantOK        bool
+		wantMasters   []string
+		wantNodePools []string
+		wantPrompts   []string
+	}{
+		{
+			desc:          "Approved",
+			wantOK:        true,
+			wantMasters:   []string{"1.19.11-gke.1700", "1.20.7-gke.1800"},
+			wantNodePools: []string{"lagging-a=1.18.18-gke.1700", "lagging-b=1.18.18-gke.1700", "lagging-a=1.19.11-gke.1700", "lagging-b=1.19.11-gke.1700"},
+		},
+		{
+			desc:          "Each intermediate NodePool step approved",
+			input:         "y\ny\ny\ny\n",
+			wantOK:        true,
+			wantMasters:   []string{"1.19.11-gke.1700", "1.20.7-gke.1800"},
+			wantNodePools: []string{"lagging-a=1.18.18-gke.1700", "lagging-b=1.18.18-gke.1700", "lagging-a=1.19.11-gke.1700", "lagging-b=1.19.11-gke.1700"},
+			wantPrompts: []string{
+				"Upgrade NodePool to intermediate version: " + clusterPath + "/nodePools/lagging-a (1.17.17-gke.8200 -> 1.18.18-gke.1700)",
+				"Upgrade NodePool to intermediate version: " + clusterPath + "/nodePools/lagging-b (1.17.17-gke.8200 -> 1.18.18-gke.1700)",
+				"Upgrade NodePool to intermediate version: " + clusterPath + "/nodePools/lagging-a (1.18.18-gke.1700 -> 1.19.11-gke.1700)",
+			},
+		},
+		{
+			desc:  "Intermediate NodePool upgrade skipped",
+			input: "y\ns\n",
+		},
+		{
+			desc:          "Changed path approved",
+			input:         "y\ny\ny\ny\ny\n",
+			approved:      []string{"1.19.10-gke.1700", "1.20.7-gke.1800"},
+			wantOK:        true,
+			wantMasters:   []string{"1.19.11-gke.1700", "1.20.7-gke.1800"},
+			wantNodePools: []string{"lagging-a=1.18.18-gke.1700", "lagging-b=1.18.18-gke.1700", "lagging-a=1.19.11-gke.1700", "lagging-b=1.19.11-gke.1700"},
+			wantPrompts: []string{
+				"Upgrade control plane (changed since approval): " + clusterPath + " (1.18.18-gke.1700 -> 1.19.11-gke.1700 -> 1.20.7-gke.1800)",
+			},
+		},
+		{
+			desc:     "Changed path skipped",
+			input:    "s\n",
+			approved: []string{"1.19.10-gke.1700", "1.20.7-gke.1800"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := testState()
+			s.ServerConfig = ServerConfig
+			s.Clusters[0].CurrentMasterVersion = "1.18.18-gke.1700"
+			s.Clusters[0].NodePools = []*container.NodePool{
+				{Name: "lagging-a", Version: "1.17.17-gke.8200"},
+				{Name: "lagging-b", Version: "1.17.17-gke.8200"},
+				{Name: "current", Version: "1.19.10-gke.1700"},
+			}
+			clients := testClients(t, s)
+			rec := record(clients)
+
+			c := test.PrePatchCluster
+			c.CurrentMasterVersion = "1.18.18-gke.1700"
+			opts := *testOptions
+			out := &bytes.Buffer{}
+			if tc.input != "" {
+				opts.Approver = approval.NewPrompter(strings.NewReader(tc.input), out)
+			}
+			m := testClusterMigrator(&c, &opts, clients)
+			m.serverConfig = ServerConfig
+			m.resolvedDesiredControlPlaneVersion = "1.20.7-gke.1800"
+			m.children = append(m.children,
+				NewNodePool(m, &container.NodePool{Name: "lagging-a", Version: "1.17.17-gke.8200"}),
+				NewNodePool(m, &container.NodePool{Name: "lagging-b", Version: "1.17.17-gke.8200"}),
+				NewNodePool(m, &container.NodePool{Name: "current", Version: "1.19.10-gke.1700"}))
+
+			approved := tc.approved
+			if approved == nil {
+				approved, _ = m.upgradePath()
+			}
+			ok, err := m.upgradeControlPlane(context.Background(), approved)
+			if err != nil {
+				t.Fatalf("clusterMigrator.upgradeControlPlane unexpected error: %v", err)
+			}
+			if ok != tc.wantOK {
+				t.Errorf("clusterMigrator.upgradeControlPlane; wanted: %t, got: %t", tc.wantOK, ok)
+			}
+
+			var got []string
+			for _, r := range rec.updateMaster {
+				got = append(got, r.MasterVersion)
+			}
+			if diff := cmp.Diff(tc.wantMasters, got); diff != "" {
+				t.Errorf("UpdateMaster versions diff (-want +got):\n%s", diff)
+			}
+			got = nil
+			for _, r := range rec.updateNodePool {
+				got = append(got, getName(r.Name)+"="+r.NodeVersion)
+			}
+			if diff := cmp.Diff(tc.wantNodePools, got); diff != "" {
+				t.Errorf("UpdateNodePool versions diff (-want +got):\n%s", diff)
+			}
+			for _, p := range tc.wantPrompts {
+				if !strings.Contains(out.String(), p) {
+					t.Errorf("Missing prompt %q in output:\n%s", p, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestClusterMigrator_CheckUpgrade_NodePoolSteps(t *testing.T) {
+	withoutNodeMinor := func(minor string) *container.ServerConfig {
+		sc := *ServerConfig
+		sc.ValidNodeVersions = nil
+		for _, v := range ServerConfig.ValidNodeVersions {
+			if !strings.HasPrefix(v, minor+".") {
+				sc.ValidNodeVersions = append(sc.ValidNodeVersions, v)
+			}
+		}
+		return &sc
+	}
+	cases := []struct {
+		desc         string
+		serverConfig *container.ServerConfig
+		version      string
+		wantErr      string
+	}{
+		{
+			desc:         "Valid steps",
+			serverConfig: ServerConfig,
+			version:      "1.17.17-gke.8200",
+		},
+		{
+			desc:         "No node version for the first step",
+			serverConfig: withoutNodeMinor("1.18"),
+			version:      "1.17.17-gke.8200",
+			wantErr:      "no valid node version for minor version 1.18 no newer than control plane version 1.18.18-gke.1700",
+		},
+		{
+			desc:         "No node version for a later step",
+			serverConfig: withoutNodeMinor("1.19"),
+			version:      "1.17.17-gke.8200",
+			wantErr:      "no valid node version for minor version 1.19 no newer than control plane version 1.19.11-gke.1700",
+		},
+		{
+			desc:         "NodePool within skew",
+			serverConfig: withoutNodeMinor("1.18"),
+			version:      "1.19.10-gke.1700",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := test.PrePatchCluster
+			c.CurrentMasterVersion = "1.18.18-gke.1700"
+			m := testClusterMigrator(&c, testOptions, nil)
+			m.serverConfig = tc.serverConfig
+			m.resolvedDesiredControlPlaneVersion = "1.20.7-gke.1800"
+			m.children = append(m.children, NewNodePool(m, &container.NodePool{Name: "pool", Version: tc.version}))
+
+			err := m.checkUpgrade()
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("clusterMigrator.checkUpgrade diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
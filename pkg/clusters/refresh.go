@@ -0,0 +1,155 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/cache"
+	"legacymigration/pkg/operations"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/container/v1"
+)
+
+// Drift is a change to a field of a resource since it was last read, e.g. by an auto-upgrade or another user.
+type Drift struct {
+	Field    string
+	Previous string
+	Current  string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s changed from %q to %q", d.Field, d.Previous, d.Current)
+}
+
+// clusterDrift returns the changes relevant to the conversion between two reads of a cluster.
+func clusterDrift(prev, cur *container.Cluster) []Drift {
+	var drift []Drift
+	drift = appendDrift(drift, "currentMasterVersion", prev.CurrentMasterVersion, cur.CurrentMasterVersion)
+	drift = appendDrift(drift, "subnetwork", prev.Subnetwork, cur.Subnetwork)
+	drift = appendDrift(drift, "releaseChannel", getReleaseChannel(prev.ReleaseChannel), getReleaseChannel(cur.ReleaseChannel))
+	drift = appendDrift(drift, "status", prev.Status, cur.Status)
+	return drift
+}
+
+// nodePoolDrift returns the changes relevant to the conversion between two reads of a node pool.
+func nodePoolDrift(prev, cur *container.NodePool) []Drift {
+	var drift []Drift
+	drift = appendDrift(drift, "version", prev.Version, cur.Version)
+	drift = appendDrift(drift, "instanceGroupUrls", strings.Join(prev.InstanceGroupUrls, ","), strings.Join(cur.InstanceGroupUrls, ","))
+	drift = appendDrift(drift, "status", prev.Status, cur.Status)
+	return drift
+}
+
+func appendDrift(drift []Drift, field, prev, cur string) []Drift {
+	if prev == cur {
+		return drift
+	}
+	return append(drift, Drift{Field: field, Previous: prev, Current: cur})
+}
+
+// reportDrift logs the changes made to a resource since it was last read.
+func reportDrift(kind, path string, drift []Drift) {
+	if len(drift) == 0 {
+		return
+	}
+	s := make([]string, len(drift))
+	for i, d := range drift {
+		s[i] = d.String()
+	}
+	log.Warnf("%s %s changed since it was last read: %s.", kind, path, strings.Join(s, "; "))
+}
+
+// refresh re-reads the cluster and its ServerConfig before the cluster is mutated and reports any drift,
+// then re-resolves and re-validates the desired control plane version against the current state.
+func (m *clusterMigrator) refresh(ctx context.Context) error {
+	cluster, err := m.clients.Container.GetCluster(ctx, m.ResourcePath())
+	if err != nil {
+		return fmt.Errorf("error retrieving Cluster %s: %w", m.ResourcePath(), err)
+	}
+	// The ServerConfig read during Complete may be cached; read it again to detect changes.
+	m.serverConfig, err = m.clients.Container.GetServerConfig(cache.WithRefresh(ctx), pkg.LocationPath(m.projectID, m.cluster.Location))
+	if err != nil {
+		return fmt.Errorf("error retrieving ServerConfig for Cluster %s: %w", m.ResourcePath(), err)
+	}
+	reportDrift("Cluster", m.ResourcePath(), clusterDrift(m.cluster, cluster))
+	m.cluster = cluster
+
+	previous := m.resolvedDesiredControlPlaneVersion
+	if err := m.resolveVersion(); err != nil {
+		return fmt.Errorf("error resolving control plane version for Cluster %s: %w", m.ResourcePath(), err)
+	}
+	if previous != m.resolvedDesiredControlPlaneVersion {
+		log.Infof("Desired control plane version for Cluster %s re-resolved from %s to %s.", m.ResourcePath(), previous, m.resolvedDesiredControlPlaneVersion)
+	}
+	return m.checkUpgrade()
+}
+
+// waitAndRefresh waits on a conflicting operation, then refreshes the cluster, which the operation may have changed.
+func (m *clusterMigrator) waitAndRefresh(ctx context.Context, c *operations.Conflict) error {
+	if err := m.wait(ctx, c); err != nil {
+		return err
+	}
+	return m.refresh(ctx)
+}
+
+// refresh re-reads the node pool before it is mutated and reports any drift, then re-evaluates
+// whether it requires an upgrade and re-resolves and re-validates its desired version.
+func (m *nodePoolMigrator) refresh(ctx context.Context) error {
+	np, err := m.clients.Container.GetNodePool(ctx, m.ResourcePath())
+	if err != nil {
+		return fmt.Errorf("error retrieving NodePool %s: %w", m.ResourcePath(), err)
+	}
+	drift := nodePoolDrift(m.nodePool, np)
+	reportDrift("NodePool", m.ResourcePath(), drift)
+	m.nodePool = np
+
+	// InstanceTemplates only change if the node pool was upgraded or recreated, which is reported as drift.
+	// Those read during Complete may be cached; read them again to detect the change.
+	if len(drift) > 0 {
+		m.upgradeRequired, err = m.isUpgradeRequired(cache.WithRefresh(ctx))
+		if err != nil {
+			return fmt.Errorf("unable to verify state for NodePool %s: %w", m.ResourcePath(), err)
+		}
+	}
+	if !m.upgradeRequired {
+		return nil
+	}
+
+	previous := m.resolvedDesiredNodeVersion
+	if err := m.resolveVersion(); err != nil {
+		return m.wrap(err, "Refresh")
+	}
+	if previous != m.resolvedDesiredNodeVersion {
+		log.Infof("Desired version for NodePool %s re-resolved from %s to %s.", m.ResourcePath(), previous, m.resolvedDesiredNodeVersion)
+	}
+	if err := m.checkUpgrade(); err != nil {
+		return m.wrap(err, "Refresh")
+	}
+	return nil
+}
+
+// waitAndRefresh waits on a conflicting operation, then refreshes the node pool, which the operation may have changed.
+func (m *nodePoolMigrator) waitAndRefresh(ctx context.Context, c *operations.Conflict) error {
+	if err := m.wait(ctx, c); err != nil {
+		return err
+	}
+	return m.refresh(ctx)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusters
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/cache"
+	"legacymigration/pkg/faults"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/container/v1"
+)
+
+func TestClusterDrift(t *testing.T) {
+	prev := test.PrePatchCluster
+	cases := []struct {
+		desc string
+		cur  func(c container.Cluster) container.Cluster
+		want []Drift
+	}{
+		{
+			desc: "Unchanged",
+			cur:  func(c container.Cluster) container.Cluster { return c },
+		},
+		{
+			desc: "Auto-upgraded and converted",
+			cur: func(c container.Cluster) container.Cluster {
+				c.CurrentMasterVersion = "1.20.7-gke.1800"
+				c.Subnetwork = "subnet"
+				c.ReleaseChannel = &container.ReleaseChannel{Channel: test.Regular}
+				c.Status = "RECONCILING"
+				return c
+			},
+			want: []Drift{
+				{Field: "currentMasterVersion", Previous: "1.19.10-gke.1700", Current: "1.20.7-gke.1800"},
+				{Field: "subnetwork", Previous: "", Current: "subnet"},
+				{Field: "releaseChannel", Previous: test.Unspecified, Current: test.Regular},
+				{Field: "status", Previous: "", Current: "RECONCILING"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cur := tc.cur(prev)
+			got := clusterDrift(&prev, &cur)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("clusterDrift diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNodePoolDrift(t *testing.T) {
+	prev := &container.NodePool{
+		Name:              test.NodePoolName,
+		Version:           "1.19.10-gke.1700",
+		InstanceGroupUrls: []string{test.InstanceGroupManagerZoneA0},
+		Status:            "RUNNING",
+	}
+	cur := &container.NodePool{
+		Name:              test.NodePoolName,
+		Version:           "1.20.7-gke.1800",
+		InstanceGroupUrls: []string{test.InstanceGroupManagerZoneA0, test.InstanceGroupManagerZoneA1},
+		Status:            "RUNNING",
+	}
+	want := []Drift{
+		{Field: "version", Previous: "1.19.10-gke.1700", Current: "1.20.7-gke.1800"},
+		{Field: "instanceGroupUrls", Previous: test.InstanceGroupManagerZoneA0, Current: test.InstanceGroupManagerZoneA0 + "," + test.InstanceGroupManagerZoneA1},
+	}
+	if diff := cmp.Diff(want, nodePoolDrift(prev, cur)); diff != "" {
+		t.Errorf("nodePoolDrift diff (-want +got):\n%s", diff)
+	}
+	if got := nodePoolDrift(prev, prev); got != nil {
+		t.Errorf("nodePoolDrift unchanged; wanted: nil, got: %v", got)
+	}
+}
+
+func TestClusterMigrator_Refresh(t *testing.T) {
+	cases := []struct {
+		desc        string
+		version     string
+		opts        *Options
+		wantVersion string
+		wantErr     string
+		wantLog     string
+	}{
+		{
+			desc:        "No drift",
+			version:     test.PrePatchCluster.CurrentMasterVersion,
+			opts:        &Options{DesiredControlPlaneVersion: LatestVersion},
+			wantVersion: "1.20.7-gke.1800",
+		},
+		{
+			desc:        "Drift re-resolved",
+			version:     "1.20.7-gke.1800",
+			opts:        &Options{DesiredControlPlaneVersion: LatestVersion},
+			wantVersion: "1.20.7-gke.1800",
+			wantLog:     `currentMasterVersion changed from \"1.19.10-gke.1700\" to \"1.20.7-gke.1800\"`,
+		},
+		{
+			desc:        "Drift fails validation",
+			version:     "1.20.7-gke.1800",
+			opts:        &Options{DesiredControlPlaneVersion: "1.20.6-gke.1000"},
+			wantVersion: "1.20.6-gke.1000",
+			wantErr:     "validation error for Cluster projects/test-project/locations/region-a/clusters/cluster-c: desired version 1.20.6-gke.1000 must be newer than current version 1.20.7-gke.1800",
+			wantLog:     "changed since it was last read",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			log.StandardLogger().SetOutput(buf)
+			s := testState()
+			s.Clusters[0].CurrentMasterVersion = tc.version
+			c := test.PrePatchCluster
+			m := testClusterMigrator(&c, tc.opts, testClients(t, s))
+
+			err := m.refresh(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("clusterMigrator.refresh diff (-want +got):\n%s", diff)
+			}
+			if m.resolvedDesiredControlPlaneVersion != tc.wantVersion {
+				t.Errorf("clusterMigrator.resolvedDesiredControlPlaneVersion; wanted: %q, got: %q", tc.wantVersion, m.resolvedDesiredControlPlaneVersion)
+			}
+			if tc.wantLog == "" && strings.Contains(buf.String(), "changed since it was last read") {
+				t.Errorf("clusterMigrator.refresh unexpected drift reported:\n%s", buf.String())
+			}
+			if !strings.Contains(buf.String(), tc.wantLog) {
+				t.Errorf("clusterMigrator.refresh missing log output:\n\twanted entry: %s\n\tgot entries: %s", tc.wantLog, buf.String())
+			}
+		})
+	}
+}
+
+func TestClusterMigrator_RefreshCached(t *testing.T) {
+	ctx := context.Background()
+	// The ServerConfig is unavailable once first read.
+	clients := testClients(t, testState(), faults.Fault{Kind: faults.Error, Method: "GetServerConfig", After: 1, Message: "server config unavailable"})
+	cached := cache.New().Wrap(clients)
+	if _, err := cached.Container.GetServerConfig(ctx, pkg.LocationPath(test.ProjectName, test.RegionA)); err != nil {
+		t.Fatalf("GetServerConfig unexpected error: %v", err)
+	}
+
+	// The refresh reads the ServerConfig again rather than using the cached response.
+	c := test.PrePatchCluster
+	m := testClusterMigrator(&c, &Options{DesiredControlPlaneVersion: LatestVersion}, cached)
+	err := m.refresh(ctx)
+	if diff := test.ErrorDiff("error retrieving ServerConfig for Cluster projects/test-project/locations/region-a/clusters/cluster-c: googleapi: Error 503: server config unavailable", err); diff != "" {
+		t.Errorf("clusterMigrator.refresh diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestNodePoolMigrator_RefreshCached(t *testing.T) {
+	ctx := context.Background()
+	clients := testClients(t, testState())
+	m := testNodePoolMigrator(cache.New().Wrap(clients))
+	m.nodePool.InstanceGroupUrls = testState().Clusters[0].NodePools[0].InstanceGroupUrls
+	required, err := m.isUpgradeRequired(ctx)
+	if err != nil || !required {
+		t.Fatalf("nodePoolMigrator.isUpgradeRequired; wanted: true, got: %t, %v", required, err)
+	}
+
+	// The node pool is upgraded out-of-band between Complete and Migrate, patching its InstanceTemplates.
+	op, err := clients.Container.UpdateNodePool(ctx, &container.UpdateNodePoolRequest{Name: m.ResourcePath(), NodeVersion: "1.19.11-gke.1700"})
+	if err != nil {
+		t.Fatalf("UpdateNodePool unexpected error: %v", err)
+	}
+	if _, err := clients.Container.GetOperation(ctx, pkg.OperationsPath(test.ProjectName, test.RegionA, op.Name)); err != nil {
+		t.Fatalf("GetOperation unexpected error: %v", err)
+	}
+	if err := m.refresh(ctx); err != nil {
+		t.Fatalf("nodePoolMigrator.refresh unexpected error: %v", err)
+	}
+	if m.upgradeRequired {
+		t.Errorf("nodePoolMigrator.upgradeRequired after refresh; wanted: false, got: true")
+	}
+}
@@ -18,6 +18,7 @@ package clusters
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -63,94 +64,141 @@ func getVersions(sc *container.ServerConfig, cc string, res Resource) (string, [
 	return defaultVersion, validVersions
 }
 
-// IsFormatValid ensures that the version string is a valid GKE version or version alias.
-// See: https://cloud.google.com/kubernetes-engine/versioning#specifying_cluster_version
-func IsFormatValid(s string) error {
+// Version is a GKE version of the form 1.x.y-gke.z.
+// Partial versions (1.x or 1.x.y) may be used to specify a desired version, and match any version they prefix.
+// See: https://cloud.google.com/kubernetes-engine/versioning#versioning_scheme
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	GKE   int
+	// Parts is the number of components specified, from 2 (1.x) to 4 (1.x.y-gke.z).
+	Parts int
+}
+
+// ParseVersion parses a full or partial GKE version.
+func ParseVersion(s string) (Version, error) {
+	var v Version
 	if s == "" {
-		return errors.New("malformed version: version must not be empty")
-	}
-	if s == "-" || s == "latest" {
-		return nil
+		return v, errors.New("malformed version: version must not be empty")
 	}
 
 	split := strings.Split(s, "-")
 	if len(split) > 2 {
-		return fmt.Errorf("malformed version: %s", s)
+		return v, fmt.Errorf("malformed version: %s", s)
 	}
 
-	kubernetes := split[0]
-	ksplit := strings.Split(kubernetes, ".")
+	ksplit := strings.Split(split[0], ".")
 	if len(ksplit) < 2 || len(ksplit) > 3 {
-		return fmt.Errorf("malformed version: %s", s)
+		return v, fmt.Errorf("malformed version: %s", s)
 	}
-	major, err := strconv.Atoi(ksplit[0])
-	if err != nil {
-		return fmt.Errorf("malformed major version %s: %w", s, err)
+	var err error
+	if v.Major, err = strconv.Atoi(ksplit[0]); err != nil {
+		return v, fmt.Errorf("malformed major version %s: %w", s, err)
 	}
-	if major != 1 {
-		return fmt.Errorf("not compatible with major versions other than 1: version %s", s)
+	if v.Major != 1 {
+		return v, fmt.Errorf("not compatible with major versions other than 1: version %s", s)
 	}
-	_, err = strconv.Atoi(ksplit[1])
-	if err != nil {
-		return fmt.Errorf("malformed minor version %s: %w", s, err)
+	if v.Minor, err = strconv.Atoi(ksplit[1]); err != nil {
+		return v, fmt.Errorf("malformed minor version %s: %w", s, err)
 	}
+	v.Parts = 2
 	if len(ksplit) == 3 {
-		_, err := strconv.Atoi(ksplit[2])
-		if err != nil {
-			return fmt.Errorf("malformed patch version %s: %w", s, err)
+		if v.Patch, err = strconv.Atoi(ksplit[2]); err != nil {
+			return v, fmt.Errorf("malformed patch version %s: %w", s, err)
 		}
-	}
-
-	if len(ksplit) != 3 && len(split) == 2 {
-		return fmt.Errorf("malformed patch version %s: %w", s, err)
+		v.Parts = 3
 	}
 	if len(split) != 2 {
-		return nil
+		return v, nil
 	}
 
+	if v.Parts != 3 {
+		return v, fmt.Errorf("malformed patch version: %s", s)
+	}
 	if !strings.HasPrefix(split[1], "gke.") {
-		return fmt.Errorf("malformed GKE version: %s", s)
+		return v, fmt.Errorf("malformed GKE version: %s", s)
+	}
+	if v.GKE, err = strconv.Atoi(strings.TrimPrefix(split[1], "gke.")); err != nil {
+		return v, fmt.Errorf("malformed GKE version %s: %w", s, err)
 	}
+	v.Parts = 4
+	return v, nil
+}
 
-	_, err = strconv.Atoi(strings.TrimPrefix(split[1], "gke."))
-	if err != nil {
-		return fmt.Errorf("malformed GKE version %s: %w", s, err)
+func (v Version) String() string {
+	switch v.Parts {
+	case 2:
+		return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	case 3:
+		return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	}
+	return fmt.Sprintf("%d.%d.%d-gke.%d", v.Major, v.Minor, v.Patch, v.GKE)
+}
+
+// Compare returns -1, 0 or 1 if v is older than, equal to or newer than o respectively.
+// Components missing from partial versions compare as 0.
+func (v Version) Compare(o Version) int {
+	a := [...]int{v.Major, v.Minor, v.Patch, v.GKE}
+	b := [...]int{o.Major, o.Minor, o.Patch, o.GKE}
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
 	}
+	return 0
+}
 
-	return nil
+// Matches returns whether the version is prefixed by p, i.e. whether each component specified by p is equal.
+// For example, 1.21.2-gke.1800 matches 1.21 and 1.21.2, but 1.21.1-gke.1900 does not match 1.21.1-gke.1.
+func (v Version) Matches(p Version) bool {
+	a := [...]int{v.Major, v.Minor, v.Patch, v.GKE}
+	b := [...]int{p.Major, p.Minor, p.Patch, p.GKE}
+	for i := 0; i < p.Parts; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
-// isUpgrade ensures that the desired version is not a downgrade and is in the list of valid versions.
+// IsFormatValid ensures that the version string is a valid GKE version or version alias.
+// See: https://cloud.google.com/kubernetes-engine/versioning#specifying_cluster_version
+func IsFormatValid(s string) error {
+	if s == DefaultVersion || s == LatestVersion {
+		return nil
+	}
+	_, err := ParseVersion(s)
+	return err
+}
+
+// isUpgrade ensures that the desired version is in the list of valid versions and is not a downgrade.
+// The current version is compared even if it is no longer a valid version, e.g. for node pools with auto-upgrade disabled.
 func isUpgrade(desired, current string, valid []string, allowInPlace bool) error {
 	if len(valid) == 0 {
 		// Should not happen, but protects from out-of-bounds error.
 		return fmt.Errorf("list of valid versions is empty: %v", valid)
 	}
-
-	var (
-		desiredIndex = -1
-		currentIndex = -1
-	)
-	for i, v := range valid {
-		if v == desired {
-			desiredIndex = i
-		}
-		if v == current {
-			currentIndex = i
-		}
-	}
-	if desiredIndex == -1 {
+	if !contains(valid, desired) {
 		return fmt.Errorf("desired version %s was not found; valid versions: %v", desired, valid)
 	}
-	if currentIndex == -1 {
-		// The defalt version is no longer a valid version.
-		// This can happen to node pools with auto-upgrade disabled.
-		return nil
+	d, err := ParseVersion(desired)
+	if err != nil {
+		return err
+	}
+	c, err := ParseVersion(current)
+	if err != nil {
+		return fmt.Errorf("current version is not valid: %w", err)
 	}
-	if allowInPlace && currentIndex == desiredIndex {
+
+	cmp := d.Compare(c)
+	if allowInPlace && cmp == 0 {
 		return nil
 	}
-	if currentIndex <= desiredIndex {
+	if cmp <= 0 {
 		return fmt.Errorf("desired version %s must be newer than current version %s; valid versions: %v", desired, current, valid)
 	}
 
@@ -161,24 +209,22 @@ func isUpgrade(desired, current string, valid []string, allowInPlace bool) error
 // This helps avoid version skew API errors, e.g.:
 //  `node version "x" must be within one minor version of master version "y"`
 //
-// Versions must be in the form "1\.x.*".
-//
 // Note: allowed GKE version skew depends on whether the cluster is using a release channel.
 //  This method uses the release channel version skew value (1 minor version).
 func IsWithinVersionSkew(npVersion, cpVersion string, allowedSkew int) error {
-	npMinor, err := GetMinorVersion(npVersion)
+	np, err := ParseVersion(npVersion)
 	if err != nil {
 		return err
 	}
-	cpMinor, err := GetMinorVersion(cpVersion)
+	cp, err := ParseVersion(cpVersion)
 	if err != nil {
 		return err
 	}
 
-	diff := cpMinor - npMinor
+	diff := cp.Minor - np.Minor
 	if diff < 0 {
 		return fmt.Errorf("desired node version %s minor version (%d) cannot be greater than desired control plane version %s minor version (%d)",
-			npVersion, npMinor, cpVersion, cpMinor)
+			npVersion, np.Minor, cpVersion, cp.Minor)
 	}
 	if diff > allowedSkew {
 		return fmt.Errorf("desired node version %s must be no less than %d minor versions from the desired control plane version %s",
@@ -189,6 +235,7 @@ func IsWithinVersionSkew(npVersion, cpVersion string, allowedSkew int) error {
 }
 
 // resolveVersion converts the desired version (alias) to a specific GKE version.
+// Partial versions resolve to the newest valid version they prefix.
 //
 // Example(s):
 //  1.21 -> 1.21.x-gke.y
@@ -206,25 +253,80 @@ func resolveVersion(desired, def string, valid []string) (string, error) {
 	if desired == DefaultVersion {
 		return def, nil
 	}
-	if desired == LatestVersion {
-		return valid[0], nil
+	if desired == LatestVersion || desired == "" {
+		// An unset version, like an empty prefix, matches every version.
+		return newestVersion(valid, func(Version) bool { return true })
 	}
 
-	// Versions are in descending order, so select the first match.
-	for _, v := range valid {
-		if strings.HasPrefix(v, desired) {
-			return v, nil
+	p, err := ParseVersion(desired)
+	if err != nil {
+		return "", err
+	}
+	v, err := newestVersion(valid, func(v Version) bool { return v.Matches(p) })
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", fmt.Errorf("desired version %q could not be resolved; valid versions: %v", desired, valid)
+	}
+	return v, nil
+}
+
+// newestVersion returns the newest of the versions which match, or "" if none do.
+// The order of the versions is not relied upon.
+func newestVersion(versions []string, match func(v Version) bool) (string, error) {
+	var (
+		newest  string
+		newestV Version
+	)
+	for _, s := range versions {
+		v, err := ParseVersion(s)
+		if err != nil {
+			return "", err
+		}
+		if !match(v) {
+			continue
+		}
+		if newest == "" || v.Compare(newestV) > 0 {
+			newest, newestV = s, v
 		}
 	}
+	return newest, nil
+}
 
-	return "", fmt.Errorf("desired version %q could not be resolved; valid versions: %v", desired, valid)
+// sortVersions returns the versions sorted from oldest to newest.
+func sortVersions(versions []string) ([]string, error) {
+	parsed := make(map[string]Version, len(versions))
+	for _, s := range versions {
+		v, err := ParseVersion(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed[s] = v
+	}
+	sorted := append([]string(nil), versions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return parsed[sorted[i]].Compare(parsed[sorted[j]]) < 0
+	})
+	return sorted, nil
 }
 
 // GetMinorVersion gets the k8s minor version as an int.
-// GetMinorVersion assumes validation has already been performed on the input.
 func GetMinorVersion(v string) (int, error) {
-	split := strings.Split(v, ".")
-	return strconv.Atoi(split[1])
+	p, err := ParseVersion(v)
+	if err != nil {
+		return 0, err
+	}
+	return p.Minor, nil
+}
+
+func contains(versions []string, v string) bool {
+	for _, s := range versions {
+		if s == v {
+			return true
+		}
+	}
+	return false
 }
 
 // getReleaseChannel returns the release channel if present.
@@ -293,6 +293,24 @@ func TestIsUpgrade(t *testing.T) {
 				"1.21.1-gke.1600",
 			},
 		},
+		{
+			desc:    "Current version no longer valid and newer",
+			desired: "1.20.9-gke.1800",
+			current: "1.21.1-gke.1500",
+			valid: []string{
+				"1.21.2-gke.1800",
+				"1.20.9-gke.1800",
+			},
+			wantErr: "must be newer than current version",
+		},
+		{
+			desc:    "Compared semantically",
+			desired: "1.21.10-gke.900",
+			current: "1.21.9-gke.1800",
+			valid: []string{
+				"1.21.10-gke.900",
+			},
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -438,14 +456,36 @@ func TestResolveVersion(t *testing.T) {
 			wantErr: "could not be resolved",
 		},
 		{
-			desc:    "GKE version resolved",
+			desc:    "GKE version is not a prefix",
 			desired: "1.21.1-gke.1",
 			defalt:  "unused",
 			valid: []string{
 				"1.21.1-gke.1900",
 				"1.21.1-gke.1800",
 			},
-			want: "1.21.1-gke.1900",
+			wantErr: "could not be resolved",
+		},
+		{
+			desc:    "Latest of unordered versions",
+			desired: "latest",
+			defalt:  "unused",
+			valid: []string{
+				"1.21.9-gke.1800",
+				"1.21.10-gke.900",
+				"1.20.15-gke.1800",
+			},
+			want: "1.21.10-gke.900",
+		},
+		{
+			desc:    "Patch version resolved from unordered versions",
+			desired: "1.21.1",
+			defalt:  "unused",
+			valid: []string{
+				"1.21.1-gke.900",
+				"1.21.10-gke.1800",
+				"1.21.1-gke.1800",
+			},
+			want: "1.21.1-gke.1800",
 		},
 		{
 			desc:    "Patch version resolved",
@@ -480,3 +520,176 @@ func TestResolveVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		desc    string
+		version string
+		want    Version
+		wantErr string
+	}{
+		{
+			desc:    "Full version",
+			version: "1.19.10-gke.1700",
+			want:    Version{Major: 1, Minor: 19, Patch: 10, GKE: 1700, Parts: 4},
+		},
+		{
+			desc:    "Patch version",
+			version: "1.19.10",
+			want:    Version{Major: 1, Minor: 19, Patch: 10, Parts: 3},
+		},
+		{
+			desc:    "Minor version",
+			version: "1.19",
+			want:    Version{Major: 1, Minor: 19, Parts: 2},
+		},
+		{
+			desc:    "Empty",
+			wantErr: "version must not be empty",
+		},
+		{
+			desc:    "Major version other than 1",
+			version: "2.19.10-gke.1700",
+			wantErr: "not compatible with major versions other than 1",
+		},
+		{
+			desc:    "Malformed minor version",
+			version: "1.x",
+			wantErr: "malformed minor version",
+		},
+		{
+			desc:    "GKE version without patch version",
+			version: "1.19-gke.1700",
+			wantErr: "malformed patch version",
+		},
+		{
+			desc:    "Malformed GKE version",
+			version: "1.19.10-foo.1700",
+			wantErr: "malformed GKE version",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := ParseVersion(tc.version)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("ParseVersion error diff (-want +got):\n%s", diff)
+			}
+			if tc.wantErr != "" {
+				return
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ParseVersion diff (-want +got):\n%s", diff)
+			}
+			if got.String() != tc.version {
+				t.Errorf("String(); wanted: %s, got: %s", tc.version, got.String())
+			}
+		})
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	cases := []struct {
+		desc string
+		a    string
+		b    string
+		want int
+	}{
+		{
+			desc: "Equal",
+			a:    "1.19.10-gke.1700",
+			b:    "1.19.10-gke.1700",
+		},
+		{
+			desc: "Older GKE version",
+			a:    "1.19.10-gke.1600",
+			b:    "1.19.10-gke.1700",
+			want: -1,
+		},
+		{
+			desc: "Newer minor version",
+			a:    "1.20.6-gke.1000",
+			b:    "1.19.11-gke.1700",
+			want: 1,
+		},
+		{
+			desc: "Numeric rather than lexical patch version",
+			a:    "1.19.10-gke.1000",
+			b:    "1.19.9-gke.1700",
+			want: 1,
+		},
+		{
+			desc: "Without GKE version",
+			a:    "1.19.10",
+			b:    "1.19.10-gke.1700",
+			want: -1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			a, err := ParseVersion(tc.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ParseVersion(tc.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := a.Compare(b); got != tc.want {
+				t.Errorf("Compare(%q, %q); wanted: %d, got: %d", tc.a, tc.b, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersion_Matches(t *testing.T) {
+	cases := []struct {
+		desc    string
+		version string
+		prefix  string
+		want    bool
+	}{
+		{
+			desc:    "Minor version",
+			version: "1.21.2-gke.1800",
+			prefix:  "1.21",
+			want:    true,
+		},
+		{
+			desc:    "Patch version",
+			version: "1.21.2-gke.1800",
+			prefix:  "1.21.2",
+			want:    true,
+		},
+		{
+			desc:    "Full version",
+			version: "1.21.2-gke.1800",
+			prefix:  "1.21.2-gke.1800",
+			want:    true,
+		},
+		{
+			desc:    "Different patch version",
+			version: "1.21.20-gke.1800",
+			prefix:  "1.21.2",
+		},
+		{
+			desc:    "GKE version is not a string prefix",
+			version: "1.21.1-gke.1900",
+			prefix:  "1.21.1-gke.1",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			v, err := ParseVersion(tc.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := ParseVersion(tc.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.Matches(p); got != tc.want {
+				t.Errorf("Matches(%q, %q); wanted: %t, got: %t", tc.version, tc.prefix, tc.want, got)
+			}
+		})
+	}
+}
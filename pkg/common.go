@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"regexp"
 
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
 	computebeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/container/v1"
@@ -96,7 +97,11 @@ type ContainerService interface {
 	GetCluster(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.Cluster, error)
 	ListClusters(ctx context.Context, parent string, opts ...googleapi.CallOption) (*container.ListClustersResponse, error)
 	GetOperation(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.Operation, error)
+	CancelOperation(ctx context.Context, name string, opts ...googleapi.CallOption) error
+	ListOperations(ctx context.Context, parent string, opts ...googleapi.CallOption) (*container.ListOperationsResponse, error)
+	SetMaintenancePolicy(ctx context.Context, req *container.SetMaintenancePolicyRequest, opts ...googleapi.CallOption) (*container.Operation, error)
 	UpdateNodePool(ctx context.Context, req *container.UpdateNodePoolRequest, opts ...googleapi.CallOption) (*container.Operation, error)
+	GetNodePool(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.NodePool, error)
 	ListNodePools(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.ListNodePoolsResponse, error)
 	GetServerConfig(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.ServerConfig, error)
 }
@@ -171,9 +176,22 @@ func (c *Container) ListClusters(ctx context.Context, parent string, opts ...goo
 func (c *Container) GetOperation(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.Operation, error) {
 	return c.V1.Projects.Locations.Operations.Get(name).Context(ctx).Do(opts...)
 }
+func (c *Container) CancelOperation(ctx context.Context, name string, opts ...googleapi.CallOption) error {
+	_, err := c.V1.Projects.Locations.Operations.Cancel(name, &container.CancelOperationRequest{}).Context(ctx).Do(opts...)
+	return err
+}
+func (c *Container) ListOperations(ctx context.Context, parent string, opts ...googleapi.CallOption) (*container.ListOperationsResponse, error) {
+	return c.V1.Projects.Locations.Operations.List(parent).Context(ctx).Do(opts...)
+}
+func (c *Container) SetMaintenancePolicy(ctx context.Context, req *container.SetMaintenancePolicyRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	return c.V1.Projects.Locations.Clusters.SetMaintenancePolicy(req.Name, req).Context(ctx).Do(opts...)
+}
 func (c *Container) UpdateNodePool(ctx context.Context, req *container.UpdateNodePoolRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
 	return c.V1.Projects.Locations.Clusters.NodePools.Update(req.Name, req).Context(ctx).Do(opts...)
 }
+func (c *Container) GetNodePool(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.NodePool, error) {
+	return c.V1.Projects.Locations.Clusters.NodePools.Get(name).Context(ctx).Do(opts...)
+}
 func (c *Container) ListNodePools(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.ListNodePoolsResponse, error) {
 	return c.V1.Projects.Locations.Clusters.NodePools.List(name).Context(ctx).Do(opts...)
 }
@@ -182,9 +200,30 @@ func (c *Container) GetServerConfig(ctx context.Context, name string, opts ...go
 	return c.V1.Projects.Locations.GetServerConfig(name).Context(ctx).Do(opts...)
 }
 
+// ResourceManagerService mirrors a (sub)set of the generated Cloud Resource Manager Service APIs.
+type ResourceManagerService interface {
+	// TestIamPermissions returns the subset of the permissions which the caller has on the project.
+	TestIamPermissions(ctx context.Context, project string, permissions []string, opts ...googleapi.CallOption) ([]string, error)
+}
+
+type ResourceManager struct {
+	V1 *cloudresourcemanager.Service
+}
+
+func (r *ResourceManager) TestIamPermissions(ctx context.Context, project string, permissions []string, opts ...googleapi.CallOption) ([]string, error) {
+	req := &cloudresourcemanager.TestIamPermissionsRequest{Permissions: permissions}
+	resp, err := r.V1.Projects.TestIamPermissions(project, req).Context(ctx).Do(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Permissions, nil
+}
+
 type Clients struct {
 	Compute   ComputeService
 	Container ContainerService
+	// ResourceManager tests the caller's permissions; permissions are not tested if nil.
+	ResourceManager ResourceManagerService
 }
 
 func IsZonal(location string) bool {
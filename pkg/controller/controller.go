@@ -0,0 +1,281 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/clusters"
+	"legacymigration/pkg/networks"
+
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+)
+
+const (
+	APIVersion = "gkeconvert/v1alpha1"
+	Kind       = "NetworkConversion"
+
+	// Condition types.
+	ConditionReconciled = "Reconciled"
+	ConditionReady      = "Ready"
+
+	ConditionTrue  = "True"
+	ConditionFalse = "False"
+)
+
+// NetworkConversion declares the desired conversion of a network and its clusters.
+type NetworkConversion struct {
+	APIVersion string   `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string   `json:"kind" yaml:"kind"`
+	Metadata   Metadata `json:"metadata" yaml:"metadata"`
+	Spec       Spec     `json:"spec" yaml:"spec"`
+	Status     Status   `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+type Metadata struct {
+	Name       string `json:"name" yaml:"name"`
+	Generation int64  `json:"generation,omitempty" yaml:"generation,omitempty"`
+}
+
+// Spec contains the conversion options. Unset fields use the same defaults as the command line flags.
+type Spec struct {
+	ProjectID                  string       `json:"projectId" yaml:"projectId"`
+	Network                    string       `json:"network" yaml:"network"`
+	Clusters                   []string     `json:"clusters,omitempty" yaml:"clusters,omitempty"`
+	Locations                  []string     `json:"locations,omitempty" yaml:"locations,omitempty"`
+	AllowMissingZones          bool         `json:"allowMissingZones,omitempty" yaml:"allowMissingZones,omitempty"`
+	ConcurrentClusters         uint16       `json:"concurrentClusters,omitempty" yaml:"concurrentClusters,omitempty"`
+	ControlPlaneVersion        string       `json:"controlPlaneVersion,omitempty" yaml:"controlPlaneVersion,omitempty"`
+	NodeVersion                string       `json:"nodeVersion,omitempty" yaml:"nodeVersion,omitempty"`
+	InPlaceControlPlaneUpgrade bool         `json:"inPlaceControlPlaneUpgrade,omitempty" yaml:"inPlaceControlPlaneUpgrade,omitempty"`
+	AutoVersion                bool         `json:"autoVersion,omitempty" yaml:"autoVersion,omitempty"`
+	PollingInterval            pkg.Duration `json:"pollingInterval,omitempty" yaml:"pollingInterval,omitempty"`
+	PollingDeadline            pkg.Duration `json:"pollingDeadline,omitempty" yaml:"pollingDeadline,omitempty"`
+}
+
+// Status is the observed state of the conversion.
+type Status struct {
+	ObservedGeneration int64        `json:"observedGeneration,omitempty" yaml:"observedGeneration,omitempty"`
+	Attempts           int          `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	Observed           *Observation `json:"observed,omitempty" yaml:"observed,omitempty"`
+	Conditions         []Condition  `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+}
+
+// Observation is the state of the network and its clusters.
+type Observation struct {
+	NetworkConverted bool `json:"networkConverted" yaml:"networkConverted"`
+	// Paths of clusters without a subnetwork.
+	PendingClusters []string `json:"pendingClusters,omitempty" yaml:"pendingClusters,omitempty"`
+	// Paths of node pools which require an upgrade.
+	PendingNodePools []string `json:"pendingNodePools,omitempty" yaml:"pendingNodePools,omitempty"`
+}
+
+// Converged returns whether the network and all its clusters have been converted.
+func (o *Observation) Converged() bool {
+	return o.NetworkConverted && len(o.PendingClusters) == 0 && len(o.PendingNodePools) == 0
+}
+
+type Condition struct {
+	Type               string    `json:"type" yaml:"type"`
+	Status             string    `json:"status" yaml:"status"`
+	Reason             string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Message            string    `json:"message,omitempty" yaml:"message,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime" yaml:"lastTransitionTime"`
+}
+
+// Store loads NetworkConversions and persists their status.
+type Store interface {
+	Load(ctx context.Context) (*NetworkConversion, error)
+	SaveStatus(ctx context.Context, nc *NetworkConversion) error
+}
+
+// Runner runs the Complete, Validate and Migrate pipeline for the Spec.
+type Runner func(ctx context.Context, spec Spec) error
+
+// Observer observes the state of the network and clusters selected by the Spec.
+type Observer func(ctx context.Context, spec Spec) (*Observation, error)
+
+// Controller reconciles a NetworkConversion until the observed state matches the Spec.
+type Controller struct {
+	store    Store
+	run      Runner
+	observe  Observer
+	interval time.Duration
+	now      func() time.Time
+}
+
+func New(store Store, run Runner, observe Observer, interval time.Duration) *Controller {
+	return &Controller{
+		store:    store,
+		run:      run,
+		observe:  observe,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Run reconciles every interval until the NetworkConversion is Ready or ctx is closed.
+// Reconcile errors are reported in the status conditions and retried.
+func (c *Controller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		ready, err := c.Reconcile(ctx)
+		if ready {
+			return nil
+		}
+		if err != nil {
+			log.Errorf("Reconcile error; retrying in %v: %v", c.interval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context error: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile observes the state and runs the conversion pipeline if the state has not converged.
+// Returns whether the NetworkConversion is Ready.
+func (c *Controller) Reconcile(ctx context.Context) (bool, error) {
+	nc, err := c.store.Load(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error loading NetworkConversion: %w", err)
+	}
+	if err := nc.Validate(); err != nil {
+		return false, err
+	}
+	nc.Status.ObservedGeneration = nc.Metadata.Generation
+
+	obs, err := c.observe(ctx, nc.Spec)
+	if err != nil {
+		c.setCondition(nc, ConditionReady, ConditionFalse, "ObservationFailed", err.Error())
+		return false, multierr.Append(err, c.store.SaveStatus(ctx, nc))
+	}
+	if !obs.Converged() {
+		nc.Status.Attempts++
+		log.Infof("NetworkConversion %s has not converged (attempt %d); running conversion.", nc.Metadata.Name, nc.Status.Attempts)
+
+		runErr := c.run(ctx, nc.Spec)
+		if runErr != nil {
+			c.setCondition(nc, ConditionReconciled, ConditionFalse, "ConversionFailed", runErr.Error())
+		} else {
+			c.setCondition(nc, ConditionReconciled, ConditionTrue, "ConversionSucceeded", "")
+		}
+
+		obs, err = c.observe(ctx, nc.Spec)
+		if err != nil {
+			c.setCondition(nc, ConditionReady, ConditionFalse, "ObservationFailed", err.Error())
+			return false, multierr.Combine(runErr, err, c.store.SaveStatus(ctx, nc))
+		}
+		err = runErr
+	}
+
+	nc.Status.Observed = obs
+	ready := obs.Converged()
+	if ready {
+		c.setCondition(nc, ConditionReady, ConditionTrue, "Converged", "Network and all clusters are converted.")
+	} else {
+		c.setCondition(nc, ConditionReady, ConditionFalse, "NotConverged", describe(obs))
+	}
+	return ready, multierr.Append(err, c.store.SaveStatus(ctx, nc))
+}
+
+// setCondition updates or adds a condition, preserving the transition time if the status is unchanged.
+func (c *Controller) setCondition(nc *NetworkConversion, typ, status, reason, message string) {
+	cond := Condition{
+		Type:               typ,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: c.now().UTC(),
+	}
+	for i, existing := range nc.Status.Conditions {
+		if existing.Type != typ {
+			continue
+		}
+		if existing.Status == status {
+			cond.LastTransitionTime = existing.LastTransitionTime
+		}
+		nc.Status.Conditions[i] = cond
+		return
+	}
+	nc.Status.Conditions = append(nc.Status.Conditions, cond)
+}
+
+// Validate ensures the NetworkConversion is well-formed.
+func (nc *NetworkConversion) Validate() error {
+	if nc.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q; expected %q", nc.APIVersion, APIVersion)
+	}
+	if nc.Kind != Kind {
+		return fmt.Errorf("unsupported kind %q; expected %q", nc.Kind, Kind)
+	}
+	if nc.Spec.ProjectID == "" {
+		return fmt.Errorf("%s %s: spec.projectId is required", Kind, nc.Metadata.Name)
+	}
+	if nc.Spec.Network == "" {
+		return fmt.Errorf("%s %s: spec.network is required", Kind, nc.Metadata.Name)
+	}
+	return nil
+}
+
+func describe(obs *Observation) string {
+	return fmt.Sprintf("network converted: %v; clusters pending upgrade: %v; node pools pending upgrade: %v",
+		obs.NetworkConverted, obs.PendingClusters, obs.PendingNodePools)
+}
+
+// Observe returns the state of the network and the clusters selected by the Spec using the clients.
+func Observe(ctx context.Context, clients *pkg.Clients, spec Spec) (*Observation, error) {
+	n, err := clients.Compute.GetNetwork(ctx, spec.ProjectID, spec.Network)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving network %s: %w", pkg.NetworkPath(spec.ProjectID, spec.Network), err)
+	}
+	obs := &Observation{NetworkConverted: n.IPv4Range == ""}
+
+	listed, missingZones, err := clusters.List(ctx, clients.Container, spec.ProjectID, spec.Locations)
+	if err != nil {
+		return nil, fmt.Errorf("error listing Clusters for network %s: %w", pkg.NetworkPath(spec.ProjectID, spec.Network), err)
+	}
+	if len(missingZones) > 0 && !spec.AllowMissingZones {
+		return nil, fmt.Errorf("unable to observe all clusters; unable to list Clusters in zones: %v", missingZones)
+	}
+
+	for _, c := range listed {
+		if !networks.IsSelected(c, spec.Network, spec.Clusters) {
+			continue
+		}
+		path := pkg.ClusterPath(spec.ProjectID, c.Location, c.Name)
+		if c.Subnetwork == "" {
+			obs.PendingClusters = append(obs.PendingClusters, path)
+		}
+		for _, np := range c.NodePools {
+			npPath := pkg.NodePoolPath(spec.ProjectID, c.Location, c.Name, np.Name)
+			required, err := clusters.IsUpgradeRequired(ctx, clients.Compute, spec.ProjectID, npPath, np)
+			if err != nil {
+				return nil, err
+			}
+			if required {
+				obs.PendingNodePools = append(obs.PendingNodePools, npPath)
+			}
+		}
+	}
+	return obs, nil
+}
@@ -0,0 +1,371 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/faults"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	testTime = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	converged = &Observation{NetworkConverted: true}
+	pending   = &Observation{PendingClusters: []string{"c"}}
+)
+
+type fakeStore struct {
+	nc      *NetworkConversion
+	loadErr error
+	saveErr error
+	saved   *NetworkConversion
+}
+
+func (f *fakeStore) Load(_ context.Context) (*NetworkConversion, error) {
+	if f.loadErr != nil {
+		return nil, f.loadErr
+	}
+	c := *f.nc
+	return &c, nil
+}
+
+func (f *fakeStore) SaveStatus(_ context.Context, nc *NetworkConversion) error {
+	f.saved = nc
+	return f.saveErr
+}
+
+// observations returns an Observer which returns each observation in turn.
+func observations(obs ...*Observation) Observer {
+	return func(_ context.Context, _ Spec) (*Observation, error) {
+		o := obs[0]
+		if len(obs) > 1 {
+			obs = obs[1:]
+		}
+		if o == nil {
+			return nil, errors.New("observe error")
+		}
+		return o, nil
+	}
+}
+
+func TestController_Reconcile(t *testing.T) {
+	cases := []struct {
+		desc           string
+		store          *fakeStore
+		runErr         error
+		observe        Observer
+		want           bool
+		wantErr        string
+		wantAttempts   int
+		wantConditions []Condition
+	}{
+		{
+			desc:    "Already converged",
+			store:   &fakeStore{nc: testNetworkConversion()},
+			observe: observations(converged),
+			want:    true,
+			wantConditions: []Condition{
+				{Type: ConditionReady, Status: ConditionTrue, Reason: "Converged", Message: "Network and all clusters are converted.", LastTransitionTime: testTime},
+			},
+		},
+		{
+			desc:         "Converged after conversion",
+			store:        &fakeStore{nc: testNetworkConversion()},
+			observe:      observations(pending, converged),
+			want:         true,
+			wantAttempts: 1,
+			wantConditions: []Condition{
+				{Type: ConditionReconciled, Status: ConditionTrue, Reason: "ConversionSucceeded", LastTransitionTime: testTime},
+				{Type: ConditionReady, Status: ConditionTrue, Reason: "Converged", Message: "Network and all clusters are converted.", LastTransitionTime: testTime},
+			},
+		},
+		{
+			desc:         "Conversion error",
+			store:        &fakeStore{nc: testNetworkConversion()},
+			runErr:       errors.New("validation error"),
+			observe:      observations(pending, pending),
+			wantErr:      "validation error",
+			wantAttempts: 1,
+			wantConditions: []Condition{
+				{Type: ConditionReconciled, Status: ConditionFalse, Reason: "ConversionFailed", Message: "validation error", LastTransitionTime: testTime},
+				{Type: ConditionReady, Status: ConditionFalse, Reason: "NotConverged", Message: "network converted: false; clusters pending upgrade: [c]; node pools pending upgrade: []", LastTransitionTime: testTime},
+			},
+		},
+		{
+			desc:    "Observe error",
+			store:   &fakeStore{nc: testNetworkConversion()},
+			observe: observations(nil),
+			wantErr: "observe error",
+			wantConditions: []Condition{
+				{Type: ConditionReady, Status: ConditionFalse, Reason: "ObservationFailed", Message: "observe error", LastTransitionTime: testTime},
+			},
+		},
+		{
+			desc:    "Load error",
+			store:   &fakeStore{loadErr: errors.New("no such file")},
+			observe: observations(converged),
+			wantErr: "error loading NetworkConversion: no such file",
+		},
+		{
+			desc: "Invalid spec",
+			store: &fakeStore{nc: func(nc *NetworkConversion) *NetworkConversion {
+				nc.Spec.Network = ""
+				return nc
+			}(testNetworkConversion())},
+			observe: observations(converged),
+			wantErr: "spec.network is required",
+		},
+		{
+			desc:    "Save error",
+			store:   &fakeStore{nc: testNetworkConversion(), saveErr: errors.New("read-only file system")},
+			observe: observations(converged),
+			want:    true,
+			wantErr: "read-only file system",
+			wantConditions: []Condition{
+				{Type: ConditionReady, Status: ConditionTrue, Reason: "Converged", Message: "Network and all clusters are converted.", LastTransitionTime: testTime},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			run := func(_ context.Context, _ Spec) error { return tc.runErr }
+			c := New(tc.store, run, tc.observe, time.Minute)
+			c.now = func() time.Time { return testTime }
+
+			got, err := c.Reconcile(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Controller.Reconcile error diff (-want +got):\n%s", diff)
+			}
+			if got != tc.want {
+				t.Errorf("Controller.Reconcile; wanted: %v, got: %v", tc.want, got)
+			}
+			if tc.store.saved == nil {
+				if tc.wantConditions != nil {
+					t.Fatalf("Controller.Reconcile did not save status")
+				}
+				return
+			}
+			if tc.store.saved.Status.Attempts != tc.wantAttempts {
+				t.Errorf("Status.Attempts; wanted: %d, got: %d", tc.wantAttempts, tc.store.saved.Status.Attempts)
+			}
+			if diff := cmp.Diff(tc.wantConditions, tc.store.saved.Status.Conditions); diff != "" {
+				t.Errorf("Status.Conditions diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestController_SetCondition(t *testing.T) {
+	nc := testNetworkConversion()
+	c := New(&fakeStore{}, nil, nil, time.Minute)
+	c.now = func() time.Time { return testTime }
+
+	c.setCondition(nc, ConditionReady, ConditionFalse, "NotConverged", "first")
+	c.now = func() time.Time { return testTime.Add(time.Hour) }
+	c.setCondition(nc, ConditionReady, ConditionFalse, "NotConverged", "second")
+
+	want := []Condition{
+		{Type: ConditionReady, Status: ConditionFalse, Reason: "NotConverged", Message: "second", LastTransitionTime: testTime},
+	}
+	if diff := cmp.Diff(want, nc.Status.Conditions); diff != "" {
+		t.Errorf("Unchanged status diff (-want +got):\n%s", diff)
+	}
+
+	c.setCondition(nc, ConditionReady, ConditionTrue, "Converged", "")
+	if got := nc.Status.Conditions[0].LastTransitionTime; !got.Equal(testTime.Add(time.Hour)) {
+		t.Errorf("Changed status LastTransitionTime; wanted: %v, got: %v", testTime.Add(time.Hour), got)
+	}
+}
+
+func TestController_Run(t *testing.T) {
+	store := &fakeStore{nc: testNetworkConversion()}
+	runs := 0
+	run := func(_ context.Context, _ Spec) error {
+		runs++
+		return nil
+	}
+	c := New(store, run, observations(pending, pending, pending, converged), time.Microsecond)
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Controller.Run unexpected error: %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("Controller.Run conversion attempts; wanted: 2, got: %d", runs)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = New(store, run, observations(pending), time.Microsecond)
+	if diff := test.ErrorDiff("context canceled", c.Run(cancelled)); diff != "" {
+		t.Errorf("Controller.Run diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	spec := testNetworkConversion().Spec
+	clusterPath := "projects/test-project/locations/region-a/clusters/cluster-c"
+	nodePoolPath := clusterPath + "/nodePools/default-pool"
+	// networkConverted converts the network, but not its clusters or node pools.
+	networkConverted := func(s *fakeapi.State) *fakeapi.State {
+		s.Networks[0].IPv4Range = ""
+		return s
+	}
+	clusterConverted := func(s *fakeapi.State) *fakeapi.State {
+		s.Clusters[0].Subnetwork = test.SelectedNetwork
+		return networkConverted(s)
+	}
+
+	cases := []struct {
+		desc    string
+		spec    Spec
+		state   *fakeapi.State
+		faults  []faults.Fault
+		want    *Observation
+		wantErr string
+	}{
+		{
+			desc:  "Legacy network and cluster",
+			spec:  spec,
+			state: testState(),
+			want: &Observation{
+				PendingClusters:  []string{clusterPath},
+				PendingNodePools: []string{nodePoolPath},
+			},
+		},
+		{
+			desc: "Converged",
+			spec: spec,
+			state: func(s *fakeapi.State) *fakeapi.State {
+				for _, it := range s.InstanceTemplates {
+					it.Properties.NetworkInterfaces[0].Subnetwork = test.SelectedNetwork
+				}
+				other := *s.Clusters[0]
+				other.Name, other.Network, other.Subnetwork = "other", "other", ""
+				s.Clusters = append(s.Clusters, &other)
+				return clusterConverted(s)
+			}(testState()),
+			want: converged,
+		},
+		{
+			desc:  "Node pool pending upgrade",
+			spec:  spec,
+			state: clusterConverted(testState()),
+			want: &Observation{
+				NetworkConverted: true,
+				PendingNodePools: []string{nodePoolPath},
+			},
+		},
+		{
+			desc: "Cluster not selected",
+			spec: func(s Spec) Spec {
+				s.Clusters = []string{"other-cluster"}
+				return s
+			}(spec),
+			state: networkConverted(testState()),
+			want:  converged,
+		},
+		{
+			desc: "Missing zones",
+			spec: spec,
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Script.UnavailableZones = []string{test.ZoneA0}
+				return s
+			}(networkConverted(testState())),
+			wantErr: "unable to observe all clusters; unable to list Clusters in zones: [region-a-0]",
+		},
+		{
+			desc: "Missing zones allowed",
+			spec: func(s Spec) Spec {
+				s.AllowMissingZones = true
+				return s
+			}(spec),
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Script.UnavailableZones = []string{test.ZoneA0}
+				return s
+			}(networkConverted(testState())),
+			want: &Observation{
+				NetworkConverted: true,
+				PendingClusters:  []string{clusterPath},
+				PendingNodePools: []string{nodePoolPath},
+			},
+		},
+		{
+			desc:    "GetNetwork error",
+			spec:    spec,
+			state:   testState(),
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "GetNetwork", Message: "get error"}},
+			wantErr: "error retrieving network projects/test-project/global/networks/network-0: googleapi: Error 503: get error",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv, err := fakeapi.New(tc.state)
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			injector, err := faults.New(&faults.Config{Faults: tc.faults})
+			if err != nil {
+				t.Fatalf("faults.New unexpected error: %v", err)
+			}
+
+			got, err := Observe(context.Background(), injector.Wrap(srv.Clients()), tc.spec)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Observe error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Observe diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// testState returns test.PrePatchCluster, with a single node pool, on a legacy network.
+func testState() *fakeapi.State {
+	sc := &fakeapi.Scenario{
+		ProjectID: test.ProjectName,
+		Network:   test.SelectedNetwork,
+		Clusters: []fakeapi.ScenarioCluster{
+			{
+				Name:      test.ClusterName,
+				Location:  test.RegionA,
+				Version:   test.PrePatchCluster.CurrentMasterVersion,
+				NodePools: []fakeapi.ScenarioNodePool{{Name: test.NodePoolName}},
+			},
+		},
+		ServerConfig: test.ServerConfig(),
+	}
+	return sc.State()
+}
+
+func testNetworkConversion() *NetworkConversion {
+	return &NetworkConversion{
+		APIVersion: APIVersion,
+		Kind:       Kind,
+		Metadata:   Metadata{Name: "conversion"},
+		Spec: Spec{
+			ProjectID: test.ProjectName,
+			Network:   test.SelectedNetwork,
+		},
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileStore is a Store backed by a YAML or JSON file.
+// The status is written in the format the file was written in.
+type FileStore struct {
+	Path string
+}
+
+// Load reads the NetworkConversion from the file.
+func (s *FileStore) Load(_ context.Context) (*NetworkConversion, error) {
+	nc, _, err := s.read()
+	return nc, err
+}
+
+// read reads the NetworkConversion from the file, and whether the file is JSON rather than YAML.
+func (s *FileStore) read() (*NetworkConversion, bool, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, false, err
+	}
+	nc := &NetworkConversion{}
+	// JSON is parsed as YAML, which it is a subset of.
+	if err := yaml.Unmarshal(b, nc); err != nil {
+		return nil, false, fmt.Errorf("error parsing %s: %w", s.Path, err)
+	}
+	return nc, bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")), nil
+}
+
+// SaveStatus writes the status of the NetworkConversion to the file.
+// The file is re-read so that concurrent changes to the spec are preserved.
+func (s *FileStore) SaveStatus(_ context.Context, nc *NetworkConversion) error {
+	current, isJSON, err := s.read()
+	if err != nil {
+		return err
+	}
+	current.Status = nc.Status
+
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if isJSON {
+		b, err = json.MarshalIndent(current, "", "  ")
+		b = append(b, '\n')
+	} else {
+		b, err = yaml.Marshal(current)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so the spec is never left partially written.
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testSpec = `apiVersion: gkeconvert/v1alpha1
+kind: NetworkConversion
+metadata:
+  name: conversion
+spec:
+  projectId: test-project
+  network: network-0
+  clusters: [cluster-c]
+  controlPlaneVersion: "1.20"
+  pollingInterval: 30s
+`
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spec.yaml")
+	if err := ioutil.WriteFile(path, []byte(testSpec), 0644); err != nil {
+		t.Fatalf("Unable to write spec: %v", err)
+	}
+	s := &FileStore{Path: path}
+
+	nc, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("FileStore.Load unexpected error: %v", err)
+	}
+	wantSpec := Spec{
+		ProjectID:           test.ProjectName,
+		Network:             test.SelectedNetwork,
+		Clusters:            []string{test.ClusterName},
+		ControlPlaneVersion: "1.20",
+		PollingInterval:     pkg.Duration(30 * time.Second),
+	}
+	if diff := cmp.Diff(wantSpec, nc.Spec); diff != "" {
+		t.Errorf("FileStore.Load spec diff (-want +got):\n%s", diff)
+	}
+
+	nc.Spec.Network = "ignored"
+	nc.Status = Status{
+		Attempts: 1,
+		Conditions: []Condition{
+			{Type: ConditionReady, Status: ConditionTrue, LastTransitionTime: testTime},
+		},
+	}
+	if err := s.SaveStatus(ctx, nc); err != nil {
+		t.Fatalf("FileStore.SaveStatus unexpected error: %v", err)
+	}
+
+	got, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("FileStore.Load unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(wantSpec, got.Spec); diff != "" {
+		t.Errorf("FileStore.SaveStatus modified spec (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(nc.Status, got.Status); diff != "" {
+		t.Errorf("FileStore.SaveStatus status diff (-want +got):\n%s", diff)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(b), "pollingInterval: 30s") {
+		t.Errorf("FileStore.SaveStatus did not preserve duration format:\n%s", b)
+	}
+}
+
+func TestFileStore_JSON(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spec.json")
+	spec := `{"apiVersion": "gkeconvert/v1alpha1", "kind": "NetworkConversion", "spec": {"projectId": "test-project", "network": "network-0", "pollingInterval": "30s"}}`
+	if err := ioutil.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatalf("Unable to write spec: %v", err)
+	}
+	s := &FileStore{Path: path}
+
+	nc, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("FileStore.Load unexpected error: %v", err)
+	}
+	if got := time.Duration(nc.Spec.PollingInterval); got != 30*time.Second {
+		t.Errorf("FileStore.Load pollingInterval; wanted: %v, got: %v", 30*time.Second, got)
+	}
+
+	nc.Status = Status{Attempts: 1}
+	if err := s.SaveStatus(ctx, nc); err != nil {
+		t.Fatalf("FileStore.SaveStatus unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read spec: %v", err)
+	}
+	var got NetworkConversion
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("FileStore.SaveStatus did not write JSON: %v\n%s", err, b)
+	}
+	if got.Spec.PollingInterval != nc.Spec.PollingInterval || got.Status.Attempts != 1 {
+		t.Errorf("FileStore.SaveStatus; wanted pollingInterval 30s and 1 attempt, got:\n%s", b)
+	}
+	if !strings.Contains(string(b), `"pollingInterval": "30s"`) {
+		t.Errorf("FileStore.SaveStatus did not preserve duration format:\n%s", b)
+	}
+}
+
+func TestFileStore_LoadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spec.yaml")
+	if err := ioutil.WriteFile(path, []byte("spec: ["), 0644); err != nil {
+		t.Fatalf("Unable to write spec: %v", err)
+	}
+
+	_, err = (&FileStore{Path: path}).Load(context.Background())
+	if diff := test.ErrorDiff("error parsing", err); diff != "" {
+		t.Errorf("FileStore.Load diff (-want +got):\n%s", diff)
+	}
+}
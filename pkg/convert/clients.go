@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/ratelimit"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"golang.org/x/oauth2"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/option"
+)
+
+// Endpoints overrides the API endpoints used by the clients (for testing).
+type Endpoints struct {
+	// Compute is a custom base URL for the compute API, under which the v1 and beta APIs are served
+	// at compute/v1/ and compute/beta/ respectively.
+	Compute string
+	// Container is a custom base URL for the container API.
+	Container string
+	// ResourceManager is a custom base URL for the Resource Manager API.
+	ResourceManager string
+}
+
+// Local returns whether all APIs are overridden by plain HTTP endpoints, e.g. a local fakeapi.Server.
+func (e Endpoints) Local() bool {
+	return strings.HasPrefix(e.Compute, "http://") && strings.HasPrefix(e.Container, "http://") &&
+		strings.HasPrefix(e.ResourceManager, "http://")
+}
+
+// DefaultClient returns an HTTP client using the Credentials.
+// As credentials are not sent over plain HTTP, an unauthenticated client is returned for Local endpoints.
+func DefaultClient(ctx context.Context, endpoints Endpoints, creds Credentials) (*http.Client, error) {
+	if endpoints.Local() {
+		return http.DefaultClient, nil
+	}
+	ts, err := creds.TokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+// NewClients returns retrying API clients which send requests using authedClient.
+// Endpoints which are set override the default API endpoints. Requests, including retries,
+// are rate limited per API by limits, shared by all users of the clients.
+func NewClients(ctx context.Context, endpoints Endpoints, authedClient *http.Client, limits ratelimit.Limits) (*pkg.Clients, error) {
+	computeClient := ratelimit.Client(authedClient, ratelimit.NewLimiter(limits.ComputeRead), ratelimit.NewLimiter(limits.ComputeWrite))
+	containerClient := ratelimit.Client(authedClient, ratelimit.NewLimiter(limits.ContainerRead), ratelimit.NewLimiter(limits.ContainerWrite))
+
+	opt := getRetryableClientOption(3, 5*time.Second, 30*time.Second, computeClient)
+	computeService, err := compute.NewService(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	containerOpt := getRetryableClientOption(3, 5*time.Second, 30*time.Second, containerClient)
+	containerService, err := container.NewService(ctx, containerOpt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retry for up-to 5 minutes for Compute Beta API calls.
+	betaOpt := getRetryableClientOption(5, 5*time.Second, 160*time.Second, computeClient)
+	computeServiceBeta, err := computebeta.NewService(ctx, betaOpt)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceManagerService, err := cloudresourcemanager.NewService(ctx, getRetryableClientOption(3, 5*time.Second, 30*time.Second, authedClient))
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoints.Compute != "" {
+		base := strings.TrimSuffix(endpoints.Compute, "/")
+		computeService.BasePath = base + "/compute/v1/"
+		computeServiceBeta.BasePath = base + "/compute/beta/"
+	}
+	if endpoints.Container != "" {
+		containerService.BasePath = endpoints.Container
+	}
+	if endpoints.ResourceManager != "" {
+		resourceManagerService.BasePath = endpoints.ResourceManager
+	}
+	return &pkg.Clients{
+		Compute: &pkg.Compute{
+			V1:   computeService,
+			Beta: computeServiceBeta,
+		},
+		Container:       &pkg.Container{V1: containerService},
+		ResourceManager: &pkg.ResourceManager{V1: resourceManagerService},
+	}, nil
+}
+
+func getRetryableClientOption(retry int, waitMin, waitMax time.Duration, authedClient *http.Client) option.ClientOption {
+	retryClient := retryablehttp.NewClient()
+	retryClient.RetryMax = retry
+	retryClient.RetryWaitMin = waitMin
+	retryClient.RetryWaitMax = waitMax
+	retryClient.Logger = nil
+	retryClient.CheckRetry = retryPolicy()
+	retryClient.Backoff = backoff
+	retryClient.RequestLogHook = logRetry
+	// The last response is returned once retries are exhausted, so that its error is reported by the API client.
+	retryClient.ErrorHandler = retryablehttp.PassthroughErrorHandler
+
+	c := retryClient.StandardClient()
+	c.Transport.(*retryablehttp.RoundTripper).Client.HTTPClient = authedClient
+	c.Transport = &retryStatsTransport{base: c.Transport}
+	return option.WithHTTPClient(c)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/ratelimit"
+	"legacymigration/test"
+)
+
+func TestNewClients(t *testing.T) {
+	endpoints := Endpoints{
+		Compute:         "http://localhost:8081",
+		Container:       "http://localhost:8080/",
+		ResourceManager: "http://localhost:8082/",
+	}
+	clients, err := NewClients(context.Background(), endpoints, http.DefaultClient, ratelimit.Default)
+	if err != nil {
+		t.Fatalf("NewClients unexpected error: %v", err)
+	}
+	if got := clients.Container.(*pkg.Container).V1.BasePath; got != endpoints.Container {
+		t.Errorf("Container BasePath; wanted: %q, got: %q", endpoints.Container, got)
+	}
+	if got := clients.ResourceManager.(*pkg.ResourceManager).V1.BasePath; got != endpoints.ResourceManager {
+		t.Errorf("ResourceManager BasePath; wanted: %q, got: %q", endpoints.ResourceManager, got)
+	}
+	c := clients.Compute.(*pkg.Compute)
+	if c.V1 == nil || c.Beta == nil {
+		t.Fatalf("Compute clients not initialized")
+	}
+	if want := "http://localhost:8081/compute/v1/"; c.V1.BasePath != want {
+		t.Errorf("Compute V1 BasePath; wanted: %q, got: %q", want, c.V1.BasePath)
+	}
+	if want := "http://localhost:8081/compute/beta/"; c.Beta.BasePath != want {
+		t.Errorf("Compute Beta BasePath; wanted: %q, got: %q", want, c.Beta.BasePath)
+	}
+}
+
+func TestEndpoints_Local(t *testing.T) {
+	cases := []struct {
+		desc      string
+		endpoints Endpoints
+		want      bool
+	}{
+		{
+			desc: "Default endpoints",
+		},
+		{
+			desc:      "Local endpoints",
+			endpoints: Endpoints{Compute: "http://localhost:8081/", Container: "http://localhost:8081/", ResourceManager: "http://localhost:8081/"},
+			want:      true,
+		},
+		{
+			desc:      "Default Resource Manager endpoint",
+			endpoints: Endpoints{Compute: "http://localhost:8081/", Container: "http://localhost:8081/"},
+		},
+		{
+			desc:      "Only container overridden",
+			endpoints: Endpoints{Container: "http://localhost:8081/"},
+		},
+		{
+			desc:      "HTTPS endpoint",
+			endpoints: Endpoints{Compute: "http://localhost:8081/", Container: "https://test-container.sandbox.googleapis.com/"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := tc.endpoints.Local(); got != tc.want {
+				t.Errorf("Endpoints.Local; wanted: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewClients_RateLimits(t *testing.T) {
+	var requests int
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name": "network"}`))
+	}))
+	defer hs.Close()
+
+	endpoints := Endpoints{Compute: hs.URL, Container: hs.URL + "/", ResourceManager: hs.URL + "/"}
+	clients, err := NewClients(context.Background(), endpoints, http.DefaultClient, ratelimit.Limits{ComputeRead: 1})
+	if err != nil {
+		t.Fatalf("NewClients unexpected error: %v", err)
+	}
+	// The first request is allowed immediately; the second waits longer than the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := clients.Compute.GetNetwork(ctx, test.ProjectName, "network"); err != nil {
+		t.Fatalf("GetNetwork unexpected error: %v", err)
+	}
+	if _, err := clients.Compute.GetNetwork(ctx, test.ProjectName, "network"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetNetwork over rate limit; wanted: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if requests != 1 {
+		t.Errorf("Requests sent; wanted: 1, got: %d", requests)
+	}
+}
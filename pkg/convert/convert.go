@@ -0,0 +1,586 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package convert converts a GCE legacy network to a VPC network and upgrades its GKE clusters.
+//
+// Example:
+//
+//	c, err := convert.New(convert.Options{
+//		ProjectID:           "my-project",
+//		Network:             "my-network",
+//		ControlPlaneVersion: "1.20",
+//		ValidateOnly:        true,
+//	})
+//	if err != nil { ... }
+//	if err := c.Complete(ctx); err != nil { ... }
+//	result, err := c.Run(ctx)
+package convert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/cache"
+	"legacymigration/pkg/clusters"
+	"legacymigration/pkg/migrate"
+	"legacymigration/pkg/networks"
+	"legacymigration/pkg/operations"
+	"legacymigration/pkg/ratelimit"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	ConcurrentNetworks  = 1
+	ConcurrentNodePools = 1
+
+	DefaultConcurrentClusters = 1
+	DefaultPollingInterval    = 15 * time.Second
+	DefaultPollingDeadline    = 24 * time.Hour
+	DefaultMaxPollingInterval = 5 * time.Minute
+
+	// Resource kinds.
+	KindNetwork  = migrate.KindNetwork
+	KindCluster  = migrate.KindCluster
+	KindNodePool = migrate.KindNodePool
+)
+
+// Options configures a Converter.
+type Options struct {
+	ProjectID string
+	Network   string
+	// Clusters restricts conversion to the named clusters; all clusters on the network are converted if empty.
+	Clusters           []string
+	ConcurrentClusters uint16
+	// Locations restricts the clusters listed to those in the zones and regions; clusters are listed in all
+	// locations if empty. Clusters on the network in other locations are not upgraded, so validation of a
+	// legacy network with such clusters fails unless AllowMissingZones is set.
+	Locations []string
+	// AllowMissingZones allows the conversion to proceed even if clusters could not be listed in some zones,
+	// or are on the network in locations other than Locations. Otherwise, validation fails, since those
+	// clusters would not be upgraded.
+	AllowMissingZones bool
+
+	// ControlPlaneVersion and NodeVersion accept GKE versions and version aliases.
+	// See: https://cloud.google.com/kubernetes-engine/versioning#specifying_cluster_version
+	ControlPlaneVersion        string
+	NodeVersion                string
+	InPlaceControlPlaneUpgrade bool
+	// AutoVersion selects, per cluster, the lowest version which is a valid upgrade for its control plane
+	// and node pools. It cannot be combined with ControlPlaneVersion, NodeVersion or InPlaceControlPlaneUpgrade.
+	AutoVersion bool
+
+	// ValidateOnly skips conversion after validation.
+	ValidateOnly bool
+
+	PollingInterval time.Duration
+	PollingDeadline time.Duration
+	// PollingDeadlines set the deadline for each type of operation.
+	// Operations without a deadline use PollingDeadline.
+	PollingDeadlines operations.Deadlines
+	// PollingStrategies select how each type of operation is polled.
+	// Operations without a strategy are polled every PollingInterval.
+	PollingStrategies operations.Strategies
+
+	// StallPeriod is the period without progress after which an operation is reported as stalled.
+	// Stall detection is disabled if zero.
+	StallPeriod time.Duration
+	// FailOnStall fails the resource waiting on an operation once it stalls.
+	FailOnStall bool
+
+	// ConflictRetry configures retries of upgrades which conflict with another operation
+	// in progress (e.g. an auto-upgrade). operations.DefaultConflictRetry is used if unset;
+	// set a Backoff with zero Retries to disable retries.
+	ConflictRetry operations.ConflictRetry
+
+	// MaintenanceExclusion, if set, adds a maintenance exclusion of this duration to each
+	// cluster while it is upgraded to prevent auto-upgrades from starting. It is removed
+	// once the cluster is converted. Must not exceed clusters.MaxMaintenanceExclusion.
+	MaintenanceExclusion time.Duration
+
+	// Approver approves each mutating step; all steps are approved if nil.
+	Approver approval.Approver
+
+	// OnEvent, if set, is called when a resource starts and finishes each phase.
+	OnEvent func(e Event)
+
+	// Hooks are invoked before and after each phase of every resource.
+	// See migrate.Hook for how hook errors are handled.
+	Hooks []migrate.Hook
+
+	// Stop, once closed, stops new phases and operations from being started while those
+	// already running are waited on. Cancel the context passed to Run to stop waiting.
+	Stop <-chan struct{}
+
+	// Clients are the API clients to use. If nil, clients are created
+	// using Application Default Credentials during Complete.
+	Clients *pkg.Clients
+	// Endpoints overrides the API endpoints when creating clients (for testing).
+	Endpoints Endpoints
+	// Credentials select the credentials of the clients created during Complete.
+	Credentials Credentials
+	// RateLimits limit the rate of requests per API sent by the clients created during Complete.
+	// Requests are not limited if zero; see ratelimit.Default.
+	RateLimits ratelimit.Limits
+	// SkipPermissionCheck skips testing that the caller has the permissions required by the run.
+	SkipPermissionCheck bool
+}
+
+// SetDefaults applies defaults to unset options.
+func (o *Options) SetDefaults() {
+	if o.ConcurrentClusters == 0 {
+		o.ConcurrentClusters = DefaultConcurrentClusters
+	}
+	if o.NodeVersion == "" {
+		o.NodeVersion = clusters.DefaultVersion
+	}
+	if o.PollingInterval == 0 {
+		o.PollingInterval = DefaultPollingInterval
+	}
+	if o.PollingDeadline == 0 {
+		o.PollingDeadline = DefaultPollingDeadline
+	}
+	if o.ConflictRetry.Retries == 0 && o.ConflictRetry.Backoff == nil {
+		o.ConflictRetry = operations.DefaultConflictRetry()
+	}
+}
+
+// FieldNames maps Options fields, e.g. "ProjectID" or "PollingDeadlines.PerNode", to the names
+// used for them in validation errors, e.g. the flags which set them. Deadlines for an operation
+// type are named "PollingDeadlines[<type>]". Unmapped fields are named as is.
+type FieldNames map[string]string
+
+func (n FieldNames) name(field string) string {
+	if name, ok := n[field]; ok {
+		return name
+	}
+	return field
+}
+
+// Validate ensures the options are valid for execution.
+func (o *Options) Validate() error {
+	return o.ValidateNames(nil)
+}
+
+// ValidateNames ensures the options are valid for execution, naming fields in errors per names.
+func (o *Options) ValidateNames(names FieldNames) error {
+	n := names.name
+	if o.ProjectID == "" {
+		return fmt.Errorf("%s not provided or empty", n("ProjectID"))
+	}
+	if o.Network == "" {
+		return fmt.Errorf("%s not provided or empty", n("Network"))
+	}
+	if o.ConcurrentClusters < 1 {
+		return fmt.Errorf("%s must be an integer greater than 0", n("ConcurrentClusters"))
+	}
+	for _, l := range o.Locations {
+		if l == "" || l == pkg.AnyLocation {
+			return fmt.Errorf("%s must be zones or regions; got: %q", n("Locations"), l)
+		}
+	}
+	if o.PollingInterval <= 0 || o.PollingDeadline <= 0 {
+		return fmt.Errorf("%s and %s must be positive", n("PollingInterval"), n("PollingDeadline"))
+	}
+	if o.PollingInterval > o.PollingDeadline {
+		return fmt.Errorf("%s=%v must be greater than %s=%v", n("PollingDeadline"), o.PollingDeadline, n("PollingInterval"), o.PollingInterval)
+	}
+	for t, d := range o.PollingDeadlines.ByType {
+		if d < 0 {
+			return fmt.Errorf("%s must not be negative", n(fmt.Sprintf("PollingDeadlines[%s]", t)))
+		}
+	}
+	if o.PollingDeadlines.Default < 0 {
+		return fmt.Errorf("%s must not be negative", n("PollingDeadlines.Default"))
+	}
+	if o.PollingDeadlines.PerNode < 0 {
+		return fmt.Errorf("%s must not be negative", n("PollingDeadlines.PerNode"))
+	}
+	if o.StallPeriod < 0 {
+		return fmt.Errorf("%s must not be negative", n("StallPeriod"))
+	}
+	if o.FailOnStall && o.StallPeriod == 0 {
+		return fmt.Errorf("%s requires a %s", n("FailOnStall"), n("StallPeriod"))
+	}
+	if o.ConflictRetry.Retries < 0 {
+		return fmt.Errorf("%s must not be negative", n("ConflictRetry.Retries"))
+	}
+	if o.MaintenanceExclusion < 0 || o.MaintenanceExclusion > clusters.MaxMaintenanceExclusion {
+		return fmt.Errorf("%s must be between 0 and %v", n("MaintenanceExclusion"), clusters.MaxMaintenanceExclusion)
+	}
+	if err := o.Credentials.Validate(); err != nil {
+		return fmt.Errorf("%s are not valid: %w", n("Credentials"), err)
+	}
+	if err := o.RateLimits.Validate(); err != nil {
+		return fmt.Errorf("%s are not valid: %w", n("RateLimits"), err)
+	}
+
+	if o.AutoVersion {
+		if o.ControlPlaneVersion != "" || (o.NodeVersion != "" && o.NodeVersion != clusters.DefaultVersion) || o.InPlaceControlPlaneUpgrade {
+			return fmt.Errorf("%s cannot be combined with %s, %s or %s",
+				n("AutoVersion"), n("ControlPlaneVersion"), n("NodeVersion"), n("InPlaceControlPlaneUpgrade"))
+		}
+		return nil
+	}
+	if (o.ControlPlaneVersion == "") == !o.InPlaceControlPlaneUpgrade {
+		return fmt.Errorf("specify %s or provide a version for %s, but not both", n("InPlaceControlPlaneUpgrade"), n("ControlPlaneVersion"))
+	}
+	if o.ControlPlaneVersion != "" {
+		if err := clusters.IsFormatValid(o.ControlPlaneVersion); err != nil {
+			return fmt.Errorf("%s=%q is not valid: %w", n("ControlPlaneVersion"), o.ControlPlaneVersion, err)
+		}
+	}
+	if err := clusters.IsFormatValid(o.NodeVersion); err != nil {
+		return fmt.Errorf("%s=%q is not valid: %w", n("NodeVersion"), o.NodeVersion, err)
+	}
+	if !o.InPlaceControlPlaneUpgrade && !isAlias(o.ControlPlaneVersion) && !isAlias(o.NodeVersion) {
+		return clusters.IsWithinVersionSkew(o.NodeVersion, o.ControlPlaneVersion, clusters.MaxVersionSkew)
+	}
+	return nil
+}
+
+// isAlias returns whether the version is an alias which is resolved later per control plane or node pool.
+func isAlias(v string) bool {
+	return v == clusters.DefaultVersion || v == clusters.LatestVersion
+}
+
+// Event describes a resource starting or finishing a phase (Complete, Validate or Migrate).
+type Event struct {
+	ResourcePath string
+	Kind         string
+	Phase        string
+	// Done is false when the phase starts and true once it has finished.
+	Done bool
+	Err  error
+}
+
+// ResourceResult is the outcome of the last phase run for a resource.
+type ResourceResult struct {
+	Path  string
+	Kind  string
+	Phase string
+	Err   error
+}
+
+// Result summarizes a conversion run.
+type Result struct {
+	// Converted is true if the Migrate phase was run.
+	Converted bool
+	// Resources are sorted by path.
+	Resources []ResourceResult
+	// Stalls are the operations which stopped making progress, in the order detected.
+	Stalls []operations.Stall
+	// Stopped is true if Options.Stop was closed during the run.
+	Stopped bool
+	// Abandoned are the operations which were still running when waiting on them stopped,
+	// e.g. because the context was cancelled.
+	Abandoned []operations.InFlight
+	// Retries are statistics of the API requests retried during the run.
+	Retries RetryStats
+}
+
+// Failed returns the results for resources which finished with an error.
+func (r *Result) Failed() []ResourceResult {
+	var failed []ResourceResult
+	for _, res := range r.Resources {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Converter converts a network and its clusters.
+type Converter struct {
+	opts Options
+
+	clients   *pkg.Clients
+	handler   *operations.HandlerImpl
+	migrators []migrate.Migrator
+	cache     *cache.Cache
+	retries   retryRecorder
+
+	mu      sync.Mutex
+	results map[string]*ResourceResult
+	stalls  []operations.Stall
+}
+
+// New returns a Converter for the options, applying defaults to unset options.
+func New(opts Options) (*Converter, error) {
+	opts.SetDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &Converter{
+		opts:    opts,
+		clients: opts.Clients,
+		results: make(map[string]*ResourceResult),
+	}, nil
+}
+
+// Clients returns the API clients used by the Converter.
+func (c *Converter) Clients() *pkg.Clients {
+	return c.clients
+}
+
+// Complete creates the API clients if needed, checks the caller's permissions and finds the selected network.
+func (c *Converter) Complete(ctx context.Context) error {
+	if c.clients == nil {
+		authedClient, err := DefaultClient(ctx, c.opts.Endpoints, c.opts.Credentials)
+		if err != nil {
+			return err
+		}
+		c.clients, err = NewClients(ctx, c.opts.Endpoints, authedClient, c.opts.RateLimits)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx = withRetryRecorder(ctx, &c.retries)
+
+	strategies := c.opts.PollingStrategies
+	if strategies.Default == nil {
+		strategies.Default = operations.Fixed{Interval: c.opts.PollingInterval}
+	}
+	deadlines := c.opts.PollingDeadlines
+	if deadlines.Default == 0 {
+		deadlines.Default = c.opts.PollingDeadline
+	}
+	handler := operations.NewStrategyHandler(strategies, deadlines)
+	c.handler = handler
+	handler.SetStallDetection(operations.StallDetection{
+		Period:  c.opts.StallPeriod,
+		Fail:    c.opts.FailOnStall,
+		OnStall: c.stalled,
+	})
+	options := &clusters.Options{
+		ConcurrentNodePools:        ConcurrentNodePools,
+		DesiredControlPlaneVersion: c.opts.ControlPlaneVersion,
+		DesiredNodeVersion:         c.opts.NodeVersion,
+		InPlaceControlPlaneUpgrade: c.opts.InPlaceControlPlaneUpgrade,
+		AutoVersion:                c.opts.AutoVersion,
+		Approver:                   c.opts.Approver,
+		ClusterNames:               c.opts.Clusters,
+		Locations:                  c.opts.Locations,
+		AllowMissingZones:          c.opts.AllowMissingZones,
+		MaintenanceExclusion:       c.opts.MaintenanceExclusion,
+	}
+
+	// Responses read repeatedly by the migrators are cached for the run.
+	c.cache = cache.New()
+	clients := c.cache.Wrap(c.clients)
+	factory := func(n *compute.Network) migrate.Migrator {
+		return networks.New(c.opts.ProjectID, n, handler, clients, c.opts.ConcurrentClusters, c.opts.Approver, options)
+	}
+
+	if c.clients.ResourceManager != nil && !c.opts.SkipPermissionCheck {
+		if err := CheckPermissions(ctx, c.clients.ResourceManager, c.opts.ProjectID, validatePermissions); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Fetching network %s for project %q", c.opts.Network, c.opts.ProjectID)
+
+	ns, err := c.clients.Compute.ListNetworks(ctx, c.opts.ProjectID)
+	if err != nil {
+		return fmt.Errorf("error listing networks: %w", err)
+	}
+
+	c.migrators = make([]migrate.Migrator, 0)
+	for _, n := range ns {
+		if n.Name == c.opts.Network {
+			c.migrators = append(c.migrators, factory(n))
+		}
+	}
+
+	if len(c.migrators) == 0 {
+		return fmt.Errorf("unable to find network %s", c.opts.Network)
+	}
+
+	return nil
+}
+
+// Run cascades down the resource hierarchy, initializing, validating, and converting all descendent migrators.
+// The Result is returned even if an error occurs.
+func (c *Converter) Run(ctx context.Context) (*Result, error) {
+	ctx = migrate.WithProgress(ctx, c.progress)
+	ctx = withRetryRecorder(ctx, &c.retries)
+	ctx = operations.WithConflictRetry(ctx, c.opts.ConflictRetry)
+	defer c.logCacheStats()
+	if len(c.opts.Hooks) > 0 {
+		ctx = migrate.WithHooks(ctx, c.opts.Hooks...)
+	}
+	if c.opts.Stop != nil {
+		ctx = migrate.WithStop(ctx, c.opts.Stop)
+		done := make(chan struct{})
+		defer close(done)
+		go c.watchStop(done)
+	}
+	sem := make(chan struct{}, ConcurrentNetworks)
+	result := &Result{}
+
+	log.Info("Initialize objects for conversion.")
+	if err := migrate.Complete(ctx, sem, c.migrators...); err != nil {
+		return c.result(result), err
+	}
+
+	log.Info("Validate resources for conversion.")
+	if err := migrate.Validate(ctx, sem, c.migrators...); err != nil {
+		return c.result(result), err
+	}
+	if err := c.checkPermissions(ctx); err != nil {
+		return c.result(result), err
+	}
+
+	if c.opts.ValidateOnly {
+		log.Info("ValidateOnly is set; skipping conversion.")
+		return c.result(result), nil
+	}
+
+	log.Info("Initiate resource conversion.")
+	result.Converted = true
+	err := migrate.Migrate(ctx, sem, c.migrators...)
+	return c.result(result), err
+}
+
+// logCacheStats logs how many API responses were read from the cache.
+func (c *Converter) logCacheStats() {
+	if c.cache == nil {
+		return
+	}
+	s := c.cache.Stats()
+	log.Infof("Read %d cacheable API responses from the cache and %d from the APIs.", s.Hits, s.Misses)
+}
+
+// checkPermissions tests that the caller has the permissions required by the planned conversion,
+// e.g. to switch the network only if it is a legacy network and to upgrade only the clusters and
+// NodePools which require it. Validate-only runs are checked too, so that they fail as the conversion would.
+func (c *Converter) checkPermissions(ctx context.Context) error {
+	if c.clients == nil || c.clients.ResourceManager == nil || c.opts.SkipPermissionCheck {
+		return nil
+	}
+	permissions := migrate.Permissions(c.migrators...)
+	if len(permissions) == 0 {
+		log.Info("The conversion requires no further permissions.")
+		return nil
+	}
+	log.Infof("The conversion requires permissions: %s", strings.Join(permissions, ", "))
+	if err := CheckPermissions(ctx, c.clients.ResourceManager, c.opts.ProjectID, permissions); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+	return nil
+}
+
+// watchStop logs the operations in flight once Options.Stop is closed, until done is closed.
+func (c *Converter) watchStop(done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-c.opts.Stop:
+	}
+	inFlight := c.InFlight()
+	log.Infof("Stopping; no new operations will be started. Waiting on %d operation(s) in flight.", len(inFlight))
+	for _, f := range inFlight {
+		log.Infof("In flight: %s", f)
+	}
+}
+
+// InFlight returns the operations currently being waited on.
+func (c *Converter) InFlight() []operations.InFlight {
+	if c.handler == nil {
+		return nil
+	}
+	return c.handler.InFlight()
+}
+
+// Abandoned returns the operations which were still running when waiting on them stopped.
+func (c *Converter) Abandoned() []operations.InFlight {
+	if c.handler == nil {
+		return nil
+	}
+	return c.handler.Abandoned()
+}
+
+// CancelOperations cancels the operations still in flight when the run was aborted which may be
+// cancelled (i.e. GKE control plane and node pool upgrades). Operations abandoned after they stalled
+// or exceeded their deadline are left running. Returns the operations which were cancelled.
+func (c *Converter) CancelOperations(ctx context.Context) ([]operations.InFlight, error) {
+	if c.handler == nil {
+		return nil, nil
+	}
+	return c.handler.CancelInFlight(ctx)
+}
+
+// progress records per-resource results and forwards Events to the OnEvent callback.
+func (c *Converter) progress(p migrate.Progress) {
+	e := Event{
+		ResourcePath: p.ResourcePath,
+		Kind:         p.Kind,
+		Phase:        p.Method.String(),
+		Done:         p.Done,
+		Err:          p.Err,
+	}
+	if e.Done {
+		c.mu.Lock()
+		c.results[e.ResourcePath] = &ResourceResult{
+			Path:  e.ResourcePath,
+			Kind:  e.Kind,
+			Phase: e.Phase,
+			Err:   e.Err,
+		}
+		c.mu.Unlock()
+	}
+	if c.opts.OnEvent != nil {
+		c.opts.OnEvent(e)
+	}
+}
+
+// stalled records operations which have stalled.
+func (c *Converter) stalled(s operations.Stall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stalls = append(c.stalls, s)
+}
+
+func (c *Converter) result(r *Result) *Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.Stalls = append([]operations.Stall(nil), c.stalls...)
+	r.Abandoned = c.Abandoned()
+	r.Retries = c.retries.snapshot()
+	if c.opts.Stop != nil {
+		select {
+		case <-c.opts.Stop:
+			r.Stopped = true
+		default:
+		}
+	}
+	r.Resources = make([]ResourceResult, 0, len(c.results))
+	for _, res := range c.results {
+		r.Resources = append(r.Resources, *res)
+	}
+	sort.Slice(r.Resources, func(i, j int) bool {
+		return r.Resources[i].Path < r.Resources[j].Path
+	})
+	return r
+}
@@ -0,0 +1,1038 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/clusters"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/faults"
+	"legacymigration/pkg/migrate"
+	"legacymigration/pkg/operations"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	cases := []struct {
+		desc    string
+		opts    Options
+		wantErr string
+	}{
+		{
+			desc: "Valid",
+			opts: defaultOptions(nil),
+		},
+		{
+			desc: "In-place upgrade",
+			opts: func(o Options) Options {
+				o.ControlPlaneVersion = ""
+				o.InPlaceControlPlaneUpgrade = true
+				return o
+			}(defaultOptions(nil)),
+		},
+		{
+			desc: "Auto version",
+			opts: func(o Options) Options {
+				o.ControlPlaneVersion = ""
+				o.AutoVersion = true
+				return o
+			}(defaultOptions(nil)),
+		},
+		{
+			desc: "Auto version with control plane version",
+			opts: func(o Options) Options {
+				o.AutoVersion = true
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "AutoVersion cannot be combined with ControlPlaneVersion, NodeVersion or InPlaceControlPlaneUpgrade",
+		},
+		{
+			desc: "Empty project",
+			opts: func(o Options) Options {
+				o.ProjectID = ""
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "ProjectID not provided or empty",
+		},
+		{
+			desc: "Empty network",
+			opts: func(o Options) Options {
+				o.Network = ""
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "Network not provided or empty",
+		},
+		{
+			desc: "No control plane version",
+			opts: func(o Options) Options {
+				o.ControlPlaneVersion = ""
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "specify InPlaceControlPlaneUpgrade or provide a version for ControlPlaneVersion, but not both",
+		},
+		{
+			desc: "Invalid node version",
+			opts: func(o Options) Options {
+				o.NodeVersion = "1.x"
+				return o
+			}(defaultOptions(nil)),
+			wantErr: `NodeVersion="1.x" is not valid`,
+		},
+		{
+			desc: "Version skew",
+			opts: func(o Options) Options {
+				o.ControlPlaneVersion = "1.21"
+				o.NodeVersion = "1.17"
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "must be no less than",
+		},
+		{
+			desc: "Polling interval greater than deadline",
+			opts: func(o Options) Options {
+				o.PollingInterval = time.Hour
+				o.PollingDeadline = time.Minute
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "PollingDeadline=1m0s must be greater than PollingInterval=1h0m0s",
+		},
+		{
+			desc: "Negative operation type deadline",
+			opts: func(o Options) Options {
+				o.PollingDeadlines.ByType = map[operations.OperationType]time.Duration{operations.TypeNodePool: -time.Minute}
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "PollingDeadlines[node-pool] must not be negative",
+		},
+		{
+			desc: "Fail on stall without period",
+			opts: func(o Options) Options {
+				o.FailOnStall = true
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "FailOnStall requires a StallPeriod",
+		},
+		{
+			desc: "Negative conflict retries",
+			opts: func(o Options) Options {
+				o.ConflictRetry.Retries = -1
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "ConflictRetry.Retries must not be negative",
+		},
+		{
+			desc: "Negative maintenance exclusion",
+			opts: func(o Options) Options {
+				o.MaintenanceExclusion = -time.Hour
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "MaintenanceExclusion must be between 0 and 720h0m0s",
+		},
+		{
+			desc: "Empty location",
+			opts: func(o Options) Options {
+				o.Locations = []string{""}
+				return o
+			}(defaultOptions(nil)),
+			wantErr: `Locations must be zones or regions; got: ""`,
+		},
+		{
+			desc: "Credentials file and access token",
+			opts: func(o Options) Options {
+				o.Credentials = Credentials{File: "key.json", AccessTokenEnv: "TOKEN"}
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "Credentials are not valid: a credentials file and an access token cannot both be used",
+		},
+		{
+			desc: "Negative rate limit",
+			opts: func(o Options) Options {
+				o.RateLimits.ContainerRead = -1
+				return o
+			}(defaultOptions(nil)),
+			wantErr: "RateLimits are not valid: container read rate limit must be a non-negative number",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := tc.opts.Validate()
+			if diff := test.ErrorDiff(tc.wantErr, got); diff != "" {
+				t.Errorf("Options.Validate diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	c, err := New(Options{
+		ProjectID:                  test.ProjectName,
+		Network:                    test.SelectedNetwork,
+		InPlaceControlPlaneUpgrade: true,
+	})
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	if c.opts.ConcurrentClusters != DefaultConcurrentClusters ||
+		c.opts.NodeVersion != clusters.DefaultVersion ||
+		c.opts.PollingInterval != DefaultPollingInterval ||
+		c.opts.PollingDeadline != DefaultPollingDeadline ||
+		c.opts.ConflictRetry.Retries != operations.DefaultConflictRetries {
+		t.Errorf("New did not apply defaults: %+v", c.opts)
+	}
+}
+
+func TestConverter_Complete(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		desc    string
+		opts    Options
+		wantErr string
+	}{
+		{
+			desc: "Success",
+			opts: defaultOptions(testClients(t, defaultState())),
+		},
+		{
+			desc: "Network miss",
+			opts: func(o Options) Options {
+				o.Network = "miss"
+				return o
+			}(defaultOptions(testClients(t, defaultState()))),
+			wantErr: "unable to find network",
+		},
+		{
+			desc:    "ListNetworks error",
+			opts:    defaultOptions(testClients(t, defaultState(), faults.Fault{Kind: faults.Error, Method: "ListNetworks", Message: "ListNetworks error"})),
+			wantErr: "error listing networks: googleapi: Error 503: ListNetworks error",
+		},
+		{
+			desc: "Missing permissions",
+			opts: func(s *fakeapi.State) Options {
+				s.DeniedPermissions = []string{"compute.networks.list"}
+				return defaultOptions(testClients(t, s))
+			}(defaultState()),
+			wantErr: "missing permissions on project test-project: compute.networks.list",
+		},
+		{
+			desc: "Skip permission check",
+			opts: func(s *fakeapi.State) Options {
+				s.DeniedPermissions = []string{"compute.networks.list"}
+				o := defaultOptions(testClients(t, s))
+				o.SkipPermissionCheck = true
+				return o
+			}(defaultState()),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c, err := New(tc.opts)
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			got := c.Complete(ctx)
+			if diff := test.ErrorDiff(tc.wantErr, got); diff != "" {
+				t.Errorf("Converter.Complete diff (-want +got):\n%s", diff)
+			}
+			if c.Clients() != tc.opts.Clients {
+				t.Errorf("Converter.Clients did not return the provided clients")
+			}
+			if tc.wantErr == "" && len(c.migrators) != 1 {
+				t.Errorf("Converter.Complete migrators; wanted: 1, got: %d", len(c.migrators))
+			}
+		})
+	}
+}
+
+func TestConverter_Run(t *testing.T) {
+	cases := []struct {
+		desc          string
+		validateOnly  bool
+		migrators     []migrate.Migrator
+		want          *Result
+		wantErr       string
+		wantLog       string
+		wantEventsLen int
+	}{
+		{
+			desc:    "Empty migrator list",
+			want:    &Result{Converted: true, Resources: []ResourceResult{}},
+			wantLog: "Initiate resource conversion",
+		},
+		{
+			desc:          "Skip conversions",
+			validateOnly:  true,
+			migrators:     []migrate.Migrator{&migrate.FakeMigrator{}},
+			want:          &Result{Resources: []ResourceResult{{Path: "resource-path", Phase: "Validate"}}},
+			wantLog:       "skipping conversion",
+			wantEventsLen: 4,
+		},
+		{
+			desc:          "Single conversion",
+			migrators:     []migrate.Migrator{&migrate.FakeMigrator{}},
+			want:          &Result{Converted: true, Resources: []ResourceResult{{Path: "resource-path", Phase: "Migrate"}}},
+			wantLog:       "Initiate resource conversion.",
+			wantEventsLen: 6,
+		},
+		{
+			desc: "Validation error",
+			migrators: []migrate.Migrator{
+				&migrate.FakeMigrator{ValidateError: errors.New("validate error")},
+			},
+			want:          &Result{Resources: []ResourceResult{{Path: "resource-path", Phase: "Validate", Err: errors.New("validate error")}}},
+			wantErr:       "validate error",
+			wantEventsLen: 4,
+		},
+		{
+			desc: "Conversion error",
+			migrators: []migrate.Migrator{
+				&migrate.FakeMigrator{MigrateError: errors.New("migrate error")},
+			},
+			want:          &Result{Converted: true, Resources: []ResourceResult{{Path: "resource-path", Phase: "Migrate", Err: errors.New("migrate error")}}},
+			wantLog:       "Initiate resource conversion",
+			wantErr:       "migrate error",
+			wantEventsLen: 6,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			log.StandardLogger().SetOutput(buf)
+
+			var events []Event
+			opts := defaultOptions(testClients(t, defaultState()))
+			opts.ValidateOnly = tc.validateOnly
+			opts.OnEvent = func(e Event) { events = append(events, e) }
+			c, err := New(opts)
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			c.migrators = tc.migrators
+
+			got, err := c.Run(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Converter.Run error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.Comparer(errorsEqual)); diff != "" {
+				t.Errorf("Converter.Run result diff (-want +got):\n%s", diff)
+			}
+			if len(events) != tc.wantEventsLen {
+				t.Errorf("Converter.Run events; wanted: %d, got: %d (%+v)", tc.wantEventsLen, len(events), events)
+			}
+			if !strings.Contains(buf.String(), tc.wantLog) {
+				t.Errorf("Converter.Run missing log output:\n\twanted entry: %s\n\tgot entries: %s", tc.wantLog, buf.String())
+			}
+		})
+	}
+}
+
+func TestConverter_RunPermissions(t *testing.T) {
+	migrators := func() []migrate.Migrator {
+		return []migrate.Migrator{
+			&migrate.FakeMigrator{RequiredPermissions: []string{"compute.networks.switchToCustomMode"}},
+			&migrate.FakeMigrator{RequiredPermissions: []string{"container.clusters.update"}},
+		}
+	}
+	cases := []struct {
+		desc          string
+		validateOnly  bool
+		skip          bool
+		migrators     []migrate.Migrator
+		denied        []string
+		wantErr       string
+		wantConverted bool
+		wantTested    []string
+	}{
+		{
+			desc:          "Granted",
+			migrators:     migrators(),
+			wantConverted: true,
+			wantTested:    []string{"compute.networks.switchToCustomMode", "container.clusters.update"},
+		},
+		{
+			desc:       "Missing",
+			migrators:  migrators(),
+			denied:     []string{"container.clusters.update"},
+			wantErr:    "validation error: missing permissions on project test-project: container.clusters.update",
+			wantTested: []string{"compute.networks.switchToCustomMode", "container.clusters.update"},
+		},
+		{
+			desc:         "Missing for validate-only run",
+			validateOnly: true,
+			migrators:    migrators(),
+			denied:       []string{"compute.networks.switchToCustomMode"},
+			wantErr:      "missing permissions on project test-project: compute.networks.switchToCustomMode",
+			wantTested:   []string{"compute.networks.switchToCustomMode", "container.clusters.update"},
+		},
+		{
+			desc:          "Skipped",
+			skip:          true,
+			migrators:     migrators(),
+			denied:        []string{"container.clusters.update"},
+			wantConverted: true,
+		},
+		{
+			desc:          "None required",
+			migrators:     []migrate.Migrator{&migrate.FakeMigrator{}},
+			denied:        []string{"container.clusters.update"},
+			wantConverted: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := defaultState()
+			s.DeniedPermissions = tc.denied
+			opts := defaultOptions(testClients(t, s))
+			rm := &permissionRecorder{ResourceManagerService: opts.Clients.ResourceManager}
+			opts.Clients.ResourceManager = rm
+			opts.ValidateOnly = tc.validateOnly
+			opts.SkipPermissionCheck = tc.skip
+			c, err := New(opts)
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			c.migrators = tc.migrators
+
+			got, err := c.Run(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Converter.Run error diff (-want +got):\n%s", diff)
+			}
+			if got.Converted != tc.wantConverted {
+				t.Errorf("Converter.Run converted; wanted: %t, got: %t", tc.wantConverted, got.Converted)
+			}
+			if diff := cmp.Diff(tc.wantTested, rm.tested); diff != "" {
+				t.Errorf("Tested permissions diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConverter_RunEvents(t *testing.T) {
+	ctx := context.Background()
+	var events []Event
+	s := defaultState()
+	s.Clusters = nil
+	opts := defaultOptions(testClients(t, s))
+	opts.PollingInterval = time.Millisecond
+	opts.OnEvent = func(e Event) {
+		if e.Done && e.Phase == "Migrate" {
+			events = append(events, e)
+		}
+	}
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	if err := c.Complete(ctx); err != nil {
+		t.Fatalf("Converter.Complete unexpected error: %v", err)
+	}
+
+	got, err := c.Run(ctx)
+	if err != nil {
+		t.Fatalf("Converter.Run unexpected error: %v", err)
+	}
+
+	path := pkg.NetworkPath(test.ProjectName, test.SelectedNetwork)
+	wantEvents := []Event{{ResourcePath: path, Kind: KindNetwork, Phase: "Migrate", Done: true}}
+	if diff := cmp.Diff(wantEvents, events, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Converter.Run events diff (-want +got):\n%s", diff)
+	}
+	want := &Result{
+		Converted: true,
+		Resources: []ResourceResult{{Path: path, Kind: KindNetwork, Phase: "Migrate"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Converter.Run result diff (-want +got):\n%s", diff)
+	}
+	if len(got.Failed()) != 0 {
+		t.Errorf("Result.Failed; wanted none, got: %+v", got.Failed())
+	}
+}
+
+func TestConverter_RunHooks(t *testing.T) {
+	var got []migrate.EventType
+	opts := defaultOptions(testClients(t, defaultState()))
+	opts.ValidateOnly = true
+	opts.Hooks = []migrate.Hook{migrate.HookFunc(func(_ context.Context, e migrate.Event) error {
+		got = append(got, e.Type)
+		if e.Type == migrate.BeforeValidate {
+			return errors.New("change freeze")
+		}
+		return nil
+	})}
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	c.migrators = []migrate.Migrator{&migrate.FakeMigrator{}}
+
+	_, err = c.Run(context.Background())
+	if diff := test.ErrorDiff("BeforeValidate hook for resource-path: change freeze", err); diff != "" {
+		t.Errorf("Converter.Run error diff (-want +got):\n%s", diff)
+	}
+	want := []migrate.EventType{migrate.BeforeComplete, migrate.AfterComplete, migrate.BeforeValidate}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Converter.Run hook events diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConverter_RunStalls(t *testing.T) {
+	c, err := New(defaultOptions(testClients(t, defaultState())))
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	stall := operations.Stall{Operation: "op", Type: operations.TypeNodePool, Duration: time.Hour, Reason: "drain blocked"}
+	c.stalled(stall)
+
+	got, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Converter.Run unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]operations.Stall{stall}, got.Stalls); diff != "" {
+		t.Errorf("Result.Stalls diff (-want +got):\n%s", diff)
+	}
+}
+
+// migrateFunc is a FakeMigrator which calls f when migrated.
+type migrateFunc struct {
+	migrate.FakeMigrator
+	f func(ctx context.Context) error
+}
+
+func (m *migrateFunc) Migrate(ctx context.Context) error {
+	return m.f(ctx)
+}
+
+func TestConverter_RunStop(t *testing.T) {
+	stop := make(chan struct{})
+	opts := defaultOptions(testClients(t, defaultState()))
+	opts.Stop = stop
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	var migrated bool
+	c.migrators = []migrate.Migrator{
+		&migrateFunc{f: func(_ context.Context) error {
+			close(stop)
+			return nil
+		}},
+		&migrateFunc{f: func(_ context.Context) error {
+			migrated = true
+			return nil
+		}},
+	}
+
+	got, err := c.Run(context.Background())
+	if !errors.Is(err, migrate.ErrStopped) {
+		t.Errorf("Converter.Run; wanted: ErrStopped, got: %v", err)
+	}
+	if migrated {
+		t.Errorf("Converter.Run started a migrator after stop")
+	}
+	if !got.Stopped {
+		t.Errorf("Result.Stopped; wanted: true, got: false")
+	}
+}
+
+func TestConverter_CancelOperations(t *testing.T) {
+	s := defaultState()
+	s.Operations = []*container.Operation{
+		{Name: "operation-expired", Status: "RUNNING", Location: test.RegionA},
+		{Name: "operation-aborted", Status: "RUNNING", Location: test.RegionA},
+	}
+	s.Script.Polls = 1000
+	opts := defaultOptions(testClients(t, s))
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	c.handler = operations.NewHandler(time.Millisecond, 20*time.Millisecond)
+	expired := &clusters.ContainerOperation{Path: pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-expired"), OperationType: operations.TypeNodePool, Client: opts.Clients.Container, Cancelable: true}
+	aborted := &clusters.ContainerOperation{Path: pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-aborted"), OperationType: operations.TypeNodePool, Client: opts.Clients.Container, Cancelable: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.migrators = []migrate.Migrator{
+		&migrateFunc{f: func(ctx context.Context) error {
+			if err := c.handler.Wait(ctx, expired); err == nil {
+				return errors.New("wanted deadline error")
+			}
+			cancel()
+			return c.handler.Wait(ctx, aborted)
+		}},
+	}
+
+	got, err := c.Run(ctx)
+	if diff := test.ErrorDiff("context canceled", err); diff != "" {
+		t.Errorf("Converter.Run error diff (-want +got):\n%s", diff)
+	}
+	ignore := cmpopts.IgnoreFields(operations.InFlight{}, "Started")
+	want := []operations.InFlight{
+		{Operation: expired.Path, Type: operations.TypeNodePool},
+		{Operation: aborted.Path, Type: operations.TypeNodePool},
+	}
+	if diff := cmp.Diff(want, got.Abandoned, ignore, cmpopts.IgnoreUnexported(operations.InFlight{})); diff != "" {
+		t.Errorf("Result.Abandoned diff (-want +got):\n%s", diff)
+	}
+
+	// Only the operation still in flight when the run was aborted is cancelled.
+	cancelled, err := c.CancelOperations(context.Background())
+	if err != nil {
+		t.Fatalf("Converter.CancelOperations unexpected error: %v", err)
+	}
+	wantCancelled := []operations.InFlight{{Operation: aborted.Path, Type: operations.TypeNodePool, Cancelled: true}}
+	if diff := cmp.Diff(wantCancelled, cancelled, ignore, cmpopts.IgnoreUnexported(operations.InFlight{})); diff != "" {
+		t.Errorf("Converter.CancelOperations diff (-want +got):\n%s", diff)
+	}
+	for _, tc := range []struct {
+		op   *clusters.ContainerOperation
+		want string
+	}{
+		{op: expired},
+		{op: aborted, want: "Operation was cancelled."},
+	} {
+		current, err := opts.Clients.Container.GetOperation(context.Background(), tc.op.Path)
+		if err != nil {
+			t.Fatalf("GetOperation unexpected error: %v", err)
+		}
+		if current.StatusMessage != tc.want {
+			t.Errorf("Operation %s status message; wanted: %q, got: %q", tc.op.Path, tc.want, current.StatusMessage)
+		}
+	}
+}
+
+func TestConverter_RunScenario(t *testing.T) {
+	autoUpgrade := &container.Operation{
+		Name:          "operation-1-auto",
+		OperationType: "UPGRADE_NODES",
+		Status:        "RUNNING",
+		Location:      "us-central1-a",
+		TargetLink:    "https://container.googleapis.com/v1/projects/test-project/locations/us-central1-a/clusters/zonal/nodePools/pool-a",
+		SelfLink:      "https://container.googleapis.com/v1/projects/test-project/locations/us-central1-a/operations/operation-1-auto",
+	}
+	cases := []struct {
+		desc         string
+		operations   []*container.Operation
+		failures     []fakeapi.Failure
+		wantErr      string
+		wantUpgraded map[string]bool
+	}{
+		{
+			desc:       "Multiple clusters and node pools",
+			operations: []*container.Operation{autoUpgrade},
+			wantUpgraded: map[string]bool{
+				"zonal/pool-a": true, "zonal/pool-b": true, "regional/pool-a": true,
+			},
+		},
+		{
+			desc: "Node pool upgrade failure",
+			failures: []fakeapi.Failure{
+				{OperationType: "UPGRADE_NODES", Target: "clusters/zonal/nodePools/pool-b", Message: "node pool upgrade failed"},
+			},
+			wantErr: "node pool upgrade failed",
+			wantUpgraded: map[string]bool{
+				"zonal/pool-a": true, "zonal/pool-b": false, "regional/pool-a": true,
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			sc := testScenario()
+			sc.Operations = tc.operations
+			sc.Script.Failures = tc.failures
+			srv, err := fakeapi.New(sc.State())
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			c, err := New(scenarioOptions(sc, srv.Clients()))
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			ctx := context.Background()
+			if err := c.Complete(ctx); err != nil {
+				t.Fatalf("Converter.Complete unexpected error: %v", err)
+			}
+
+			_, err = c.Run(ctx)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Converter.Run error diff (-want +got):\n%s", diff)
+			}
+
+			state, err := srv.State()
+			if err != nil {
+				t.Fatalf("State unexpected error: %v", err)
+			}
+			if n := state.Networks[0]; n.IPv4Range != "" || len(n.Subnetworks) != 2 {
+				t.Errorf("Network not converted: %+v", n)
+			}
+			upgraded := make(map[string]bool)
+			for _, cluster := range state.Clusters {
+				if cluster.CurrentMasterVersion != "1.19.11-gke.1700" || cluster.Subnetwork == "" {
+					t.Errorf("Cluster %s not upgraded on a subnetwork: %s, %q", cluster.Name, cluster.CurrentMasterVersion, cluster.Subnetwork)
+				}
+				for _, np := range cluster.NodePools {
+					upgraded[cluster.Name+"/"+np.Name] = np.Version == "1.19.11-gke.1700"
+				}
+			}
+			if diff := cmp.Diff(tc.wantUpgraded, upgraded); diff != "" {
+				t.Errorf("Upgraded node pools diff (-want +got):\n%s", diff)
+			}
+			for _, it := range state.InstanceTemplates {
+				np := strings.TrimSuffix(strings.TrimPrefix(it.Name, "gke-"), "-tmpl")
+				np = strings.Replace(np, "-pool", "/pool", 1)
+				if got := it.Properties.NetworkInterfaces[0].Subnetwork != ""; got != tc.wantUpgraded[np] {
+					t.Errorf("Instance template %s on a subnetwork; wanted: %t, got: %t", it.Name, tc.wantUpgraded[np], got)
+				}
+			}
+		})
+	}
+}
+
+// TestConverter_RunScenarioPermissions tests the permissions required by the plan for a Scenario.
+func TestConverter_RunScenarioPermissions(t *testing.T) {
+	upgrade := []string{
+		"compute.instanceGroupManagers.get",
+		"compute.instanceTemplates.get",
+		"container.clusters.get",
+		"container.clusters.update",
+		"container.operations.get",
+		"container.operations.list",
+	}
+	cases := []struct {
+		desc   string
+		state  func(s *fakeapi.State)
+		denied []string
+		want   []string
+	}{
+		{
+			desc: "Legacy network",
+			want: []string{
+				"compute.globalOperations.get",
+				"compute.instanceGroupManagers.get",
+				"compute.instanceTemplates.get",
+				"compute.networks.get",
+				"compute.networks.switchToCustomMode",
+				"container.clusters.get",
+				"container.clusters.update",
+				"container.operations.get",
+				"container.operations.list",
+			},
+		},
+		{
+			desc: "Converted network",
+			state: func(s *fakeapi.State) {
+				s.Networks[0].IPv4Range = ""
+			},
+			denied: []string{"compute.networks.switchToCustomMode"},
+			want:   upgrade,
+		},
+		{
+			desc: "Upgraded clusters",
+			state: func(s *fakeapi.State) {
+				s.Networks[0].IPv4Range = ""
+				for _, c := range s.Clusters {
+					c.Subnetwork = "subnetwork"
+				}
+				for _, it := range s.InstanceTemplates {
+					it.Properties.NetworkInterfaces[0].Subnetwork = "subnetwork"
+				}
+			},
+			// A validate-only run of a converted network requires no permission used only by the conversion.
+			denied: []string{
+				"compute.networks.get",
+				"compute.networks.switchToCustomMode",
+				"container.clusters.update",
+				"container.operations.get",
+				"container.operations.list",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			sc := testScenario()
+			state := sc.State()
+			if tc.state != nil {
+				tc.state(state)
+			}
+			state.DeniedPermissions = tc.denied
+			srv, err := fakeapi.New(state)
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			opts := scenarioOptions(sc, srv.Clients())
+			opts.ValidateOnly = true
+			c, err := New(opts)
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			ctx := context.Background()
+			if err := c.Complete(ctx); err != nil {
+				t.Fatalf("Converter.Complete unexpected error: %v", err)
+			}
+			if _, err := c.Run(ctx); err != nil {
+				t.Fatalf("Converter.Run unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, migrate.Permissions(c.migrators...)); diff != "" {
+				t.Errorf("Permissions diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConverter_RunScenarioCache(t *testing.T) {
+	for _, validateOnly := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ValidateOnly=%t", validateOnly), func(t *testing.T) {
+			sc := testScenario()
+			sc.Clusters = append(sc.Clusters, fakeapi.ScenarioCluster{
+				Name:      "zonal-2",
+				Location:  "us-central1-a",
+				Version:   "1.19.10-gke.1700",
+				NodePools: []fakeapi.ScenarioNodePool{{Name: "pool-a"}},
+			})
+			srv, err := fakeapi.New(sc.State())
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			opts := scenarioOptions(sc, srv.Clients())
+			opts.ValidateOnly = validateOnly
+			c, err := New(opts)
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			ctx := context.Background()
+			if err := c.Complete(ctx); err != nil {
+				t.Fatalf("Converter.Complete unexpected error: %v", err)
+			}
+			if _, err := c.Run(ctx); err != nil {
+				t.Fatalf("Converter.Run unexpected error: %v", err)
+			}
+
+			// The zonal clusters share the ServerConfig of their location.
+			if s := c.cache.Stats(); s.Hits == 0 {
+				t.Errorf("Converter.Run; wanted responses read from the cache, got: %+v", s)
+			}
+		})
+	}
+}
+
+// testScenario returns a network with a zonal cluster of two node pools and a regional cluster.
+func testScenario() *fakeapi.Scenario {
+	return &fakeapi.Scenario{
+		ProjectID: "test-project",
+		Network:   "legacy-network",
+		Clusters: []fakeapi.ScenarioCluster{
+			{
+				Name:     "zonal",
+				Location: "us-central1-a",
+				Version:  "1.19.10-gke.1700",
+				NodePools: []fakeapi.ScenarioNodePool{
+					{Name: "pool-a"},
+					{Name: "pool-b", Version: "1.18.20-gke.900"},
+				},
+			},
+			{
+				Name:      "regional",
+				Location:  "us-east1",
+				Version:   "1.19.10-gke.1700",
+				NodePools: []fakeapi.ScenarioNodePool{{Name: "pool-a"}},
+			},
+		},
+		ServerConfig: &container.ServerConfig{
+			DefaultClusterVersion: "1.19.10-gke.1700",
+			ValidMasterVersions:   []string{"1.19.11-gke.1700", "1.19.10-gke.1700"},
+			ValidNodeVersions:     []string{"1.19.11-gke.1700", "1.19.10-gke.1700", "1.18.20-gke.900"},
+		},
+		Script: fakeapi.Script{Polls: 2},
+	}
+}
+
+// scenarioOptions returns Options to convert the Scenario using the clients, polling without delay.
+func scenarioOptions(sc *fakeapi.Scenario, clients *pkg.Clients) Options {
+	return Options{
+		ProjectID:           sc.ProjectID,
+		Network:             sc.Network,
+		ControlPlaneVersion: "1.19.11-gke.1700",
+		NodeVersion:         "1.19.11-gke.1700",
+		ConcurrentClusters:  2,
+		PollingInterval:     time.Millisecond,
+		PollingDeadline:     time.Minute,
+		ConflictRetry:       operations.ConflictRetry{Retries: 1, Backoff: operations.Fixed{Interval: time.Millisecond}},
+		Clients:             clients,
+	}
+}
+
+func TestConverter_RunFaults(t *testing.T) {
+	cases := []struct {
+		desc       string
+		operations []*container.Operation
+		faults     []faults.Fault
+		wantErr    string
+	}{
+		{
+			desc: "Latency and a transient conflict",
+			faults: []faults.Fault{
+				{Kind: faults.Latency, Latency: faults.Duration(time.Millisecond)},
+				{Kind: faults.Conflict, Method: "UpdateMaster", Count: 1},
+			},
+		},
+		{
+			desc: "Conflict with a reported operation",
+			operations: []*container.Operation{
+				{
+					Name:       "operation-1-auto",
+					Status:     "RUNNING",
+					Location:   "us-east1",
+					TargetLink: "https://container.googleapis.com/v1/projects/test-project/locations/us-east1/clusters/other",
+					SelfLink:   "https://container.googleapis.com/v1/projects/test-project/locations/us-east1/operations/operation-1-auto",
+				},
+			},
+			faults: []faults.Fault{
+				{Kind: faults.Conflict, Method: "UpdateNodePool", Target: "clusters/regional", Operation: "operation-1-auto", Count: 1},
+			},
+		},
+		{
+			desc: "Rate limited",
+			faults: []faults.Fault{
+				{Kind: faults.Error, Method: "UpdateNodePool", Target: "clusters/regional", Code: 429},
+			},
+			wantErr: "injected error: Too Many Requests",
+		},
+		{
+			desc: "Operation ends in error",
+			faults: []faults.Fault{
+				{Kind: faults.OperationError, Method: "GetOperation", Target: "nodePools/pool-b", Message: "node pool upgrade failed"},
+			},
+			wantErr: "node pool upgrade failed",
+		},
+		{
+			desc: "Canceled during node pool upgrades",
+			faults: []faults.Fault{
+				{Kind: faults.Cancel, Method: "UpdateNodePool"},
+			},
+			wantErr: "context canceled",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			sc := testScenario()
+			sc.Operations = tc.operations
+			srv, err := fakeapi.New(sc.State())
+			if err != nil {
+				t.Fatalf("fakeapi.New unexpected error: %v", err)
+			}
+			injector, err := faults.New(&faults.Config{Faults: tc.faults})
+			if err != nil {
+				t.Fatalf("faults.New unexpected error: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			injector.SetCancel(cancel)
+
+			c, err := New(scenarioOptions(sc, injector.Wrap(srv.Clients())))
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			if err := c.Complete(ctx); err != nil {
+				t.Fatalf("Converter.Complete unexpected error: %v", err)
+			}
+			_, err = c.Run(ctx)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Converter.Run error diff (-want +got):\n%s", diff)
+			}
+			if len(injector.Injected()) == 0 {
+				t.Errorf("No faults were injected")
+			}
+		})
+	}
+}
+
+func errorsEqual(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Error() == b.Error()
+}
+
+// defaultState returns a regional cluster with a single node pool on the legacy network test.SelectedNetwork.
+func defaultState() *fakeapi.State {
+	sc := &fakeapi.Scenario{
+		ProjectID: test.ProjectName,
+		Network:   test.SelectedNetwork,
+		Clusters: []fakeapi.ScenarioCluster{
+			{
+				Name:      test.ClusterName,
+				Location:  test.RegionA,
+				Version:   "1.19.10-gke.1700",
+				NodePools: []fakeapi.ScenarioNodePool{{Name: test.NodePoolName}},
+			},
+		},
+		ServerConfig: test.ServerConfig(),
+	}
+	return sc.State()
+}
+
+// testClients returns clients of a fakeapi Server for the State, which inject the faults.
+func testClients(t *testing.T, s *fakeapi.State, fs ...faults.Fault) *pkg.Clients {
+	t.Helper()
+	srv, err := fakeapi.New(s)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	injector, err := faults.New(&faults.Config{Faults: fs})
+	if err != nil {
+		t.Fatalf("faults.New unexpected error: %v", err)
+	}
+	return injector.Wrap(srv.Clients())
+}
+
+// permissionRecorder records the permissions tested with a ResourceManagerService.
+type permissionRecorder struct {
+	pkg.ResourceManagerService
+
+	tested []string
+}
+
+func (r *permissionRecorder) TestIamPermissions(ctx context.Context, project string, permissions []string, opts ...googleapi.CallOption) ([]string, error) {
+	r.tested = append(r.tested, permissions...)
+	return r.ResourceManagerService.TestIamPermissions(ctx, project, permissions, opts...)
+}
+
+func defaultOptions(clients *pkg.Clients) Options {
+	return Options{
+		ProjectID:           test.ProjectName,
+		Network:             test.SelectedNetwork,
+		ControlPlaneVersion: clusters.DefaultVersion,
+		NodeVersion:         clusters.DefaultVersion,
+		ConcurrentClusters:  1,
+		PollingInterval:     10 * time.Minute,
+		PollingDeadline:     20 * time.Minute,
+		Clients:             clients,
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// Credentials selects the credentials used to call the APIs. Application Default Credentials
+// are used unless File or AccessTokenEnv is set.
+type Credentials struct {
+	// File is a service account key file, or another credentials JSON file.
+	File string
+	// AccessTokenEnv is the name of an environment variable holding an OAuth2 access token.
+	AccessTokenEnv string
+	// ImpersonateServiceAccount is the email of a service account to impersonate.
+	// The credentials must be granted roles/iam.serviceAccountTokenCreator on it.
+	ImpersonateServiceAccount string
+
+	// iamCredentialsOpts configure the IAM Credentials client used for impersonation (for testing).
+	iamCredentialsOpts []option.ClientOption
+}
+
+// Validate ensures at most one source of credentials is set.
+func (c Credentials) Validate() error {
+	if c.File != "" && c.AccessTokenEnv != "" {
+		return errors.New("a credentials file and an access token cannot both be used")
+	}
+	return nil
+}
+
+// TokenSource returns a source of tokens for the Credentials.
+func (c Credentials) TokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var ts oauth2.TokenSource
+	switch {
+	case c.File != "":
+		b, err := ioutil.ReadFile(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("error reading credentials file: %w", err)
+		}
+		creds, err := google.CredentialsFromJSON(ctx, b, compute.CloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing credentials file %s: %w", c.File, err)
+		}
+		ts = creds.TokenSource
+	case c.AccessTokenEnv != "":
+		token := os.Getenv(c.AccessTokenEnv)
+		if token == "" {
+			return nil, fmt.Errorf("environment variable %s is not set or empty", c.AccessTokenEnv)
+		}
+		ts = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	default:
+		var err error
+		ts, err = google.DefaultTokenSource(ctx, compute.CloudPlatformScope)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.ImpersonateServiceAccount == "" {
+		return ts, nil
+	}
+	return impersonate(ctx, ts, c.ImpersonateServiceAccount, c.iamCredentialsOpts...)
+}
+
+// impersonate returns a source of tokens for the service account, generated using the base tokens.
+func impersonate(ctx context.Context, base oauth2.TokenSource, serviceAccount string, opts ...option.ClientOption) (oauth2.TokenSource, error) {
+	opts = append([]option.ClientOption{option.WithTokenSource(base)}, opts...)
+	svc, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.ReuseTokenSource(nil, &impersonatedTokenSource{
+		ctx:  ctx,
+		svc:  svc,
+		name: "projects/-/serviceAccounts/" + serviceAccount,
+	}), nil
+}
+
+// impersonatedTokenSource generates access tokens for a service account.
+type impersonatedTokenSource struct {
+	ctx  context.Context
+	svc  *iamcredentials.Service
+	name string
+}
+
+// Token implements oauth2.TokenSource.
+func (s *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	req := &iamcredentials.GenerateAccessTokenRequest{
+		Scope:    []string{compute.CloudPlatformScope},
+		Lifetime: "3600s",
+	}
+	resp, err := s.svc.Projects.ServiceAccounts.GenerateAccessToken(s.name, req).Context(s.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error impersonating %s: %w", s.name, err)
+	}
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing expiry of token for %s: %w", s.name, err)
+	}
+	return &oauth2.Token{AccessToken: resp.AccessToken, Expiry: expiry}, nil
+}
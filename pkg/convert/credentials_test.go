@@ -0,0 +1,143 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"legacymigration/test"
+
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+const testTokenEnv = "GKECONVERT_TEST_ACCESS_TOKEN"
+
+func TestCredentials_TokenSource(t *testing.T) {
+	dir := t.TempDir()
+	authorizedUser := filepath.Join(dir, "authorized_user.json")
+	if err := ioutil.WriteFile(authorizedUser, []byte(`{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "refresh"}`), 0600); err != nil {
+		t.Fatalf("WriteFile unexpected error: %v", err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(`{`), 0600); err != nil {
+		t.Fatalf("WriteFile unexpected error: %v", err)
+	}
+	os.Setenv(testTokenEnv, "env-token")
+	defer os.Unsetenv(testTokenEnv)
+
+	cases := []struct {
+		desc      string
+		creds     Credentials
+		wantToken string
+		wantErr   string
+	}{
+		{
+			desc:      "Access token from the environment",
+			creds:     Credentials{AccessTokenEnv: testTokenEnv},
+			wantToken: "env-token",
+		},
+		{
+			desc:    "Unset environment variable",
+			creds:   Credentials{AccessTokenEnv: "GKECONVERT_TEST_UNSET"},
+			wantErr: "environment variable GKECONVERT_TEST_UNSET is not set or empty",
+		},
+		{
+			desc:  "Credentials file",
+			creds: Credentials{File: authorizedUser},
+		},
+		{
+			desc:    "Missing credentials file",
+			creds:   Credentials{File: filepath.Join(dir, "missing.json")},
+			wantErr: "error reading credentials file",
+		},
+		{
+			desc:    "Invalid credentials file",
+			creds:   Credentials{File: invalid},
+			wantErr: "error parsing credentials file",
+		},
+		{
+			desc:    "File and access token",
+			creds:   Credentials{File: authorizedUser, AccessTokenEnv: testTokenEnv},
+			wantErr: "a credentials file and an access token cannot both be used",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ts, err := tc.creds.TokenSource(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Fatalf("Credentials.TokenSource diff (-want +got):\n%s", diff)
+			}
+			if tc.wantToken == "" {
+				return
+			}
+			token, err := ts.Token()
+			if err != nil {
+				t.Fatalf("Token unexpected error: %v", err)
+			}
+			if token.AccessToken != tc.wantToken {
+				t.Errorf("AccessToken; wanted: %s, got: %s", tc.wantToken, token.AccessToken)
+			}
+		})
+	}
+}
+
+func TestCredentials_Impersonate(t *testing.T) {
+	const sa = "migration@test-project.iam.gserviceaccount.com"
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	var gotPath, gotAuth string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(&iamcredentials.GenerateAccessTokenResponse{
+			AccessToken: "impersonated-token",
+			ExpireTime:  expiry.Format(time.RFC3339),
+		})
+	}))
+	defer hs.Close()
+	os.Setenv(testTokenEnv, "env-token")
+	defer os.Unsetenv(testTokenEnv)
+
+	creds := Credentials{
+		AccessTokenEnv:            testTokenEnv,
+		ImpersonateServiceAccount: sa,
+		iamCredentialsOpts:        []option.ClientOption{option.WithEndpoint(hs.URL + "/")},
+	}
+	ts, err := creds.TokenSource(context.Background())
+	if err != nil {
+		t.Fatalf("Credentials.TokenSource unexpected error: %v", err)
+	}
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token unexpected error: %v", err)
+	}
+	if token.AccessToken != "impersonated-token" || !token.Expiry.Equal(expiry) {
+		t.Errorf("Token; wanted: impersonated-token expiring at %v, got: %s expiring at %v", expiry, token.AccessToken, token.Expiry)
+	}
+	if want := "/v1/projects/-/serviceAccounts/" + sa + ":generateAccessToken"; gotPath != want {
+		t.Errorf("GenerateAccessToken path; wanted: %s, got: %s", want, gotPath)
+	}
+	if gotAuth != "Bearer env-token" {
+		t.Errorf("GenerateAccessToken authorization; wanted: Bearer env-token, got: %s", gotAuth)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"legacymigration/pkg"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// validatePermissions are required to complete and validate the migrators of a network and its clusters,
+// i.e. to list networks and clusters and read clusters, node pools, instance groups and their templates.
+// The permissions required to convert them depend on the plan; see migrate.Permissions.
+var validatePermissions = []string{
+	"compute.networks.list",
+	"compute.instanceGroupManagers.get",
+	"compute.instanceTemplates.get",
+	"container.clusters.list",
+	"container.clusters.get",
+}
+
+// CheckPermissions tests that the caller has the permissions on the project,
+// returning an error listing those which are missing.
+func CheckPermissions(ctx context.Context, rm pkg.ResourceManagerService, project string, permissions []string) error {
+	granted, err := rm.TestIamPermissions(ctx, project, permissions)
+	if err != nil {
+		return fmt.Errorf("error testing permissions on project %s: %w", project, err)
+	}
+	has := make(map[string]bool)
+	for _, p := range granted {
+		has[p] = true
+	}
+	var missing []string
+	for _, p := range permissions {
+		if !has[p] {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing permissions on project %s: %s", project, strings.Join(missing, ", "))
+	}
+	log.Infof("Verified %d permissions on project %s", len(permissions), project)
+	return nil
+}
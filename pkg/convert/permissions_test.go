@@ -0,0 +1,59 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"context"
+	"testing"
+
+	"legacymigration/pkg/faults"
+	"legacymigration/test"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	permissions := []string{"container.clusters.update", "compute.networks.get", "compute.networks.switchToCustomMode"}
+	cases := []struct {
+		desc    string
+		denied  []string
+		faults  []faults.Fault
+		wantErr string
+	}{
+		{
+			desc: "Granted",
+		},
+		{
+			desc:    "Missing permissions",
+			denied:  []string{"container.clusters.update", "compute.networks.switchToCustomMode"},
+			wantErr: "missing permissions on project test-project: compute.networks.switchToCustomMode, container.clusters.update",
+		},
+		{
+			desc:    "Error",
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "TestIamPermissions", Message: "quota exceeded"}},
+			wantErr: "error testing permissions on project test-project: googleapi: Error 503: quota exceeded",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := defaultState()
+			s.DeniedPermissions = tc.denied
+			clients := testClients(t, s, tc.faults...)
+			err := CheckPermissions(context.Background(), clients.ResourceManager, test.ProjectName, permissions)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("CheckPermissions diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryStats are statistics of the API requests retried by the clients during a run.
+type RetryStats struct {
+	// Retries counts the retried requests by the reason the previous attempt failed: the error reason
+	// of a rate limited 403 response (e.g. rateLimitExceeded), the status code of other responses
+	// (e.g. 503), or "error" if no response was received.
+	Retries map[string]int `json:"retries,omitempty"`
+	// RetryAfter counts the retries delayed as requested by a Retry-After header.
+	RetryAfter int `json:"retryAfter,omitempty"`
+	// Exhausted counts the requests which failed once all of their retries were exhausted.
+	Exhausted int `json:"exhausted,omitempty"`
+}
+
+// Total returns the number of retried requests.
+func (s RetryStats) Total() int {
+	total := 0
+	for _, n := range s.Retries {
+		total += n
+	}
+	return total
+}
+
+// retryRecorder records the RetryStats of the requests sent with a context carrying it.
+type retryRecorder struct {
+	mu    sync.Mutex
+	stats RetryStats
+}
+
+func (r *retryRecorder) retry(reason string, retryAfter bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stats.Retries == nil {
+		r.stats.Retries = make(map[string]int)
+	}
+	r.stats.Retries[reason]++
+	if retryAfter {
+		r.stats.RetryAfter++
+	}
+}
+
+func (r *retryRecorder) exhausted() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Exhausted++
+}
+
+// snapshot returns a copy of the statistics recorded so far.
+func (r *retryRecorder) snapshot() RetryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	if s.Retries != nil {
+		s.Retries = make(map[string]int, len(r.stats.Retries))
+		for reason, n := range r.stats.Retries {
+			s.Retries[reason] = n
+		}
+	}
+	return s
+}
+
+type retryRecorderKey struct{}
+
+// withRetryRecorder returns a copy of ctx which records the retries of requests sent with it to r.
+func withRetryRecorder(ctx context.Context, r *retryRecorder) context.Context {
+	return context.WithValue(ctx, retryRecorderKey{}, r)
+}
+
+// attempts tracks the attempts of a single request, which are made sequentially.
+type attempts struct {
+	recorder *retryRecorder
+	// Fields describing the last attempt.
+	retryable  bool
+	reason     string
+	retryAfter bool
+}
+
+type attemptsKey struct{}
+
+func attemptsFrom(ctx context.Context) *attempts {
+	a, _ := ctx.Value(attemptsKey{}).(*attempts)
+	return a
+}
+
+// retryStatsTransport tracks the attempts of each request sent through base,
+// a retryablehttp.RoundTripper, for the retryRecorder of its context.
+type retryStatsTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryStatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, ok := req.Context().Value(retryRecorderKey{}).(*retryRecorder)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	a := &attempts{recorder: r}
+	resp, err := t.base.RoundTrip(req.WithContext(context.WithValue(req.Context(), attemptsKey{}, a)))
+	if a.retryable && req.Context().Err() == nil {
+		r.exhausted()
+	}
+	return resp, err
+}
+
+// logRetry is a retryablehttp.RequestLogHook which records each retry of a request.
+func logRetry(_ retryablehttp.Logger, req *http.Request, attempt int) {
+	a := attemptsFrom(req.Context())
+	if attempt == 0 || a == nil {
+		return
+	}
+	a.recorder.retry(a.reason, a.retryAfter)
+	log.Debugf("Retrying %s %s (attempt %d) after %s", req.Method, req.URL, attempt+1, a.reason)
+}
+
+// retryPolicy retries as retryablehttp.DefaultRetryPolicy does, and also retries 403 responses
+// for exceeded rate limits or quota, which GCE returns when rate limiting. Other 403 responses,
+// e.g. for missing permissions, are not retried.
+func retryPolicy() func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		shouldRetry, reason, newErr := checkRetry(ctx, resp, err)
+		if a := attemptsFrom(ctx); a != nil {
+			a.retryable, a.reason, a.retryAfter = shouldRetry, reason, false
+		}
+		return shouldRetry, newErr
+	}
+}
+
+// checkRetry returns whether to retry a request, and the reason if so.
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, string, error) {
+	shouldRetry, newErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if newErr != nil || shouldRetry {
+		if resp == nil {
+			return shouldRetry, "error", newErr
+		}
+		return shouldRetry, strconv.Itoa(resp.StatusCode), newErr
+	}
+
+	if resp.StatusCode != http.StatusForbidden {
+		return false, "", nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return true, "error", err
+	}
+	// Leave the body readable, so that the error is reported by the API client if not retried.
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	reason, ok := rateLimitReason(body)
+	return ok, reason, nil
+}
+
+// backoff waits as long as requested by the Retry-After header of a response, if any,
+// and otherwise as retryablehttp.DefaultBackoff does. Either wait is at most max, so that
+// a server cannot stall a retry indefinitely.
+func backoff(min, max time.Duration, attempt int, resp *http.Response) time.Duration {
+	wait, ok := retryAfter(resp, time.Now())
+	if !ok {
+		return retryablehttp.DefaultBackoff(min, max, attempt, resp)
+	}
+	if a := attemptsFrom(resp.Request.Context()); a != nil {
+		a.retryAfter = true
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a response,
+// either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || resp.Request == nil {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if wait := t.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// rateLimitReasons are the error reasons, and ErrorInfo reasons, of exceeded rate limits or quota.
+var rateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"RATE_LIMIT_EXCEEDED":   true,
+}
+
+// errorResponse is the body of an API error response.
+type errorResponse struct {
+	Error struct {
+		Status string `json:"status"`
+		Errors []struct {
+			Reason string `json:"reason"`
+		} `json:"errors"`
+		Details []struct {
+			Reason string `json:"reason"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// rateLimitReason returns the reason reported by the body of a 403 response for an exceeded rate limit
+// or quota, or false if it reports another error, e.g. denied permissions (reason forbidden).
+// Bodies which cannot be parsed are treated as denied permissions.
+func rateLimitReason(body []byte) (string, bool) {
+	var resp errorResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", false
+	}
+	for _, e := range resp.Error.Errors {
+		if rateLimitReasons[e.Reason] {
+			return e.Reason, true
+		}
+	}
+	for _, d := range resp.Error.Details {
+		if rateLimitReasons[d.Reason] {
+			return d.Reason, true
+		}
+	}
+	if resp.Error.Status == "RESOURCE_EXHAUSTED" {
+		return resp.Error.Status, true
+	}
+	return "", false
+}
@@ -0,0 +1,306 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package convert
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"legacymigration/pkg/ratelimit"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	rateLimitedBody      = `{"error": {"code": 403, "message": "Rate Limit Exceeded", "errors": [{"reason": "rateLimitExceeded"}]}}`
+	permissionDeniedBody = `{"error": {"code": 403, "message": "Required 'compute.networks.get' permission", "status": "PERMISSION_DENIED", "errors": [{"reason": "forbidden"}]}}`
+)
+
+func TestRetryPolicy(t *testing.T) {
+	cases := []struct {
+		desc       string
+		code       int
+		body       string
+		wantRetry  bool
+		wantReason string
+	}{
+		{
+			desc: "OK",
+			code: http.StatusOK,
+		},
+		{
+			desc:       "Rate limited",
+			code:       http.StatusForbidden,
+			body:       rateLimitedBody,
+			wantRetry:  true,
+			wantReason: "rateLimitExceeded",
+		},
+		{
+			desc:       "User rate limited",
+			code:       http.StatusForbidden,
+			body:       `{"error": {"code": 403, "errors": [{"reason": "userRateLimitExceeded"}]}}`,
+			wantRetry:  true,
+			wantReason: "userRateLimitExceeded",
+		},
+		{
+			desc:       "Quota exceeded",
+			code:       http.StatusForbidden,
+			body:       `{"error": {"code": 403, "message": "Quota exceeded", "errors": [{"reason": "quotaExceeded"}]}}`,
+			wantRetry:  true,
+			wantReason: "quotaExceeded",
+		},
+		{
+			desc:       "Resource exhausted",
+			code:       http.StatusForbidden,
+			body:       `{"error": {"code": 403, "status": "RESOURCE_EXHAUSTED"}}`,
+			wantRetry:  true,
+			wantReason: "RESOURCE_EXHAUSTED",
+		},
+		{
+			desc:       "Rate limit ErrorInfo",
+			code:       http.StatusForbidden,
+			body:       `{"error": {"code": 403, "status": "PERMISSION_DENIED", "details": [{"reason": "RATE_LIMIT_EXCEEDED"}]}}`,
+			wantRetry:  true,
+			wantReason: "RATE_LIMIT_EXCEEDED",
+		},
+		{
+			desc: "Permission denied",
+			code: http.StatusForbidden,
+			body: permissionDeniedBody,
+		},
+		{
+			desc: "Unparseable 403",
+			code: http.StatusForbidden,
+			body: "Forbidden",
+		},
+		{
+			desc:       "Server error",
+			code:       http.StatusServiceUnavailable,
+			wantRetry:  true,
+			wantReason: "503",
+		},
+		{
+			desc: "Not found",
+			code: http.StatusNotFound,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tc.code,
+				Body:       ioutil.NopCloser(strings.NewReader(tc.body)),
+			}
+			a := &attempts{}
+			ctx := context.WithValue(context.Background(), attemptsKey{}, a)
+			got, err := retryPolicy()(ctx, resp, nil)
+			if err != nil {
+				t.Errorf("retryPolicy unexpected error: %v", err)
+			}
+			if got != tc.wantRetry || a.retryable != tc.wantRetry {
+				t.Errorf("retryPolicy; wanted: %v, got: %v", tc.wantRetry, got)
+			}
+			if a.reason != tc.wantReason {
+				t.Errorf("retryPolicy reason; wanted: %q, got: %q", tc.wantReason, a.reason)
+			}
+			// The body must remain readable by the API client.
+			if b, _ := ioutil.ReadAll(resp.Body); tc.code == http.StatusForbidden && string(b) != tc.body {
+				t.Errorf("Response body after retryPolicy; wanted: %q, got: %q", tc.body, b)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		desc   string
+		header string
+		want   time.Duration
+		wantOK bool
+	}{
+		{
+			desc: "Missing",
+		},
+		{
+			desc:   "Seconds",
+			header: "30",
+			want:   30 * time.Second,
+			wantOK: true,
+		},
+		{
+			desc:   "HTTP date",
+			header: now.Add(time.Minute).Format(http.TimeFormat),
+			want:   time.Minute,
+			wantOK: true,
+		},
+		{
+			desc:   "Past HTTP date",
+			header: now.Add(-time.Minute).Format(http.TimeFormat),
+			wantOK: true,
+		},
+		{
+			desc:   "Negative",
+			header: "-1",
+		},
+		{
+			desc:   "Invalid",
+			header: "soon",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}, Request: &http.Request{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			got, ok := retryAfter(resp, now)
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("retryAfter; wanted: %v, %t, got: %v, %t", tc.want, tc.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		desc   string
+		header string
+		want   time.Duration
+	}{
+		{
+			desc:   "Retry-After below max",
+			header: "10",
+			want:   10 * time.Second,
+		},
+		{
+			desc:   "Retry-After clamped to max",
+			header: "3600",
+			want:   30 * time.Second,
+		},
+		{
+			desc: "Exponential backoff",
+			want: 20 * time.Second,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}, Request: httptest.NewRequest("GET", "/", nil)}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			if got := backoff(5*time.Second, 30*time.Second, 2, resp); got != tc.want {
+				t.Errorf("backoff; wanted: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewClients_Retries(t *testing.T) {
+	cases := []struct {
+		desc      string
+		responses []int
+		body      string
+		wantErr   string
+		wantCode  int
+		wantCalls int
+		want      RetryStats
+	}{
+		{
+			desc:      "Rate limited",
+			responses: []int{http.StatusForbidden, http.StatusForbidden, http.StatusOK},
+			body:      rateLimitedBody,
+			wantCalls: 3,
+			want:      RetryStats{Retries: map[string]int{"rateLimitExceeded": 2}, RetryAfter: 2},
+		},
+		{
+			desc:      "Permission denied",
+			responses: []int{http.StatusForbidden},
+			body:      permissionDeniedBody,
+			wantErr:   "Required 'compute.networks.get' permission",
+			wantCode:  http.StatusForbidden,
+			wantCalls: 1,
+		},
+		{
+			desc:      "Exhausted",
+			responses: []int{http.StatusServiceUnavailable},
+			body:      `{"error": {"code": 503, "message": "Backend Error"}}`,
+			wantErr:   "Backend Error",
+			wantCode:  http.StatusServiceUnavailable,
+			wantCalls: 4,
+			want:      RetryStats{Retries: map[string]int{"503": 3}, RetryAfter: 3, Exhausted: 1},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var calls int
+			hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				code := tc.responses[min(calls, len(tc.responses)-1)]
+				calls++
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(code)
+				if code == http.StatusOK {
+					w.Write([]byte(`{"name": "network"}`))
+					return
+				}
+				w.Write([]byte(tc.body))
+			}))
+			defer hs.Close()
+
+			clients, err := NewClients(context.Background(), Endpoints{Compute: hs.URL, Container: hs.URL + "/", ResourceManager: hs.URL + "/"}, http.DefaultClient, ratelimit.Limits{})
+			if err != nil {
+				t.Fatalf("NewClients unexpected error: %v", err)
+			}
+			r := &retryRecorder{}
+			_, err = clients.Compute.GetNetwork(withRetryRecorder(context.Background(), r), test.ProjectName, "network")
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("GetNetwork diff (-want +got):\n%s", diff)
+			}
+			var apiErr *googleapi.Error
+			if tc.wantCode != 0 && (!errors.As(err, &apiErr) || apiErr.Code != tc.wantCode) {
+				t.Errorf("GetNetwork error; wanted a googleapi.Error with code %d, got: %#v", tc.wantCode, err)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("Requests sent; wanted: %d, got: %d", tc.wantCalls, calls)
+			}
+			if diff := cmp.Diff(tc.want, r.snapshot()); diff != "" {
+				t.Errorf("RetryStats diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRetryStats_Total(t *testing.T) {
+	s := RetryStats{Retries: map[string]int{"503": 2, "rateLimitExceeded": 3}}
+	if got := s.Total(); got != 5 {
+		t.Errorf("RetryStats.Total; wanted: 5, got: %d", got)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDuration_Unmarshal(t *testing.T) {
+	cases := []struct {
+		desc    string
+		in      string
+		want    Duration
+		wantErr string
+	}{
+		{
+			desc: "Seconds",
+			in:   `"15s"`,
+			want: Duration(15 * time.Second),
+		},
+		{
+			desc: "Hours",
+			in:   `"24h"`,
+			want: Duration(24 * time.Hour),
+		},
+		{
+			desc:    "Number",
+			in:      `[15]`,
+			wantErr: "duration must be a string",
+		},
+		{
+			desc:    "Malformed",
+			in:      `"15x"`,
+			wantErr: "unknown unit",
+		},
+	}
+	unmarshal := map[string]func([]byte, interface{}) error{
+		"JSON": json.Unmarshal,
+		"YAML": yaml.Unmarshal,
+	}
+	for _, tc := range cases {
+		for format, f := range unmarshal {
+			t.Run(tc.desc+"/"+format, func(t *testing.T) {
+				var got Duration
+				err := f([]byte(tc.in), &got)
+				if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+					t.Errorf("Duration.Unmarshal%s; wanted error: %q, got: %v", format, tc.wantErr, err)
+				}
+				if got != tc.want {
+					t.Errorf("Duration.Unmarshal%s; wanted: %v, got: %v", format, tc.want, got)
+				}
+			})
+		}
+	}
+}
+
+func TestDuration_Marshal(t *testing.T) {
+	d := Duration(90 * time.Second)
+	b, err := json.Marshal(d)
+	if err != nil || string(b) != `"1m30s"` {
+		t.Errorf("Duration.MarshalJSON; wanted: %q, got: %q (error: %v)", `"1m30s"`, b, err)
+	}
+	b, err = yaml.Marshal(d)
+	if err != nil || string(b) != "1m30s\n" {
+		t.Errorf("Duration.MarshalYAML; wanted: %q, got: %q (error: %v)", "1m30s\n", b, err)
+	}
+}
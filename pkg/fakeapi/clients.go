@@ -0,0 +1,322 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"context"
+
+	"legacymigration/pkg"
+
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Clients returns API clients which call the Server in-process rather than over HTTP,
+// e.g. for tests of the conversion. Resources are copied so that callers cannot modify the State.
+func (s *Server) Clients() *pkg.Clients {
+	return &pkg.Clients{
+		Compute:         &computeClient{s: s},
+		Container:       &containerClient{s: s},
+		ResourceManager: &resourceManagerClient{s: s},
+	}
+}
+
+// call runs f while holding the Server lock and copies its result into resp, like a call over HTTP.
+func (s *Server) call(ctx context.Context, resp interface{}, f func() (interface{}, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := f()
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return deepCopy(v, resp)
+}
+
+// checkProject returns the error for resources of projects other than the State's.
+func (s *Server) checkProject(project string) error {
+	if project != s.state.ProjectID {
+		return notFound("project %s not found", project)
+	}
+	return nil
+}
+
+// computeClient implements pkg.ComputeService.
+type computeClient struct {
+	s *Server
+}
+
+func (c *computeClient) GetInstanceGroupManager(ctx context.Context, project, location, name string, _ ...googleapi.CallOption) (*compute.InstanceGroupManager, error) {
+	resp := &compute.InstanceGroupManager{}
+	if err := c.s.call(ctx, resp, func() (interface{}, error) {
+		if err := c.s.checkProject(project); err != nil {
+			return nil, err
+		}
+		return c.s.instanceGroupManager(location, name)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *computeClient) GetInstanceTemplate(ctx context.Context, project, name string, _ ...googleapi.CallOption) (*compute.InstanceTemplate, error) {
+	resp := &compute.InstanceTemplate{}
+	if err := c.s.call(ctx, resp, func() (interface{}, error) {
+		if err := c.s.checkProject(project); err != nil {
+			return nil, err
+		}
+		return c.s.instanceTemplate(name)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *computeClient) GetGlobalOperation(ctx context.Context, project, name string, _ ...googleapi.CallOption) (*compute.Operation, error) {
+	resp := &compute.Operation{}
+	if err := c.s.call(ctx, resp, func() (interface{}, error) {
+		if err := c.s.checkProject(project); err != nil {
+			return nil, err
+		}
+		return c.s.computeOperation(name)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *computeClient) WaitOperation(ctx context.Context, project string, op *compute.Operation, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	return c.GetGlobalOperation(ctx, project, op.Name, opts...)
+}
+
+// SwitchToCustomMode reads the operation once after starting it, like pkg.Compute.
+func (c *computeClient) SwitchToCustomMode(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	resp := &compute.Operation{}
+	if err := c.s.call(ctx, resp, func() (interface{}, error) {
+		if err := c.s.checkProject(project); err != nil {
+			return nil, err
+		}
+		return c.s.switchToCustomMode(name)
+	}); err != nil {
+		return nil, err
+	}
+	return c.GetGlobalOperation(ctx, project, resp.Name, opts...)
+}
+
+func (c *computeClient) GetNetwork(ctx context.Context, project, name string, _ ...googleapi.CallOption) (*compute.Network, error) {
+	resp := &compute.Network{}
+	if err := c.s.call(ctx, resp, func() (interface{}, error) {
+		if err := c.s.checkProject(project); err != nil {
+			return nil, err
+		}
+		return c.s.network(name)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *computeClient) ListNetworks(ctx context.Context, project string) ([]*compute.Network, error) {
+	var resp []*compute.Network
+	if err := c.s.call(ctx, &resp, func() (interface{}, error) {
+		if err := c.s.checkProject(project); err != nil {
+			return nil, err
+		}
+		return c.s.state.Networks, nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// containerClient implements pkg.ContainerService.
+type containerClient struct {
+	s *Server
+}
+
+// cluster calls f with the location and name of the cluster at the path.
+func (c *containerClient) cluster(ctx context.Context, path string, resp interface{}, f func(location, name string) (interface{}, error)) error {
+	return c.s.call(ctx, resp, func() (interface{}, error) {
+		location, parts, err := c.s.parseContainerPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 || parts[0] != "clusters" {
+			return nil, notFound("cluster %s not found", path)
+		}
+		return f(location, parts[1])
+	})
+}
+
+// nodePool calls f with the location, cluster and name of the node pool at the path.
+func (c *containerClient) nodePool(ctx context.Context, path string, resp interface{}, f func(location, cluster, name string) (interface{}, error)) error {
+	return c.s.call(ctx, resp, func() (interface{}, error) {
+		location, parts, err := c.s.parseContainerPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 4 || parts[0] != "clusters" || parts[2] != "nodePools" {
+			return nil, notFound("node pool %s not found", path)
+		}
+		return f(location, parts[1], parts[3])
+	})
+}
+
+// location calls f with the location of the path, and the name which follows it if any.
+func (c *containerClient) location(ctx context.Context, path, collection string, resp interface{}, f func(location, name string) (interface{}, error)) error {
+	return c.s.call(ctx, resp, func() (interface{}, error) {
+		location, parts, err := c.s.parseContainerPath(path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case collection == "" && len(parts) == 0:
+			return f(location, "")
+		case len(parts) == 2 && parts[0] == collection:
+			return f(location, parts[1])
+		}
+		return nil, notFound("resource %s not found", path)
+	})
+}
+
+func (c *containerClient) UpdateMaster(ctx context.Context, req *container.UpdateMasterRequest, _ ...googleapi.CallOption) (*container.Operation, error) {
+	resp := &container.Operation{}
+	if err := c.cluster(ctx, req.Name, resp, func(location, name string) (interface{}, error) {
+		return c.s.updateMaster(location, name, req)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) GetCluster(ctx context.Context, name string, _ ...googleapi.CallOption) (*container.Cluster, error) {
+	resp := &container.Cluster{}
+	if err := c.cluster(ctx, name, resp, func(location, name string) (interface{}, error) {
+		return c.s.cluster(location, name)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) ListClusters(ctx context.Context, parent string, _ ...googleapi.CallOption) (*container.ListClustersResponse, error) {
+	resp := &container.ListClustersResponse{}
+	if err := c.location(ctx, parent, "", resp, func(location, _ string) (interface{}, error) {
+		return c.s.listClusters(location), nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) GetOperation(ctx context.Context, name string, _ ...googleapi.CallOption) (*container.Operation, error) {
+	resp := &container.Operation{}
+	if err := c.location(ctx, name, "operations", resp, func(_, name string) (interface{}, error) {
+		return c.s.getOperation(name)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) CancelOperation(ctx context.Context, name string, _ ...googleapi.CallOption) error {
+	return c.location(ctx, name, "operations", nil, func(_, name string) (interface{}, error) {
+		return nil, c.s.cancelOperation(name)
+	})
+}
+
+func (c *containerClient) ListOperations(ctx context.Context, parent string, _ ...googleapi.CallOption) (*container.ListOperationsResponse, error) {
+	resp := &container.ListOperationsResponse{}
+	if err := c.location(ctx, parent, "", resp, func(location, _ string) (interface{}, error) {
+		return c.s.listOperations(location), nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) SetMaintenancePolicy(ctx context.Context, req *container.SetMaintenancePolicyRequest, _ ...googleapi.CallOption) (*container.Operation, error) {
+	resp := &container.Operation{}
+	if err := c.cluster(ctx, req.Name, resp, func(location, name string) (interface{}, error) {
+		return c.s.setMaintenancePolicy(location, name, req)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) UpdateNodePool(ctx context.Context, req *container.UpdateNodePoolRequest, _ ...googleapi.CallOption) (*container.Operation, error) {
+	resp := &container.Operation{}
+	if err := c.nodePool(ctx, req.Name, resp, func(location, cluster, name string) (interface{}, error) {
+		return c.s.updateNodePool(location, cluster, name, req)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) GetNodePool(ctx context.Context, name string, _ ...googleapi.CallOption) (*container.NodePool, error) {
+	resp := &container.NodePool{}
+	if err := c.nodePool(ctx, name, resp, func(location, cluster, name string) (interface{}, error) {
+		_, np, err := c.s.nodePool(location, cluster, name)
+		return np, err
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) ListNodePools(ctx context.Context, name string, _ ...googleapi.CallOption) (*container.ListNodePoolsResponse, error) {
+	resp := &container.ListNodePoolsResponse{}
+	if err := c.cluster(ctx, name, resp, func(location, name string) (interface{}, error) {
+		return c.s.listNodePools(location, name)
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *containerClient) GetServerConfig(ctx context.Context, name string, _ ...googleapi.CallOption) (*container.ServerConfig, error) {
+	resp := &container.ServerConfig{}
+	if err := c.location(ctx, name, "", resp, func(_, _ string) (interface{}, error) {
+		return c.s.serverConfig()
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// resourceManagerClient implements pkg.ResourceManagerService.
+type resourceManagerClient struct {
+	s *Server
+}
+
+func (c *resourceManagerClient) TestIamPermissions(ctx context.Context, project string, permissions []string, _ ...googleapi.CallOption) ([]string, error) {
+	resp := &cloudresourcemanager.TestIamPermissionsResponse{}
+	if err := c.s.call(ctx, resp, func() (interface{}, error) {
+		return c.s.testIamPermissions(project, permissions)
+	}); err != nil {
+		return nil, err
+	}
+	return resp.Permissions, nil
+}
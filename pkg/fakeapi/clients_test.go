@@ -0,0 +1,141 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"context"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/test"
+
+	"google.golang.org/api/container/v1"
+)
+
+func TestServer_Clients(t *testing.T) {
+	state := testState()
+	state.Script.Polls = 1
+	srv, err := New(state)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	clients := srv.Clients()
+	ctx := context.Background()
+
+	op, err := clients.Compute.SwitchToCustomMode(ctx, testProject, testNetwork)
+	if err != nil {
+		t.Fatalf("SwitchToCustomMode unexpected error: %v", err)
+	}
+	if op, err = clients.Compute.WaitOperation(ctx, testProject, op); err != nil || op.Status != statusDone {
+		t.Fatalf("WaitOperation; wanted: %s, got: %+v, %v", statusDone, op, err)
+	}
+
+	path := pkg.NodePoolPath(testProject, testZone, testCluster, testNodePool)
+	cop, err := clients.Container.UpdateNodePool(ctx, &container.UpdateNodePoolRequest{Name: path, NodeVersion: "1.19.11-gke.1700"})
+	if err != nil {
+		t.Fatalf("UpdateNodePool unexpected error: %v", err)
+	}
+	waitContainer(t, clients, cop)
+
+	np, err := clients.Container.GetNodePool(ctx, path)
+	if err != nil {
+		t.Fatalf("GetNodePool unexpected error: %v", err)
+	}
+	if np.Version != "1.19.11-gke.1700" {
+		t.Errorf("Version; wanted: 1.19.11-gke.1700, got: %s", np.Version)
+	}
+	it, err := clients.Compute.GetInstanceTemplate(ctx, testProject, testTemplate)
+	if err != nil {
+		t.Fatalf("GetInstanceTemplate unexpected error: %v", err)
+	}
+	want := computeSelfLink + "projects/test-project/regions/us-central1/subnetworks/" + testNetwork
+	if got := it.Properties.NetworkInterfaces[0].Subnetwork; got != want {
+		t.Errorf("Subnetwork; wanted: %q, got: %q", want, got)
+	}
+
+	// Resources are copies of the State.
+	np.Version = "modified"
+	got, err := srv.State()
+	if err != nil {
+		t.Fatalf("State unexpected error: %v", err)
+	}
+	if v := got.Clusters[0].NodePools[0].Version; v != "1.19.11-gke.1700" {
+		t.Errorf("State node pool version; wanted: 1.19.11-gke.1700, got: %s", v)
+	}
+}
+
+func TestServer_ClientsErrors(t *testing.T) {
+	srv, err := New(testState())
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	clients := srv.Clients()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		desc    string
+		call    func(ctx context.Context) error
+		ctx     context.Context
+		wantErr string
+	}{
+		{
+			desc: "Other project",
+			call: func(ctx context.Context) error {
+				_, err := clients.Compute.GetNetwork(ctx, "other", testNetwork)
+				return err
+			},
+			wantErr: "project other not found",
+		},
+		{
+			desc: "Missing cluster",
+			call: func(ctx context.Context) error {
+				_, err := clients.Container.GetCluster(ctx, pkg.ClusterPath(testProject, testZone, "miss"))
+				return err
+			},
+			wantErr: "not found",
+		},
+		{
+			desc: "Malformed path",
+			call: func(ctx context.Context) error {
+				_, err := clients.Container.GetNodePool(ctx, "projects/test-project")
+				return err
+			},
+			wantErr: "not found",
+		},
+		{
+			desc: "Canceled context",
+			ctx:  canceled,
+			call: func(ctx context.Context) error {
+				_, err := clients.Container.ListClusters(ctx, pkg.LocationPath(testProject, "-"))
+				return err
+			},
+			wantErr: "context canceled",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			err := tc.call(ctx)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Clients call diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+)
+
+const switchToCustomMode = "switchToCustomMode"
+
+// serveCompute serves the Compute API methods used by the conversion:
+//  GET  projects/{p}/global/networks
+//  GET  projects/{p}/global/networks/{n}
+//  POST projects/{p}/global/networks/{n}/switchToCustomMode
+//  GET  projects/{p}/global/operations/{o}
+//  POST projects/{p}/{global|zones/{z}|regions/{r}}/operations/{o}/wait
+//  GET  projects/{p}/{zones/{z}|regions/{r}}/instanceGroupManagers/{n}
+//  GET  projects/{p}/global/instanceTemplates/{n}
+func (s *Server) serveCompute(r *http.Request, path string) (interface{}, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 4 || parts[0] != "projects" {
+		return nil, notFound("%s %s", r.Method, r.URL.Path)
+	}
+	if err := s.checkProject(parts[1]); err != nil {
+		return nil, err
+	}
+	scope, parts := parts[2], parts[3:]
+	if scope != "global" {
+		// Zonal or regional resources are qualified by their location.
+		scope, parts = scope+"/"+parts[0], parts[1:]
+	}
+
+	get, post := r.Method == http.MethodGet, r.Method == http.MethodPost
+	switch {
+	case scope == "global" && len(parts) == 1 && parts[0] == "networks" && get:
+		return &compute.NetworkList{Items: s.state.Networks}, nil
+	case scope == "global" && len(parts) == 2 && parts[0] == "networks" && get:
+		return s.network(parts[1])
+	case scope == "global" && len(parts) == 3 && parts[0] == "networks" && parts[2] == switchToCustomMode && post:
+		return s.switchToCustomMode(parts[1])
+	case scope == "global" && len(parts) == 2 && parts[0] == "operations" && get:
+		return s.computeOperation(parts[1])
+	case len(parts) == 3 && parts[0] == "operations" && parts[2] == "wait" && post:
+		return s.computeOperation(parts[1])
+	case scope != "global" && len(parts) == 2 && parts[0] == "instanceGroupManagers" && get:
+		return s.instanceGroupManager(lastSegment(scope), parts[1])
+	case scope == "global" && len(parts) == 2 && parts[0] == "instanceTemplates" && get:
+		return s.instanceTemplate(parts[1])
+	}
+	return nil, notFound("%s %s", r.Method, r.URL.Path)
+}
+
+func (s *Server) network(name string) (*compute.Network, error) {
+	for _, n := range s.state.Networks {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return nil, notFound("network %s not found", name)
+}
+
+// switchToCustomMode starts an operation which removes the network's legacy range and adds
+// an automatic subnetwork in each region with a cluster on the network.
+func (s *Server) switchToCustomMode(name string) (*compute.Operation, error) {
+	n, err := s.network(name)
+	if err != nil {
+		return nil, err
+	}
+	if n.IPv4Range == "" {
+		return nil, badRequest("network %s is not a legacy network", name)
+	}
+
+	target := fmt.Sprintf("%sprojects/%s/global/networks/%s", computeSelfLink, s.state.ProjectID, name)
+	opName := s.nextName()
+	op := &operation{
+		compute: &compute.Operation{
+			Name:          opName,
+			OperationType: switchToCustomMode,
+			Status:        statusRunning,
+			TargetLink:    target,
+			SelfLink:      fmt.Sprintf("%sprojects/%s/global/operations/%s", computeSelfLink, s.state.ProjectID, opName),
+		},
+		apply: func() {
+			n.IPv4Range = ""
+			n.GatewayIPv4 = ""
+			n.AutoCreateSubnetworks = true
+			n.Subnetworks = nil
+			regions := make(map[string]bool)
+			for _, c := range s.state.Clusters {
+				r := region(c.Location)
+				if c.Network == name && !regions[r] {
+					regions[r] = true
+					n.Subnetworks = append(n.Subnetworks, s.subnetworkURL(r, name))
+				}
+			}
+		},
+	}
+	s.start(op, switchToCustomMode, target)
+	return op.compute, nil
+}
+
+// computeOperation returns the operation after advancing it by a poll.
+func (s *Server) computeOperation(name string) (*compute.Operation, error) {
+	op, ok := s.ops[name]
+	if !ok || op.compute == nil {
+		return nil, notFound("operation %s not found", name)
+	}
+	s.poll(op)
+	return op.compute, nil
+}
+
+func (s *Server) instanceGroupManager(location, name string) (*compute.InstanceGroupManager, error) {
+	for _, igm := range s.state.InstanceGroupManagers {
+		if igm.Name == name && (lastSegment(igm.Zone) == location || lastSegment(igm.Region) == location) {
+			return igm, nil
+		}
+	}
+	return nil, notFound("instance group manager %s/%s not found", location, name)
+}
+
+func (s *Server) instanceTemplate(name string) (*compute.InstanceTemplate, error) {
+	for _, it := range s.state.InstanceTemplates {
+		if it.Name == name {
+			return it, nil
+		}
+	}
+	return nil, notFound("instance template %s not found", name)
+}
+
+// subnetworkURL returns the URL of the automatic subnetwork of a network in the region.
+func (s *Server) subnetworkURL(region, network string) string {
+	return fmt.Sprintf("%sprojects/%s/regions/%s/subnetworks/%s", computeSelfLink, s.state.ProjectID, region, network)
+}
+
+// converted returns whether the network is a VPC network, and so whether
+// clusters and node pools on the network are given a subnetwork when upgraded.
+func (s *Server) converted(network string) bool {
+	n, err := s.network(network)
+	return err == nil && n.IPv4Range == ""
+}
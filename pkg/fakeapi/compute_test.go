@@ -0,0 +1,128 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"context"
+	"testing"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServer_SwitchToCustomMode(t *testing.T) {
+	state := testState()
+	state.Script.Polls = 1
+	_, clients, stop := newTestServer(t, state)
+	defer stop()
+	ctx := context.Background()
+
+	op, err := clients.Compute.SwitchToCustomMode(ctx, testProject, testNetwork)
+	if err != nil {
+		t.Fatalf("SwitchToCustomMode unexpected error: %v", err)
+	}
+	// SwitchToCustomMode reads the operation once.
+	if op.Status != statusRunning {
+		t.Errorf("Operation status; wanted: %s, got: %s", statusRunning, op.Status)
+	}
+	op, err = clients.Compute.WaitOperation(ctx, testProject, op)
+	if err != nil {
+		t.Fatalf("WaitOperation unexpected error: %v", err)
+	}
+	if op.Status != statusDone || op.Error != nil {
+		t.Errorf("Operation; wanted: %s without error, got: %s, %v", statusDone, op.Status, op.Error)
+	}
+
+	n, err := clients.Compute.GetNetwork(ctx, testProject, testNetwork)
+	if err != nil {
+		t.Fatalf("GetNetwork unexpected error: %v", err)
+	}
+	if n.IPv4Range != "" {
+		t.Errorf("IPv4Range; wanted empty, got: %s", n.IPv4Range)
+	}
+	want := []string{computeSelfLink + "projects/test-project/regions/us-central1/subnetworks/" + testNetwork}
+	if diff := cmp.Diff(want, n.Subnetworks); diff != "" {
+		t.Errorf("Subnetworks diff (-want +got):\n%s", diff)
+	}
+
+	_, err = clients.Compute.SwitchToCustomMode(ctx, testProject, testNetwork)
+	if diff := test.ErrorDiff("is not a legacy network", err); diff != "" {
+		t.Errorf("SwitchToCustomMode of a VPC network diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestServer_ListNetworks(t *testing.T) {
+	_, clients, stop := newTestServer(t, testState())
+	defer stop()
+
+	got, err := clients.Compute.ListNetworks(context.Background(), testProject)
+	if err != nil {
+		t.Fatalf("ListNetworks unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(testState().Networks, got); diff != "" {
+		t.Errorf("ListNetworks diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestServer_InstanceGroupManagers(t *testing.T) {
+	_, clients, stop := newTestServer(t, testState())
+	defer stop()
+	ctx := context.Background()
+
+	cases := []struct {
+		desc     string
+		location string
+		name     string
+		wantErr  string
+	}{
+		{
+			desc:     "Zonal",
+			location: testZone,
+			name:     testIGM,
+		},
+		{
+			desc:     "Other zone",
+			location: "us-central1-b",
+			name:     testIGM,
+			wantErr:  "instance group manager us-central1-b/" + testIGM + " not found",
+		},
+		{
+			desc:     "Zonal, requested by region",
+			location: "us-central1",
+			name:     testIGM,
+			wantErr:  "instance group manager us-central1/" + testIGM + " not found",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			igm, err := clients.Compute.GetInstanceGroupManager(ctx, testProject, tc.location, tc.name)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Fatalf("GetInstanceGroupManager diff (-want +got):\n%s", diff)
+			}
+			if tc.wantErr != "" {
+				return
+			}
+			it, err := clients.Compute.GetInstanceTemplate(ctx, testProject, testTemplate)
+			if err != nil {
+				t.Fatalf("GetInstanceTemplate unexpected error: %v", err)
+			}
+			if igm.InstanceTemplate != computeSelfLink+"projects/test-project/global/instanceTemplates/"+it.Name {
+				t.Errorf("InstanceTemplate; wanted: %s, got: %s", it.Name, igm.InstanceTemplate)
+			}
+		})
+	}
+}
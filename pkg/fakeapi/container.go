@@ -0,0 +1,431 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/container/v1"
+)
+
+var (
+	// targetRegex matches the location and name of the cluster targeted by an operation.
+	targetRegex = regexp.MustCompile(`/locations/([^/]+)/clusters/([^/]+)(?:/|$)`)
+	// instanceGroupManagerRegex matches the location and name of an instance group manager URL.
+	instanceGroupManagerRegex = regexp.MustCompile(`/(?:zones|regions)/([^/]+)/instanceGroupManagers/([^/]+)$`)
+)
+
+const (
+	upgradeMaster = "UPGRADE_MASTER"
+	upgradeNodes  = "UPGRADE_NODES"
+	updateCluster = "UPDATE_CLUSTER"
+
+	// maxVersionSkew is the number of minor versions by which nodes may trail the control plane.
+	maxVersionSkew = 1
+)
+
+// serveContainer serves the GKE API methods used by the conversion:
+//  GET  projects/{p}/locations/{l}/serverConfig
+//  GET  projects/{p}/locations/{l}/clusters
+//  GET  projects/{p}/locations/{l}/clusters/{c}
+//  POST projects/{p}/locations/{l}/clusters/{c}:updateMaster
+//  POST projects/{p}/locations/{l}/clusters/{c}:setMaintenancePolicy
+//  GET  projects/{p}/locations/{l}/clusters/{c}/nodePools
+//  GET  projects/{p}/locations/{l}/clusters/{c}/nodePools/{np}
+//  PUT  projects/{p}/locations/{l}/clusters/{c}/nodePools/{np}
+//  GET  projects/{p}/locations/{l}/operations
+//  GET  projects/{p}/locations/{l}/operations/{o}
+//  POST projects/{p}/locations/{l}/operations/{o}:cancel
+//  POST projects/{p}:testIamPermissions (of the Resource Manager API)
+//
+// The location may be "-" to list clusters or operations in all locations.
+func (s *Server) serveContainer(r *http.Request, path string) (interface{}, error) {
+	var verb string
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		path, verb = path[:i], path[i+1:]
+	}
+	if verb == "testIamPermissions" && r.Method == http.MethodPost {
+		req := &cloudresourcemanager.TestIamPermissionsRequest{}
+		if err := readBody(r, req); err != nil {
+			return nil, err
+		}
+		return s.testIamPermissions(strings.TrimPrefix(path, "projects/"), req.Permissions)
+	}
+	location, parts, err := s.parseContainerPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	get, post, put := r.Method == http.MethodGet, r.Method == http.MethodPost, r.Method == http.MethodPut
+	switch {
+	case len(parts) == 1 && parts[0] == "serverConfig" && get:
+		return s.serverConfig()
+	case len(parts) == 1 && parts[0] == "clusters" && get:
+		return s.listClusters(location), nil
+	case len(parts) == 2 && parts[0] == "clusters" && get && verb == "":
+		return s.cluster(location, parts[1])
+	case len(parts) == 2 && parts[0] == "clusters" && post && verb == "updateMaster":
+		req := &container.UpdateMasterRequest{}
+		if err := readBody(r, req); err != nil {
+			return nil, err
+		}
+		return s.updateMaster(location, parts[1], req)
+	case len(parts) == 2 && parts[0] == "clusters" && post && verb == "setMaintenancePolicy":
+		req := &container.SetMaintenancePolicyRequest{}
+		if err := readBody(r, req); err != nil {
+			return nil, err
+		}
+		return s.setMaintenancePolicy(location, parts[1], req)
+	case len(parts) == 3 && parts[0] == "clusters" && parts[2] == "nodePools" && get:
+		return s.listNodePools(location, parts[1])
+	case len(parts) == 4 && parts[0] == "clusters" && parts[2] == "nodePools" && get:
+		_, np, err := s.nodePool(location, parts[1], parts[3])
+		return np, err
+	case len(parts) == 4 && parts[0] == "clusters" && parts[2] == "nodePools" && put:
+		req := &container.UpdateNodePoolRequest{}
+		if err := readBody(r, req); err != nil {
+			return nil, err
+		}
+		return s.updateNodePool(location, parts[1], parts[3], req)
+	case len(parts) == 1 && parts[0] == "operations" && get:
+		return s.listOperations(location), nil
+	case len(parts) == 2 && parts[0] == "operations" && get && verb == "":
+		return s.getOperation(parts[1])
+	case len(parts) == 2 && parts[0] == "operations" && post && verb == "cancel":
+		return struct{}{}, s.cancelOperation(parts[1])
+	}
+	return nil, notFound("%s %s", r.Method, r.URL.Path)
+}
+
+// parseContainerPath splits a GKE resource or location path into its location and the segments which follow it.
+func (s *Server) parseContainerPath(path string) (string, []string, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 4 || parts[0] != "projects" || parts[2] != "locations" {
+		return "", nil, notFound("resource %s not found", path)
+	}
+	if err := s.checkProject(parts[1]); err != nil {
+		return "", nil, err
+	}
+	return parts[3], parts[4:], nil
+}
+
+func (s *Server) serverConfig() (*container.ServerConfig, error) {
+	if s.state.ServerConfig == nil {
+		return nil, notFound("server config not found")
+	}
+	return s.state.ServerConfig, nil
+}
+
+func (s *Server) listClusters(location string) *container.ListClustersResponse {
+	resp := &container.ListClustersResponse{}
+	missing := make(map[string]bool)
+	for _, zone := range s.state.Script.UnavailableZones {
+		if matchLocation(location, zone) {
+			missing[zone] = true
+			resp.MissingZones = append(resp.MissingZones, zone)
+		}
+	}
+	if location == "-" {
+		for _, zone := range s.state.Script.MissingZones {
+			if !missing[zone] {
+				missing[zone] = true
+				resp.MissingZones = append(resp.MissingZones, zone)
+			}
+		}
+	}
+	for _, c := range s.state.Clusters {
+		if matchLocation(location, c.Location) && !missing[c.Location] {
+			resp.Clusters = append(resp.Clusters, c)
+		}
+	}
+	return resp
+}
+
+func (s *Server) listNodePools(location, cluster string) (*container.ListNodePoolsResponse, error) {
+	c, err := s.cluster(location, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return &container.ListNodePoolsResponse{NodePools: c.NodePools}, nil
+}
+
+func (s *Server) listOperations(location string) *container.ListOperationsResponse {
+	resp := &container.ListOperationsResponse{}
+	for _, op := range s.state.Operations {
+		if matchLocation(location, op.Location) {
+			resp.Operations = append(resp.Operations, op)
+		}
+	}
+	return resp
+}
+
+// getOperation returns the operation after advancing it by a poll.
+func (s *Server) getOperation(name string) (*container.Operation, error) {
+	op, err := s.containerOperation(name)
+	if err != nil {
+		return nil, err
+	}
+	s.poll(op)
+	return op.container, nil
+}
+
+func (s *Server) cancelOperation(name string) error {
+	op, err := s.containerOperation(name)
+	if err != nil {
+		return err
+	}
+	if !op.done() {
+		op.cancel()
+	}
+	return nil
+}
+
+func (s *Server) cluster(location, name string) (*container.Cluster, error) {
+	for _, c := range s.state.Clusters {
+		if c.Location == location && c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, notFound("cluster %s/%s not found", location, name)
+}
+
+func (s *Server) nodePool(location, cluster, name string) (*container.Cluster, *container.NodePool, error) {
+	c, err := s.cluster(location, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, np := range c.NodePools {
+		if np.Name == name {
+			return c, np, nil
+		}
+	}
+	return nil, nil, notFound("node pool %s/%s/%s not found", location, cluster, name)
+}
+
+// updateMaster starts a control plane upgrade. Once done, clusters on a VPC network are given a subnetwork.
+func (s *Server) updateMaster(location, name string, req *container.UpdateMasterRequest) (*container.Operation, error) {
+	c, err := s.cluster(location, name)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isValid(req.MasterVersion, true) {
+		return nil, badRequest("Master version %q is unsupported.", req.MasterVersion)
+	}
+	if err := s.checkConflict(c); err != nil {
+		return nil, err
+	}
+
+	version := req.MasterVersion
+	c.Status = statusReconciling
+	return s.startContainer(c, "", upgradeMaster, func() {
+		c.Status = statusRunning
+		c.CurrentMasterVersion = version
+		if c.Subnetwork == "" && s.converted(c.Network) {
+			c.Subnetwork = c.Network
+			if c.NetworkConfig == nil {
+				c.NetworkConfig = &container.NetworkConfig{}
+			}
+			c.NetworkConfig.Subnetwork = fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", s.state.ProjectID, region(c.Location), c.Network)
+		}
+	}), nil
+}
+
+// updateNodePool starts a node pool upgrade. Once done, the instance templates of node pools
+// on a VPC network are given a subnetwork.
+func (s *Server) updateNodePool(location, cluster, name string, req *container.UpdateNodePoolRequest) (*container.Operation, error) {
+	c, np, err := s.nodePool(location, cluster, name)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isValid(req.NodeVersion, false) {
+		return nil, badRequest("Node version %q is unsupported.", req.NodeVersion)
+	}
+	if err := checkVersionSkew(req.NodeVersion, c.CurrentMasterVersion); err != nil {
+		return nil, badRequest("Node version %q is not compatible with master version %q: %v", req.NodeVersion, c.CurrentMasterVersion, err)
+	}
+	if err := s.checkConflict(c); err != nil {
+		return nil, err
+	}
+
+	version := req.NodeVersion
+	np.Status = statusReconciling
+	return s.startContainer(c, name, upgradeNodes, func() {
+		np.Status = statusRunning
+		np.Version = version
+		if !s.converted(c.Network) {
+			return
+		}
+		for _, url := range np.InstanceGroupUrls {
+			m := instanceGroupManagerRegex.FindStringSubmatch(url)
+			if m == nil {
+				continue
+			}
+			igm, err := s.instanceGroupManager(m[1], m[2])
+			if err != nil {
+				continue
+			}
+			it, err := s.instanceTemplate(lastSegment(igm.InstanceTemplate))
+			if err != nil || it.Properties == nil {
+				continue
+			}
+			for _, ni := range it.Properties.NetworkInterfaces {
+				if ni.Subnetwork == "" {
+					ni.Subnetwork = s.subnetworkURL(region(c.Location), c.Network)
+				}
+			}
+		}
+	}), nil
+}
+
+// setMaintenancePolicy starts an operation which replaces the cluster's maintenance policy.
+func (s *Server) setMaintenancePolicy(location, name string, req *container.SetMaintenancePolicyRequest) (*container.Operation, error) {
+	c, err := s.cluster(location, name)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaintenancePolicy != nil && req.MaintenancePolicy != nil && req.MaintenancePolicy.ResourceVersion != c.MaintenancePolicy.ResourceVersion {
+		return nil, apiError(http.StatusPreconditionFailed, "conditionNotMet", "maintenance policy resource version %q does not match %q",
+			req.MaintenancePolicy.ResourceVersion, c.MaintenancePolicy.ResourceVersion)
+	}
+	if err := s.checkConflict(c); err != nil {
+		return nil, err
+	}
+	// Copy the policy, as the request may be reused by in-process callers.
+	var policy *container.MaintenancePolicy
+	if err := deepCopy(req.MaintenancePolicy, &policy); err != nil {
+		return nil, err
+	}
+
+	return s.startContainer(c, "", updateCluster, func() {
+		c.MaintenancePolicy = policy
+		if c.MaintenancePolicy != nil {
+			c.MaintenancePolicy.ResourceVersion = fmt.Sprintf("%08x", s.nextID)
+		}
+	}), nil
+}
+
+// checkConflict returns the error GKE returns when the cluster or one of its node pools is
+// already being changed by another operation.
+func (s *Server) checkConflict(c *container.Cluster) error {
+	for _, op := range s.state.Operations {
+		if op.Status == statusDone {
+			continue
+		}
+		if t := targetRegex.FindStringSubmatch(op.TargetLink); t != nil && t[1] == c.Location && t[2] == c.Name {
+			return apiError(http.StatusBadRequest, "failedPrecondition", "Cluster is running incompatible operation %s.", op.Name)
+		}
+	}
+	return nil
+}
+
+// startContainer starts a GKE operation on the cluster, or on the node pool if set.
+func (s *Server) startContainer(c *container.Cluster, nodePool, opType string, apply func()) *container.Operation {
+	target := fmt.Sprintf("%sprojects/%s/locations/%s/clusters/%s", containerSelfLink, s.state.ProjectID, c.Location, c.Name)
+	if nodePool != "" {
+		target += "/nodePools/" + nodePool
+	}
+	name := s.nextName()
+	op := &operation{
+		container: &container.Operation{
+			Name:          name,
+			OperationType: opType,
+			Status:        statusRunning,
+			Location:      c.Location,
+			Zone:          c.Location,
+			TargetLink:    target,
+			SelfLink:      fmt.Sprintf("%sprojects/%s/locations/%s/operations/%s", containerSelfLink, s.state.ProjectID, c.Location, name),
+			StartTime:     time.Now().UTC().Format(time.RFC3339),
+		},
+		apply: apply,
+	}
+	s.start(op, opType, target)
+	s.state.Operations = append(s.state.Operations, op.container)
+	return op.container
+}
+
+func (s *Server) containerOperation(name string) (*operation, error) {
+	op, ok := s.ops[name]
+	if !ok || op.container == nil {
+		return nil, notFound("operation %s not found", name)
+	}
+	return op, nil
+}
+
+// isValid returns whether the control plane or node version is valid, either for clusters
+// without a release channel or in any channel.
+func (s *Server) isValid(version string, master bool) bool {
+	sc := s.state.ServerConfig
+	if sc == nil {
+		return false
+	}
+	valid := sc.ValidNodeVersions
+	if master {
+		valid = sc.ValidMasterVersions
+	}
+	for _, v := range valid {
+		if v == version {
+			return true
+		}
+	}
+	for _, ch := range sc.Channels {
+		for _, v := range ch.ValidVersions {
+			if v == version {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchLocation(want, location string) bool {
+	return want == "-" || want == location
+}
+
+// checkVersionSkew returns an error if the node version is newer than the master version,
+// or more than maxVersionSkew minor versions older.
+func checkVersionSkew(node, master string) error {
+	n, err := minorVersion(node)
+	if err != nil {
+		return err
+	}
+	m, err := minorVersion(master)
+	if err != nil {
+		return err
+	}
+	if n > m || m-n > maxVersionSkew {
+		return fmt.Errorf("node minor version %d must be within %d minor version(s) below master minor version %d", n, maxVersionSkew, m)
+	}
+	return nil
+}
+
+// minorVersion returns the minor version of a GKE version, e.g. 19 for 1.19.10-gke.1700.
+func minorVersion(v string) (int, error) {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return 0, fmt.Errorf("invalid version %q", v)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid version %q: %w", v, err)
+	}
+	return minor, nil
+}
@@ -0,0 +1,304 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"context"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/operations"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/container/v1"
+)
+
+// waitContainer reads the operation until it is done.
+func waitContainer(t *testing.T, clients *pkg.Clients, op *container.Operation) *container.Operation {
+	t.Helper()
+	for op.Status != statusDone {
+		var err error
+		op, err = clients.Container.GetOperation(context.Background(), pkg.OperationsPath(testProject, op.Location, op.Name))
+		if err != nil {
+			t.Fatalf("GetOperation unexpected error: %v", err)
+		}
+	}
+	return op
+}
+
+func TestServer_UpdateMaster(t *testing.T) {
+	cases := []struct {
+		desc           string
+		converted      bool
+		version        string
+		want           string
+		wantSubnetwork string
+		wantErr        string
+	}{
+		{
+			desc:    "Legacy network",
+			version: "1.19.11-gke.1700",
+			want:    "1.19.11-gke.1700",
+		},
+		{
+			desc:           "VPC network",
+			converted:      true,
+			version:        "1.19.11-gke.1700",
+			want:           "1.19.11-gke.1700",
+			wantSubnetwork: testNetwork,
+		},
+		{
+			desc:    "Unsupported version",
+			version: "1.21.1-gke.1800",
+			want:    "1.19.10-gke.1700",
+			wantErr: `Master version "1.21.1-gke.1800" is unsupported`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			state := testState()
+			if tc.converted {
+				state.Networks[0].IPv4Range = ""
+			}
+			_, clients, stop := newTestServer(t, state)
+			defer stop()
+			ctx := context.Background()
+			path := pkg.ClusterPath(testProject, testZone, testCluster)
+
+			op, err := clients.Container.UpdateMaster(ctx, &container.UpdateMasterRequest{Name: path, MasterVersion: tc.version})
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("UpdateMaster diff (-want +got):\n%s", diff)
+			}
+			if err == nil {
+				waitContainer(t, clients, op)
+			}
+
+			c, err := clients.Container.GetCluster(ctx, path)
+			if err != nil {
+				t.Fatalf("GetCluster unexpected error: %v", err)
+			}
+			if c.CurrentMasterVersion != tc.want {
+				t.Errorf("CurrentMasterVersion; wanted: %s, got: %s", tc.want, c.CurrentMasterVersion)
+			}
+			if c.Subnetwork != tc.wantSubnetwork {
+				t.Errorf("Subnetwork; wanted: %q, got: %q", tc.wantSubnetwork, c.Subnetwork)
+			}
+		})
+	}
+}
+
+func TestServer_UpdateNodePool(t *testing.T) {
+	cases := []struct {
+		desc           string
+		converted      bool
+		version        string
+		want           string
+		wantSubnetwork string
+		wantErr        string
+	}{
+		{
+			desc:    "Legacy network",
+			version: "1.19.11-gke.1700",
+			want:    "1.19.11-gke.1700",
+		},
+		{
+			desc:           "VPC network",
+			converted:      true,
+			version:        "1.19.11-gke.1700",
+			want:           "1.19.11-gke.1700",
+			wantSubnetwork: computeSelfLink + "projects/test-project/regions/us-central1/subnetworks/" + testNetwork,
+		},
+		{
+			desc:    "Newer than control plane",
+			version: "1.20.7-gke.1800",
+			want:    "1.19.10-gke.1700",
+			wantErr: "is not compatible with master version",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			state := testState()
+			if tc.converted {
+				state.Networks[0].IPv4Range = ""
+			}
+			_, clients, stop := newTestServer(t, state)
+			defer stop()
+			ctx := context.Background()
+			path := pkg.NodePoolPath(testProject, testZone, testCluster, testNodePool)
+
+			op, err := clients.Container.UpdateNodePool(ctx, &container.UpdateNodePoolRequest{Name: path, NodeVersion: tc.version})
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("UpdateNodePool diff (-want +got):\n%s", diff)
+			}
+			if err == nil {
+				waitContainer(t, clients, op)
+			}
+
+			np, err := clients.Container.GetNodePool(ctx, path)
+			if err != nil {
+				t.Fatalf("GetNodePool unexpected error: %v", err)
+			}
+			if np.Version != tc.want {
+				t.Errorf("Version; wanted: %s, got: %s", tc.want, np.Version)
+			}
+			it, err := clients.Compute.GetInstanceTemplate(ctx, testProject, testTemplate)
+			if err != nil {
+				t.Fatalf("GetInstanceTemplate unexpected error: %v", err)
+			}
+			if got := it.Properties.NetworkInterfaces[0].Subnetwork; got != tc.wantSubnetwork {
+				t.Errorf("Subnetwork; wanted: %q, got: %q", tc.wantSubnetwork, got)
+			}
+		})
+	}
+}
+
+func TestServer_Conflicts(t *testing.T) {
+	state := testState()
+	state.Script.Polls = 1
+	state.Operations = []*container.Operation{
+		{
+			Name:          "operation-1-auto",
+			OperationType: upgradeNodes,
+			Status:        statusRunning,
+			Location:      testZone,
+			TargetLink:    containerSelfLink + "projects/test-project/locations/us-central1-a/clusters/cluster-a/nodePools/default-pool",
+			SelfLink:      containerSelfLink + "projects/test-project/locations/us-central1-a/operations/operation-1-auto",
+		},
+	}
+	_, clients, stop := newTestServer(t, state)
+	defer stop()
+	ctx := context.Background()
+	req := &container.UpdateMasterRequest{
+		Name:          pkg.ClusterPath(testProject, testZone, testCluster),
+		MasterVersion: "1.19.11-gke.1700",
+	}
+
+	_, err := clients.Container.UpdateMaster(ctx, req)
+	c, ok := operations.AsConflict(err)
+	if !ok {
+		t.Fatalf("UpdateMaster during another operation; wanted a conflict, got: %v", err)
+	}
+	if c.Operation != "operation-1-auto" {
+		t.Errorf("Conflict.Operation; wanted: operation-1-auto, got: %s", c.Operation)
+	}
+
+	resp, err := clients.Container.ListOperations(ctx, pkg.LocationPath(testProject, "-"))
+	if err != nil {
+		t.Fatalf("ListOperations unexpected error: %v", err)
+	}
+	if len(resp.Operations) != 1 {
+		t.Fatalf("ListOperations; wanted 1 operation, got: %d", len(resp.Operations))
+	}
+	waitContainer(t, clients, resp.Operations[0])
+
+	if _, err := clients.Container.UpdateMaster(ctx, req); err != nil {
+		t.Errorf("UpdateMaster after the operation unexpected error: %v", err)
+	}
+}
+
+func TestServer_CancelOperation(t *testing.T) {
+	state := testState()
+	state.Script.Polls = 5
+	_, clients, stop := newTestServer(t, state)
+	defer stop()
+	ctx := context.Background()
+	path := pkg.ClusterPath(testProject, testZone, testCluster)
+
+	op, err := clients.Container.UpdateMaster(ctx, &container.UpdateMasterRequest{Name: path, MasterVersion: "1.19.11-gke.1700"})
+	if err != nil {
+		t.Fatalf("UpdateMaster unexpected error: %v", err)
+	}
+	if err := clients.Container.CancelOperation(ctx, pkg.OperationsPath(testProject, testZone, op.Name)); err != nil {
+		t.Fatalf("CancelOperation unexpected error: %v", err)
+	}
+	op = waitContainer(t, clients, op)
+	if op.Error == nil {
+		t.Errorf("Cancelled operation; wanted an error")
+	}
+
+	c, err := clients.Container.GetCluster(ctx, path)
+	if err != nil {
+		t.Fatalf("GetCluster unexpected error: %v", err)
+	}
+	if c.CurrentMasterVersion != "1.19.10-gke.1700" {
+		t.Errorf("CurrentMasterVersion; wanted: 1.19.10-gke.1700, got: %s", c.CurrentMasterVersion)
+	}
+}
+
+func TestServer_ListClusters(t *testing.T) {
+	cases := []struct {
+		desc        string
+		location    string
+		script      Script
+		want        []string
+		wantMissing []string
+	}{
+		{
+			desc:     "All locations",
+			location: "-",
+			want:     []string{testCluster, "cluster-b"},
+		},
+		{
+			desc:     "Region",
+			location: "us-east1",
+			want:     []string{"cluster-b"},
+		},
+		{
+			desc:        "Missing zone in all locations",
+			location:    "-",
+			script:      Script{MissingZones: []string{testZone}},
+			want:        []string{"cluster-b"},
+			wantMissing: []string{testZone},
+		},
+		{
+			desc:     "Missing zone listed individually",
+			location: testZone,
+			script:   Script{MissingZones: []string{testZone}},
+			want:     []string{testCluster},
+		},
+		{
+			desc:        "Unavailable zone listed individually",
+			location:    testZone,
+			script:      Script{UnavailableZones: []string{testZone}},
+			wantMissing: []string{testZone},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			state := testState()
+			state.Clusters = append(state.Clusters, &container.Cluster{Name: "cluster-b", Location: "us-east1"})
+			state.Script = tc.script
+			_, clients, stop := newTestServer(t, state)
+			defer stop()
+
+			resp, err := clients.Container.ListClusters(context.Background(), pkg.LocationPath(testProject, tc.location))
+			if err != nil {
+				t.Fatalf("ListClusters unexpected error: %v", err)
+			}
+			var got []string
+			for _, c := range resp.Clusters {
+				got = append(got, c.Name)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ListClusters diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantMissing, resp.MissingZones); diff != "" {
+				t.Errorf("ListClusters missing zones diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeapi serves a stateful fake of the Compute and GKE APIs used by the conversion,
+// so that the conversion can be run end-to-end without network access.
+package fakeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Paths served for each API, relative to the base URL of the Server.
+	computeV1Path   = "/compute/v1/"
+	computeBetaPath = "/compute/beta/"
+	containerPath   = "/v1/"
+	statePath       = "/fakeapi/state"
+
+	computeSelfLink   = "https://www.googleapis.com/compute/v1/"
+	containerSelfLink = "https://container.googleapis.com/v1/"
+
+	statusPending = "PENDING"
+	statusRunning = "RUNNING"
+	statusDone    = "DONE"
+
+	statusReconciling = "RECONCILING"
+)
+
+// State is the state of the fake APIs, which may be loaded from a JSON file.
+// All resources belong to a single project.
+type State struct {
+	ProjectID             string                          `json:"projectId"`
+	Networks              []*compute.Network              `json:"networks,omitempty"`
+	InstanceGroupManagers []*compute.InstanceGroupManager `json:"instanceGroupManagers,omitempty"`
+	InstanceTemplates     []*compute.InstanceTemplate     `json:"instanceTemplates,omitempty"`
+	// Clusters include their NodePools.
+	Clusters []*container.Cluster `json:"clusters,omitempty"`
+	// ServerConfig is returned for every location.
+	ServerConfig *container.ServerConfig `json:"serverConfig,omitempty"`
+	// Operations are GKE operations not started by the conversion, e.g. auto-upgrades.
+	// Those which are running complete like any other operation, but have no effect.
+	Operations []*container.Operation `json:"operations,omitempty"`
+	// DeniedPermissions are the IAM permissions which the caller does not have on the project.
+	DeniedPermissions []string `json:"deniedPermissions,omitempty"`
+	Script            Script   `json:"script,omitempty"`
+}
+
+// Script controls how operations progress.
+type Script struct {
+	// Polls is the number of times an operation is read while running before it is done.
+	Polls int `json:"polls,omitempty"`
+	// Failures make matching operations end in error rather than taking effect.
+	Failures []Failure `json:"failures,omitempty"`
+	// MissingZones are reported as missing, and their clusters omitted, when clusters are listed in all
+	// locations. Clusters are listed when the zones are listed individually.
+	MissingZones []string `json:"missingZones,omitempty"`
+	// UnavailableZones are reported as missing, and their clusters omitted, whenever clusters are listed in them.
+	UnavailableZones []string `json:"unavailableZones,omitempty"`
+}
+
+// Failure makes the operations which match it end in error.
+type Failure struct {
+	// OperationType is e.g. UPGRADE_MASTER, UPGRADE_NODES or switchToCustomMode; any type if empty.
+	OperationType string `json:"operationType,omitempty"`
+	// Target is matched against the end of the operation's target link, e.g. clusters/c or nodePools/np; any target if empty.
+	Target  string `json:"target,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Count is the number of matching operations which fail; all if 0.
+	Count int `json:"count,omitempty"`
+}
+
+// LoadState reads a State from a JSON file.
+func LoadState(path string) (*State, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &State{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("error parsing state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// operation is a Compute or GKE operation started by a mutation.
+type operation struct {
+	compute   *compute.Operation
+	container *container.Operation
+	// polls is the number of reads remaining before the operation is done.
+	polls int
+	// failure, if set, is the error the operation ends with instead of calling apply.
+	failure string
+	apply   func()
+}
+
+// Server is an http.Handler serving the fake APIs:
+//  /compute/v1/ and /compute/beta/   Compute networks, operations, instance group managers and templates.
+//  /v1/                              GKE clusters, node pools, operations and server config, and project permissions.
+//  GET /fakeapi/state                The current State.
+//
+// Mutations start operations which are done after Script.Polls reads, and then update the State.
+type Server struct {
+	mu       sync.Mutex
+	state    State
+	ops      map[string]*operation
+	failures []Failure
+	nextID   int
+}
+
+// New returns a Server for a copy of the State.
+func New(state *State) (*Server, error) {
+	s := &Server{ops: make(map[string]*operation)}
+	if err := deepCopy(state, &s.state); err != nil {
+		return nil, err
+	}
+	s.failures = append(s.failures, s.state.Script.Failures...)
+	for _, op := range s.state.Operations {
+		if op.Status == statusDone {
+			continue
+		}
+		s.ops[op.Name] = &operation{container: op, polls: s.state.Script.Polls, apply: func() {}}
+	}
+	return s, nil
+}
+
+// State returns a copy of the current State.
+func (s *Server) State() (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := &State{}
+	if err := deepCopy(&s.state, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// ServeHTTP routes requests to the fake APIs.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var (
+		resp interface{}
+		err  error
+	)
+	switch p := r.URL.Path; {
+	case strings.HasPrefix(p, computeV1Path):
+		resp, err = s.serveCompute(r, strings.TrimPrefix(p, computeV1Path))
+	case strings.HasPrefix(p, computeBetaPath):
+		resp, err = s.serveCompute(r, strings.TrimPrefix(p, computeBetaPath))
+	case strings.HasPrefix(p, containerPath):
+		resp, err = s.serveContainer(r, strings.TrimPrefix(p, containerPath))
+	case p == statePath && r.Method == http.MethodGet:
+		resp = &s.state
+	default:
+		err = notFound("%s %s", r.Method, p)
+	}
+	// Marshal while holding the lock, as responses may refer to the State.
+	code := http.StatusOK
+	if err != nil {
+		gerr, ok := err.(*googleapi.Error)
+		if !ok {
+			gerr = &googleapi.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+		}
+		code, resp = gerr.Code, map[string]interface{}{"error": gerr}
+	}
+	b, merr := json.Marshal(resp)
+	s.mu.Unlock()
+
+	if merr != nil {
+		code, b = http.StatusInternalServerError, []byte(fmt.Sprintf(`{"error": {"code": 500, "message": %q}}`, merr.Error()))
+	}
+	log.Debugf("Fake API %s %s: %d", r.Method, r.URL.Path, code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(b); err != nil {
+		log.Errorf("Error writing response: %v", err)
+	}
+}
+
+// start records a new operation, which fails if it matches a Failure.
+// Must be called while holding the Server lock.
+func (s *Server) start(op *operation, opType, targetLink string) {
+	for i, f := range s.failures {
+		if (f.OperationType != "" && f.OperationType != opType) || !strings.HasSuffix(targetLink, f.Target) {
+			continue
+		}
+		op.failure = f.Message
+		if op.failure == "" {
+			op.failure = fmt.Sprintf("%s operation failed", opType)
+		}
+		if f.Count > 0 {
+			s.failures[i].Count--
+			if s.failures[i].Count == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		break
+	}
+	op.polls = s.state.Script.Polls
+	s.ops[name(op)] = op
+}
+
+// poll advances the operation by one read, completing it once no polls remain.
+// Must be called while holding the Server lock.
+func (s *Server) poll(op *operation) {
+	if op.done() {
+		return
+	}
+	if op.polls > 0 {
+		op.polls--
+		op.setStatus(statusRunning, "")
+		return
+	}
+	op.setStatus(statusDone, op.failure)
+	if op.failure == "" {
+		op.apply()
+	}
+}
+
+// nextName returns a unique operation name, in the format used by GKE and GCE.
+func (s *Server) nextName() string {
+	s.nextID++
+	return fmt.Sprintf("operation-%d-%08x", s.nextID, s.nextID)
+}
+
+func name(op *operation) string {
+	if op.compute != nil {
+		return op.compute.Name
+	}
+	return op.container.Name
+}
+
+func (op *operation) done() bool {
+	if op.compute != nil {
+		return op.compute.Status == statusDone
+	}
+	return op.container.Status == statusDone
+}
+
+func (op *operation) setStatus(status, failure string) {
+	if op.compute != nil {
+		op.compute.Status = status
+		if status == statusDone {
+			op.compute.Progress = 100
+		}
+		if failure != "" {
+			op.compute.Error = &compute.OperationError{Errors: []*compute.OperationErrorErrors{{Code: "OPERATION_FAILED", Message: failure}}}
+		}
+		return
+	}
+	op.container.Status = status
+	if status == statusDone {
+		op.container.EndTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	if failure != "" {
+		op.container.Error = &container.Status{Code: 2, Message: failure}
+		op.container.StatusMessage = failure
+	}
+}
+
+// cancel ends the operation with an error, without it taking effect.
+func (op *operation) cancel() {
+	op.polls = 0
+	op.failure = "Operation was cancelled."
+	op.apply = func() {}
+}
+
+// deepCopy copies from into to, which must be a pointer, by JSON round trip.
+func deepCopy(from, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+// readBody decodes the JSON request body into v.
+func readBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func notFound(format string, args ...interface{}) error {
+	return apiError(http.StatusNotFound, "notFound", format, args...)
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return apiError(http.StatusBadRequest, "invalid", format, args...)
+}
+
+// apiError returns an error which is encoded like the errors of Google APIs.
+func apiError(code int, reason, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return &googleapi.Error{
+		Code:    code,
+		Message: msg,
+		Errors:  []googleapi.ErrorItem{{Reason: reason, Message: msg}},
+	}
+}
+
+// lastSegment returns the name at the end of a URL or path.
+func lastSegment(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
+
+// region returns the region of a zone or region.
+func region(location string) string {
+	if parts := strings.Split(location, "-"); len(parts) == 3 {
+		return strings.Join(parts[:2], "-")
+	}
+	return location
+}
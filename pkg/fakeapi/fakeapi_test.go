@@ -0,0 +1,336 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const (
+	testProject  = "test-project"
+	testNetwork  = "legacy-network"
+	testZone     = "us-central1-a"
+	testCluster  = "cluster-a"
+	testNodePool = "default-pool"
+	testIGM      = "gke-cluster-a-default-pool-grp"
+	testTemplate = "gke-cluster-a-default-pool-tmpl"
+)
+
+func testState() *State {
+	return &State{
+		ProjectID: testProject,
+		Networks: []*compute.Network{
+			{Name: testNetwork, IPv4Range: "10.0.0.0/8", GatewayIPv4: "10.0.0.1"},
+		},
+		InstanceGroupManagers: []*compute.InstanceGroupManager{
+			{
+				Name:             testIGM,
+				Zone:             computeSelfLink + "projects/test-project/zones/us-central1-a",
+				InstanceTemplate: computeSelfLink + "projects/test-project/global/instanceTemplates/" + testTemplate,
+			},
+		},
+		InstanceTemplates: []*compute.InstanceTemplate{
+			{
+				Name: testTemplate,
+				Properties: &compute.InstanceProperties{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{Network: computeSelfLink + "projects/test-project/global/networks/" + testNetwork},
+					},
+				},
+			},
+		},
+		Clusters: []*container.Cluster{
+			{
+				Name:                 testCluster,
+				Location:             testZone,
+				Network:              testNetwork,
+				Status:               statusRunning,
+				CurrentMasterVersion: "1.19.10-gke.1700",
+				NodePools: []*container.NodePool{
+					{
+						Name:              testNodePool,
+						Version:           "1.19.10-gke.1700",
+						Status:            statusRunning,
+						InstanceGroupUrls: []string{computeSelfLink + "projects/test-project/zones/us-central1-a/instanceGroupManagers/" + testIGM},
+					},
+				},
+			},
+		},
+		ServerConfig: &container.ServerConfig{
+			DefaultClusterVersion: "1.19.10-gke.1700",
+			ValidMasterVersions:   []string{"1.20.7-gke.1800", "1.19.11-gke.1700", "1.19.10-gke.1700"},
+			ValidNodeVersions:     []string{"1.20.7-gke.1800", "1.19.11-gke.1700", "1.19.10-gke.1700"},
+		},
+	}
+}
+
+// newTestServer serves the State, returning clients for the Server and a function to stop it.
+func newTestServer(t *testing.T, state *State) (*Server, *pkg.Clients, func()) {
+	t.Helper()
+	srv, err := New(state)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	hs := httptest.NewServer(srv)
+
+	ctx := context.Background()
+	v1, err := compute.NewService(ctx, option.WithHTTPClient(hs.Client()), option.WithEndpoint(hs.URL+computeV1Path))
+	if err != nil {
+		t.Fatalf("compute.NewService unexpected error: %v", err)
+	}
+	beta, err := computebeta.NewService(ctx, option.WithHTTPClient(hs.Client()), option.WithEndpoint(hs.URL+computeBetaPath))
+	if err != nil {
+		t.Fatalf("computebeta.NewService unexpected error: %v", err)
+	}
+	c, err := container.NewService(ctx, option.WithHTTPClient(hs.Client()), option.WithEndpoint(hs.URL+"/"))
+	if err != nil {
+		t.Fatalf("container.NewService unexpected error: %v", err)
+	}
+	clients := &pkg.Clients{
+		Compute:   &pkg.Compute{V1: v1, Beta: beta},
+		Container: &pkg.Container{V1: c},
+	}
+	return srv, clients, hs.Close
+}
+
+func TestLoadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakeapi")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "state.json")
+	b, err := json.Marshal(testState())
+	if err != nil {
+		t.Fatalf("Unable to marshal state: %v", err)
+	}
+	if err := ioutil.WriteFile(valid, b, 0644); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := ioutil.WriteFile(malformed, []byte("{"), 0644); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+
+	cases := []struct {
+		desc    string
+		path    string
+		want    *State
+		wantErr string
+	}{
+		{
+			desc: "Valid",
+			path: valid,
+			want: testState(),
+		},
+		{
+			desc:    "Malformed",
+			path:    malformed,
+			wantErr: "error parsing state",
+		},
+		{
+			desc:    "Missing",
+			path:    filepath.Join(dir, "missing.json"),
+			wantErr: "no such file",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := LoadState(tc.path)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("LoadState error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("LoadState diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestServer_Polls(t *testing.T) {
+	state := testState()
+	state.Script.Polls = 2
+	srv, clients, stop := newTestServer(t, state)
+	defer stop()
+	ctx := context.Background()
+
+	op, err := clients.Container.UpdateMaster(ctx, &container.UpdateMasterRequest{
+		Name:          pkg.ClusterPath(testProject, testZone, testCluster),
+		MasterVersion: "1.19.11-gke.1700",
+	})
+	if err != nil {
+		t.Fatalf("UpdateMaster unexpected error: %v", err)
+	}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		resp, err := clients.Container.GetOperation(ctx, pkg.OperationsPath(testProject, testZone, op.Name))
+		if err != nil {
+			t.Fatalf("GetOperation unexpected error: %v", err)
+		}
+		got = append(got, resp.Status)
+		if i == 1 {
+			s, _ := srv.State()
+			if v := s.Clusters[0].CurrentMasterVersion; v != "1.19.10-gke.1700" {
+				t.Errorf("CurrentMasterVersion before the operation is done; wanted: 1.19.10-gke.1700, got: %s", v)
+			}
+		}
+	}
+	if diff := cmp.Diff([]string{statusRunning, statusRunning, statusDone}, got); diff != "" {
+		t.Errorf("Operation status diff (-want +got):\n%s", diff)
+	}
+	s, err := srv.State()
+	if err != nil {
+		t.Fatalf("State unexpected error: %v", err)
+	}
+	if v := s.Clusters[0].CurrentMasterVersion; v != "1.19.11-gke.1700" {
+		t.Errorf("CurrentMasterVersion; wanted: 1.19.11-gke.1700, got: %s", v)
+	}
+}
+
+func TestServer_Failures(t *testing.T) {
+	state := testState()
+	state.Script.Failures = []Failure{
+		{OperationType: upgradeMaster, Target: "clusters/" + testCluster, Message: "upgrade failed", Count: 1},
+	}
+	srv, clients, stop := newTestServer(t, state)
+	defer stop()
+	ctx := context.Background()
+
+	var got []string
+	for _, v := range []string{"1.19.11-gke.1700", "1.20.7-gke.1800"} {
+		op, err := clients.Container.UpdateMaster(ctx, &container.UpdateMasterRequest{
+			Name:          pkg.ClusterPath(testProject, testZone, testCluster),
+			MasterVersion: v,
+		})
+		if err != nil {
+			t.Fatalf("UpdateMaster unexpected error: %v", err)
+		}
+		resp, err := clients.Container.GetOperation(ctx, pkg.OperationsPath(testProject, testZone, op.Name))
+		if err != nil {
+			t.Fatalf("GetOperation unexpected error: %v", err)
+		}
+		msg := ""
+		if resp.Error != nil {
+			msg = resp.Error.Message
+		}
+		got = append(got, msg)
+	}
+	if diff := cmp.Diff([]string{"upgrade failed", ""}, got); diff != "" {
+		t.Errorf("Operation errors diff (-want +got):\n%s", diff)
+	}
+	s, err := srv.State()
+	if err != nil {
+		t.Fatalf("State unexpected error: %v", err)
+	}
+	if v := s.Clusters[0].CurrentMasterVersion; v != "1.20.7-gke.1800" {
+		t.Errorf("CurrentMasterVersion; wanted: 1.20.7-gke.1800, got: %s", v)
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	_, clients, stop := newTestServer(t, testState())
+	defer stop()
+	ctx := context.Background()
+
+	cases := []struct {
+		desc     string
+		call     func() error
+		wantCode int
+		wantErr  string
+	}{
+		{
+			desc: "Other project",
+			call: func() error {
+				_, err := clients.Compute.GetNetwork(ctx, "other-project", testNetwork)
+				return err
+			},
+			wantCode: http.StatusNotFound,
+			wantErr:  "project other-project not found",
+		},
+		{
+			desc: "Missing cluster",
+			call: func() error {
+				_, err := clients.Container.GetCluster(ctx, pkg.ClusterPath(testProject, testZone, "missing"))
+				return err
+			},
+			wantCode: http.StatusNotFound,
+			wantErr:  "cluster us-central1-a/missing not found",
+		},
+		{
+			desc: "Missing operation",
+			call: func() error {
+				_, err := clients.Compute.GetGlobalOperation(ctx, testProject, "operation-missing")
+				return err
+			},
+			wantCode: http.StatusNotFound,
+			wantErr:  "operation operation-missing not found",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.call()
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("error diff (-want +got):\n%s", diff)
+			}
+			var gerr *googleapi.Error
+			if !errors.As(err, &gerr) || gerr.Code != tc.wantCode {
+				t.Errorf("error code; wanted: %d, got: %v", tc.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestServer_State(t *testing.T) {
+	srv, err := New(testState())
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	resp, err := http.Get(hs.URL + statePath)
+	if err != nil {
+		t.Fatalf("GET %s unexpected error: %v", statePath, err)
+	}
+	defer resp.Body.Close()
+	got := &State{}
+	if err := json.NewDecoder(resp.Body).Decode(got); err != nil {
+		t.Fatalf("Unable to decode state: %v", err)
+	}
+	if diff := cmp.Diff(testState(), got); diff != "" {
+		t.Errorf("State diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// testIamPermissions returns the permissions which are not denied by the State.
+func (s *Server) testIamPermissions(project string, permissions []string) (*cloudresourcemanager.TestIamPermissionsResponse, error) {
+	if err := s.checkProject(project); err != nil {
+		return nil, err
+	}
+	denied := make(map[string]bool)
+	for _, p := range s.state.DeniedPermissions {
+		denied[p] = true
+	}
+	resp := &cloudresourcemanager.TestIamPermissionsResponse{}
+	for _, p := range permissions {
+		if !denied[p] {
+			resp.Permissions = append(resp.Permissions, p)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/option"
+)
+
+func TestServer_TestIamPermissions(t *testing.T) {
+	state := testState()
+	state.DeniedPermissions = []string{"container.clusters.update"}
+	srv, err := New(state)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	ctx := context.Background()
+	svc, err := cloudresourcemanager.NewService(ctx, option.WithHTTPClient(hs.Client()), option.WithEndpoint(hs.URL+"/"))
+	if err != nil {
+		t.Fatalf("cloudresourcemanager.NewService unexpected error: %v", err)
+	}
+	permissions := []string{"compute.networks.get", "container.clusters.update"}
+
+	for desc, rm := range map[string]pkg.ResourceManagerService{
+		"HTTP":       &pkg.ResourceManager{V1: svc},
+		"In-process": srv.Clients().ResourceManager,
+	} {
+		t.Run(desc, func(t *testing.T) {
+			got, err := rm.TestIamPermissions(ctx, testProject, permissions)
+			if err != nil {
+				t.Fatalf("TestIamPermissions unexpected error: %v", err)
+			}
+			if diff := cmp.Diff([]string{"compute.networks.get"}, got); diff != "" {
+				t.Errorf("TestIamPermissions diff (-want +got):\n%s", diff)
+			}
+			_, err = rm.TestIamPermissions(ctx, "other", permissions)
+			if diff := test.ErrorDiff("project other not found", err); diff != "" {
+				t.Errorf("TestIamPermissions of another project diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
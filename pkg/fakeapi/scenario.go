@@ -0,0 +1,112 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+)
+
+// Scenario describes a legacy network and the clusters on it, from which a complete State
+// is built with an instance group manager and instance template per node pool and zone.
+type Scenario struct {
+	ProjectID string
+	Network   string
+	Clusters  []ScenarioCluster
+	// ServerConfig is returned for every location.
+	ServerConfig *container.ServerConfig
+	// Operations are GKE operations not started by the conversion, e.g. auto-upgrades.
+	Operations []*container.Operation
+	Script     Script
+}
+
+// ScenarioCluster is a cluster of a Scenario.
+type ScenarioCluster struct {
+	Name string
+	// Location is a zone, or a region for regional clusters.
+	// Node pools of regional clusters have instance groups in the zones <region>-a, -b and -c.
+	Location  string
+	Version   string
+	NodePools []ScenarioNodePool
+}
+
+// ScenarioNodePool is a node pool of a ScenarioCluster.
+type ScenarioNodePool struct {
+	Name string
+	// Version defaults to the cluster's version.
+	Version string
+}
+
+// State returns the State described by the Scenario.
+func (sc *Scenario) State() *State {
+	s := &State{
+		ProjectID: sc.ProjectID,
+		Networks: []*compute.Network{
+			{Name: sc.Network, IPv4Range: "10.0.0.0/8", GatewayIPv4: "10.0.0.1"},
+		},
+		ServerConfig: sc.ServerConfig,
+		Operations:   sc.Operations,
+		Script:       sc.Script,
+	}
+	network := fmt.Sprintf("%sprojects/%s/global/networks/%s", computeSelfLink, sc.ProjectID, sc.Network)
+	for _, c := range sc.Clusters {
+		cluster := &container.Cluster{
+			Name:                 c.Name,
+			Location:             c.Location,
+			Network:              sc.Network,
+			Status:               statusRunning,
+			CurrentMasterVersion: c.Version,
+		}
+		for _, np := range c.NodePools {
+			version := np.Version
+			if version == "" {
+				version = c.Version
+			}
+			nodePool := &container.NodePool{Name: np.Name, Version: version, Status: statusRunning}
+			template := fmt.Sprintf("gke-%s-%s-tmpl", c.Name, np.Name)
+			for _, zone := range zones(c.Location) {
+				igm := fmt.Sprintf("gke-%s-%s-%s-grp", c.Name, np.Name, zone[strings.LastIndex(zone, "-")+1:])
+				zoneURL := fmt.Sprintf("%sprojects/%s/zones/%s", computeSelfLink, sc.ProjectID, zone)
+				s.InstanceGroupManagers = append(s.InstanceGroupManagers, &compute.InstanceGroupManager{
+					Name:             igm,
+					Zone:             zoneURL,
+					InstanceTemplate: fmt.Sprintf("%sprojects/%s/global/instanceTemplates/%s", computeSelfLink, sc.ProjectID, template),
+				})
+				nodePool.InstanceGroupUrls = append(nodePool.InstanceGroupUrls, zoneURL+"/instanceGroupManagers/"+igm)
+			}
+			s.InstanceTemplates = append(s.InstanceTemplates, &compute.InstanceTemplate{
+				Name: template,
+				Properties: &compute.InstanceProperties{
+					NetworkInterfaces: []*compute.NetworkInterface{{Network: network}},
+				},
+			})
+			cluster.NodePools = append(cluster.NodePools, nodePool)
+		}
+		s.Clusters = append(s.Clusters, cluster)
+	}
+	return s
+}
+
+// zones returns the zone of a zonal location, or three zones of a region.
+func zones(location string) []string {
+	if region(location) != location {
+		return []string{location}
+	}
+	return []string{location + "-a", location + "-b", location + "-c"}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fakeapi
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/container/v1"
+)
+
+func testScenario() *Scenario {
+	return &Scenario{
+		ProjectID: testProject,
+		Network:   testNetwork,
+		Clusters: []ScenarioCluster{
+			{
+				Name:      testCluster,
+				Location:  testZone,
+				Version:   "1.19.10-gke.1700",
+				NodePools: []ScenarioNodePool{{Name: testNodePool}},
+			},
+		},
+		ServerConfig: testState().ServerConfig,
+	}
+}
+
+func TestScenario_State(t *testing.T) {
+	cases := []struct {
+		desc     string
+		scenario func(*Scenario)
+		want     func(*State)
+	}{
+		{
+			desc:     "Zonal cluster",
+			scenario: func(*Scenario) {},
+			want: func(s *State) {
+				s.InstanceGroupManagers[0].Name = "gke-cluster-a-default-pool-a-grp"
+				s.Clusters[0].NodePools[0].InstanceGroupUrls[0] = computeSelfLink + "projects/test-project/zones/us-central1-a/instanceGroupManagers/gke-cluster-a-default-pool-a-grp"
+			},
+		},
+		{
+			desc: "Regional cluster with an older node pool",
+			scenario: func(sc *Scenario) {
+				sc.Clusters[0].Location = "us-central1"
+				sc.Clusters[0].NodePools[0].Version = "1.18.20-gke.900"
+			},
+			want: func(s *State) {
+				s.Clusters[0].Location = "us-central1"
+				np := s.Clusters[0].NodePools[0]
+				np.Version = "1.18.20-gke.900"
+				np.InstanceGroupUrls = nil
+				s.InstanceGroupManagers = nil
+				for _, z := range []string{"a", "b", "c"} {
+					igm := *testState().InstanceGroupManagers[0]
+					igm.Name = "gke-cluster-a-default-pool-" + z + "-grp"
+					igm.Zone = computeSelfLink + "projects/test-project/zones/us-central1-" + z
+					s.InstanceGroupManagers = append(s.InstanceGroupManagers, &igm)
+					np.InstanceGroupUrls = append(np.InstanceGroupUrls, igm.Zone+"/instanceGroupManagers/"+igm.Name)
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			sc := testScenario()
+			tc.scenario(sc)
+			want := testState()
+			tc.want(want)
+
+			got := sc.State()
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Scenario.State diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScenario_StateServes(t *testing.T) {
+	sc := testScenario()
+	sc.Operations = []*container.Operation{{Name: "operation-1-auto", Status: statusRunning}}
+	srv, err := New(sc.State())
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	if _, ok := srv.ops["operation-1-auto"]; !ok {
+		t.Errorf("New did not track the running operation of the Scenario")
+	}
+}
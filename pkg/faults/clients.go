@@ -0,0 +1,235 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package faults
+
+import (
+	"context"
+	"fmt"
+
+	"legacymigration/pkg"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// computeClient injects faults into the calls to a ComputeService.
+type computeClient struct {
+	pkg.ComputeService
+	i *Injector
+}
+
+func (c *computeClient) GetInstanceGroupManager(ctx context.Context, project, location, name string, opts ...googleapi.CallOption) (*compute.InstanceGroupManager, error) {
+	target := fmt.Sprintf("projects/%s/%s/instanceGroupManagers/%s", project, location, name)
+	if err := c.i.before(ctx, "GetInstanceGroupManager", target); err != nil {
+		return nil, err
+	}
+	return c.ComputeService.GetInstanceGroupManager(ctx, project, location, name, opts...)
+}
+
+func (c *computeClient) GetInstanceTemplate(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.InstanceTemplate, error) {
+	target := fmt.Sprintf("projects/%s/global/instanceTemplates/%s", project, name)
+	if err := c.i.before(ctx, "GetInstanceTemplate", target); err != nil {
+		return nil, err
+	}
+	return c.ComputeService.GetInstanceTemplate(ctx, project, name, opts...)
+}
+
+func (c *computeClient) GetGlobalOperation(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	target := fmt.Sprintf("projects/%s/global/operations/%s", project, name)
+	if err := c.i.before(ctx, "GetGlobalOperation", target); err != nil {
+		return nil, err
+	}
+	op, err := c.ComputeService.GetGlobalOperation(ctx, project, name, opts...)
+	return c.i.computeOperation("GetGlobalOperation", target, op), err
+}
+
+func (c *computeClient) WaitOperation(ctx context.Context, project string, op *compute.Operation, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	target := fmt.Sprintf("projects/%s/operations/%s", project, op.Name)
+	if err := c.i.before(ctx, "WaitOperation", target); err != nil {
+		return nil, err
+	}
+	op, err := c.ComputeService.WaitOperation(ctx, project, op, opts...)
+	return c.i.computeOperation("WaitOperation", target, op), err
+}
+
+func (c *computeClient) SwitchToCustomMode(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.Operation, error) {
+	target := pkg.NetworkPath(project, name)
+	if err := c.i.before(ctx, "SwitchToCustomMode", target); err != nil {
+		return nil, err
+	}
+	op, err := c.ComputeService.SwitchToCustomMode(ctx, project, name, opts...)
+	return c.i.computeOperation("SwitchToCustomMode", target, op), err
+}
+
+func (c *computeClient) GetNetwork(ctx context.Context, project, name string, opts ...googleapi.CallOption) (*compute.Network, error) {
+	target := pkg.NetworkPath(project, name)
+	if err := c.i.before(ctx, "GetNetwork", target); err != nil {
+		return nil, err
+	}
+	return c.ComputeService.GetNetwork(ctx, project, name, opts...)
+}
+
+func (c *computeClient) ListNetworks(ctx context.Context, project string) ([]*compute.Network, error) {
+	target := fmt.Sprintf("projects/%s/global/networks", project)
+	if err := c.i.before(ctx, "ListNetworks", target); err != nil {
+		return nil, err
+	}
+	return c.ComputeService.ListNetworks(ctx, project)
+}
+
+// containerClient injects faults into the calls to a ContainerService.
+type containerClient struct {
+	pkg.ContainerService
+	i *Injector
+}
+
+func (c *containerClient) UpdateMaster(ctx context.Context, req *container.UpdateMasterRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	target := req.Name
+	if err := c.i.before(ctx, "UpdateMaster", target); err != nil {
+		return nil, err
+	}
+	op, err := c.ContainerService.UpdateMaster(ctx, req, opts...)
+	return c.i.containerOperation("UpdateMaster", target, op), err
+}
+
+func (c *containerClient) GetCluster(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.Cluster, error) {
+	target := name
+	if err := c.i.before(ctx, "GetCluster", target); err != nil {
+		return nil, err
+	}
+	return c.ContainerService.GetCluster(ctx, name, opts...)
+}
+
+func (c *containerClient) ListClusters(ctx context.Context, parent string, opts ...googleapi.CallOption) (*container.ListClustersResponse, error) {
+	target := parent
+	if err := c.i.before(ctx, "ListClusters", target); err != nil {
+		return nil, err
+	}
+	return c.ContainerService.ListClusters(ctx, parent, opts...)
+}
+
+func (c *containerClient) GetOperation(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.Operation, error) {
+	target := name
+	if err := c.i.before(ctx, "GetOperation", target); err != nil {
+		return nil, err
+	}
+	op, err := c.ContainerService.GetOperation(ctx, name, opts...)
+	return c.i.containerOperation("GetOperation", target, op), err
+}
+
+func (c *containerClient) CancelOperation(ctx context.Context, name string, opts ...googleapi.CallOption) error {
+	target := name
+	if err := c.i.before(ctx, "CancelOperation", target); err != nil {
+		return err
+	}
+	return c.ContainerService.CancelOperation(ctx, name, opts...)
+}
+
+func (c *containerClient) ListOperations(ctx context.Context, parent string, opts ...googleapi.CallOption) (*container.ListOperationsResponse, error) {
+	target := parent
+	if err := c.i.before(ctx, "ListOperations", target); err != nil {
+		return nil, err
+	}
+	return c.ContainerService.ListOperations(ctx, parent, opts...)
+}
+
+func (c *containerClient) SetMaintenancePolicy(ctx context.Context, req *container.SetMaintenancePolicyRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	target := req.Name
+	if err := c.i.before(ctx, "SetMaintenancePolicy", target); err != nil {
+		return nil, err
+	}
+	op, err := c.ContainerService.SetMaintenancePolicy(ctx, req, opts...)
+	return c.i.containerOperation("SetMaintenancePolicy", target, op), err
+}
+
+func (c *containerClient) UpdateNodePool(ctx context.Context, req *container.UpdateNodePoolRequest, opts ...googleapi.CallOption) (*container.Operation, error) {
+	target := req.Name
+	if err := c.i.before(ctx, "UpdateNodePool", target); err != nil {
+		return nil, err
+	}
+	op, err := c.ContainerService.UpdateNodePool(ctx, req, opts...)
+	return c.i.containerOperation("UpdateNodePool", target, op), err
+}
+
+func (c *containerClient) GetNodePool(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.NodePool, error) {
+	target := name
+	if err := c.i.before(ctx, "GetNodePool", target); err != nil {
+		return nil, err
+	}
+	return c.ContainerService.GetNodePool(ctx, name, opts...)
+}
+
+func (c *containerClient) ListNodePools(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.ListNodePoolsResponse, error) {
+	target := name
+	if err := c.i.before(ctx, "ListNodePools", target); err != nil {
+		return nil, err
+	}
+	return c.ContainerService.ListNodePools(ctx, name, opts...)
+}
+
+func (c *containerClient) GetServerConfig(ctx context.Context, name string, opts ...googleapi.CallOption) (*container.ServerConfig, error) {
+	target := name
+	if err := c.i.before(ctx, "GetServerConfig", target); err != nil {
+		return nil, err
+	}
+	return c.ContainerService.GetServerConfig(ctx, name, opts...)
+}
+
+// resourceManagerClient injects faults into the calls to a ResourceManagerService.
+type resourceManagerClient struct {
+	pkg.ResourceManagerService
+	i *Injector
+}
+
+func (c *resourceManagerClient) TestIamPermissions(ctx context.Context, project string, permissions []string, opts ...googleapi.CallOption) ([]string, error) {
+	target := fmt.Sprintf("projects/%s", project)
+	if err := c.i.before(ctx, "TestIamPermissions", target); err != nil {
+		return nil, err
+	}
+	return c.ResourceManagerService.TestIamPermissions(ctx, project, permissions, opts...)
+}
+
+// computeOperation returns a copy of the operation ended in error if an OperationError fault is injected.
+func (i *Injector) computeOperation(method, target string, op *compute.Operation) *compute.Operation {
+	if op == nil {
+		return nil
+	}
+	msg, ok := i.failOperation(method, target, op.Name, op.TargetLink)
+	if !ok {
+		return op
+	}
+	failed := *op
+	failed.Status = "DONE"
+	failed.Error = &compute.OperationError{Errors: []*compute.OperationErrorErrors{{Code: "INJECTED", Message: msg}}}
+	return &failed
+}
+
+// containerOperation returns a copy of the operation ended in error if an OperationError fault is injected.
+func (i *Injector) containerOperation(method, target string, op *container.Operation) *container.Operation {
+	if op == nil {
+		return nil
+	}
+	msg, ok := i.failOperation(method, target, op.Name, op.TargetLink)
+	if !ok {
+		return op
+	}
+	failed := *op
+	failed.Status = "DONE"
+	failed.StatusMessage = msg
+	failed.Error = &container.Status{Code: 13, Message: msg}
+	return &failed
+}
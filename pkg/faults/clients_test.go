@@ -0,0 +1,88 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package faults
+
+import (
+	"context"
+	"testing"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/container/v1"
+)
+
+func TestWrap(t *testing.T) {
+	i, err := New(&Config{Faults: []Fault{
+		{Kind: Error, Method: "GetNetwork", Target: "networks/" + test.SelectedNetwork, Count: 1},
+		{Kind: OperationError, Method: "GetOperation", Message: "upgrade failed"},
+		{Kind: OperationError, Method: "SwitchToCustomMode"},
+	}})
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	sc := &fakeapi.Scenario{
+		ProjectID: test.ProjectName,
+		Network:   test.SelectedNetwork,
+		Clusters: []fakeapi.ScenarioCluster{
+			{Name: test.ClusterName, Location: test.RegionA, Version: "1.19.10-gke.1700"},
+		},
+		ServerConfig: test.ServerConfig(),
+		Operations:   []*container.Operation{{Name: "operation-1", Status: "RUNNING", Location: test.RegionA}},
+	}
+	srv, err := fakeapi.New(sc.State())
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	clients := i.Wrap(srv.Clients())
+	ctx := context.Background()
+
+	if _, err := clients.Compute.GetNetwork(ctx, test.ProjectName, test.SelectedNetwork); err == nil {
+		t.Errorf("GetNetwork; wanted an injected error")
+	}
+	if _, err := clients.Compute.GetNetwork(ctx, test.ProjectName, test.SelectedNetwork); err != nil {
+		t.Errorf("GetNetwork after the fault unexpected error: %v", err)
+	}
+
+	op, err := clients.Container.GetOperation(ctx, pkg.OperationsPath(test.ProjectName, test.RegionA, "operation-1"))
+	if err != nil {
+		t.Fatalf("GetOperation unexpected error: %v", err)
+	}
+	want := []string{"DONE", "upgrade failed"}
+	if diff := cmp.Diff(want, []string{op.Status, op.Error.Message}); diff != "" {
+		t.Errorf("GetOperation (status, error) diff (-want +got):\n%s", diff)
+	}
+
+	cop, err := clients.Compute.SwitchToCustomMode(ctx, test.ProjectName, test.SelectedNetwork)
+	if err != nil {
+		t.Fatalf("SwitchToCustomMode unexpected error: %v", err)
+	}
+	if cop.Status != "DONE" || cop.Error == nil || cop.Error.Errors[0].Message != "injected operation error" {
+		t.Errorf("SwitchToCustomMode; wanted an operation ended in error, got: %+v", cop)
+	}
+
+	if _, err := clients.Container.UpdateMaster(ctx, &container.UpdateMasterRequest{
+		Name:          pkg.ClusterPath(test.ProjectName, test.RegionA, test.ClusterName),
+		MasterVersion: "1.19.11-gke.1700",
+	}); err != nil {
+		t.Errorf("UpdateMaster without faults unexpected error: %v", err)
+	}
+	if got := len(i.Injected()); got != 3 {
+		t.Errorf("Injector.Injected; wanted 3 injections, got: %d (%v)", got, i.Injected())
+	}
+}
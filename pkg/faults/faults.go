@@ -0,0 +1,408 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package faults injects faults into the API clients or their HTTP transport, to test how the conversion behaves
+// under latency, API errors, conflicting operations, failed operations and cancellation.
+package faults
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"legacymigration/pkg"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+// Kind is a kind of fault.
+type Kind string
+
+const (
+	// Latency delays the call by Fault.Latency.
+	Latency Kind = "latency"
+	// Error fails the call with a googleapi.Error of Fault.Code (503 by default).
+	Error Kind = "error"
+	// Conflict fails the call as if another operation were in progress. The conflicting
+	// operation is named in the error if Fault.Operation is set.
+	Conflict Kind = "conflict"
+	// OperationError makes the operation returned by the call end in error.
+	OperationError Kind = "operationError"
+	// Cancel cancels the context of the conversion, as if it were aborted, and fails the call.
+	Cancel Kind = "cancel"
+)
+
+// methods are the names of the ComputeService, ContainerService and ResourceManagerService methods.
+var methods = map[string]bool{
+	"GetInstanceGroupManager": true,
+	"GetInstanceTemplate":     true,
+	"GetGlobalOperation":      true,
+	"WaitOperation":           true,
+	"SwitchToCustomMode":      true,
+	"GetNetwork":              true,
+	"ListNetworks":            true,
+	"UpdateMaster":            true,
+	"GetCluster":              true,
+	"ListClusters":            true,
+	"GetOperation":            true,
+	"CancelOperation":         true,
+	"ListOperations":          true,
+	"SetMaintenancePolicy":    true,
+	"UpdateNodePool":          true,
+	"GetNodePool":             true,
+	"ListNodePools":           true,
+	"GetServerConfig":         true,
+	"TestIamPermissions":      true,
+}
+
+// Duration is a time.Duration which is read from JSON as a string, e.g. "1.5s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Fault is injected into the calls which match it.
+type Fault struct {
+	Kind Kind `json:"kind"`
+	// Method is the name of a ComputeService, ContainerService or ResourceManagerService method, e.g. UpdateNodePool; any method if empty.
+	Method string `json:"method,omitempty"`
+	// Target is matched as a substring of the resource path or name of the call, or of the
+	// target link of the returned operation; any target if empty.
+	Target string `json:"target,omitempty"`
+	// After is the number of matching calls before the fault is injected.
+	After int `json:"after,omitempty"`
+	// Count is the number of times the fault is injected; unlimited if 0.
+	Count int `json:"count,omitempty"`
+	// Probability is the probability of injecting the fault into a matching call; always if 0.
+	Probability float64 `json:"probability,omitempty"`
+
+	// Latency is the delay of a Latency fault.
+	Latency Duration `json:"latency,omitempty"`
+	// Code is the HTTP status code of an Error fault, e.g. 403, 429 or 503.
+	Code int `json:"code,omitempty"`
+	// Reason is the error reason of an Error fault, e.g. forbidden or rateLimitExceeded; by default,
+	// rateLimitExceeded for a 403 or 429 and backendError otherwise.
+	Reason string `json:"reason,omitempty"`
+	// Message is the message of an Error, Conflict or OperationError fault.
+	Message string `json:"message,omitempty"`
+	// Operation is the name of the conflicting operation of a Conflict fault.
+	Operation string `json:"operation,omitempty"`
+
+	// Transport injects a Latency, Error or Conflict fault into the HTTP requests of the clients,
+	// below their retries, rather than into the calls to the clients. The Target is matched as a
+	// substring of the URL path of the request; the Method cannot be set.
+	Transport bool `json:"transport,omitempty"`
+}
+
+// Config configures the faults to inject.
+type Config struct {
+	// Seed seeds the choice of faults with a Probability.
+	Seed   int64   `json:"seed,omitempty"`
+	Faults []Fault `json:"faults"`
+}
+
+// LoadConfig reads a Config from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("error parsing fault config %s: %w", path, err)
+	}
+	return c, c.Validate()
+}
+
+// Validate ensures the Config is valid.
+func (c *Config) Validate() error {
+	for i, f := range c.Faults {
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("fault %d is not valid: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (f Fault) validate() error {
+	switch f.Kind {
+	case Latency:
+		if f.Latency <= 0 {
+			return errors.New("latency must be positive")
+		}
+	case Error:
+		if f.Code != 0 && (f.Code < 400 || f.Code > 599) {
+			return fmt.Errorf("code %d is not an HTTP error code", f.Code)
+		}
+	case Conflict, OperationError, Cancel:
+	default:
+		return fmt.Errorf("unknown kind %q", f.Kind)
+	}
+	if f.Reason != "" && f.Kind != Error {
+		return errors.New("reason can only be set for error faults")
+	}
+	if f.Transport {
+		if f.Kind != Latency && f.Kind != Error && f.Kind != Conflict {
+			return fmt.Errorf("%s faults cannot be injected into the transport", f.Kind)
+		}
+		if f.Method != "" {
+			return errors.New("method cannot be set for transport faults")
+		}
+	}
+	if f.Method != "" && !methods[f.Method] {
+		return fmt.Errorf("unknown method %q", f.Method)
+	}
+	if f.After < 0 || f.Count < 0 {
+		return errors.New("after and count must not be negative")
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return errors.New("probability must be between 0 and 1")
+	}
+	return nil
+}
+
+// Injection records a fault injected into a call.
+type Injection struct {
+	Kind   Kind
+	Method string
+	Target string
+}
+
+func (i Injection) String() string {
+	return fmt.Sprintf("%s into %s %s", i.Kind, i.Method, i.Target)
+}
+
+// Injector injects the faults of a Config into the clients it wraps.
+type Injector struct {
+	mu       sync.Mutex
+	faults   []Fault
+	matched  []int
+	injected []int
+	rand     *rand.Rand
+	cancel   context.CancelFunc
+	history  []Injection
+	// failed are the messages of the operations failed by OperationError faults, by name.
+	failed map[string]string
+}
+
+// New returns an Injector for the Config.
+func New(c *Config) (*Injector, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &Injector{
+		faults:   append([]Fault(nil), c.Faults...),
+		matched:  make([]int, len(c.Faults)),
+		injected: make([]int, len(c.Faults)),
+		rand:     rand.New(rand.NewSource(c.Seed)),
+		failed:   make(map[string]string),
+	}, nil
+}
+
+// SetCancel sets the function called to inject Cancel faults.
+// Without it, Cancel faults only fail the call with context.Canceled.
+func (i *Injector) SetCancel(cancel context.CancelFunc) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cancel = cancel
+}
+
+// Injected returns the faults injected so far.
+func (i *Injector) Injected() []Injection {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]Injection(nil), i.history...)
+}
+
+// Wrap returns clients which inject faults into the calls to clients.
+func (i *Injector) Wrap(clients *pkg.Clients) *pkg.Clients {
+	wrapped := &pkg.Clients{
+		Compute:   &computeClient{ComputeService: clients.Compute, i: i},
+		Container: &containerClient{ContainerService: clients.Container, i: i},
+	}
+	if clients.ResourceManager != nil {
+		wrapped.ResourceManager = &resourceManagerClient{ResourceManagerService: clients.ResourceManager, i: i}
+	}
+	return wrapped
+}
+
+// next returns the faults of the kinds to inject into a call, up to the first which fails the call.
+// Only Transport faults are returned for HTTP requests, and only other faults for calls to the clients.
+// A fault's Target is matched against the target of the call or, if set, the target link of its operation.
+func (i *Injector) next(transport bool, kinds []Kind, method, target, targetLink string) []Fault {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var faults []Fault
+	for n, f := range i.faults {
+		if f.Transport != transport ||
+			!hasKind(kinds, f.Kind) ||
+			(f.Method != "" && f.Method != method) ||
+			(f.Target != "" && !strings.Contains(target, f.Target) && (targetLink == "" || !strings.Contains(targetLink, f.Target))) {
+			continue
+		}
+		i.matched[n]++
+		if i.matched[n] <= f.After ||
+			(f.Count > 0 && i.injected[n] >= f.Count) ||
+			(f.Probability > 0 && i.rand.Float64() >= f.Probability) {
+			continue
+		}
+		i.injected[n]++
+		injection := Injection{Kind: f.Kind, Method: method, Target: target}
+		i.history = append(i.history, injection)
+		log.Infof("Injecting %s", injection)
+		faults = append(faults, f)
+		if f.Kind != Latency {
+			break
+		}
+	}
+	return faults
+}
+
+func hasKind(kinds []Kind, k Kind) bool {
+	for _, kind := range kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// before injects faults before a call is sent, returning the error to fail the call with, if any.
+func (i *Injector) before(ctx context.Context, method, target string) error {
+	for _, f := range i.next(false, []Kind{Latency, Error, Conflict, Cancel}, method, target, "") {
+		switch f.Kind {
+		case Latency:
+			if err := sleep(ctx, time.Duration(f.Latency)); err != nil {
+				return err
+			}
+		case Error:
+			return apiError(f)
+		case Conflict:
+			return conflictError(f)
+		case Cancel:
+			i.mu.Lock()
+			cancel := i.cancel
+			i.mu.Unlock()
+			if cancel != nil {
+				cancel()
+			}
+			return context.Canceled
+		}
+	}
+	return nil
+}
+
+// sleep waits for d, or until ctx is closed.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// failOperation returns the message of an OperationError fault for the operation returned by a call, if any.
+// Once failed, an operation is failed whenever it is read again, e.g. when polled after being started.
+func (i *Injector) failOperation(method, target, name, targetLink string) (string, bool) {
+	i.mu.Lock()
+	msg, ok := i.failed[name]
+	i.mu.Unlock()
+	if ok {
+		return msg, true
+	}
+	faults := i.next(false, []Kind{OperationError}, method, target, targetLink)
+	if len(faults) == 0 {
+		return "", false
+	}
+	msg = faults[0].Message
+	if msg == "" {
+		msg = "injected operation error"
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.failed[name] = msg
+	return msg, true
+}
+
+func apiError(f Fault) error {
+	code := f.Code
+	if code == 0 {
+		code = http.StatusServiceUnavailable
+	}
+	reason := f.Reason
+	if reason == "" {
+		reason = "backendError"
+		switch code {
+		case http.StatusTooManyRequests, http.StatusForbidden:
+			reason = "rateLimitExceeded"
+		}
+	}
+	msg := f.Message
+	if msg == "" {
+		msg = fmt.Sprintf("injected error: %s", http.StatusText(code))
+	}
+	return &googleapi.Error{
+		Code:    code,
+		Message: msg,
+		Errors:  []googleapi.ErrorItem{{Reason: reason, Message: msg}},
+	}
+}
+
+func conflictError(f Fault) error {
+	if f.Operation != "" {
+		msg := f.Message
+		if msg == "" {
+			msg = fmt.Sprintf("Cluster is running incompatible operation %s.", f.Operation)
+		}
+		return &googleapi.Error{Code: http.StatusBadRequest, Message: msg}
+	}
+	msg := f.Message
+	if msg == "" {
+		msg = "injected conflict: another operation is in progress"
+	}
+	return &googleapi.Error{
+		Code:    http.StatusConflict,
+		Message: msg,
+		Errors:  []googleapi.ErrorItem{{Reason: "operationInProgress", Message: msg}},
+	}
+}
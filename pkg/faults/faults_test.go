@@ -0,0 +1,195 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package faults
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"legacymigration/pkg/operations"
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/googleapi"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	cases := []struct {
+		desc    string
+		fault   Fault
+		wantErr string
+	}{
+		{desc: "Valid error", fault: Fault{Kind: Error, Method: "UpdateNodePool", Code: 429}},
+		{desc: "Valid latency", fault: Fault{Kind: Latency, Latency: Duration(time.Second)}},
+		{desc: "Unknown kind", fault: Fault{Kind: "explode"}, wantErr: `fault 0 is not valid: unknown kind "explode"`},
+		{desc: "Unknown method", fault: Fault{Kind: Cancel, Method: "Delete"}, wantErr: `unknown method "Delete"`},
+		{desc: "No latency", fault: Fault{Kind: Latency}, wantErr: "latency must be positive"},
+		{desc: "Not an error code", fault: Fault{Kind: Error, Code: 200}, wantErr: "code 200 is not an HTTP error code"},
+		{desc: "Negative count", fault: Fault{Kind: Conflict, Count: -1}, wantErr: "after and count must not be negative"},
+		{desc: "Probability too high", fault: Fault{Kind: Conflict, Probability: 2}, wantErr: "probability must be between 0 and 1"},
+		{desc: "Valid transport error", fault: Fault{Kind: Error, Transport: true, Code: 403, Reason: "forbidden"}},
+		{desc: "Reason without error", fault: Fault{Kind: Conflict, Reason: "forbidden"}, wantErr: "reason can only be set for error faults"},
+		{desc: "Transport operation error", fault: Fault{Kind: OperationError, Transport: true}, wantErr: "operationError faults cannot be injected into the transport"},
+		{desc: "Transport method", fault: Fault{Kind: Error, Transport: true, Method: "GetNetwork"}, wantErr: "method cannot be set for transport faults"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &Config{Faults: []Fault{tc.fault}}
+			if diff := test.ErrorDiff(tc.wantErr, c.Validate()); diff != "" {
+				t.Errorf("Config.Validate diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "faults.json")
+	config := `{"seed": 7, "faults": [{"kind": "latency", "method": "GetCluster", "latency": "1.5s", "count": 2}]}`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("WriteFile unexpected error: %v", err)
+	}
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig unexpected error: %v", err)
+	}
+	want := &Config{Seed: 7, Faults: []Fault{{Kind: Latency, Method: "GetCluster", Latency: Duration(1500 * time.Millisecond), Count: 2}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LoadConfig diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("LoadConfig of a missing file; wanted an error")
+	}
+}
+
+func TestInjector_Before(t *testing.T) {
+	const target = "projects/p/locations/l/clusters/c"
+	cases := []struct {
+		desc    string
+		faults  []Fault
+		calls   int
+		wantErr []string
+	}{
+		{
+			desc:    "After and count",
+			faults:  []Fault{{Kind: Error, Code: http.StatusServiceUnavailable, After: 1, Count: 2}},
+			calls:   4,
+			wantErr: []string{"", "injected error: Service Unavailable", "injected error: Service Unavailable", ""},
+		},
+		{
+			desc:    "Method and target",
+			faults:  []Fault{{Kind: Error, Method: "GetNodePool"}, {Kind: Error, Target: "clusters/other"}},
+			calls:   1,
+			wantErr: []string{""},
+		},
+		{
+			desc:    "Never with probability",
+			faults:  []Fault{{Kind: Error, Probability: 1e-9}},
+			calls:   2,
+			wantErr: []string{"", ""},
+		},
+		{
+			desc:    "Latency then error",
+			faults:  []Fault{{Kind: Latency, Latency: Duration(time.Millisecond)}, {Kind: Error, Code: http.StatusTooManyRequests, Message: "quota"}},
+			calls:   1,
+			wantErr: []string{"quota"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			i, err := New(&Config{Faults: tc.faults})
+			if err != nil {
+				t.Fatalf("New unexpected error: %v", err)
+			}
+			for n := 0; n < tc.calls; n++ {
+				err := i.before(context.Background(), "GetCluster", target)
+				if diff := test.ErrorDiff(tc.wantErr[n], err); diff != "" {
+					t.Errorf("Injector.before call %d diff (-want +got):\n%s", n, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestInjector_Errors(t *testing.T) {
+	i, err := New(&Config{Faults: []Fault{
+		{Kind: Error, Code: http.StatusForbidden, Count: 1},
+		{Kind: Error, Code: http.StatusForbidden, Reason: "forbidden", Count: 1},
+		{Kind: Conflict, Count: 1},
+		{Kind: Conflict, Operation: "operation-123-abc", Count: 1},
+	}})
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	err = i.before(ctx, "UpdateMaster", "c")
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusForbidden || gerr.Errors[0].Reason != "rateLimitExceeded" {
+		t.Errorf("Error fault; wanted a 403 rateLimitExceeded error, got: %v", err)
+	}
+	err = i.before(ctx, "UpdateMaster", "c")
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusForbidden || gerr.Errors[0].Reason != "forbidden" {
+		t.Errorf("Error fault with reason; wanted a 403 forbidden error, got: %v", err)
+	}
+	if _, ok := operations.AsConflict(i.before(ctx, "UpdateMaster", "c")); !ok {
+		t.Errorf("Conflict fault; wanted a conflict")
+	}
+	c, ok := operations.AsConflict(i.before(ctx, "UpdateMaster", "c"))
+	if !ok || c.Operation != "operation-123-abc" {
+		t.Errorf("Conflict fault with operation; wanted a conflict on operation-123-abc, got: %+v", c)
+	}
+	if got := len(i.Injected()); got != 4 {
+		t.Errorf("Injector.Injected; wanted 4 injections, got: %d", got)
+	}
+}
+
+func TestInjector_Cancel(t *testing.T) {
+	i, err := New(&Config{Faults: []Fault{{Kind: Cancel, Method: "UpdateNodePool"}}})
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i.SetCancel(cancel)
+
+	err = i.before(ctx, "UpdateNodePool", "np")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancel fault; wanted context.Canceled, got: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Errorf("Cancel fault did not cancel the context")
+	}
+}
+
+func TestInjector_LatencyCanceled(t *testing.T) {
+	i, err := New(&Config{Faults: []Fault{{Kind: Latency, Latency: Duration(time.Hour)}}})
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := i.before(ctx, "GetCluster", "c"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Latency fault with a canceled context; wanted context.Canceled, got: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package faults
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Transport returns an http.RoundTripper which injects the Transport faults into the requests sent
+// with rt, or http.DefaultTransport if nil. Used as the transport of the clients' HTTP client, the
+// faults are injected below the retries of the clients, so that they are retried as API responses are.
+func (i *Injector) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &transport{rt: rt, i: i}
+}
+
+type transport struct {
+	rt http.RoundTripper
+	i  *Injector
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, f := range t.i.next(true, []Kind{Latency, Error, Conflict}, req.Method, req.URL.Path, "") {
+		switch f.Kind {
+		case Latency:
+			if err := sleep(req.Context(), time.Duration(f.Latency)); err != nil {
+				return nil, err
+			}
+		case Error:
+			return errorResponse(req, apiError(f))
+		case Conflict:
+			return errorResponse(req, conflictError(f))
+		}
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// errorResponse returns the response of the API for the error, a *googleapi.Error.
+func errorResponse(req *http.Request, err error) (*http.Response, error) {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return nil, err
+	}
+	var body struct {
+		Error struct {
+			Code    int                   `json:"code"`
+			Message string                `json:"message"`
+			Errors  []googleapi.ErrorItem `json:"errors,omitempty"`
+		} `json:"error"`
+	}
+	body.Error.Code, body.Error.Message, body.Error.Errors = gerr.Code, gerr.Message, gerr.Errors
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", gerr.Code, http.StatusText(gerr.Code)),
+		StatusCode:    gerr.Code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json; charset=UTF-8"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
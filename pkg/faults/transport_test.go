@@ -0,0 +1,102 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"legacymigration/pkg/operations"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/googleapi"
+)
+
+func TestInjector_Transport(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "n"}`)
+	}))
+	defer hs.Close()
+
+	i, err := New(&Config{Faults: []Fault{
+		{Kind: Latency, Transport: true, Latency: Duration(time.Millisecond)},
+		{Kind: Error, Transport: true, Target: "networks/n", Code: http.StatusForbidden, Reason: "forbidden", Count: 1},
+		{Kind: Conflict, Transport: true, Target: "clusters/c", Count: 1},
+		{Kind: Error, Method: "GetNetwork"},
+	}})
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	c := &http.Client{Transport: i.Transport(nil)}
+
+	var got []string
+	for _, path := range []string{"/projects/p/global/networks/n", "/projects/p/global/networks/n", "/projects/p/zones/z/clusters/c"} {
+		resp, err := c.Get(hs.URL + path)
+		if err != nil {
+			t.Fatalf("Get unexpected error: %v", err)
+		}
+		err = googleapi.CheckResponse(resp)
+		resp.Body.Close()
+		got = append(got, fmt.Sprint(err))
+		if resp.StatusCode == http.StatusConflict {
+			if _, ok := operations.AsConflict(err); !ok {
+				t.Errorf("Conflict fault; wanted a conflict, got: %v", err)
+			}
+		}
+	}
+	want := []string{
+		"googleapi: Error 403: injected error: Forbidden, forbidden",
+		"<nil>",
+		"googleapi: Error 409: injected conflict: another operation is in progress, operationInProgress",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Responses diff (-want +got):\n%s", diff)
+	}
+	var gerr *googleapi.Error
+	if !errors.As(i.before(context.Background(), "GetNetwork", "n"), &gerr) {
+		t.Errorf("Injector.before; wanted the client fault, not injected into the transport")
+	}
+
+	wantInjected := []Injection{
+		{Kind: Latency, Method: "GET", Target: "/projects/p/global/networks/n"},
+		{Kind: Error, Method: "GET", Target: "/projects/p/global/networks/n"},
+		{Kind: Latency, Method: "GET", Target: "/projects/p/global/networks/n"},
+		{Kind: Latency, Method: "GET", Target: "/projects/p/zones/z/clusters/c"},
+		{Kind: Conflict, Method: "GET", Target: "/projects/p/zones/z/clusters/c"},
+		{Kind: Error, Method: "GetNetwork", Target: "n"},
+	}
+	if diff := cmp.Diff(wantInjected, i.Injected()); diff != "" {
+		t.Errorf("Injector.Injected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestInjector_TransportLatencyCanceled(t *testing.T) {
+	i, err := New(&Config{Faults: []Fault{{Kind: Latency, Transport: true, Latency: Duration(time.Hour)}}})
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "http://localhost/projects/p", nil).WithContext(ctx)
+	if _, err := i.Transport(nil).RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("RoundTrip; wanted context.Canceled, got: %v", err)
+	}
+}
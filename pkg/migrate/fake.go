@@ -24,6 +24,8 @@ type FakeMigrator struct {
 	CompleteError error
 	ValidateError error
 	MigrateError  error
+	// RequiredPermissions are returned by Permissions.
+	RequiredPermissions []string
 }
 
 func (m *FakeMigrator) Complete(ctx context.Context) error {
@@ -53,6 +55,10 @@ func (m *FakeMigrator) Migrate(ctx context.Context) error {
 	}
 }
 
+func (m *FakeMigrator) Permissions() []string {
+	return m.RequiredPermissions
+}
+
 func (m *FakeMigrator) ResourcePath() string {
 	return "resource-path"
 }
@@ -0,0 +1,168 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+)
+
+// EventType identifies the point in a Migrator's lifecycle at which a Hook is invoked.
+type EventType string
+
+const (
+	BeforeComplete EventType = "BeforeComplete"
+	AfterComplete  EventType = "AfterComplete"
+	BeforeValidate EventType = "BeforeValidate"
+	AfterValidate  EventType = "AfterValidate"
+	BeforeMigrate  EventType = "BeforeMigrate"
+	AfterMigrate   EventType = "AfterMigrate"
+)
+
+// beforeEvent and afterEvent return the EventTypes surrounding a Migrator method.
+func beforeEvent(t MethodType) EventType {
+	return EventType("Before" + t.String())
+}
+
+func afterEvent(t MethodType) EventType {
+	return EventType("After" + t.String())
+}
+
+// Event describes a Migrator entering or leaving a method.
+type Event struct {
+	Type         EventType
+	ResourcePath string
+	Kind         string
+	// Err is the error returned by the method; only set for After events.
+	Err error
+}
+
+// MarshalJSON encodes the Event with the error as a string.
+func (e Event) MarshalJSON() ([]byte, error) {
+	var errMsg string
+	if e.Err != nil {
+		errMsg = e.Err.Error()
+	}
+	return json.Marshal(struct {
+		Type         EventType `json:"type"`
+		ResourcePath string    `json:"resourcePath"`
+		Kind         string    `json:"kind,omitempty"`
+		Error        string    `json:"error,omitempty"`
+	}{e.Type, e.ResourcePath, e.Kind, errMsg})
+}
+
+// Hook is invoked before and after each Migrator method.
+//
+// An error returned for a Before event prevents the method from running and is
+// returned in its place. An error returned for an After event is combined with
+// the error returned by the method.
+type Hook interface {
+	Handle(ctx context.Context, e Event) error
+}
+
+// HookFunc adapts a function to a Hook.
+type HookFunc func(ctx context.Context, e Event) error
+
+func (f HookFunc) Handle(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+type hooksKey struct{}
+
+// WithHooks returns a copy of ctx which invokes hooks, after any hooks already
+// attached to ctx, for all migrators run with it (or a descendant context).
+func WithHooks(ctx context.Context, hooks ...Hook) context.Context {
+	existing, _ := ctx.Value(hooksKey{}).([]Hook)
+	all := make([]Hook, 0, len(existing)+len(hooks))
+	all = append(all, existing...)
+	all = append(all, hooks...)
+	return context.WithValue(ctx, hooksKey{}, all)
+}
+
+// invokeHooks invokes all hooks attached to ctx in order, accumulating any errors.
+func invokeHooks(ctx context.Context, e Event) error {
+	hooks, _ := ctx.Value(hooksKey{}).([]Hook)
+	var errs error
+	for _, h := range hooks {
+		if err := h.Handle(ctx, e); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("%s hook for %s: %w", e.Type, e.ResourcePath, err))
+		}
+	}
+	return errs
+}
+
+// ExecHook is a Hook which runs a command for each event, with the Event as JSON on stdin.
+// A non-zero exit status is reported as an error.
+type ExecHook struct {
+	Command string
+	Args    []string
+	// Events restricts the events for which the command is run; all events if empty.
+	Events []EventType
+	// Timeout bounds each run of the command; unbounded if zero.
+	Timeout time.Duration
+}
+
+// NewExecHook returns an ExecHook which runs command with args for all events.
+func NewExecHook(command string, args ...string) *ExecHook {
+	return &ExecHook{Command: command, Args: args}
+}
+
+func (h *ExecHook) Handle(ctx context.Context, e Event) error {
+	if !h.handles(e.Type) {
+		return nil
+	}
+	in, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	cmd.Stdin = bytes.NewReader(in)
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if output != "" {
+		log.Infof("Hook %s output for %s %s: %s", h.Command, e.Type, e.ResourcePath, output)
+	}
+	if err != nil {
+		return fmt.Errorf("command %s failed: %w", h.Command, err)
+	}
+	return nil
+}
+
+func (h *ExecHook) handles(t EventType) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// kindMigrator is a FakeMigrator which reports its kind.
+type kindMigrator struct {
+	FakeMigrator
+}
+
+func (m *kindMigrator) Kind() string {
+	return KindCluster
+}
+
+func TestHooks(t *testing.T) {
+	migrateErr := errors.New("migrate error")
+	hookErr := errors.New("hook error")
+
+	cases := []struct {
+		desc       string
+		migrator   Migrator
+		method     func(ctx context.Context, sem chan struct{}, migrators ...Migrator) error
+		hookErrFor EventType
+		want       []Event
+		wantErr    string
+	}{
+		{
+			desc:     "Before and after",
+			migrator: &kindMigrator{},
+			method:   Complete,
+			want: []Event{
+				{Type: BeforeComplete, ResourcePath: "resource-path", Kind: KindCluster},
+				{Type: AfterComplete, ResourcePath: "resource-path", Kind: KindCluster},
+			},
+		},
+		{
+			desc:     "After event carries error",
+			migrator: &FakeMigrator{MigrateError: migrateErr},
+			method:   Migrate,
+			want: []Event{
+				{Type: BeforeMigrate, ResourcePath: "resource-path"},
+				{Type: AfterMigrate, ResourcePath: "resource-path", Err: migrateErr},
+			},
+			wantErr: "migrate error",
+		},
+		{
+			desc:       "Before hook error skips method",
+			migrator:   &FakeMigrator{MigrateError: migrateErr},
+			method:     Migrate,
+			hookErrFor: BeforeMigrate,
+			want: []Event{
+				{Type: BeforeMigrate, ResourcePath: "resource-path"},
+			},
+			wantErr: "BeforeMigrate hook for resource-path: hook error",
+		},
+		{
+			desc:       "After hook error",
+			migrator:   &FakeMigrator{},
+			method:     Validate,
+			hookErrFor: AfterValidate,
+			want: []Event{
+				{Type: BeforeValidate, ResourcePath: "resource-path"},
+				{Type: AfterValidate, ResourcePath: "resource-path"},
+			},
+			wantErr: "AfterValidate hook for resource-path: hook error",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []Event
+			ctx := WithHooks(context.Background(), HookFunc(func(_ context.Context, e Event) error {
+				got = append(got, e)
+				if e.Type == tc.hookErrFor {
+					return hookErr
+				}
+				return nil
+			}))
+
+			err := tc.method(ctx, make(chan struct{}, 1), tc.migrator)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Hook error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Hook events diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithHooks_Order(t *testing.T) {
+	var got []string
+	hook := func(name string) Hook {
+		return HookFunc(func(_ context.Context, e Event) error {
+			got = append(got, name)
+			return nil
+		})
+	}
+	ctx := WithHooks(context.Background(), hook("first"))
+	ctx = WithHooks(ctx, hook("second"))
+
+	invokeHooks(ctx, Event{Type: BeforeComplete})
+
+	if diff := cmp.Diff([]string{"first", "second"}, got); diff != "" {
+		t.Errorf("WithHooks order diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "event.json")
+
+	cases := []struct {
+		desc     string
+		hook     *ExecHook
+		event    Event
+		wantErr  string
+		wantFile string
+	}{
+		{
+			desc:     "Event on stdin",
+			hook:     NewExecHook("sh", "-c", "cat > "+out),
+			event:    Event{Type: AfterMigrate, ResourcePath: "resource-path", Kind: KindCluster, Err: errors.New("migrate error")},
+			wantFile: `{"type":"AfterMigrate","resourcePath":"resource-path","kind":"Cluster","error":"migrate error"}`,
+		},
+		{
+			desc:    "Non-zero exit",
+			hook:    NewExecHook("sh", "-c", "exit 3"),
+			event:   Event{Type: BeforeMigrate, ResourcePath: "resource-path"},
+			wantErr: "command sh failed: exit status 3",
+		},
+		{
+			desc: "Filtered event",
+			hook: &ExecHook{
+				Command: "sh",
+				Args:    []string{"-c", "exit 3"},
+				Events:  []EventType{BeforeMigrate},
+			},
+			event: Event{Type: BeforeValidate, ResourcePath: "resource-path"},
+		},
+		{
+			desc: "Timeout",
+			hook: &ExecHook{
+				Command: "sleep",
+				Args:    []string{"5"},
+				Timeout: 10 * time.Millisecond,
+			},
+			event:   Event{Type: BeforeMigrate, ResourcePath: "resource-path"},
+			wantErr: "command sleep failed",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.hook.Handle(context.Background(), tc.event)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("ExecHook.Handle diff (-want +got):\n%s", diff)
+			}
+			if tc.wantFile == "" {
+				return
+			}
+			b, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatalf("Unable to read hook output: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantFile, string(b)); diff != "" {
+				t.Errorf("ExecHook stdin diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
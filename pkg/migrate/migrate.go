@@ -17,13 +17,18 @@ package migrate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"go.uber.org/multierr"
 )
 
+// ErrStopped is returned for migrators which were not run because the stop channel was closed.
+var ErrStopped = errors.New("stopped")
+
 type Migrator interface {
 	Complete(ctx context.Context) error
 	Validate(ctx context.Context) error
@@ -31,6 +36,53 @@ type Migrator interface {
 	ResourcePath() string
 }
 
+// Resource kinds reported by migrators.
+const (
+	KindNetwork  = "Network"
+	KindCluster  = "Cluster"
+	KindNodePool = "NodePool"
+)
+
+// Kinder is implemented by migrators which report the kind of resource they migrate.
+type Kinder interface {
+	Kind() string
+}
+
+// KindOf returns the kind of resource migrated by m, or an empty string if unknown.
+func KindOf(m Migrator) string {
+	if k, ok := m.(Kinder); ok {
+		return k.Kind()
+	}
+	return ""
+}
+
+// Permissioner is implemented by migrators which report the IAM permissions required to migrate
+// their resource, and those of their children, as planned once completed.
+type Permissioner interface {
+	Permissions() []string
+}
+
+// Permissions returns the sorted, distinct permissions required to migrate the migrators.
+// Migrators which do not implement Permissioner require no permissions.
+func Permissions(migrators ...Migrator) []string {
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, m := range migrators {
+		p, ok := m.(Permissioner)
+		if !ok {
+			continue
+		}
+		for _, permission := range p.Permissions() {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
 type MethodType int
 
 const (
@@ -52,6 +104,54 @@ func (e MethodType) String() string {
 	}
 }
 
+// Progress describes a change in state of a Migrator method.
+type Progress struct {
+	ResourcePath string
+	Kind         string
+	Method       MethodType
+	// Done is false when the method starts and true once it has returned.
+	Done bool
+	Err  error
+}
+
+// ProgressFunc receives Progress updates for every Migrator run with a context carrying it.
+type ProgressFunc func(p Progress)
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx which reports the progress of all migrators
+// run with it (or a descendant context) to f, followed by any ProgressFunc already attached to ctx.
+func WithProgress(ctx context.Context, f ProgressFunc) context.Context {
+	if parent, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && parent != nil {
+		child := f
+		f = func(p Progress) {
+			child(p)
+			parent(p)
+		}
+	}
+	return context.WithValue(ctx, progressKey{}, f)
+}
+
+func reportProgress(ctx context.Context, p Progress) {
+	if f, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && f != nil {
+		f(p)
+	}
+}
+
+type stopKey struct{}
+
+// WithStop returns a copy of ctx which stops migrators from being launched once stop is closed.
+// Unlike cancelling the context, migrators which are already running are not interrupted.
+func WithStop(ctx context.Context, stop <-chan struct{}) context.Context {
+	return context.WithValue(ctx, stopKey{}, stop)
+}
+
+// stopped returns the stop channel attached to ctx, or nil (which blocks forever) if there is none.
+func stopped(ctx context.Context) <-chan struct{} {
+	stop, _ := ctx.Value(stopKey{}).(<-chan struct{})
+	return stop
+}
+
 // Complete runs Migrator.Complete on all migrators.
 func Complete(ctx context.Context, sem chan struct{}, migrators ...Migrator) error {
 	return run(ctx, sem, CompleteMethod, migrators...)
@@ -74,6 +174,7 @@ func run(ctx context.Context, sem chan struct{}, t MethodType, migrators ...Migr
 		errors  error
 		wg      = sync.WaitGroup{}
 		results = make(chan error, len(migrators))
+		stop    = stopped(ctx)
 	)
 
 Loop:
@@ -82,8 +183,19 @@ Loop:
 		case <-ctx.Done():
 			errors = multierr.Append(errors, fmt.Errorf("context closed during %T.%v: %w", m, t, ctx.Err()))
 			break Loop
+		case <-stop:
+			errors = multierr.Append(errors, fmt.Errorf("%v for %s not started: %w", t, m.ResourcePath(), ErrStopped))
+			break Loop
 		case sem <- struct{}{}:
 		}
+		select {
+		case <-stop:
+			// The stop channel may have been closed while waiting for the semaphore.
+			<-sem
+			errors = multierr.Append(errors, fmt.Errorf("%v for %s not started: %w", t, m.ResourcePath(), ErrStopped))
+			break Loop
+		default:
+		}
 		wg.Add(1)
 		go func(m Migrator) {
 			defer func() { <-sem }()
@@ -100,21 +212,27 @@ Loop:
 				log.Errorf("Invalid method %v", t)
 				return
 			}
-			results <- method(ctx)
+			path, kind := m.ResourcePath(), KindOf(m)
+			reportProgress(ctx, Progress{ResourcePath: path, Kind: kind, Method: t})
+			err := invokeHooks(ctx, Event{Type: beforeEvent(t), ResourcePath: path, Kind: kind})
+			if err == nil {
+				err = method(ctx)
+				err = multierr.Append(err, invokeHooks(ctx, Event{Type: afterEvent(t), ResourcePath: path, Kind: kind, Err: err}))
+			}
+			reportProgress(ctx, Progress{ResourcePath: path, Kind: kind, Method: t, Done: true, Err: err})
+			results <- err
 		}(m)
 	}
 	wg.Wait()
 	close(results)
 
-	if errors != nil {
-		return errors
-	}
-
+	// The results of migrators started before the loop was broken are kept.
+	var errs error
 	for err := range results {
 		if err != nil {
-			errors = multierr.Append(errors, err)
+			errs = multierr.Append(errs, err)
 		}
 	}
 
-	return errors
+	return multierr.Append(errs, errors)
 }
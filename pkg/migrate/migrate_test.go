@@ -24,6 +24,8 @@ import (
 
 	"legacymigration/test"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -33,6 +35,10 @@ func TestMigrate_Run(t *testing.T) {
 
 	<-cancelled.Done()
 
+	stop := make(chan struct{})
+	close(stop)
+	stopped := WithStop(context.Background(), stop)
+
 	cases := []struct {
 		desc      string
 		migrators []Migrator
@@ -124,6 +130,16 @@ func TestMigrate_Run(t *testing.T) {
 			ctx:     cancelled,
 			wantErr: "Complete: context canceled",
 		},
+		{
+			desc: "Migrate, Stopped",
+			migrators: []Migrator{
+				&FakeMigrator{},
+				&FakeMigrator{},
+			},
+			method:  MigrateMethod,
+			ctx:     stopped,
+			wantErr: "Migrate for resource-path not started: stopped",
+		},
 		{
 			desc: "Validate",
 			migrators: []Migrator{
@@ -179,3 +195,95 @@ func TestMigrate_Run(t *testing.T) {
 		})
 	}
 }
+
+// stoppingMigrator closes stop when migrated, then returns err.
+type stoppingMigrator struct {
+	FakeMigrator
+	stop     chan struct{}
+	err      error
+	migrated bool
+}
+
+func (m *stoppingMigrator) Migrate(ctx context.Context) error {
+	m.migrated = true
+	close(m.stop)
+	return m.err
+}
+
+func TestMigrate_Stop(t *testing.T) {
+	stop := make(chan struct{})
+	first := &stoppingMigrator{stop: stop}
+	second := &FakeMigrator{MigrateError: errors.New("should not run")}
+
+	err := Migrate(WithStop(context.Background(), stop), make(chan struct{}, 1), first, second)
+	if !errors.Is(err, ErrStopped) {
+		t.Errorf("Migrate; wanted: ErrStopped, got: %v", err)
+	}
+	if !first.migrated {
+		t.Errorf("Migrate; running migrator was not completed")
+	}
+}
+
+func TestMigrate_StopKeepsResults(t *testing.T) {
+	stop := make(chan struct{})
+	first := &stoppingMigrator{stop: stop, err: errors.New("migrate error")}
+	second := &FakeMigrator{MigrateError: errors.New("should not run")}
+
+	err := Migrate(WithStop(context.Background(), stop), make(chan struct{}, 1), first, second)
+	if !errors.Is(err, ErrStopped) {
+		t.Errorf("Migrate; wanted: ErrStopped, got: %v", err)
+	}
+	if diff := test.ErrorDiff("migrate error", err); diff != "" {
+		t.Errorf("Migrate; result of the running migrator was discarded (-want +got):\n%s", diff)
+	}
+}
+
+func TestMigrate_Progress(t *testing.T) {
+	var got []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		got = append(got, p)
+	})
+	err := errors.New("expected error")
+
+	Validate(ctx, make(chan struct{}, 1), &FakeMigrator{ValidateError: err})
+
+	want := []Progress{
+		{ResourcePath: "resource-path", Method: ValidateMethod},
+		{ResourcePath: "resource-path", Method: ValidateMethod, Done: true, Err: err},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("migrate.run progress diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithProgress_Nested(t *testing.T) {
+	var got []string
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		got = append(got, "parent")
+	})
+	ctx = WithProgress(ctx, func(p Progress) {
+		got = append(got, "child")
+	})
+
+	Complete(ctx, make(chan struct{}, 1), &FakeMigrator{})
+
+	want := []string{"child", "parent", "child", "parent"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("WithProgress nested diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	migrators := []Migrator{
+		&FakeMigrator{RequiredPermissions: []string{"container.clusters.update", "compute.networks.switchToCustomMode"}},
+		&FakeMigrator{RequiredPermissions: []string{"container.clusters.update", "container.operations.get"}},
+		&FakeMigrator{},
+	}
+	want := []string{"compute.networks.switchToCustomMode", "container.clusters.update", "container.operations.get"}
+	if diff := cmp.Diff(want, Permissions(migrators...)); diff != "" {
+		t.Errorf("Permissions diff (-want +got):\n%s", diff)
+	}
+	if got := Permissions(); got != nil {
+		t.Errorf("Permissions for no migrators; wanted none, got: %v", got)
+	}
+}
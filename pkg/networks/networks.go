@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
 	"legacymigration/pkg/clusters"
 	"legacymigration/pkg/migrate"
 	"legacymigration/pkg/operations"
@@ -36,9 +37,16 @@ type networkMigrator struct {
 	handler            operations.Handler
 	clients            *pkg.Clients
 	concurrentClusters uint16
+	approver           approval.Approver
+	clusterNames       []string
+	locations          []string
+	allowMissingZones  bool
 	factory            func(c *container.Cluster) migrate.Migrator
 
-	children []migrate.Migrator
+	// Field(s) populated during Complete.
+	missingZones []string
+	excluded     []string
+	children     []migrate.Migrator
 }
 
 func New(
@@ -47,6 +55,7 @@ func New(
 	handler operations.Handler,
 	clients *pkg.Clients,
 	concurrentClusters uint16,
+	approver approval.Approver,
 	opts *clusters.Options) *networkMigrator {
 	factory := func(c *container.Cluster) migrate.Migrator {
 		return clusters.New(projectID, c, handler, clients, opts)
@@ -57,6 +66,10 @@ func New(
 		network:            network,
 		clients:            clients,
 		concurrentClusters: concurrentClusters,
+		approver:           approver,
+		clusterNames:       opts.ClusterNames,
+		locations:          opts.Locations,
+		allowMissingZones:  opts.AllowMissingZones,
 		factory:            factory,
 	}
 }
@@ -66,25 +79,57 @@ func (m *networkMigrator) ResourcePath() string {
 	return pkg.NetworkPath(m.projectID, m.network.Name)
 }
 
+// Kind returns the kind of resource migrated.
+func (m *networkMigrator) Kind() string {
+	return migrate.KindNetwork
+}
+
+// Permissions returns the permissions required to switch the network to a custom mode VPC network,
+// if it is a legacy network, and to upgrade its clusters.
+func (m *networkMigrator) Permissions() []string {
+	permissions := migrate.Permissions(m.children...)
+	if m.network.IPv4Range != "" {
+		permissions = append(permissions,
+			"compute.networks.switchToCustomMode",
+			"compute.globalOperations.get",
+			"compute.networks.get")
+	}
+	return permissions
+}
+
 // Complete finishes initializing the networkMigrator.
 func (m *networkMigrator) Complete(ctx context.Context) error {
-	path := pkg.LocationPath(m.projectID, pkg.AnyLocation)
-	resp, err := m.clients.Container.ListClusters(ctx, path)
+	listed, missingZones, err := clusters.List(ctx, m.clients.Container, m.projectID, m.locations)
 	if err != nil {
 		return fmt.Errorf("error listing Clusters for network %s: %w", m.ResourcePath(), err)
 	}
-
-	if len(resp.MissingZones) > 0 {
-		log.Warnf("Clusters.List response is missing zones: %v", resp.MissingZones)
+	m.missingZones = missingZones
+	if len(m.missingZones) > 0 && m.allowMissingZones {
+		log.Warnf("Unable to list Clusters in zones %s; clusters in them on network %s will not be upgraded.",
+			strings.Join(m.missingZones, ", "), m.ResourcePath())
 	}
 
 	filteredClusters := make([]*container.Cluster, 0)
-	for _, c := range resp.Clusters {
-		if c.Network == m.network.Name {
+	for _, c := range listed {
+		if IsSelected(c, m.network.Name, m.clusterNames) {
 			filteredClusters = append(filteredClusters, c)
 		}
 	}
 
+	// Clusters on a legacy network outside of the locations would not be upgraded once it is converted.
+	if len(m.locations) > 0 && m.network.IPv4Range != "" {
+		m.excluded, err = clusters.Excluded(ctx, m.clients.Container, m.projectID, listed, func(c *container.Cluster) bool {
+			return IsSelected(c, m.network.Name, m.clusterNames)
+		})
+		if err != nil {
+			return fmt.Errorf("error listing Clusters for network %s: %w", m.ResourcePath(), err)
+		}
+		if len(m.excluded) > 0 && m.allowMissingZones {
+			log.Warnf("Clusters %s on network %s are not in locations %s and will not be upgraded.",
+				strings.Join(m.excluded, ", "), m.ResourcePath(), strings.Join(m.locations, ", "))
+		}
+	}
+
 	m.children = make([]migrate.Migrator, len(filteredClusters))
 	for i, c := range filteredClusters {
 		m.children[i] = m.factory(c)
@@ -100,14 +145,54 @@ func (m *networkMigrator) Complete(ctx context.Context) error {
 	return migrate.Complete(ctx, sem, m.children...)
 }
 
-// Validate ensures child migrators can be run without error.
+// IsSelected returns whether the cluster is on the network and was selected for conversion
+// by name; all clusters on the network are selected if clusterNames is empty.
+func IsSelected(c *container.Cluster, network string, clusterNames []string) bool {
+	if c.Network != network {
+		return false
+	}
+	if len(clusterNames) == 0 {
+		return true
+	}
+	for _, name := range clusterNames {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate ensures child migrators can be run without error, and that no clusters on the network
+// may have been omitted because their zones could not be listed or were not among the locations.
 func (m *networkMigrator) Validate(ctx context.Context) error {
+	if len(m.missingZones) > 0 && !m.allowMissingZones {
+		return fmt.Errorf("validation error for network %s: unable to list Clusters in zones %s; "+
+			"clusters in them may be on the network and would not be upgraded", m.ResourcePath(), strings.Join(m.missingZones, ", "))
+	}
+	if len(m.excluded) > 0 && !m.allowMissingZones {
+		return fmt.Errorf("validation error for network %s: Clusters %s are on the network but not in locations %s; "+
+			"they would not be upgraded", m.ResourcePath(), strings.Join(m.excluded, ", "), strings.Join(m.locations, ", "))
+	}
 	sem := make(chan struct{}, m.concurrentClusters)
 	return migrate.Validate(ctx, sem, m.children...)
 }
 
 // Migrate performs the network migration and then the cluster upgrades.
 func (m *networkMigrator) Migrate(ctx context.Context) error {
+	if m.network.IPv4Range != "" {
+		ok, err := approval.Confirm(ctx, m.approver, approval.Step{
+			Action:       "Switch to custom mode VPC network",
+			ResourcePath: m.ResourcePath(),
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Infof("Skipping conversion for network %s and upgrades for its cluster(s).", m.ResourcePath())
+			return nil
+		}
+	}
+
 	if err := operations.WaitForOperationInProgress(ctx, m.migrateNetwork, m.wait); err != nil {
 		return err
 	}
@@ -157,10 +242,28 @@ func (m *networkMigrator) migrateClusters(ctx context.Context) error {
 	return migrate.Migrate(ctx, sem, m.children...)
 }
 
-func (m *networkMigrator) wait(ctx context.Context, opID string) error {
-	op, err := m.clients.Compute.GetGlobalOperation(ctx, m.projectID, opID)
-	if err != nil {
-		return err
+// wait waits on the operation conflicting with the conversion, which may be a global, regional or zonal operation.
+func (m *networkMigrator) wait(ctx context.Context, c *operations.Conflict) error {
+	var op *compute.Operation
+	switch {
+	case c.Location == "":
+		var err error
+		op, err = m.clients.Compute.GetGlobalOperation(ctx, m.projectID, c.Operation)
+		if err != nil {
+			return err
+		}
+	case pkg.IsZonal(c.Location):
+		op = &compute.Operation{
+			Name:     c.Operation,
+			Zone:     c.Location,
+			SelfLink: fmt.Sprintf("projects/%s/zones/%s/operations/%s", m.projectID, c.Location, c.Operation),
+		}
+	default:
+		op = &compute.Operation{
+			Name:     c.Operation,
+			Region:   c.Location,
+			SelfLink: fmt.Sprintf("projects/%s/regions/%s/operations/%s", m.projectID, c.Location, c.Operation),
+		}
 	}
 
 	w := &ComputeOperation{
@@ -178,12 +281,42 @@ type ComputeOperation struct {
 	ProjectID string
 	Operation *compute.Operation
 	Client    pkg.ComputeService
+
+	// last is the Operation returned by the most recent poll.
+	last *compute.Operation
 }
 
 func (o *ComputeOperation) String() string {
 	return pkg.PathRegex.FindString(o.Operation.SelfLink)
 }
 
+// Type returns the type of the operation, used to select a polling strategy.
+func (o *ComputeOperation) Type() operations.OperationType {
+	return operations.TypeNetwork
+}
+
+// LongPoll returns true as polls use Operations.Wait, which blocks until the operation
+// is complete or the server times out.
+func (o *ComputeOperation) LongPoll() bool {
+	return true
+}
+
+// Progress returns the fraction of work complete reported by the most recent poll.
+func (o *ComputeOperation) Progress() (float64, bool) {
+	if o.last == nil || o.last.Progress <= 0 {
+		return 0, false
+	}
+	return float64(o.last.Progress) / 100, true
+}
+
+// StatusMessage returns the status reported by the most recent poll.
+func (o *ComputeOperation) StatusMessage() string {
+	if o.last == nil {
+		return ""
+	}
+	return o.last.StatusMessage
+}
+
 func (o *ComputeOperation) poll(ctx context.Context) (operations.OperationStatus, error) {
 	path := o.String()
 	log.Debugf("Waiting for %s", path)
@@ -193,6 +326,7 @@ func (o *ComputeOperation) poll(ctx context.Context) (operations.OperationStatus
 	if err != nil {
 		return status, err
 	}
+	o.last = resp
 
 	status = operationStatus(resp)
 	log.Debugf("Operation %s status: %#v", path, status)
@@ -16,22 +16,29 @@ limitations under the License.
 package networks
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"legacymigration/pkg"
+	"legacymigration/pkg/approval"
+	"legacymigration/pkg/fakeapi"
+	"legacymigration/pkg/faults"
 	"legacymigration/pkg/migrate"
 	"legacymigration/pkg/operations"
 	"legacymigration/test"
 
+	"github.com/google/go-cmp/cmp"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
 )
 
 var (
-	testHandler = operations.NewHandler(1*time.Microsecond, 1*time.Millisecond)
+	testHandler = operations.NewHandler(1*time.Microsecond, 1*time.Second)
 )
 
 func TestNetworkMigrator_Complete(t *testing.T) {
@@ -44,45 +51,97 @@ func TestNetworkMigrator_Complete(t *testing.T) {
 	}
 
 	cases := []struct {
-		desc         string
-		ctx          context.Context
-		m            *networkMigrator
-		wantChildren int
-		wantErr      string
+		desc             string
+		ctx              context.Context
+		script           fakeapi.Script
+		faults           []faults.Fault
+		clusterNames     []string
+		locations        []string
+		wantChildren     int
+		wantMissingZones []string
+		wantExcluded     []string
+		wantErr          string
 	}{
 		{
-			desc: "ListClusterError",
-			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).ListClustersErr = errors.New("list cluster err")
-					return clients
-				}(test.DefaultClients()),
-			),
+			desc:    "ListClusterError",
+			ctx:     ctx,
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "ListClusters", Message: "list cluster err"}},
 			wantErr: "list cluster err",
 		},
 		{
-			desc: "Success",
-			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				test.DefaultClients(),
-			),
-			wantChildren: len(test.DefaultFakeContainer().ListClustersResp.Clusters),
+			desc:         "Success",
+			ctx:          ctx,
+			wantChildren: 1,
+		},
+		{
+			desc:         "Selected cluster",
+			ctx:          ctx,
+			clusterNames: []string{test.ClusterName},
+			wantChildren: 1,
+		},
+		{
+			desc:         "Cluster not selected",
+			ctx:          ctx,
+			clusterNames: []string{"other-cluster"},
+			wantChildren: 0,
+		},
+		{
+			desc:         "Missing zone listed again",
+			ctx:          ctx,
+			script:       fakeapi.Script{MissingZones: []string{test.ZoneA0}},
+			wantChildren: 1,
+		},
+		{
+			desc:             "Missing zone still missing",
+			ctx:              ctx,
+			script:           fakeapi.Script{UnavailableZones: []string{test.ZoneA0}},
+			wantChildren:     1,
+			wantMissingZones: []string{test.ZoneA0},
+		},
+		{
+			desc:         "Cluster in locations",
+			ctx:          ctx,
+			locations:    []string{test.RegionA},
+			wantChildren: 1,
+		},
+		{
+			desc:         "Cluster excluded by locations",
+			ctx:          ctx,
+			locations:    []string{"region-b"},
+			wantChildren: 0,
+			wantExcluded: []string{"projects/test-project/locations/region-a/clusters/cluster-c"},
+		},
+		{
+			desc:         "Cluster not selected outside of locations",
+			ctx:          ctx,
+			clusterNames: []string{"other-cluster"},
+			locations:    []string{"region-b"},
+			wantChildren: 0,
 		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			err := tc.m.Complete(tc.ctx)
+			s := testState()
+			s.Script = tc.script
+			m := testNetworkMigrator(legacyNetwork, testClients(t, s, tc.faults...))
+			m.clusterNames = tc.clusterNames
+			m.locations = tc.locations
+
+			err := m.Complete(tc.ctx)
 			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
 				t.Errorf("networkMigrator.Complete diff (-want +got):\n%s", diff)
 			}
 
-			gotChildren := len(tc.m.children)
+			gotChildren := len(m.children)
 			if tc.wantChildren != gotChildren {
 				t.Errorf("networkMigrator.Complete did not produce expected child migrators (want: %d, got: %d)", tc.wantChildren, gotChildren)
 			}
+			if diff := cmp.Diff(tc.wantMissingZones, m.missingZones); diff != "" {
+				t.Errorf("networkMigrator.Complete missing zones diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantExcluded, m.excluded); diff != "" {
+				t.Errorf("networkMigrator.Complete excluded clusters diff (-want +got):\n%s", diff)
+			}
 		})
 	}
 }
@@ -99,10 +158,13 @@ func TestNetworkMigrator_Validate(t *testing.T) {
 	}
 
 	cases := []struct {
-		desc     string
-		ctx      context.Context
-		children []migrate.Migrator
-		wantErr  string
+		desc              string
+		ctx               context.Context
+		children          []migrate.Migrator
+		missingZones      []string
+		excluded          []string
+		allowMissingZones bool
+		wantErr           string
 	}{
 		{
 			desc: "Success",
@@ -111,6 +173,42 @@ func TestNetworkMigrator_Validate(t *testing.T) {
 				&migrate.FakeMigrator{},
 			},
 		},
+		{
+			desc: "Missing zones",
+			ctx:  ctx,
+			children: []migrate.Migrator{
+				&migrate.FakeMigrator{},
+			},
+			missingZones: []string{"zone-0-a", "zone-1-b"},
+			wantErr:      "validation error for network projects/test-project/global/networks/network-0: unable to list Clusters in zones zone-0-a, zone-1-b",
+		},
+		{
+			desc: "Missing zones allowed",
+			ctx:  ctx,
+			children: []migrate.Migrator{
+				&migrate.FakeMigrator{},
+			},
+			missingZones:      []string{"zone-0-a"},
+			allowMissingZones: true,
+		},
+		{
+			desc: "Clusters excluded by locations",
+			ctx:  ctx,
+			children: []migrate.Migrator{
+				&migrate.FakeMigrator{},
+			},
+			excluded: []string{"projects/test-project/locations/region-b/clusters/cluster-b"},
+			wantErr:  "validation error for network projects/test-project/global/networks/network-0: Clusters projects/test-project/locations/region-b/clusters/cluster-b are on the network but not in locations region-a",
+		},
+		{
+			desc: "Clusters excluded by locations allowed",
+			ctx:  ctx,
+			children: []migrate.Migrator{
+				&migrate.FakeMigrator{},
+			},
+			excluded:          []string{"projects/test-project/locations/region-b/clusters/cluster-b"},
+			allowMissingZones: true,
+		},
 		{
 			desc: "Children validation error",
 			ctx:  ctx,
@@ -130,8 +228,12 @@ func TestNetworkMigrator_Validate(t *testing.T) {
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			m := testNetworkMigrator(legacyNetwork, test.DefaultClients())
+			m := testNetworkMigrator(legacyNetwork, nil)
 			m.children = tc.children
+			m.missingZones = tc.missingZones
+			m.excluded = tc.excluded
+			m.locations = []string{test.RegionA}
+			m.allowMissingZones = tc.allowMissingZones
 
 			err := m.Validate(tc.ctx)
 			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
@@ -141,145 +243,171 @@ func TestNetworkMigrator_Validate(t *testing.T) {
 	}
 }
 
+func TestNetworkMigrator_Permissions(t *testing.T) {
+	children := []migrate.Migrator{&migrate.FakeMigrator{RequiredPermissions: []string{"container.clusters.update"}}}
+	cases := []struct {
+		desc    string
+		network *compute.Network
+		want    []string
+	}{
+		{
+			desc:    "Legacy network",
+			network: &compute.Network{Name: test.SelectedNetwork, IPv4Range: "10.20.0.0/16"},
+			want: []string{
+				"compute.globalOperations.get",
+				"compute.networks.get",
+				"compute.networks.switchToCustomMode",
+				"container.clusters.update",
+			},
+		},
+		{
+			desc:    "VPC network",
+			network: &compute.Network{Name: test.SelectedNetwork},
+			want:    []string{"container.clusters.update"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := testNetworkMigrator(tc.network, nil)
+			m.children = children
+
+			if diff := cmp.Diff(tc.want, migrate.Permissions(m)); diff != "" {
+				t.Errorf("networkMigrator.Permissions diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestNetworkMigrator_Migrate(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
-	clients := test.DefaultClients()
 	cancelled, cancel := context.WithCancel(context.Background())
 	cancel()
+	// Conflicts without a reported operation are retried without delay.
+	retried := operations.WithConflictRetry(ctx, operations.ConflictRetry{Retries: 1, Backoff: operations.Fixed{Interval: time.Millisecond}})
 
 	legacyNetwork := &compute.Network{
 		Name:      test.SelectedNetwork,
 		IPv4Range: "10.20.0.0/16",
 	}
+	vpcNetwork := &compute.Network{Name: test.SelectedNetwork}
+	converted := func(s *fakeapi.State) *fakeapi.State {
+		s.Networks[0].IPv4Range = ""
+		return s
+	}
 
 	cases := []struct {
-		desc    string
-		ctx     context.Context
-		m       *networkMigrator
+		desc     string
+		ctx      context.Context
+		network  *compute.Network
+		state    *fakeapi.State
+		faults   []faults.Fault
+		approver approval.Approver
+		children []migrate.Migrator
+		// stale, if set, reads the network as it was before the conversion.
+		stale   bool
 		wantErr string
 	}{
 		{
 			desc: "Success",
 			ctx:  ctx,
-			m:    testNetworkMigrator(legacyNetwork, clients),
 		},
 		{
 			desc: "Missing zones",
 			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Container.(*test.FakeContainer).ListClustersResp = &container.ListClustersResponse{
-						Clusters:     []*container.Cluster{&test.PrePatchCluster},
-						MissingZones: []string{"zone-0-a", "zone-1-b"},
-					}
-					return clients
-				}(test.DefaultClients()),
-			),
-		},
-		{
-			desc: "VPC Network",
-			ctx:  ctx,
-			m:    testNetworkMigrator(&compute.Network{Name: test.SelectedNetwork}, clients),
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Script.UnavailableZones = []string{"zone-0-a", "zone-1-b"}
+				return s
+			}(testState()),
 		},
 		{
-			desc: "SwitchToCustomMode error",
-			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Compute.(*test.FakeCompute).SwitchToCustomModeErrs = []error{errors.New("unknown error")}
-					clients.Compute.(*test.FakeCompute).GetGlobalOperationErr = errors.New("not found")
-					return clients
-				}(test.DefaultClients()),
-			),
-			wantErr: "error switching legacy network projects/test-project/global/networks/network-0 to custom mode VPC network: unknown error",
-		},
-		{
-			desc: "Operation in progress",
-			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Compute.(*test.FakeCompute).SwitchToCustomModeResps = []*compute.Operation{
-						nil,
-						{Status: test.OperationDone},
-					}
-					clients.Compute.(*test.FakeCompute).SwitchToCustomModeErrs = []error{
-						test.ErrorInProgress,
-						nil,
-					}
-					return clients
-				}(test.DefaultClients()),
-			),
+			desc:    "VPC Network",
+			ctx:     ctx,
+			network: vpcNetwork,
+			state:   converted(testState()),
+		},
+		{
+			desc:    "SwitchToCustomMode error",
+			ctx:     ctx,
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "SwitchToCustomMode", Message: "unknown error"}},
+			wantErr: "error switching legacy network projects/test-project/global/networks/network-0 to custom mode VPC network: googleapi: Error 503: unknown error",
+		},
+		{
+			desc:   "Operation in progress",
+			ctx:    retried,
+			faults: []faults.Fault{{Kind: faults.Conflict, Method: "SwitchToCustomMode", Count: 1}},
 		},
 		{
 			desc: "SwitchToCustomMode fails",
 			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Compute.(*test.FakeCompute).WaitOperationResp = &compute.Operation{
-						Name:   test.GenericOperationName,
-						Status: test.OperationDone,
-						Error: &compute.OperationError{
-							Errors: []*compute.OperationErrorErrors{
-								{Message: "switch to custom mode failed"},
-							},
-						},
-					}
-					return clients
-				}(test.DefaultClients()),
-			),
+			state: func(s *fakeapi.State) *fakeapi.State {
+				s.Script.Failures = []fakeapi.Failure{{OperationType: "switchToCustomMode", Message: "switch to custom mode failed"}}
+				return s
+			}(testState()),
 			wantErr: "switch to custom mode failed",
 		},
 		{
-			desc: "WaitOperation error",
-			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Compute.(*test.FakeCompute).WaitOperationErr = errors.New("wait error")
-					return clients
-				}(test.DefaultClients()),
-			),
+			desc:    "WaitOperation error",
+			ctx:     ctx,
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "WaitOperation", Message: "wait error"}},
 			wantErr: "wait error",
 		},
 		{
-			desc: "Unable to confirm conversion",
-			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Compute.(*test.FakeCompute).GetNetworkErr = errors.New("get error")
-					return clients
-				}(test.DefaultClients()),
-			),
-			wantErr: `unable to confirm network projects/test-project/global/networks/network-0 was converted: get error`,
+			desc:    "Unable to confirm conversion",
+			ctx:     ctx,
+			faults:  []faults.Fault{{Kind: faults.Error, Method: "GetNetwork", Message: "get error"}},
+			wantErr: `unable to confirm network projects/test-project/global/networks/network-0 was converted: googleapi: Error 503: get error`,
 		},
 		{
-			desc: "Network not converted",
-			ctx:  ctx,
-			m: testNetworkMigrator(
-				legacyNetwork,
-				func(clients *pkg.Clients) *pkg.Clients {
-					clients.Compute.(*test.FakeCompute).GetNetworkResp = legacyNetwork
-					return clients
-				}(test.DefaultClients()),
-			),
+			desc:    "Network not converted",
+			ctx:     ctx,
+			stale:   true,
 			wantErr: `network projects/test-project/global/networks/network-0 was not converted; Network.IPv4Range (10.20.0.0/16) should be empty`,
 		},
 		{
 			desc:    "Context cancelled",
 			ctx:     cancelled,
-			m:       testNetworkMigrator(legacyNetwork, clients),
-			wantErr: "context error: context canceled",
+			wantErr: "error switching legacy network projects/test-project/global/networks/network-0 to custom mode VPC network: context canceled",
+		},
+		{
+			desc:     "Conversion skipped",
+			ctx:      ctx,
+			faults:   []faults.Fault{{Kind: faults.Error, Method: "SwitchToCustomMode", Message: "should not convert"}},
+			approver: approval.NewPrompter(strings.NewReader("s\n"), &bytes.Buffer{}),
+			children: []migrate.Migrator{&migrate.FakeMigrator{MigrateError: errors.New("should not migrate")}},
+		},
+		{
+			desc:     "Conversion aborted",
+			ctx:      ctx,
+			approver: approval.NewPrompter(strings.NewReader("a\n"), &bytes.Buffer{}),
+			wantErr:  "conversion aborted by user",
+		},
+		{
+			desc:     "Converted network does not prompt",
+			ctx:      ctx,
+			network:  vpcNetwork,
+			state:    converted(testState()),
+			approver: approval.NewPrompter(strings.NewReader(""), &bytes.Buffer{}),
 		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			err := tc.m.Migrate(tc.ctx)
+			network, state := tc.network, tc.state
+			if network == nil {
+				network = legacyNetwork
+			}
+			if state == nil {
+				state = testState()
+			}
+			clients := testClients(t, state, tc.faults...)
+			if tc.stale {
+				clients.Compute = &staleNetwork{ComputeService: clients.Compute, network: network}
+			}
+			m := testNetworkMigrator(network, clients)
+			m.approver = tc.approver
+			m.children = tc.children
+
+			err := m.Migrate(tc.ctx)
 			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
 				t.Errorf("networkMigrator.Migrate diff (-want +got):\n%s", diff)
 			}
@@ -287,6 +415,16 @@ func TestNetworkMigrator_Migrate(t *testing.T) {
 	}
 }
 
+// staleNetwork reads the network as it was before any conversion, e.g. from a stale replica.
+type staleNetwork struct {
+	pkg.ComputeService
+	network *compute.Network
+}
+
+func (c *staleNetwork) GetNetwork(_ context.Context, _, _ string, _ ...googleapi.CallOption) (*compute.Network, error) {
+	return c.network, nil
+}
+
 func testNetworkMigrator(n *compute.Network, c *pkg.Clients) *networkMigrator {
 	return &networkMigrator{
 		projectID:          test.ProjectName,
@@ -299,3 +437,146 @@ func testNetworkMigrator(n *compute.Network, c *pkg.Clients) *networkMigrator {
 		},
 	}
 }
+
+// testState returns test.PrePatchCluster on the legacy network test.SelectedNetwork.
+func testState() *fakeapi.State {
+	sc := &fakeapi.Scenario{
+		ProjectID: test.ProjectName,
+		Network:   test.SelectedNetwork,
+		Clusters: []fakeapi.ScenarioCluster{
+			{
+				Name:      test.ClusterName,
+				Location:  test.RegionA,
+				Version:   test.PrePatchCluster.CurrentMasterVersion,
+				NodePools: []fakeapi.ScenarioNodePool{{Name: test.NodePoolName}},
+			},
+		},
+		ServerConfig: test.ServerConfig(),
+	}
+	s := sc.State()
+	s.Networks[0].IPv4Range = "10.20.0.0/16"
+	return s
+}
+
+// testClients returns clients of a fake API serving the State, into which the faults are injected.
+func testClients(t *testing.T, s *fakeapi.State, fs ...faults.Fault) *pkg.Clients {
+	t.Helper()
+	srv, err := fakeapi.New(s)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	injector, err := faults.New(&faults.Config{Faults: fs})
+	if err != nil {
+		t.Fatalf("faults.New unexpected error: %v", err)
+	}
+	return injector.Wrap(srv.Clients())
+}
+
+func TestIsSelected(t *testing.T) {
+	cases := []struct {
+		desc         string
+		cluster      *container.Cluster
+		clusterNames []string
+		want         bool
+	}{
+		{
+			desc:    "All clusters on the network",
+			cluster: &container.Cluster{Name: test.ClusterName, Network: test.SelectedNetwork},
+			want:    true,
+		},
+		{
+			desc:         "Named cluster",
+			cluster:      &container.Cluster{Name: test.ClusterName, Network: test.SelectedNetwork},
+			clusterNames: []string{"other", test.ClusterName},
+			want:         true,
+		},
+		{
+			desc:         "Unnamed cluster",
+			cluster:      &container.Cluster{Name: test.ClusterName, Network: test.SelectedNetwork},
+			clusterNames: []string{"other"},
+		},
+		{
+			desc:         "Other network",
+			cluster:      &container.Cluster{Name: test.ClusterName, Network: "other"},
+			clusterNames: []string{test.ClusterName},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := IsSelected(tc.cluster, test.SelectedNetwork, tc.clusterNames); got != tc.want {
+				t.Errorf("IsSelected; wanted: %t, got: %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestComputeOperation_Progress(t *testing.T) {
+	clients := testClients(t, testState())
+	op, err := clients.Compute.SwitchToCustomMode(context.Background(), test.ProjectName, test.SelectedNetwork)
+	if err != nil {
+		t.Fatalf("SwitchToCustomMode unexpected error: %v", err)
+	}
+	o := &ComputeOperation{ProjectID: test.ProjectName, Operation: op, Client: clients.Compute}
+
+	if _, known := o.Progress(); known {
+		t.Errorf("ComputeOperation.Progress known before first poll")
+	}
+	if _, err := o.IsFinished(context.Background()); err != nil {
+		t.Fatalf("ComputeOperation.IsFinished unexpected error: %v", err)
+	}
+	if got, known := o.Progress(); got != 1 || !known {
+		t.Errorf("ComputeOperation.Progress; wanted: 1, true, got: %v, %v", got, known)
+	}
+	if got := operations.TypeOf(o); got != operations.TypeNetwork {
+		t.Errorf("ComputeOperation type; wanted: %q, got: %q", operations.TypeNetwork, got)
+	}
+}
+
+func TestNetworkMigrator_Wait(t *testing.T) {
+	// The conflicting operation is the conversion of another network; operations are found by name in any location.
+	s := testState()
+	s.Networks = append(s.Networks, &compute.Network{Name: "other", IPv4Range: "10.30.0.0/16"})
+	srv, err := fakeapi.New(s)
+	if err != nil {
+		t.Fatalf("fakeapi.New unexpected error: %v", err)
+	}
+	op, err := srv.Clients().Compute.SwitchToCustomMode(context.Background(), test.ProjectName, "other")
+	if err != nil {
+		t.Fatalf("SwitchToCustomMode unexpected error: %v", err)
+	}
+	injector, err := faults.New(&faults.Config{Faults: []faults.Fault{{Kind: faults.Error, Method: "GetGlobalOperation", Message: "global operation error"}}})
+	if err != nil {
+		t.Fatalf("faults.New unexpected error: %v", err)
+	}
+	clients := injector.Wrap(srv.Clients())
+
+	cases := []struct {
+		desc     string
+		conflict *operations.Conflict
+		wantErr  string
+	}{
+		{
+			desc:     "Global operation",
+			conflict: &operations.Conflict{Operation: op.Name},
+			wantErr:  "global operation error",
+		},
+		{
+			desc:     "Regional operation",
+			conflict: &operations.Conflict{Operation: op.Name, Location: "us-central1"},
+		},
+		{
+			desc:     "Zonal operation",
+			conflict: &operations.Conflict{Operation: op.Name, Location: "us-central1-a"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := testNetworkMigrator(&compute.Network{Name: test.SelectedNetwork, IPv4Range: "10.20.0.0/16"}, clients)
+
+			err := m.wait(context.Background(), tc.conflict)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("networkMigrator.wait diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
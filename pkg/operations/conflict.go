@@ -0,0 +1,186 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	DefaultConflictRetries        = 3
+	DefaultConflictBackoff        = 30 * time.Second
+	DefaultConflictBackoffMaximum = 5 * time.Minute
+)
+
+var (
+	// conflictOperationRegex matches an operation name, optionally preceded by the
+	// zone, region or location of the operation in its path.
+	// GKE names are of the form operation-<digits>-<hex>, while GCE names have additional segments.
+	conflictOperationRegex = regexp.MustCompile(`(?:(?:zones|regions|locations)/([\w-]+)/operations/)?(operation-\w+(?:-\w+)+)`)
+
+	// conflictMessageRegex matches the messages of errors caused by another operation in progress, e.g.
+	// "Operation operation-1-a is currently upgrading cluster c." or "Cluster is running incompatible operation."
+	conflictMessageRegex = regexp.MustCompile(`(?i)(operation \S+ is currently|is running incompatible operation|another operation is in progress|operation in progress)`)
+
+	// conflictReasons are the error reasons of GCE and GKE errors caused by another operation in progress.
+	conflictReasons = map[string]bool{
+		"resourceNotReady":    true,
+		"operationInProgress": true,
+	}
+)
+
+// Conflict is an error caused by another operation in progress on the same resource.
+type Conflict struct {
+	// Operation is the name of the conflicting operation, if reported.
+	Operation string
+	// Location is the zone, region or location of the conflicting operation, if reported.
+	Location string
+
+	Err error
+}
+
+func (c *Conflict) Error() string {
+	return c.Err.Error()
+}
+
+func (c *Conflict) Unwrap() error {
+	return c.Err
+}
+
+// AsConflict returns the Conflict for err if it was caused by another operation in progress.
+func AsConflict(err error) (*Conflict, bool) {
+	if err == nil {
+		return nil, false
+	}
+	var c *Conflict
+	if errors.As(err, &c) {
+		return c, true
+	}
+	if !isConflict(err) {
+		return nil, false
+	}
+	c = &Conflict{Err: err}
+	if m := conflictOperationRegex.FindStringSubmatch(err.Error()); m != nil {
+		c.Location, c.Operation = m[1], m[2]
+	}
+	return c, true
+}
+
+// isConflict returns whether err was caused by another operation in progress, based on the
+// reasons, or the code and message, of a googleapi.Error, or on the error message otherwise.
+func isConflict(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return conflictMessageRegex.MatchString(err.Error()) && conflictOperationRegex.MatchString(err.Error())
+	}
+	for _, e := range gerr.Errors {
+		if conflictReasons[e.Reason] {
+			return true
+		}
+	}
+	for _, d := range gerr.Details {
+		if m, ok := d.(map[string]interface{}); ok {
+			if reason, ok := m["reason"].(string); ok && conflictReasons[reason] {
+				return true
+			}
+		}
+	}
+	// Other errors with these codes, e.g. a 409 for a resource which already exists, are not conflicts.
+	switch gerr.Code {
+	case http.StatusBadRequest, http.StatusConflict, http.StatusPreconditionFailed:
+		return conflictMessageRegex.MatchString(gerr.Message)
+	}
+	return false
+}
+
+// ConflictRetry configures retries of calls which fail because of another operation in progress.
+type ConflictRetry struct {
+	// Retries is the maximum number of times a call is retried.
+	Retries int
+	// Backoff sets the delay before each retry when the conflicting operation is not reported,
+	// and so cannot be waited on.
+	Backoff Strategy
+}
+
+// DefaultConflictRetry returns the ConflictRetry used when none is attached to the context.
+func DefaultConflictRetry() ConflictRetry {
+	return ConflictRetry{
+		Retries: DefaultConflictRetries,
+		Backoff: NewExponential(DefaultConflictBackoff, DefaultConflictBackoffMaximum),
+	}
+}
+
+type conflictRetryKey struct{}
+
+// WithConflictRetry returns a copy of ctx which retries conflicting calls made with
+// WaitForOperationInProgress according to r.
+func WithConflictRetry(ctx context.Context, r ConflictRetry) context.Context {
+	return context.WithValue(ctx, conflictRetryKey{}, r)
+}
+
+func conflictRetry(ctx context.Context) ConflictRetry {
+	if r, ok := ctx.Value(conflictRetryKey{}).(ConflictRetry); ok {
+		return r
+	}
+	return DefaultConflictRetry()
+}
+
+// Do calls f until it succeeds, fails with an error other than a Conflict, or the retries are exhausted.
+// Conflicting operations are waited on using wait before retrying; if the operation is not reported,
+// the call is retried after the Backoff delay. If Retries is 0, the error of f is returned unwrapped.
+func (r ConflictRetry) Do(ctx context.Context, f func(ctx context.Context) error, wait func(ctx context.Context, c *Conflict) error) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := f(ctx)
+		c, ok := AsConflict(err)
+		if !ok {
+			return err
+		}
+		if r.Retries == 0 {
+			// Retries are disabled, so the error is returned as is.
+			return err
+		}
+		if attempt >= r.Retries {
+			return fmt.Errorf("giving up after %d retries: %w", r.Retries, err)
+		}
+
+		if c.Operation != "" {
+			log.Infof("Operation %s is in progress; wait for operation to complete: %v", c.Operation, err)
+			if err := wait(ctx, c); err != nil {
+				return err
+			}
+			log.Infof("Operation %s is complete; retrying. Retry due to: %v", c.Operation, err)
+			continue
+		}
+
+		var delay time.Duration
+		if r.Backoff != nil {
+			delay = r.Backoff.Next(attempt, time.Since(start), nil)
+		}
+		log.Infof("Another operation is in progress; retrying in %v. Retry due to: %v", delay.Round(time.Second), err)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
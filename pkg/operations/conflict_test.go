@@ -0,0 +1,237 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/api/googleapi"
+)
+
+func TestAsConflict(t *testing.T) {
+	cases := []struct {
+		desc   string
+		err    error
+		want   *Conflict
+		wantOK bool
+	}{
+		{
+			desc: "Nil",
+		},
+		{
+			desc: "Unrelated error",
+			err:  errors.New("some other error"),
+		},
+		{
+			desc:   "GKE in progress message",
+			err:    errors.New("Operation operation-1622566230187-eed3f066 is currently upgrading cluster name-here. Please wait and try again once it is done."),
+			want:   &Conflict{Operation: "operation-1622566230187-eed3f066"},
+			wantOK: true,
+		},
+		{
+			desc: "GKE incompatible operation with location",
+			err: fmt.Errorf("wrapped: %w", &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Cluster is running incompatible operation projects/123/locations/us-central1-a/operations/operation-1622566230187-eed3f066.",
+			}),
+			want:   &Conflict{Operation: "operation-1622566230187-eed3f066", Location: "us-central1-a"},
+			wantOK: true,
+		},
+		{
+			desc: "GKE conflict code without operation",
+			err: &googleapi.Error{
+				Code:    http.StatusConflict,
+				Message: "Cluster is running incompatible operation.",
+			},
+			want:   &Conflict{},
+			wantOK: true,
+		},
+		{
+			desc: "Conflict code for an existing resource",
+			err: &googleapi.Error{
+				Code:    http.StatusConflict,
+				Message: "Already exists: projects/p/locations/l/clusters/c.",
+				Errors:  []googleapi.ErrorItem{{Reason: "alreadyExists"}},
+			},
+		},
+		{
+			desc: "GCE resource not ready",
+			err: &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "The resource 'projects/p/global/networks/n' is not ready",
+				Errors:  []googleapi.ErrorItem{{Reason: "resourceNotReady"}},
+			},
+			want:   &Conflict{},
+			wantOK: true,
+		},
+		{
+			desc: "GCE regional operation",
+			err: &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Operation projects/p/regions/us-central1/operations/operation-1622566230187-5c3b6f7c6b0c5-49b7c1c0-6c1a3e29 is currently in progress",
+			},
+			want:   &Conflict{Operation: "operation-1622566230187-5c3b6f7c6b0c5-49b7c1c0-6c1a3e29", Location: "us-central1"},
+			wantOK: true,
+		},
+		{
+			desc: "Reason in details",
+			err: &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Details: []interface{}{map[string]interface{}{"reason": "operationInProgress"}},
+			},
+			want:   &Conflict{},
+			wantOK: true,
+		},
+		{
+			desc: "Unrelated API error",
+			err: &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Master version \"1.30\" is unsupported.",
+			},
+		},
+		{
+			desc: "Resource not ready without a reason",
+			err: &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Node pool default-pool is not ready for operation-1-a.",
+			},
+		},
+		{
+			desc: "Resource in an error state",
+			err: &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Node pool default-pool is currently in an error state.",
+			},
+		},
+		{
+			desc: "Not found mentioning an operation",
+			err: &googleapi.Error{
+				Code:    http.StatusNotFound,
+				Message: "Operation operation-1-a is currently not found",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, ok := AsConflict(tc.err)
+			if ok != tc.wantOK {
+				t.Fatalf("AsConflict(%v); wanted: %v, got: %v", tc.err, tc.wantOK, ok)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreFields(Conflict{}, "Err")); diff != "" {
+				t.Errorf("AsConflict diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConflictRetry_Do(t *testing.T) {
+	conflict := &googleapi.Error{Code: http.StatusConflict, Message: "Cluster is running incompatible operation."}
+	cases := []struct {
+		desc      string
+		results   []error
+		retries   int
+		wantErr   string
+		wantCalls int
+		wantWaits int
+	}{
+		{
+			desc:      "Waits on each conflicting operation",
+			results:   []error{test.ErrorInProgress, test.ErrorInProgress, nil},
+			retries:   3,
+			wantCalls: 3,
+			wantWaits: 2,
+		},
+		{
+			desc:      "Backs off without an operation",
+			results:   []error{conflict, conflict, nil},
+			retries:   3,
+			wantCalls: 3,
+		},
+		{
+			desc:      "Retries exhausted",
+			results:   []error{test.ErrorInProgress, test.ErrorInProgress, test.ErrorInProgress},
+			retries:   2,
+			wantErr:   "giving up after 2 retries: Operation operation-asdf-asdf is currently doing something",
+			wantCalls: 3,
+			wantWaits: 2,
+		},
+		{
+			desc:      "No retries",
+			results:   []error{conflict},
+			wantErr:   "googleapi: Error 409: Cluster is running incompatible operation.",
+			wantCalls: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var calls, waits int
+			f := func(_ context.Context) error {
+				err := tc.results[calls]
+				calls++
+				return err
+			}
+			wait := func(_ context.Context, c *Conflict) error {
+				if c.Operation != "operation-asdf-asdf" {
+					t.Errorf("Conflict.Operation; wanted: operation-asdf-asdf, got: %q", c.Operation)
+				}
+				waits++
+				return nil
+			}
+			r := ConflictRetry{Retries: tc.retries, Backoff: Fixed{Interval: time.Millisecond}}
+
+			err := r.Do(context.Background(), f, wait)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("ConflictRetry.Do error diff (-want +got):\n%s", diff)
+			}
+			if calls != tc.wantCalls || waits != tc.wantWaits {
+				t.Errorf("ConflictRetry.Do calls; wanted: %d calls, %d waits, got: %d calls, %d waits", tc.wantCalls, tc.wantWaits, calls, waits)
+			}
+		})
+	}
+}
+
+func TestConflictRetry_DoDisabled(t *testing.T) {
+	r := ConflictRetry{}
+	err := r.Do(context.Background(), func(_ context.Context) error { return test.ErrorInProgress }, nil)
+	if err != test.ErrorInProgress {
+		t.Errorf("ConflictRetry.Do with retries disabled; wanted the original error, got: %v", err)
+	}
+}
+
+func TestWaitForOperationInProgress_ContextRetry(t *testing.T) {
+	ctx := WithConflictRetry(context.Background(), ConflictRetry{Retries: 1})
+	var calls int
+	f := func(_ context.Context) error {
+		calls++
+		return test.ErrorInProgress
+	}
+	err := WaitForOperationInProgress(ctx, f, func(_ context.Context, _ *Conflict) error { return nil })
+	if diff := test.ErrorDiff("giving up after 1 retries", err); diff != "" {
+		t.Errorf("WaitForOperationInProgress diff (-want +got):\n%s", diff)
+	}
+	if calls != 2 {
+		t.Errorf("WaitForOperationInProgress calls; wanted: 2, got: %d", calls)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"time"
+)
+
+// NodeCounter is implemented by Operations which act on a number of nodes (e.g. node pool upgrades).
+type NodeCounter interface {
+	NodeCount() int64
+}
+
+// Deadlines selects the deadline for an Operation by OperationType.
+type Deadlines struct {
+	// Default is used for types without a deadline in ByType.
+	Default time.Duration
+	ByType  map[OperationType]time.Duration
+	// PerNode is added to the deadline for each node of Operations which implement NodeCounter.
+	PerNode time.Duration
+}
+
+// For returns the deadline for op.
+func (d Deadlines) For(op Operation) time.Duration {
+	deadline, ok := d.ByType[TypeOf(op)]
+	if !ok || deadline == 0 {
+		deadline = d.Default
+	}
+	if n, ok := op.(NodeCounter); ok && d.PerNode > 0 {
+		deadline += time.Duration(n.NodeCount()) * d.PerNode
+	}
+	return deadline
+}
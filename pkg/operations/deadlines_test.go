@@ -0,0 +1,112 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"legacymigration/test"
+)
+
+// nodeOperation is a progressOperation which acts on a number of nodes.
+type nodeOperation struct {
+	progressOperation
+	nodes int64
+}
+
+func (o *nodeOperation) NodeCount() int64 {
+	return o.nodes
+}
+
+func TestDeadlines_For(t *testing.T) {
+	d := Deadlines{
+		Default: time.Hour,
+		ByType: map[OperationType]time.Duration{
+			TypeNetwork:  10 * time.Minute,
+			TypeNodePool: 2 * time.Hour,
+		},
+		PerNode: time.Minute,
+	}
+	cases := []struct {
+		desc      string
+		deadlines Deadlines
+		op        Operation
+		want      time.Duration
+	}{
+		{
+			desc:      "Untyped",
+			deadlines: d,
+			op:        &FakeOperation{},
+			want:      time.Hour,
+		},
+		{
+			desc:      "Type without deadline",
+			deadlines: d,
+			op:        &progressOperation{typ: TypeControlPlane},
+			want:      time.Hour,
+		},
+		{
+			desc:      "Network",
+			deadlines: d,
+			op:        &progressOperation{typ: TypeNetwork},
+			want:      10 * time.Minute,
+		},
+		{
+			desc:      "Scaled by nodes",
+			deadlines: d,
+			op:        &nodeOperation{progressOperation: progressOperation{typ: TypeNodePool}, nodes: 30},
+			want:      150 * time.Minute,
+		},
+		{
+			desc:      "No per node deadline",
+			deadlines: Deadlines{Default: time.Hour},
+			op:        &nodeOperation{nodes: 30},
+			want:      time.Hour,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := tc.deadlines.For(tc.op); got != tc.want {
+				t.Errorf("Deadlines.For; wanted: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWait_TypedDeadline(t *testing.T) {
+	h := NewStrategyHandler(Strategies{Default: Fixed{Interval: time.Millisecond}}, Deadlines{
+		Default: time.Hour,
+		ByType:  map[OperationType]time.Duration{TypeNetwork: 10 * time.Millisecond},
+	})
+	op := &progressOperation{
+		FakeOperation: FakeOperation{
+			Responses: []struct {
+				finished bool
+				err      error
+			}{
+				{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {},
+			},
+		},
+		typ: TypeNetwork,
+	}
+
+	err := h.Wait(context.Background(), op)
+	if diff := test.ErrorDiff("context deadline exceeded", err); diff != "" {
+		t.Errorf("HandlerImpl.Wait diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+)
+
+// Canceler is implemented by Operations which may be cancelled server-side.
+// Only operations for which CanCancel reports true are cancelled by CancelInFlight,
+// e.g. those started by the migrators rather than only waited on.
+type Canceler interface {
+	Cancel(ctx context.Context) error
+	CanCancel() bool
+}
+
+// InFlight describes an operation which is being waited on, or which was still
+// running when waiting stopped (e.g. on abort or when its deadline was exceeded).
+type InFlight struct {
+	Operation string        `json:"operation"`
+	Type      OperationType `json:"type,omitempty"`
+	Started   time.Time     `json:"started"`
+	Cancelled bool          `json:"cancelled,omitempty"`
+
+	op Operation
+	// aborted is set if waiting stopped because the context was cancelled, rather than
+	// e.g. because the operation stalled or its deadline was exceeded.
+	aborted bool
+}
+
+func (f InFlight) String() string {
+	msg := fmt.Sprintf("operation %s", f.Operation)
+	if f.Type != TypeUnknown {
+		msg = fmt.Sprintf("%s operation %s", f.Type, f.Operation)
+	}
+	msg += fmt.Sprintf(" (running for %v)", time.Since(f.Started).Round(time.Second))
+	if f.Cancelled {
+		msg += ", cancelled"
+	}
+	return msg
+}
+
+// tracker records the operations waited on by a Handler.
+// A nil tracker records nothing.
+type tracker struct {
+	mu        sync.Mutex
+	inFlight  []*InFlight
+	abandoned []*InFlight
+}
+
+func newTracker() *tracker {
+	return &tracker{}
+}
+
+// add records that op is being waited on.
+func (t *tracker) add(op Operation) *InFlight {
+	if t == nil {
+		return nil
+	}
+	f := &InFlight{Operation: op.String(), Type: TypeOf(op), Started: time.Now(), op: op}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight = append(t.inFlight, f)
+	return f
+}
+
+// remove records that waiting on f stopped. Operations which did not finish are abandoned.
+func (t *tracker) remove(f *InFlight, finished, aborted bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, g := range t.inFlight {
+		if g == f {
+			t.inFlight = append(t.inFlight[:i], t.inFlight[i+1:]...)
+			break
+		}
+	}
+	if !finished {
+		f.aborted = aborted
+		t.abandoned = append(t.abandoned, f)
+	}
+}
+
+// list returns copies of entries, ordered by start time.
+func list(entries []*InFlight) []InFlight {
+	var res []InFlight
+	for _, f := range entries {
+		res = append(res, *f)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Started.Before(res[j].Started) })
+	return res
+}
+
+// InFlight returns the operations currently being waited on.
+func (h HandlerImpl) InFlight() []InFlight {
+	if h.ops == nil {
+		return nil
+	}
+	h.ops.mu.Lock()
+	defer h.ops.mu.Unlock()
+	return list(h.ops.inFlight)
+}
+
+// Abandoned returns the operations which were still running when waiting on them stopped.
+func (h HandlerImpl) Abandoned() []InFlight {
+	if h.ops == nil {
+		return nil
+	}
+	h.ops.mu.Lock()
+	defer h.ops.mu.Unlock()
+	return list(h.ops.abandoned)
+}
+
+// CancelInFlight cancels the operations still in flight when the run was aborted: those being waited on,
+// and those abandoned because waiting on them was cancelled. Operations abandoned for another reason (e.g. a
+// stall or an exceeded deadline) are left running. Only operations which implement Canceler, report that they
+// can be cancelled and have not already been cancelled are cancelled. Returns the operations which were cancelled.
+func (h HandlerImpl) CancelInFlight(ctx context.Context) ([]InFlight, error) {
+	if h.ops == nil {
+		return nil, nil
+	}
+	h.ops.mu.Lock()
+	candidates := append([]*InFlight{}, h.ops.inFlight...)
+	for _, f := range h.ops.abandoned {
+		if f.aborted {
+			candidates = append(candidates, f)
+		}
+	}
+	var pending []*InFlight
+	for _, f := range candidates {
+		if c, ok := f.op.(Canceler); ok && c.CanCancel() && !f.Cancelled {
+			pending = append(pending, f)
+		}
+	}
+	h.ops.mu.Unlock()
+
+	var (
+		errs      error
+		cancelled []*InFlight
+	)
+	for _, f := range pending {
+		log.Infof("Cancelling %s.", f)
+		if err := f.op.(Canceler).Cancel(ctx); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("error cancelling operation %s: %w", f.Operation, err))
+			continue
+		}
+		h.ops.mu.Lock()
+		f.Cancelled = true
+		h.ops.mu.Unlock()
+		cancelled = append(cancelled, f)
+	}
+
+	h.ops.mu.Lock()
+	defer h.ops.mu.Unlock()
+	return list(cancelled), errs
+}
@@ -0,0 +1,213 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// blockingOperation finishes once release is closed, and may be cancelled unless notCancelable is set.
+type blockingOperation struct {
+	name          string
+	release       chan struct{}
+	cancelErr     error
+	notCancelable bool
+
+	mu        sync.Mutex
+	cancelled int
+}
+
+func (o *blockingOperation) IsFinished(_ context.Context) (bool, error) {
+	select {
+	case <-o.release:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (o *blockingOperation) String() string {
+	return o.name
+}
+
+func (o *blockingOperation) Type() OperationType {
+	return TypeNodePool
+}
+
+func (o *blockingOperation) CanCancel() bool {
+	return !o.notCancelable
+}
+
+func (o *blockingOperation) Cancel(_ context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.cancelled++
+	return o.cancelErr
+}
+
+var ignoreInFlight = cmpopts.IgnoreFields(InFlight{}, "Started", "op", "aborted")
+
+// waitInFlight waits until h reports n in-flight operations.
+func waitInFlight(t *testing.T, h *HandlerImpl, n int) {
+	for i := 0; i < 1000; i++ {
+		if len(h.InFlight()) == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("HandlerImpl.InFlight; wanted %d operations, got: %v", n, h.InFlight())
+}
+
+func TestWait_InFlight(t *testing.T) {
+	h := NewStrategyHandler(Strategies{Default: Fixed{Interval: time.Millisecond}}, Deadlines{Default: time.Minute})
+	op := &blockingOperation{name: "op", release: make(chan struct{})}
+
+	errs := make(chan error)
+	go func() { errs <- h.Wait(context.Background(), op) }()
+	waitInFlight(t, h, 1)
+
+	want := []InFlight{{Operation: "op", Type: TypeNodePool}}
+	if diff := cmp.Diff(want, h.InFlight(), ignoreInFlight); diff != "" {
+		t.Errorf("HandlerImpl.InFlight diff (-want +got):\n%s", diff)
+	}
+
+	close(op.release)
+	if err := <-errs; err != nil {
+		t.Fatalf("HandlerImpl.Wait unexpected error: %v", err)
+	}
+	if got := h.InFlight(); len(got) != 0 {
+		t.Errorf("HandlerImpl.InFlight after completion; wanted none, got: %v", got)
+	}
+	if got := h.Abandoned(); len(got) != 0 {
+		t.Errorf("HandlerImpl.Abandoned after completion; wanted none, got: %v", got)
+	}
+}
+
+func TestWait_Abandoned(t *testing.T) {
+	h := NewStrategyHandler(Strategies{Default: Fixed{Interval: time.Millisecond}}, Deadlines{Default: time.Minute})
+	op := &blockingOperation{name: "op", release: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() { errs <- h.Wait(ctx, op) }()
+	waitInFlight(t, h, 1)
+	cancel()
+
+	if diff := test.ErrorDiff("context canceled", <-errs); diff != "" {
+		t.Errorf("HandlerImpl.Wait diff (-want +got):\n%s", diff)
+	}
+	if got := h.InFlight(); len(got) != 0 {
+		t.Errorf("HandlerImpl.InFlight after abort; wanted none, got: %v", got)
+	}
+	want := []InFlight{{Operation: "op", Type: TypeNodePool}}
+	if diff := cmp.Diff(want, h.Abandoned(), ignoreInFlight); diff != "" {
+		t.Errorf("HandlerImpl.Abandoned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCancelInFlight(t *testing.T) {
+	cases := []struct {
+		desc          string
+		op            *blockingOperation
+		want          []InFlight
+		wantErr       string
+		wantCancelled int
+	}{
+		{
+			desc:          "Cancelled",
+			op:            &blockingOperation{name: "op", release: make(chan struct{})},
+			want:          []InFlight{{Operation: "op", Type: TypeNodePool, Cancelled: true}},
+			wantCancelled: 1,
+		},
+		{
+			desc:          "Cancel error",
+			op:            &blockingOperation{name: "op", release: make(chan struct{}), cancelErr: errors.New("cancel error")},
+			wantErr:       "error cancelling operation op: cancel error",
+			wantCancelled: 2,
+		},
+		{
+			desc:          "Not cancelable",
+			op:            &blockingOperation{name: "op", release: make(chan struct{}), notCancelable: true},
+			wantCancelled: 0,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			h := NewStrategyHandler(Strategies{Default: Fixed{Interval: time.Millisecond}}, Deadlines{Default: time.Minute})
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error)
+			go func() { errs <- h.Wait(ctx, tc.op) }()
+			waitInFlight(t, h, 1)
+
+			got, err := h.CancelInFlight(context.Background())
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("HandlerImpl.CancelInFlight error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got, ignoreInFlight); diff != "" {
+				t.Errorf("HandlerImpl.CancelInFlight diff (-want +got):\n%s", diff)
+			}
+
+			// Abandoned operations are cancelled at most once.
+			cancel()
+			<-errs
+			h.CancelInFlight(context.Background())
+			if tc.op.cancelled != tc.wantCancelled {
+				t.Errorf("Operation.Cancel calls; wanted: %d, got: %d", tc.wantCancelled, tc.op.cancelled)
+			}
+		})
+	}
+}
+
+func TestCancelInFlight_Expired(t *testing.T) {
+	h := NewStrategyHandler(Strategies{Default: Fixed{Interval: time.Millisecond}}, Deadlines{Default: 5 * time.Millisecond})
+	op := &blockingOperation{name: "op", release: make(chan struct{})}
+
+	if diff := test.ErrorDiff("deadline exceeded", h.Wait(context.Background(), op)); diff != "" {
+		t.Errorf("HandlerImpl.Wait diff (-want +got):\n%s", diff)
+	}
+	if got := h.Abandoned(); len(got) != 1 {
+		t.Fatalf("HandlerImpl.Abandoned; wanted 1 operation, got: %v", got)
+	}
+	got, err := h.CancelInFlight(context.Background())
+	if err != nil {
+		t.Errorf("HandlerImpl.CancelInFlight unexpected error: %v", err)
+	}
+	if len(got) != 0 || op.cancelled != 0 {
+		t.Errorf("HandlerImpl.CancelInFlight; wanted none cancelled, got: %v", got)
+	}
+}
+
+func TestCancelInFlight_NotCancelable(t *testing.T) {
+	h := NewHandler(time.Millisecond, time.Minute)
+	h.ops.add(&FakeOperation{})
+
+	got, err := h.CancelInFlight(context.Background())
+	if err != nil {
+		t.Errorf("HandlerImpl.CancelInFlight unexpected error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("HandlerImpl.CancelInFlight; wanted none, got: %v", got)
+	}
+}
@@ -19,8 +19,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"regexp"
-	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -30,10 +28,6 @@ const (
 	StatusDone = "DONE"
 )
 
-var (
-	operationRegex = regexp.MustCompile(`operation-[\d\w]+-[\d\w]+`)
-)
-
 // Operation is an interface for GCE and GKE Operations.
 type Operation interface {
 	// IsFinished checks whether the Operation status is complete.
@@ -56,41 +50,120 @@ type Handler interface {
 
 // HandlerImpl is a thread-safe implementation of Handler.
 type HandlerImpl struct {
-	interval time.Duration
-	deadline time.Duration
+	interval   time.Duration
+	deadline   time.Duration
+	strategies Strategies
+	deadlines  Deadlines
+	stalls     StallDetection
+	ops        *tracker
 }
 
+// NewHandler returns a Handler which polls all operations at a fixed interval.
 func NewHandler(interval time.Duration, deadline time.Duration) *HandlerImpl {
-	return &HandlerImpl{interval: interval, deadline: deadline}
+	return &HandlerImpl{interval: interval, deadline: deadline, ops: newTracker()}
+}
+
+// NewStrategyHandler returns a Handler which polls operations using the Strategy
+// and the deadline for their type.
+func NewStrategyHandler(strategies Strategies, deadlines Deadlines) *HandlerImpl {
+	return &HandlerImpl{deadline: deadlines.Default, strategies: strategies, deadlines: deadlines, ops: newTracker()}
+}
+
+// strategy returns the polling Strategy for op.
+func (h HandlerImpl) strategy(op Operation) Strategy {
+	if s := h.strategies.For(op); s != nil {
+		return s
+	}
+	return Fixed{Interval: h.interval}
+}
+
+// SetStallDetection configures detection of operations which stop making progress.
+func (h *HandlerImpl) SetStallDetection(d StallDetection) {
+	h.stalls = d
 }
 
-// Wait loops over Operation.IsFinished method until the operation is complete.
+// deadlineFor returns the deadline for op.
+func (h HandlerImpl) deadlineFor(op Operation) time.Duration {
+	if d := h.deadlines.For(op); d > 0 {
+		return d
+	}
+	return h.deadline
+}
+
+// Wait polls Operation.IsFinished, with delays set by the polling Strategy for the operation, until the operation is complete.
+// The operation is reported by InFlight while waiting, and by Abandoned if waiting stops before it is complete.
 func (h HandlerImpl) Wait(ctx context.Context, op Operation) error {
-	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(h.deadline))
+	var finished bool
+	entry := h.ops.add(op)
+	parent := ctx
+	defer func() { h.ops.remove(entry, finished, errors.Is(parent.Err(), context.Canceled)) }()
+
+	deadline := h.deadlineFor(op)
+	log.Debugf("Waiting up to %v for %s", deadline, op)
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(deadline))
 	defer cancel()
-	ticker := time.NewTicker(h.interval)
-	defer ticker.Stop()
 
-	for {
+	strategy := h.strategy(op)
+	lp, ok := op.(LongPoller)
+	longPoll := ok && lp.LongPoll()
+	start := time.Now()
+	stalls := newStallTracker(h.stalls, op)
+	var pollDuration time.Duration
+
+	for attempt := 0; ; attempt++ {
+		delay := strategy.Next(attempt, time.Since(start), op)
+		if longPoll {
+			// The server has already spent part of the delay waiting on the operation.
+			delay -= pollDuration
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+
+		log.Debugf("Polling for %s (attempt %d)", op, attempt+1)
+		pollStart := time.Now()
+		done, err := op.IsFinished(ctx)
+		pollDuration = time.Since(pollStart)
+		finished = done
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if err := stalls.observe(time.Now()); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for d or until ctx is closed or past its deadline.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
 		select {
 		case <-ctx.Done():
 			return fmt.Errorf("context error: %w", ctx.Err())
-		case <-ticker.C:
-			log.Debugf("Polling for %s", op)
-			done, err := op.IsFinished(ctx)
-			if err != nil {
-				return err
-			}
-			if done {
-				return nil
-			}
+		case <-timer.C:
 		}
 	}
+	// The deadline may have passed without ctx being closed yet.
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return fmt.Errorf("context error: %w", context.DeadlineExceeded)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("context error: %w", err)
+	}
+	return nil
 }
 
 // ObtainID attempts to retrieve an operation name from the error.
 func ObtainID(err error) string {
-	return operationRegex.FindString(err.Error())
+	if m := conflictOperationRegex.FindStringSubmatch(err.Error()); m != nil {
+		return m[2]
+	}
+	return ""
 }
 
 func IsFinished(ctx context.Context, poll func(ctx context.Context) (OperationStatus, error)) (bool, error) {
@@ -109,29 +182,9 @@ func IsFinished(ctx context.Context, poll func(ctx context.Context) (OperationSt
 	return true, nil
 }
 
-// WaitForOperationInProgress will attempt a retry of the function.
-func WaitForOperationInProgress(ctx context.Context, f func(ctx context.Context) error, wait func(ctx context.Context, op string) error) error {
-	err := f(ctx)
-	if err == nil {
-		return nil
-	}
-
-	op := ObtainID(err)
-	if op == "" {
-		return err
-	}
-	if !strings.Contains(err.Error(), fmt.Sprintf("Operation %s is currently", op)) {
-		// Match format of errors returned by the GKE API.
-		return err
-	}
-
-	log.Infof("Operation %s is in progress; wait for operation to complete: %v", op, err)
-
-	if err := wait(ctx, op); err != nil {
-		return err
-	}
-
-	log.Infof("Operation %s is complete; retrying. Retry due to: %v", op, err)
-
-	return f(ctx)
+// WaitForOperationInProgress calls f, retrying if it fails because of another operation in progress.
+// Conflicting operations are waited on using wait. Retries are configured by the ConflictRetry
+// attached to ctx, or DefaultConflictRetry if none is attached.
+func WaitForOperationInProgress(ctx context.Context, f func(ctx context.Context) error, wait func(ctx context.Context, c *Conflict) error) error {
+	return conflictRetry(ctx).Do(ctx, f, wait)
 }
@@ -22,6 +22,8 @@ import (
 	"time"
 
 	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 type FakeOperation struct {
@@ -166,6 +168,79 @@ func TestWait(t *testing.T) {
 	}
 }
 
+// longPollOperation blocks for each poll and finishes on the final poll.
+type longPollOperation struct {
+	block time.Duration
+	polls int
+}
+
+func (o *longPollOperation) IsFinished(_ context.Context) (bool, error) {
+	time.Sleep(o.block)
+	o.polls--
+	return o.polls <= 0, nil
+}
+
+func (o *longPollOperation) String() string {
+	return "long-poll-op"
+}
+
+func (o *longPollOperation) LongPoll() bool {
+	return true
+}
+
+// recordingStrategy records the attempts it was asked for.
+type recordingStrategy struct {
+	attempts []int
+}
+
+func (s *recordingStrategy) Next(attempt int, _ time.Duration, _ Operation) time.Duration {
+	s.attempts = append(s.attempts, attempt)
+	return time.Microsecond
+}
+
+func TestWait_Strategies(t *testing.T) {
+	typed := &recordingStrategy{}
+	def := &recordingStrategy{}
+	h := NewStrategyHandler(Strategies{
+		Default: def,
+		ByType:  map[OperationType]Strategy{TypeNodePool: typed},
+	}, Deadlines{Default: time.Second})
+
+	op := &progressOperation{
+		FakeOperation: FakeOperation{
+			Responses: []struct {
+				finished bool
+				err      error
+			}{
+				{},
+				{finished: true},
+			},
+		},
+		typ: TypeNodePool,
+	}
+	if err := h.Wait(context.Background(), op); err != nil {
+		t.Fatalf("HandlerImpl.Wait unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]int{0, 1}, typed.attempts); diff != "" {
+		t.Errorf("Typed strategy attempts diff (-want +got):\n%s", diff)
+	}
+	if len(def.attempts) != 0 {
+		t.Errorf("Default strategy used for typed operation: %v", def.attempts)
+	}
+}
+
+func TestWait_LongPoll(t *testing.T) {
+	// Each poll blocks for the full interval, so no further delay is added after the first poll.
+	// Without deducting the time spent blocked, the operation would finish after 600ms.
+	interval := 100 * time.Millisecond
+	h := NewStrategyHandler(Strategies{Default: Fixed{Interval: interval}}, Deadlines{Default: 500 * time.Millisecond})
+
+	err := h.Wait(context.Background(), &longPollOperation{block: interval, polls: 3})
+	if err != nil {
+		t.Errorf("HandlerImpl.Wait unexpected error: %v", err)
+	}
+}
+
 func TestIsFinished(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -286,7 +361,7 @@ func TestWaitIfOperationInProgress(t *testing.T) {
 	cases := []struct {
 		desc    string
 		results []error
-		wait    func(ctx context.Context, op string) error
+		wait    func(ctx context.Context, c *Conflict) error
 		want    string
 	}{
 		{
@@ -296,24 +371,24 @@ func TestWaitIfOperationInProgress(t *testing.T) {
 		{
 			desc:    "Success after wait",
 			results: []error{test.ErrorInProgress, nil},
-			wait:    func(ctx context.Context, op string) error { return nil },
+			wait:    func(ctx context.Context, c *Conflict) error { return nil },
 		},
 		{
 			desc:    "Not ongoing operation",
 			results: []error{errors.New("unrecoverable error during call")},
-			wait:    func(ctx context.Context, op string) error { return nil },
+			wait:    func(ctx context.Context, c *Conflict) error { return nil },
 			want:    "unrecoverable error during call",
 		},
 		{
 			desc:    "Error during wait",
 			results: []error{test.ErrorInProgress},
-			wait:    func(ctx context.Context, op string) error { return errors.New("unrecoverable error during wait") },
+			wait:    func(ctx context.Context, c *Conflict) error { return errors.New("unrecoverable error during wait") },
 			want:    "unrecoverable error during wait",
 		},
 		{
 			desc:    "Error on retry",
 			results: []error{test.ErrorInProgress, errors.New("unrecoverable error during second call")},
-			wait:    func(ctx context.Context, op string) error { return nil },
+			wait:    func(ctx context.Context, c *Conflict) error { return nil },
 			want:    "unrecoverable error during second call",
 		},
 	}
@@ -0,0 +1,214 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// OperationType classifies Operations so that each type may be polled differently.
+type OperationType string
+
+const (
+	TypeUnknown      OperationType = ""
+	TypeNetwork      OperationType = "network"
+	TypeControlPlane OperationType = "control-plane"
+	TypeNodePool     OperationType = "node-pool"
+
+	// Polling strategy names.
+	StrategyFixed       = "fixed"
+	StrategyExponential = "exponential"
+	StrategyETA         = "eta"
+
+	defaultMultiplier = 2.0
+	defaultJitter     = 0.2
+)
+
+// Typed is implemented by Operations which report their OperationType.
+type Typed interface {
+	Type() OperationType
+}
+
+// ProgressReporter is implemented by Operations which report how much of the
+// operation was complete, as a fraction between 0 and 1, when last polled.
+// ok is false if the progress is unknown.
+type ProgressReporter interface {
+	Progress() (fraction float64, ok bool)
+}
+
+// LongPoller is implemented by Operations whose IsFinished blocks server-side
+// until the operation is complete or a server timeout elapses (e.g. GCE Operations.Wait).
+// Time spent blocked is deducted from the delay before the next poll.
+type LongPoller interface {
+	LongPoll() bool
+}
+
+// TypeOf returns the OperationType of op, or TypeUnknown if it does not report one.
+func TypeOf(op Operation) OperationType {
+	if t, ok := op.(Typed); ok {
+		return t.Type()
+	}
+	return TypeUnknown
+}
+
+// Strategy determines the delay before each poll of an Operation.
+type Strategy interface {
+	// Next returns the delay before the poll numbered attempt (starting at 0),
+	// given the time elapsed since waiting started.
+	Next(attempt int, elapsed time.Duration, op Operation) time.Duration
+}
+
+// Fixed polls at a constant interval.
+type Fixed struct {
+	Interval time.Duration
+}
+
+func (s Fixed) Next(_ int, _ time.Duration, _ Operation) time.Duration {
+	return s.Interval
+}
+
+func (s Fixed) String() string {
+	return fmt.Sprintf("%s(%v)", StrategyFixed, s.Interval)
+}
+
+// Exponential polls with exponentially increasing delays, starting at Initial and capped at Max.
+// Each delay is randomly adjusted by up to ±Jitter (a fraction of the delay).
+type Exponential struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// NewExponential returns an Exponential strategy which doubles the delay with 20% jitter.
+func NewExponential(initial, max time.Duration) Exponential {
+	return Exponential{
+		Initial:    initial,
+		Max:        max,
+		Multiplier: defaultMultiplier,
+		Jitter:     defaultJitter,
+	}
+}
+
+func (s Exponential) Next(attempt int, _ time.Duration, _ Operation) time.Duration {
+	d := float64(s.Initial) * math.Pow(s.Multiplier, float64(attempt))
+	if max := float64(s.Max); s.Max > 0 && d > max {
+		d = max
+	}
+	if s.Jitter > 0 {
+		d += d * s.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+func (s Exponential) String() string {
+	return fmt.Sprintf("%s(%v, %v)", StrategyExponential, s.Initial, s.Max)
+}
+
+// ETA polls based on the estimated time remaining for Operations which report progress.
+// The delay is half the estimated time remaining, bounded by Min and Max.
+// Min is used until progress is reported.
+type ETA struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+func (s ETA) Next(_ int, elapsed time.Duration, op Operation) time.Duration {
+	p, ok := op.(ProgressReporter)
+	if !ok {
+		return s.Min
+	}
+	fraction, ok := p.Progress()
+	if !ok || fraction <= 0 || fraction >= 1 {
+		return s.Min
+	}
+	remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+	d := remaining / 2
+	if d < s.Min {
+		return s.Min
+	}
+	if d > s.Max {
+		return s.Max
+	}
+	return d
+}
+
+func (s ETA) String() string {
+	return fmt.Sprintf("%s(%v, %v)", StrategyETA, s.Min, s.Max)
+}
+
+// Strategies selects a Strategy by OperationType.
+type Strategies struct {
+	// Default is used for types without a Strategy in ByType.
+	Default Strategy
+	ByType  map[OperationType]Strategy
+}
+
+// For returns the Strategy for op.
+func (s Strategies) For(op Operation) Strategy {
+	if st, ok := s.ByType[TypeOf(op)]; ok {
+		return st
+	}
+	return s.Default
+}
+
+// NewStrategy returns the named Strategy using interval as the initial (or minimum) delay and maxInterval as the cap.
+func NewStrategy(name string, interval, maxInterval time.Duration) (Strategy, error) {
+	switch name {
+	case StrategyFixed:
+		return Fixed{Interval: interval}, nil
+	case StrategyExponential:
+		return NewExponential(interval, maxInterval), nil
+	case StrategyETA:
+		return ETA{Min: interval, Max: maxInterval}, nil
+	default:
+		return nil, fmt.Errorf("unknown polling strategy %q; expected one of %s, %s, %s", name, StrategyFixed, StrategyExponential, StrategyETA)
+	}
+}
+
+// ParseStrategies parses a list of entries of the form <strategy> (the default for all types)
+// or <type>=<strategy> (e.g. node-pool=eta). The default is a Fixed strategy if not specified.
+func ParseStrategies(entries []string, interval, maxInterval time.Duration) (Strategies, error) {
+	s := Strategies{
+		Default: Fixed{Interval: interval},
+		ByType:  make(map[OperationType]Strategy),
+	}
+	for _, e := range entries {
+		typ, name := TypeUnknown, e
+		if i := strings.Index(e, "="); i >= 0 {
+			typ, name = OperationType(e[:i]), e[i+1:]
+			switch typ {
+			case TypeNetwork, TypeControlPlane, TypeNodePool:
+			default:
+				return Strategies{}, fmt.Errorf("unknown operation type %q; expected one of %s, %s, %s", typ, TypeNetwork, TypeControlPlane, TypeNodePool)
+			}
+		}
+		st, err := NewStrategy(name, interval, maxInterval)
+		if err != nil {
+			return Strategies{}, err
+		}
+		if typ == TypeUnknown {
+			s.Default = st
+		} else {
+			s.ByType[typ] = st
+		}
+	}
+	return s, nil
+}
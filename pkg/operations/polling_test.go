@@ -0,0 +1,172 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// progressOperation is a FakeOperation with a type and progress.
+type progressOperation struct {
+	FakeOperation
+	typ      OperationType
+	fraction float64
+	known    bool
+}
+
+func (o *progressOperation) Type() OperationType {
+	return o.typ
+}
+
+func (o *progressOperation) Progress() (float64, bool) {
+	return o.fraction, o.known
+}
+
+func TestExponential_Next(t *testing.T) {
+	s := Exponential{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	var got []time.Duration
+	for i := 0; i < 6; i++ {
+		got = append(got, s.Next(i, 0, &FakeOperation{}))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Exponential.Next diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestExponential_Jitter(t *testing.T) {
+	s := NewExponential(10*time.Second, time.Minute)
+	for i := 0; i < 100; i++ {
+		got := s.Next(1, 0, &FakeOperation{})
+		if got < 16*time.Second || got > 24*time.Second {
+			t.Fatalf("Exponential.Next with jitter; wanted: 20s ±20%%, got: %v", got)
+		}
+	}
+}
+
+func TestETA_Next(t *testing.T) {
+	s := ETA{Min: 10 * time.Second, Max: 5 * time.Minute}
+	cases := []struct {
+		desc    string
+		op      Operation
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{
+			desc:    "No progress reported",
+			op:      &FakeOperation{},
+			elapsed: time.Hour,
+			want:    10 * time.Second,
+		},
+		{
+			desc:    "Progress unknown",
+			op:      &progressOperation{},
+			elapsed: time.Hour,
+			want:    10 * time.Second,
+		},
+		{
+			desc:    "Half of remaining time",
+			op:      &progressOperation{fraction: 0.5, known: true},
+			elapsed: 2 * time.Minute,
+			want:    time.Minute,
+		},
+		{
+			desc:    "Capped",
+			op:      &progressOperation{fraction: 0.1, known: true},
+			elapsed: 10 * time.Minute,
+			want:    5 * time.Minute,
+		},
+		{
+			desc:    "Minimum",
+			op:      &progressOperation{fraction: 0.99, known: true},
+			elapsed: time.Minute,
+			want:    10 * time.Second,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := s.Next(3, tc.elapsed, tc.op); got != tc.want {
+				t.Errorf("ETA.Next; wanted: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseStrategies(t *testing.T) {
+	interval, max := 10*time.Second, time.Minute
+	cases := []struct {
+		desc    string
+		entries []string
+		want    Strategies
+		wantErr string
+	}{
+		{
+			desc:    "Default",
+			entries: nil,
+			want:    Strategies{Default: Fixed{Interval: interval}, ByType: map[OperationType]Strategy{}},
+		},
+		{
+			desc:    "Default and per type",
+			entries: []string{"exponential", "node-pool=eta", "network=fixed"},
+			want: Strategies{
+				Default: NewExponential(interval, max),
+				ByType: map[OperationType]Strategy{
+					TypeNodePool: ETA{Min: interval, Max: max},
+					TypeNetwork:  Fixed{Interval: interval},
+				},
+			},
+		},
+		{
+			desc:    "Unknown strategy",
+			entries: []string{"control-plane=linear"},
+			wantErr: `unknown polling strategy "linear"`,
+		},
+		{
+			desc:    "Unknown type",
+			entries: []string{"cluster=eta"},
+			wantErr: `unknown operation type "cluster"`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := ParseStrategies(tc.entries, interval, max)
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("ParseStrategies error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ParseStrategies diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStrategies_For(t *testing.T) {
+	s := Strategies{
+		Default: Fixed{Interval: time.Second},
+		ByType:  map[OperationType]Strategy{TypeNodePool: Fixed{Interval: time.Minute}},
+	}
+	if got := s.For(&progressOperation{typ: TypeNodePool}); got != (Fixed{Interval: time.Minute}) {
+		t.Errorf("Strategies.For node pool; wanted: %v, got: %v", Fixed{Interval: time.Minute}, got)
+	}
+	if got := s.For(&FakeOperation{}); got != (Fixed{Interval: time.Second}) {
+		t.Errorf("Strategies.For untyped; wanted: %v, got: %v", Fixed{Interval: time.Second}, got)
+	}
+}
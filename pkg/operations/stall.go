@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrStalled is returned by Wait for operations which stall when StallDetection.Fail is set.
+var ErrStalled = errors.New("operation stalled")
+
+// StatusMessenger is implemented by Operations which report a human readable status
+// (e.g. the reason a node pool upgrade is blocked) when last polled.
+type StatusMessenger interface {
+	StatusMessage() string
+}
+
+// StallDetection configures how operations which stop making progress are handled.
+// Only operations which implement ProgressReporter are tracked, from the first poll
+// which reports progress.
+type StallDetection struct {
+	// Period without progress after which an operation is stalled; disabled if zero.
+	Period time.Duration
+	// Fail stops waiting on stalled operations and returns ErrStalled.
+	Fail bool
+	// OnStall, if set, is called each time an operation stalls.
+	OnStall func(s Stall)
+}
+
+// Stall describes an operation which has not made progress.
+type Stall struct {
+	Operation string
+	Type      OperationType
+	// Progress is the fraction of work complete when the operation stalled.
+	Progress float64
+	// Duration is the time since progress was last made.
+	Duration time.Duration
+	// Reason is the status message reported by the operation, if any.
+	Reason string
+}
+
+func (s Stall) String() string {
+	msg := fmt.Sprintf("operation %s made no progress for %v (%.0f%% complete)", s.Operation, s.Duration.Round(time.Second), s.Progress*100)
+	if s.Reason != "" {
+		msg += ": " + s.Reason
+	}
+	return msg
+}
+
+// stallTracker tracks the progress of an operation across polls.
+type stallTracker struct {
+	detection StallDetection
+	op        Operation
+
+	known        bool
+	last         float64
+	lastProgress time.Time
+	stalled      bool
+}
+
+func newStallTracker(d StallDetection, op Operation) *stallTracker {
+	return &stallTracker{detection: d, op: op}
+}
+
+// observe records the progress of the operation after a poll at now.
+// Returns an error if the operation has stalled and StallDetection.Fail is set.
+func (t *stallTracker) observe(now time.Time) error {
+	p, ok := t.op.(ProgressReporter)
+	if t.detection.Period <= 0 || !ok {
+		return nil
+	}
+	fraction, ok := p.Progress()
+	if !ok {
+		return nil
+	}
+	if !t.known || fraction > t.last {
+		if t.stalled {
+			log.Infof("Operation %s is making progress again (%.0f%% complete).", t.op, fraction*100)
+		}
+		t.known, t.last, t.lastProgress, t.stalled = true, fraction, now, false
+		return nil
+	}
+
+	since := now.Sub(t.lastProgress)
+	if t.stalled || since < t.detection.Period {
+		return nil
+	}
+	t.stalled = true
+	s := Stall{
+		Operation: t.op.String(),
+		Type:      TypeOf(t.op),
+		Progress:  fraction,
+		Duration:  since,
+	}
+	if m, ok := t.op.(StatusMessenger); ok {
+		s.Reason = m.StatusMessage()
+	}
+	log.Warnf("Stalled: %s", s)
+	if t.detection.OnStall != nil {
+		t.detection.OnStall(s)
+	}
+	if t.detection.Fail {
+		return fmt.Errorf("%s: %w", s, ErrStalled)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package operations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"legacymigration/test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// stallingOperation reports a sequence of progress values, one per poll, and never finishes.
+type stallingOperation struct {
+	progress []float64
+	reason   string
+	polls    int
+}
+
+func (o *stallingOperation) IsFinished(_ context.Context) (bool, error) {
+	o.polls++
+	return false, nil
+}
+
+func (o *stallingOperation) String() string {
+	return "stalling-op"
+}
+
+func (o *stallingOperation) Type() OperationType {
+	return TypeNodePool
+}
+
+func (o *stallingOperation) Progress() (float64, bool) {
+	if o.polls == 0 {
+		return 0, false
+	}
+	i := o.polls - 1
+	if i >= len(o.progress) {
+		i = len(o.progress) - 1
+	}
+	return o.progress[i], true
+}
+
+func (o *stallingOperation) StatusMessage() string {
+	return o.reason
+}
+
+func TestStallTracker_Observe(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		desc     string
+		progress []float64
+		fail     bool
+		want     []Stall
+		wantErr  string
+	}{
+		{
+			desc:     "Progressing",
+			progress: []float64{0.1, 0.2, 0.3, 0.4, 0.5},
+		},
+		{
+			desc:     "Stalled once",
+			progress: []float64{0.1, 0.2, 0.2, 0.2, 0.2},
+			want: []Stall{
+				{Operation: "stalling-op", Type: TypeNodePool, Progress: 0.2, Duration: 20 * time.Minute, Reason: "drain blocked"},
+			},
+		},
+		{
+			desc:     "Stalled, resumed and stalled again",
+			progress: []float64{0.2, 0.2, 0.2, 0.5, 0.5, 0.5},
+			want: []Stall{
+				{Operation: "stalling-op", Type: TypeNodePool, Progress: 0.2, Duration: 20 * time.Minute, Reason: "drain blocked"},
+				{Operation: "stalling-op", Type: TypeNodePool, Progress: 0.5, Duration: 20 * time.Minute, Reason: "drain blocked"},
+			},
+		},
+		{
+			desc:     "Fail",
+			progress: []float64{0.2, 0.2, 0.2},
+			fail:     true,
+			want: []Stall{
+				{Operation: "stalling-op", Type: TypeNodePool, Progress: 0.2, Duration: 20 * time.Minute, Reason: "drain blocked"},
+			},
+			wantErr: "operation stalling-op made no progress for 20m0s (20% complete): drain blocked: operation stalled",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []Stall
+			op := &stallingOperation{progress: tc.progress, reason: "drain blocked"}
+			tracker := newStallTracker(StallDetection{
+				Period:  15 * time.Minute,
+				Fail:    tc.fail,
+				OnStall: func(s Stall) { got = append(got, s) },
+			}, op)
+
+			var err error
+			for i := range tc.progress {
+				op.IsFinished(context.Background())
+				if err = tracker.observe(start.Add(time.Duration(i) * 10 * time.Minute)); err != nil {
+					break
+				}
+			}
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("stallTracker.observe error diff (-want +got):\n%s", diff)
+			}
+			if tc.fail && !errors.Is(err, ErrStalled) {
+				t.Errorf("stallTracker.observe error is not ErrStalled: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("stallTracker.observe stalls diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWait_FailOnStall(t *testing.T) {
+	h := NewStrategyHandler(Strategies{Default: Fixed{Interval: time.Millisecond}}, Deadlines{Default: time.Minute})
+	h.SetStallDetection(StallDetection{Period: 10 * time.Millisecond, Fail: true})
+
+	err := h.Wait(context.Background(), &stallingOperation{progress: []float64{0.5}})
+	if !errors.Is(err, ErrStalled) {
+		t.Errorf("HandlerImpl.Wait; wanted: ErrStalled, got: %v", err)
+	}
+}
+
+func TestWait_StallDisabled(t *testing.T) {
+	h := NewStrategyHandler(Strategies{Default: Fixed{Interval: time.Millisecond}}, Deadlines{Default: 20 * time.Millisecond})
+
+	err := h.Wait(context.Background(), &stallingOperation{progress: []float64{0.5}})
+	if diff := test.ErrorDiff("deadline exceeded", err); diff != "" {
+		t.Errorf("HandlerImpl.Wait diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit limits the rate of requests sent to the APIs on the client side,
+// so that conversions of many clusters stay within the project's API quotas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits are the rates of requests per API, in requests per second. A rate of 0 is unlimited.
+type Limits struct {
+	ComputeRead    float64
+	ComputeWrite   float64
+	ContainerRead  float64
+	ContainerWrite float64
+}
+
+// Default are the default Limits, well within the default API quotas of a project.
+var Default = Limits{
+	ComputeRead:    20,
+	ComputeWrite:   10,
+	ContainerRead:  10,
+	ContainerWrite: 5,
+}
+
+// Validate ensures no rate is negative.
+func (l Limits) Validate() error {
+	rates := []struct {
+		name string
+		rate float64
+	}{
+		{"compute read", l.ComputeRead},
+		{"compute write", l.ComputeWrite},
+		{"container read", l.ContainerRead},
+		{"container write", l.ContainerWrite},
+	}
+	for _, r := range rates {
+		if r.rate < 0 || math.IsNaN(r.rate) || math.IsInf(r.rate, 0) {
+			return fmt.Errorf("%s rate limit must be a non-negative number, got: %v", r.name, r.rate)
+		}
+	}
+	return nil
+}
+
+// Limiter is a token bucket which allows requests at a rate, with bursts of up to a second's worth of requests.
+type Limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter for the rate, in requests per second, or nil (which is unlimited) if the rate is 0.
+func NewLimiter(rate float64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Ceil(rate))
+	return &Limiter{rate: rate, burst: burst, now: time.Now, tokens: burst}
+}
+
+// Wait blocks until a request is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	d := l.reserve()
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// reserve takes a token, returning how long to wait until it is available.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns a token taken by a request which was not sent.
+func (l *Limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
+
+// Transport is an http.RoundTripper which limits the rate of reads (GET and HEAD requests)
+// and writes (other requests) sent through Base.
+type Transport struct {
+	// Base sends the requests; http.DefaultTransport is used if nil.
+	Base  http.RoundTripper
+	Read  *Limiter
+	Write *Limiter
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := t.Write
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		l = t.Read
+	}
+	if err := l.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// Client returns a client which sends requests using c, at the rates of the limiters.
+// c is returned if neither rate is limited.
+func Client(c *http.Client, read, write *Limiter) *http.Client {
+	if read == nil && write == nil {
+		return c
+	}
+	limited := *c
+	limited.Transport = &Transport{Base: c.Transport, Read: read, Write: write}
+	return &limited
+}
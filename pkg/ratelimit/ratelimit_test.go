@@ -0,0 +1,134 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"legacymigration/test"
+)
+
+func TestLimits_Validate(t *testing.T) {
+	cases := []struct {
+		desc    string
+		limits  Limits
+		wantErr string
+	}{
+		{
+			desc:   "Default",
+			limits: Default,
+		},
+		{
+			desc: "Unlimited",
+		},
+		{
+			desc:    "Negative",
+			limits:  Limits{ContainerWrite: -1},
+			wantErr: "container write rate limit must be a non-negative number",
+		},
+		{
+			desc:    "Infinite",
+			limits:  Limits{ComputeRead: math.Inf(1)},
+			wantErr: "compute read rate limit must be a non-negative number",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.limits.Validate()
+			if diff := test.ErrorDiff(tc.wantErr, err); diff != "" {
+				t.Errorf("Limits.Validate diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLimiter_Reserve(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(2)
+	l.now = func() time.Time { return now }
+
+	// The burst of 2 requests is allowed immediately, after which requests are spaced by 500ms.
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if got := l.reserve(); got != want {
+			t.Errorf("Limiter.reserve %d; wanted: %v, got: %v", i, want, got)
+		}
+	}
+	// Tokens are refilled over time, up to the burst.
+	now = now.Add(time.Hour)
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond} {
+		if got := l.reserve(); got != want {
+			t.Errorf("Limiter.reserve %d after refill; wanted: %v, got: %v", i, want, got)
+		}
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	if err := (*Limiter)(nil).Wait(context.Background()); err != nil {
+		t.Errorf("Wait on unlimited Limiter unexpected error: %v", err)
+	}
+	if l := NewLimiter(0); l != nil {
+		t.Errorf("NewLimiter(0); wanted: nil, got: %+v", l)
+	}
+
+	now := time.Unix(0, 0)
+	l := NewLimiter(1)
+	l.now = func() time.Time { return now }
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait with canceled context; wanted: %v, got: %v", context.Canceled, err)
+	}
+	// The token of the canceled request is returned.
+	if l.tokens != 0 {
+		t.Errorf("Limiter tokens after cancel; wanted: 0, got: %v", l.tokens)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hs.Close()
+
+	read, write := NewLimiter(1), NewLimiter(1)
+	c := Client(hs.Client(), read, write)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, _ := http.NewRequestWithContext(ctx, method, hs.URL, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s unexpected error: %v", method, err)
+		}
+		resp.Body.Close()
+	}
+	// Both limiters are exhausted, so a further read waits beyond the deadline.
+	req, _ := http.NewRequestWithContext(ctx, http.MethodHead, hs.URL, nil)
+	if _, err := c.Do(req); err == nil {
+		t.Errorf("HEAD over rate limit; wanted error, got none")
+	}
+
+	if got := Client(hs.Client(), nil, nil); got.Transport != hs.Client().Transport {
+		t.Errorf("Client without limiters; wanted the client's transport, got: %T", got.Transport)
+	}
+}
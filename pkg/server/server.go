@@ -0,0 +1,494 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"legacymigration/pkg"
+	"legacymigration/pkg/migrate"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// State is the state of a Job or of a resource within a Job.
+type State string
+
+const (
+	StatePending   State = "PENDING"
+	StateRunning   State = "RUNNING"
+	StateSucceeded State = "SUCCEEDED"
+	StateFailed    State = "FAILED"
+	StateCancelled State = "CANCELLED"
+
+	// DefaultQueueSize is the number of pending jobs accepted if Options.QueueSize is not set.
+	DefaultQueueSize = 100
+	// DefaultRetainedJobs is the number of finished jobs kept if Options.RetainedJobs is not set.
+	DefaultRetainedJobs = 100
+
+	jobsPath = "/v1/jobs"
+
+	// Maximum size of a Request body.
+	maxRequestBytes = 1 << 20
+)
+
+var (
+	// ErrQueueFull is returned when a job is submitted while the queue is at capacity.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrNotFound is returned when a job does not exist.
+	ErrNotFound = errors.New("not found")
+)
+
+// Request contains the options for a conversion job.
+// Unset fields use the same defaults as the command line flags, e.g. PollingStrategies are as for
+// --polling-strategy and ConflictRetries defaults to operations.DefaultConflictRetries (0 disables retries).
+// Exec hooks run commands on the server, so they are set by the server's flags rather than per job.
+type Request struct {
+	ProjectID                  string       `json:"projectId"`
+	Network                    string       `json:"network"`
+	Clusters                   []string     `json:"clusters,omitempty"`
+	Locations                  []string     `json:"locations,omitempty"`
+	AllowMissingZones          bool         `json:"allowMissingZones,omitempty"`
+	ConcurrentClusters         uint16       `json:"concurrentClusters,omitempty"`
+	ControlPlaneVersion        string       `json:"controlPlaneVersion,omitempty"`
+	NodeVersion                string       `json:"nodeVersion,omitempty"`
+	InPlaceControlPlaneUpgrade bool         `json:"inPlaceControlPlaneUpgrade,omitempty"`
+	AutoVersion                bool         `json:"autoVersion,omitempty"`
+	ValidateOnly               *bool        `json:"validateOnly,omitempty"`
+	PollingInterval            pkg.Duration `json:"pollingInterval,omitempty"`
+	PollingDeadline            pkg.Duration `json:"pollingDeadline,omitempty"`
+	PollingStrategies          []string     `json:"pollingStrategies,omitempty"`
+	MaxPollingInterval         pkg.Duration `json:"maxPollingInterval,omitempty"`
+	NetworkDeadline            pkg.Duration `json:"networkPollingDeadline,omitempty"`
+	ControlPlaneDeadline       pkg.Duration `json:"controlPlanePollingDeadline,omitempty"`
+	NodePoolDeadline           pkg.Duration `json:"nodePoolPollingDeadline,omitempty"`
+	PerNodeDeadline            pkg.Duration `json:"perNodePollingDeadline,omitempty"`
+	StallPeriod                pkg.Duration `json:"stallPeriod,omitempty"`
+	FailOnStall                bool         `json:"failOnStall,omitempty"`
+	ConflictRetries            *int         `json:"conflictRetries,omitempty"`
+	ConflictBackoff            pkg.Duration `json:"conflictBackoff,omitempty"`
+	MaintenanceExclusion       pkg.Duration `json:"maintenanceExclusion,omitempty"`
+}
+
+// Runner validates and runs conversion jobs.
+type Runner interface {
+	// Validate checks the Request options before the job is accepted.
+	Validate(req Request) error
+	// Run performs the conversion.
+	Run(ctx context.Context, req Request) error
+}
+
+// Resource is the progress of a single resource (network, cluster or node pool) within a Job.
+type Resource struct {
+	Path  string `json:"path"`
+	Phase string `json:"phase"`
+	State State  `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// LogEntry is a log line emitted while a Job was running.
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// Job is a snapshot of a conversion job.
+type Job struct {
+	ID         string      `json:"id"`
+	Request    Request     `json:"request"`
+	State      State       `json:"state"`
+	Phase      string      `json:"phase,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	CreateTime time.Time   `json:"createTime"`
+	StartTime  *time.Time  `json:"startTime,omitempty"`
+	EndTime    *time.Time  `json:"endTime,omitempty"`
+	Resources  []*Resource `json:"resources,omitempty"`
+}
+
+type job struct {
+	Job
+	resources map[string]*Resource
+	logs      []LogEntry
+	cancel    context.CancelFunc
+}
+
+// Options configure a Server.
+type Options struct {
+	// QueueSize is the number of jobs which may be pending; further submissions are rejected with
+	// ErrQueueFull until a job is started. Defaults to DefaultQueueSize.
+	QueueSize int
+	// RetainedJobs is the number of finished jobs whose state and logs are kept in memory.
+	// The oldest finished jobs are evicted beyond it. Defaults to DefaultRetainedJobs.
+	RetainedJobs int
+}
+
+// Server accepts conversion jobs over HTTP/JSON and runs them one at a time.
+//
+// Jobs are serialized as all migrators log through the standard logger, which
+// is how logs are attributed to the running job.
+type Server struct {
+	runner   Runner
+	retained int
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	order   []string
+	current *job
+	nextID  int
+	queue   chan *job
+}
+
+// New returns a Server which runs jobs with runner.
+func New(runner Runner, opts Options) *Server {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.RetainedJobs <= 0 {
+		opts.RetainedJobs = DefaultRetainedJobs
+	}
+	return &Server{
+		runner:   runner,
+		retained: opts.RetainedJobs,
+		jobs:     make(map[string]*job),
+		queue:    make(chan *job, opts.QueueSize),
+	}
+}
+
+// Start processes queued jobs until ctx is closed.
+func (s *Server) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-s.queue:
+			s.run(ctx, j)
+		}
+	}
+}
+
+func (s *Server) run(ctx context.Context, j *job) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	if j.State != StatePending {
+		// Cancelled while queued.
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	j.State = StateRunning
+	j.StartTime = &now
+	j.cancel = cancel
+	s.current = j
+	s.mu.Unlock()
+
+	err := s.runner.Run(migrate.WithProgress(ctx, s.progress(j)), j.Request)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := time.Now()
+	j.EndTime = &end
+	j.cancel = nil
+	s.current = nil
+	switch {
+	case j.State == StateCancelled:
+		if err != nil {
+			j.Error = err.Error()
+		}
+	case err != nil:
+		j.State = StateFailed
+		j.Error = err.Error()
+	default:
+		j.State = StateSucceeded
+	}
+	s.evict()
+}
+
+// evict removes the oldest finished jobs, and their logs, beyond the number retained.
+// Must be called while holding the Server lock.
+func (s *Server) evict() {
+	finished := 0
+	for _, id := range s.order {
+		if s.jobs[id].EndTime != nil {
+			finished++
+		}
+	}
+	kept := make([]string, 0, len(s.order))
+	for _, id := range s.order {
+		if finished > s.retained && s.jobs[id].EndTime != nil {
+			delete(s.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
+
+// progress records per-resource progress updates for the job.
+func (s *Server) progress(j *job) migrate.ProgressFunc {
+	return func(p migrate.Progress) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r, ok := j.resources[p.ResourcePath]
+		if !ok {
+			r = &Resource{Path: p.ResourcePath}
+			j.resources[p.ResourcePath] = r
+		}
+		r.Phase = p.Method.String()
+		r.Error = ""
+		switch {
+		case !p.Done:
+			r.State = StateRunning
+		case p.Err != nil:
+			r.State = StateFailed
+			r.Error = p.Err.Error()
+		default:
+			r.State = StateSucceeded
+		}
+		j.Phase = r.Phase
+	}
+}
+
+// Submit validates and queues a new job.
+func (s *Server) Submit(req Request) (Job, error) {
+	if err := s.runner.Validate(req); err != nil {
+		return Job{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	j := &job{
+		Job: Job{
+			ID:         strconv.Itoa(s.nextID),
+			Request:    req,
+			State:      StatePending,
+			CreateTime: time.Now(),
+		},
+		resources: make(map[string]*Resource),
+	}
+	select {
+	case s.queue <- j:
+	default:
+		s.nextID--
+		return Job{}, fmt.Errorf("%w: %d jobs are pending; submit again once a job has started", ErrQueueFull, cap(s.queue))
+	}
+	s.jobs[j.ID] = j
+	s.order = append(s.order, j.ID)
+	return j.snapshot(), nil
+}
+
+// Get returns a snapshot of a job.
+func (s *Server) Get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.snapshot(), true
+}
+
+// List returns snapshots of all jobs in order of submission.
+func (s *Server) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.order))
+	for _, id := range s.order {
+		jobs = append(jobs, s.jobs[id].snapshot())
+	}
+	return jobs
+}
+
+// Logs returns the log entries of a job, starting at the given offset.
+func (s *Server) Logs(id string, offset int) ([]LogEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	if offset < 0 || offset > len(j.logs) {
+		offset = len(j.logs)
+	}
+	return append([]LogEntry{}, j.logs[offset:]...), true
+}
+
+// Cancel cancels a pending or running job. It returns ErrNotFound if the job does not exist.
+func (s *Server) Cancel(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("job %s %w", id, ErrNotFound)
+	}
+	switch j.State {
+	case StatePending:
+		now := time.Now()
+		j.EndTime = &now
+	case StateRunning:
+		j.cancel()
+	default:
+		return Job{}, fmt.Errorf("job %s is %s and cannot be cancelled", id, j.State)
+	}
+	j.State = StateCancelled
+	snapshot := j.snapshot()
+	s.evict()
+	return snapshot, nil
+}
+
+// Levels implements logrus.Hook.
+func (s *Server) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements logrus.Hook, attributing log entries to the running job.
+func (s *Server) Fire(e *log.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	s.current.logs = append(s.current.logs, LogEntry{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+	})
+	return nil
+}
+
+// snapshot copies the job; must be called while holding the Server lock.
+func (j *job) snapshot() Job {
+	c := j.Job
+	c.Resources = make([]*Resource, 0, len(j.resources))
+	for _, r := range j.resources {
+		rc := *r
+		c.Resources = append(c.Resources, &rc)
+	}
+	sort.Slice(c.Resources, func(a, b int) bool {
+		return c.Resources[a].Path < c.Resources[b].Path
+	})
+	return c
+}
+
+// ServeHTTP routes the jobs API:
+//  POST /v1/jobs                 Submit a job.
+//  GET  /v1/jobs                 List jobs.
+//  GET  /v1/jobs/{id}            Get a job and the progress of its resources.
+//  GET  /v1/jobs/{id}/logs       Get job logs; use ?offset=N to skip N entries.
+//  POST /v1/jobs/{id}/cancel     Cancel a job.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == jobsPath {
+		switch r.Method {
+		case http.MethodPost:
+			s.handleSubmit(w, r)
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": s.List()})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, jobsPath+"/") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("path %s not found", r.URL.Path))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, jobsPath+"/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		j, ok := s.Get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	case len(parts) == 2 && parts[1] == "logs" && r.Method == http.MethodGet:
+		offset := 0
+		if v := r.URL.Query().Get("offset"); v != "" {
+			var err error
+			if offset, err = strconv.Atoi(v); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset %q: %w", v, err))
+				return
+			}
+		}
+		logs, ok := s.Logs(id, offset)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"logs": logs})
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		j, err := s.Cancel(id)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("malformed request: %w", err))
+		return
+	}
+	j, err := s.Submit(req)
+	if errors.Is(err, ErrQueueFull) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
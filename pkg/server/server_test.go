@@ -0,0 +1,373 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"legacymigration/pkg/migrate"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+)
+
+type fakeRunner struct {
+	validateErr error
+	runErr      error
+	// block, if set, blocks Run until closed or the context is cancelled.
+	block chan struct{}
+}
+
+func (f *fakeRunner) Validate(_ Request) error {
+	return f.validateErr
+}
+
+func (f *fakeRunner) Run(ctx context.Context, _ Request) error {
+	log.Info("running fake job")
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return migrate.Migrate(ctx, make(chan struct{}, 1), &migrate.FakeMigrator{MigrateError: f.runErr})
+}
+
+func TestServer_Jobs(t *testing.T) {
+	cases := []struct {
+		desc          string
+		runner        *fakeRunner
+		body          string
+		wantCode      int
+		wantState     State
+		wantErr       string
+		wantResources []*Resource
+	}{
+		{
+			desc:      "Success",
+			runner:    &fakeRunner{},
+			body:      `{"projectId": "p", "network": "n", "pollingInterval": "10s"}`,
+			wantCode:  http.StatusAccepted,
+			wantState: StateSucceeded,
+			wantResources: []*Resource{
+				{Path: "resource-path", Phase: "Migrate", State: StateSucceeded},
+			},
+		},
+		{
+			desc:      "Job failure",
+			runner:    &fakeRunner{runErr: errors.New("migrate error")},
+			body:      `{"projectId": "p", "network": "n"}`,
+			wantCode:  http.StatusAccepted,
+			wantState: StateFailed,
+			wantErr:   "migrate error",
+			wantResources: []*Resource{
+				{Path: "resource-path", Phase: "Migrate", State: StateFailed, Error: "migrate error"},
+			},
+		},
+		{
+			desc:     "Invalid options",
+			runner:   &fakeRunner{validateErr: errors.New("--project not provided or empty")},
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "Unknown field",
+			runner:   &fakeRunner{},
+			body:     `{"projectId": "p", "unknown": true}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "Malformed duration",
+			runner:   &fakeRunner{},
+			body:     `{"projectId": "p", "pollingInterval": 10}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := New(tc.runner, Options{})
+			go s.Start(ctx)
+
+			resp := do(t, s, http.MethodPost, "/v1/jobs", tc.body)
+			if resp.Code != tc.wantCode {
+				t.Fatalf("POST /v1/jobs status code; wanted: %d, got: %d (%s)", tc.wantCode, resp.Code, resp.Body.String())
+			}
+			if tc.wantCode != http.StatusAccepted {
+				return
+			}
+			var submitted Job
+			decode(t, resp, &submitted)
+
+			got := waitForJob(t, s, submitted.ID)
+			if got.State != tc.wantState {
+				t.Errorf("Job state; wanted: %s, got: %s", tc.wantState, got.State)
+			}
+			if diff := cmp.Diff(tc.wantErr, got.Error); diff != "" {
+				t.Errorf("Job error diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantResources, got.Resources); diff != "" {
+				t.Errorf("Job resources diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestServer_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(&fakeRunner{block: make(chan struct{})}, Options{})
+
+	// The first job blocks the second job in the queue.
+	running, err := s.Submit(Request{ProjectID: "p", Network: "n"})
+	if err != nil {
+		t.Fatalf("Submit unexpected error: %v", err)
+	}
+	queued, err := s.Submit(Request{ProjectID: "p", Network: "n"})
+	if err != nil {
+		t.Fatalf("Submit unexpected error: %v", err)
+	}
+	go s.Start(ctx)
+
+	if resp := do(t, s, http.MethodPost, "/v1/jobs/"+queued.ID+"/cancel", ""); resp.Code != http.StatusOK {
+		t.Fatalf("Cancel queued job status code; wanted: %d, got: %d", http.StatusOK, resp.Code)
+	}
+	for {
+		if j, _ := s.Get(running.ID); j.State == StateRunning {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if resp := do(t, s, http.MethodPost, "/v1/jobs/"+running.ID+"/cancel", ""); resp.Code != http.StatusOK {
+		t.Fatalf("Cancel running job status code; wanted: %d, got: %d", http.StatusOK, resp.Code)
+	}
+
+	if got := waitForJob(t, s, running.ID); got.State != StateCancelled || !strings.Contains(got.Error, "context canceled") {
+		t.Errorf("Running job; wanted: %s (context canceled), got: %s (%s)", StateCancelled, got.State, got.Error)
+	}
+	if got, _ := s.Get(queued.ID); got.State != StateCancelled || got.StartTime != nil {
+		t.Errorf("Queued job; wanted: %s and not started, got: %s (started: %v)", StateCancelled, got.State, got.StartTime)
+	}
+	if resp := do(t, s, http.MethodPost, "/v1/jobs/"+running.ID+"/cancel", ""); resp.Code != http.StatusConflict {
+		t.Errorf("Cancel finished job status code; wanted: %d, got: %d", http.StatusConflict, resp.Code)
+	}
+	if _, err := s.Cancel("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel missing job; wanted: %v, got: %v", ErrNotFound, err)
+	}
+}
+
+func TestServer_Logs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(&fakeRunner{}, Options{})
+	log.StandardLogger().SetOutput(&bytes.Buffer{})
+	log.AddHook(s)
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	go s.Start(ctx)
+
+	log.Info("not attributed to a job")
+	j, err := s.Submit(Request{ProjectID: "p", Network: "n"})
+	if err != nil {
+		t.Fatalf("Submit unexpected error: %v", err)
+	}
+	waitForJob(t, s, j.ID)
+
+	resp := do(t, s, http.MethodGet, "/v1/jobs/"+j.ID+"/logs", "")
+	var got struct {
+		Logs []LogEntry `json:"logs"`
+	}
+	decode(t, resp, &got)
+	if len(got.Logs) != 1 || got.Logs[0].Message != "running fake job" {
+		t.Errorf("Job logs; wanted: [running fake job], got: %+v", got.Logs)
+	}
+
+	resp = do(t, s, http.MethodGet, "/v1/jobs/"+j.ID+"/logs?offset=1", "")
+	got.Logs = nil
+	decode(t, resp, &got)
+	if len(got.Logs) != 0 {
+		t.Errorf("Job logs with offset; wanted none, got: %+v", got.Logs)
+	}
+}
+
+func TestServer_QueueFull(t *testing.T) {
+	s := New(&fakeRunner{}, Options{QueueSize: 1})
+	if _, err := s.Submit(Request{ProjectID: "p", Network: "n"}); err != nil {
+		t.Fatalf("Submit unexpected error: %v", err)
+	}
+
+	resp := do(t, s, http.MethodPost, "/v1/jobs", `{"projectId": "p", "network": "n"}`)
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST /v1/jobs status code; wanted: %d, got: %d", http.StatusServiceUnavailable, resp.Code)
+	}
+	if want := "job queue is full: 1 jobs are pending"; !strings.Contains(resp.Body.String(), want) {
+		t.Errorf("POST /v1/jobs body; wanted: %s, got: %s", want, resp.Body.String())
+	}
+	if got := s.List(); len(got) != 1 {
+		t.Errorf("Server.List; wanted 1 job, got: %+v", got)
+	}
+}
+
+func TestServer_Retention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(&fakeRunner{}, Options{RetainedJobs: 1})
+	go s.Start(ctx)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		j, err := s.Submit(Request{ProjectID: "p", Network: "n"})
+		if err != nil {
+			t.Fatalf("Submit unexpected error: %v", err)
+		}
+		waitForJob(t, s, j.ID)
+		ids = append(ids, j.ID)
+	}
+
+	if _, ok := s.Get(ids[0]); ok {
+		t.Errorf("Server.Get; wanted the oldest finished job %s evicted", ids[0])
+	}
+	if _, ok := s.Logs(ids[0], 0); ok {
+		t.Errorf("Server.Logs; wanted the logs of job %s evicted", ids[0])
+	}
+	if got := s.List(); len(got) != 1 || got[0].ID != ids[1] {
+		t.Errorf("Server.List; wanted only job %s, got: %+v", ids[1], got)
+	}
+}
+
+func TestServer_ServeHTTP(t *testing.T) {
+	s := New(&fakeRunner{}, Options{})
+	if _, err := s.Submit(Request{ProjectID: "p", Network: "n"}); err != nil {
+		t.Fatalf("Submit unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		desc     string
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			desc:     "List jobs",
+			method:   http.MethodGet,
+			path:     "/v1/jobs",
+			wantCode: http.StatusOK,
+			wantBody: `"state":"PENDING"`,
+		},
+		{
+			desc:     "Get job",
+			method:   http.MethodGet,
+			path:     "/v1/jobs/1",
+			wantCode: http.StatusOK,
+			wantBody: `"projectId":"p"`,
+		},
+		{
+			desc:     "Job not found",
+			method:   http.MethodGet,
+			path:     "/v1/jobs/2",
+			wantCode: http.StatusNotFound,
+			wantBody: "job 2 not found",
+		},
+		{
+			desc:     "Logs not found",
+			method:   http.MethodGet,
+			path:     "/v1/jobs/2/logs",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			desc:     "Invalid log offset",
+			method:   http.MethodGet,
+			path:     "/v1/jobs/1/logs?offset=x",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			desc:     "Cancel not found",
+			method:   http.MethodPost,
+			path:     "/v1/jobs/2/cancel",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			desc:     "Method not allowed",
+			method:   http.MethodDelete,
+			path:     "/v1/jobs",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			desc:     "Unknown path",
+			method:   http.MethodGet,
+			path:     "/v2/jobs",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp := do(t, s, tc.method, tc.path, "")
+			if resp.Code != tc.wantCode {
+				t.Errorf("%s %s status code; wanted: %d, got: %d", tc.method, tc.path, tc.wantCode, resp.Code)
+			}
+			if !strings.Contains(resp.Body.String(), tc.wantBody) {
+				t.Errorf("%s %s body; wanted: %s, got: %s", tc.method, tc.path, tc.wantBody, resp.Body.String())
+			}
+		})
+	}
+}
+
+func do(t *testing.T, s *Server, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	return resp
+}
+
+func decode(t *testing.T, resp *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Unable to read response: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("Unable to decode response %s: %v", b, err)
+	}
+}
+
+func waitForJob(t *testing.T, s *Server, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		j, ok := s.Get(id)
+		if !ok {
+			t.Fatalf("Job %s not found", id)
+		}
+		if j.EndTime != nil {
+			return j
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish", id)
+	return Job{}
+}
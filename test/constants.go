@@ -21,22 +21,17 @@ import (
 )
 
 const (
-	ProjectName                     = "test-project"
-	OperationDone                   = "DONE"
-	GenericOperationName            = "operation-generic-op"
-	SwitchToCustomModeOperationName = "operation-switch-mode"
-	UpdateMasterOperationName       = "operation-update-master"
-	UpdateNodePoolOperationName     = "operation-update-nodepool"
-	ClusterName                     = "cluster-c"
-	NodePoolName                    = "default-pool"
-	InstanceGroupManagerName        = "default-pool-m"
-	InstanceTemplateName            = "default-pool-t"
-	SelectedNetwork                 = "network-0"
-	RegionA                         = "region-a"
-	ZoneA0                          = "region-a-0"
-	ZoneA1                          = "region-a-1"
-	ComputeAPI                      = "https://compute.googleapis.com/compute/v1"
-	ContainerAPI                    = "https://container.googleapis.com/compute/v1"
+	ProjectName              = "test-project"
+	OperationDone            = "DONE"
+	ClusterName              = "cluster-c"
+	NodePoolName             = "default-pool"
+	InstanceGroupManagerName = "default-pool-m"
+	SelectedNetwork          = "network-0"
+	RegionA                  = "region-a"
+	ZoneA0                   = "region-a-0"
+	ZoneA1                   = "region-a-1"
+	ComputeAPI               = "https://compute.googleapis.com/compute/v1"
+	ContainerAPI             = "https://container.googleapis.com/compute/v1"
 
 	// Release channel types.
 	Unspecified = "UNSPECIFIED"
@@ -46,7 +41,6 @@ const (
 )
 
 var (
-	InstanceGroupURL            = SelfLink(ComputeAPI, fmt.Sprintf("projects/%s/zones/%s/instanceGroups/%s", ProjectName, ZoneA0, "default-pool-g"))
 	InstanceGroupManagerZoneA0  = SelfLink(ComputeAPI, fmt.Sprintf("projects/%s/zones/%s/instanceGroupManagers/%s", ProjectName, ZoneA0, InstanceGroupManagerName))
 	InstanceGroupManagerZoneA1  = SelfLink(ComputeAPI, fmt.Sprintf("projects/%s/zones/%s/instanceGroupManagers/%s", ProjectName, ZoneA1, InstanceGroupManagerName))
 	InstanceGroupManagerRegionA = SelfLink(ComputeAPI, fmt.Sprintf("projects/%s/regions/%s/instanceGroupManagers/%s", ProjectName, RegionA, InstanceGroupManagerName))
@@ -0,0 +1,78 @@
+/*
+Copyright © 2021 Google
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"google.golang.org/api/container/v1"
+)
+
+var (
+	PrePatchCluster = container.Cluster{
+		Name:     ClusterName,
+		Location: RegionA,
+		Network:  SelectedNetwork,
+		ReleaseChannel: &container.ReleaseChannel{
+			Channel: Unspecified,
+		},
+		CurrentMasterVersion: "1.19.10-gke.1700",
+	}
+)
+
+// ServerConfig returns the GKE ServerConfig of the test project.
+func ServerConfig() *container.ServerConfig {
+	return &container.ServerConfig{
+		Channels: []*container.ReleaseChannelConfig{
+			{
+				Channel:        Rapid,
+				DefaultVersion: "1.20.6-gke.1400",
+				ValidVersions: []string{
+					"1.21.1-gke.1800",
+					"1.20.7-gke.1800",
+					"1.20.6-gke.1400",
+				},
+			}, {
+				Channel:        Regular,
+				DefaultVersion: "1.19.10-gke.1600",
+				ValidVersions: []string{
+					"1.20.6-gke.1000",
+					"1.19.10-gke.1700",
+					"1.19.10-gke.1600",
+				},
+			}, {
+				Channel:        Stable,
+				DefaultVersion: "1.18.17-gke.1901",
+				ValidVersions: []string{
+					"1.19.10-gke.1000",
+					"1.18.18-gke.1100",
+					"1.18.17-gke.1901",
+				},
+			},
+		},
+		DefaultClusterVersion: "1.19.10-gke.1600",
+		ValidMasterVersions: []string{
+			"1.20.7-gke.1800",
+			"1.20.6-gke.1000",
+			"1.19.11-gke.1700",
+			"1.19.10-gke.1700",
+		},
+		ValidNodeVersions: []string{
+			"1.20.7-gke.1800",
+			"1.20.6-gke.1000",
+			"1.19.11-gke.1700",
+			"1.19.10-gke.1700",
+		},
+	}
+}